@@ -0,0 +1,174 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"unicode/utf8"
+
+	"github.com/rsookram/wiki-builder/internal/dryrun"
+	"github.com/rsookram/wiki-builder/internal/reader"
+)
+
+// entryGroup is the total size of a group of entries reported by analyze.
+type entryGroup struct {
+	name         string
+	entries      int
+	compressed   int64
+	uncompressed int64
+}
+
+// analyze writes the compressed and uncompressed sizes of the entries in the
+// wiki file at wikiPath to w, grouped by the start of their keys, with the
+// groups which take up the most space first. Only the keys which start with
+// prefix are included. Keys are grouped by their namespace after prefix (the
+// path segment up to the next /), or by their first character after prefix if
+// they don't have one, so passing a group as the prefix breaks it down
+// further.
+func analyze(w io.Writer, wikiPath string, prefix string) {
+	wiki, err := reader.OpenWiki(wikiPath)
+	if err != nil {
+		panic(err)
+	}
+	defer wiki.Close()
+
+	var keys []reader.SearchResult
+	err = wiki.Keys(func(r reader.SearchResult) error {
+		if strings.HasPrefix(r.Key, prefix) {
+			keys = append(keys, r)
+		}
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	// Each entry is counted once, in the group of its canonical key.
+	canonical := canonicalKeys(keys)
+	var entries []reader.SearchResult
+	for _, k := range keys {
+		if canonical[k.EntryOffset] == k.Key {
+			entries = append(entries, k)
+		}
+	}
+	log.Println("Read", len(keys), "keys of", len(entries), "entries")
+
+	compressed := make([]int64, len(entries))
+	uncompressed := make([]int64, len(entries))
+	ch := make(chan int)
+	var wg sync.WaitGroup
+	for range runtime.NumCPU() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range ch {
+				compressed[i], uncompressed[i] = entrySizes(&wiki, entries[i].EntryOffset)
+			}
+		}()
+	}
+	for i := range entries {
+		ch <- i
+	}
+	close(ch)
+	wg.Wait()
+
+	groups := make(map[string]*entryGroup)
+	total := entryGroup{name: "Total"}
+	for i, e := range entries {
+		name := keyGroup(e.Key, prefix)
+		g, found := groups[name]
+		if !found {
+			g = &entryGroup{name: name}
+			groups[name] = g
+		}
+
+		for _, g := range []*entryGroup{g, &total} {
+			g.entries++
+			g.compressed += compressed[i]
+			g.uncompressed += uncompressed[i]
+		}
+	}
+
+	sorted := make([]*entryGroup, 0, len(groups))
+	for _, g := range groups {
+		sorted = append(sorted, g)
+	}
+	slices.SortFunc(sorted, func(a, b *entryGroup) int {
+		return cmp.Or(cmp.Compare(b.compressed, a.compressed), strings.Compare(a.name, b.name))
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Group\tEntries\tCompressed\tUncompressed\tRatio\tShare")
+	for _, g := range append(sorted, &total) {
+		ratio := 0.0
+		if g.uncompressed > 0 {
+			ratio = float64(g.compressed) / float64(g.uncompressed)
+		}
+		share := 0.0
+		if total.compressed > 0 {
+			share = float64(g.compressed) / float64(total.compressed)
+		}
+
+		fmt.Fprintf(
+			tw,
+			"%s\t%d\t%s\t%s\t%.2f\t%.1f%%\n",
+			g.name,
+			g.entries,
+			dryrun.FormatSize(g.compressed),
+			dryrun.FormatSize(g.uncompressed),
+			ratio,
+			share*100,
+		)
+	}
+	if err := tw.Flush(); err != nil {
+		panic(err)
+	}
+}
+
+// keyGroup returns the group that analyze reports key (which starts with
+// prefix) in.
+func keyGroup(key string, prefix string) string {
+	rest := key[len(prefix):]
+	if i := strings.Index(rest, "/"); i >= 0 {
+		return prefix + rest[:i+1]
+	}
+
+	_, size := utf8.DecodeRuneInString(rest)
+	return prefix + rest[:size]
+}
+
+// entrySizes returns the compressed size of the entry at offset in wiki
+// (including its length prefix), and the size of its contents. The contents
+// are decompressed to measure them if the wiki was built without sizes.
+func entrySizes(wiki *reader.Wiki, offset int64) (int64, int64) {
+	size, _, err := wiki.RawEntry(offset)
+	if err != nil {
+		panic(err)
+	}
+
+	uncompressed, err := wiki.EntrySizeAt(offset)
+	if err == nil {
+		return 3 + int64(size), uncompressed
+	}
+	if !errors.Is(err, reader.ErrNotFound) {
+		panic(err)
+	}
+
+	rdr, err := wiki.EntryAt(offset)
+	if err != nil {
+		panic(err)
+	}
+	uncompressed, err = io.Copy(io.Discard, rdr)
+	if err != nil {
+		panic(fmt.Sprintf("failed to decompress the entry at %d: %s", offset, err))
+	}
+
+	return 3 + int64(size), uncompressed
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/rsookram/wiki-builder/internal/storage"
+)
+
+// anchorRows are the anchors of entries, which are encoded in the same way as
+// snippets.
+type anchorRows struct {
+	snippetRows
+}
+
+// append adds the anchors of the entries from a source whose entries start at
+// baseOffset. Entries without anchors are left out.
+func (a *anchorRows) append(entries storage.EntryMetadata, anchors []string, baseOffset uint64) {
+	if len(anchors) != entries.Len() {
+		panic(fmt.Sprintf("number of anchors (%d) doesn't match the number of entries (%d)", len(anchors), entries.Len()))
+	}
+
+	for i, anchors := range anchors {
+		if anchors != "" {
+			a.add(baseOffset+entries.StartOffset(i), anchors)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"log"
+
+	"github.com/rsookram/wiki-builder/internal/bloom"
+	"github.com/rsookram/wiki-builder/wikifile"
+)
+
+// encodeBloomFilter returns a bloom filter over the keys of rows, with
+// bitsPerKey bits for each key.
+func encodeBloomFilter(rows []wikifile.IndexRow, bitsPerKey uint) []byte {
+	f := bloom.New(len(rows), int(bitsPerKey))
+	for _, r := range rows {
+		f.Add(r.Name)
+	}
+
+	section := f.Encode()
+	log.Println("Wrote bloom filter over", len(rows), "keys in", len(section), "B")
+	return section
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"io"
+
+	"github.com/rsookram/wiki-builder/internal/reader"
+	"github.com/rsookram/wiki-builder/internal/storage"
+)
+
+// catEntry writes the decompressed contents of the entry for name in the wiki
+// file at wikiPath to w. Redirects resolve to the entry they refer to, since
+// they share its offset.
+func catEntry(w io.Writer, wikiPath string, name string, normalization storage.Normalization) {
+	wiki, err := reader.OpenWiki(wikiPath)
+	if err != nil {
+		panic(err)
+	}
+	defer wiki.Close()
+
+	offset, err := wiki.EntryOffset(normalization.Apply(name))
+	if err != nil {
+		panic(err)
+	}
+
+	rdr, err := wiki.EntryAt(offset)
+	if err != nil {
+		panic(err)
+	}
+
+	if _, err := io.Copy(w, rdr); err != nil {
+		panic(err)
+	}
+}
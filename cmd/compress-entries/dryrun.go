@@ -0,0 +1,183 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+
+	"github.com/rsookram/wiki-builder/internal/dryrun"
+	"github.com/rsookram/wiki-builder/internal/images"
+	"github.com/rsookram/wiki-builder/internal/storage"
+	"github.com/rsookram/wiki-builder/internal/transform"
+)
+
+// dryRunSamples is roughly the number of entries which are compressed to
+// estimate the size of the output with -dry-run.
+const dryRunSamples = 1000
+
+// maxEntrySize is the largest compressed entry which fits in its length
+// prefix.
+const maxEntrySize = 1 << 24
+
+// reportDryRun logs the estimated sizes of the output files for entries, and
+// any entries which can't be compressed. Instead of compressing every entry,
+// it compresses a sample of them (and any which could be too big) to estimate
+// the compression ratio. If archivePath isn't empty, the entries are read from
+// the archive there.
+func reportDryRun(outputDir string, archivePath string, entries []storage.Entry, transformer transform.Chain, imageSettings images.Settings, withSnippets bool, withHashes bool, withAnchors bool, withWordCounts bool) {
+	step := max(len(entries)/dryRunSamples, 1)
+
+	// The sizes of the entries in an archive, and the contents of the ones
+	// which are compressed, are read from it in one pass first.
+	var archiveSizes map[int]int64
+	var archiveContents map[int][]byte
+	if archivePath != "" {
+		archiveSizes, archiveContents = readArchiveSamples(archivePath, entries, step)
+	}
+
+	var totalSize, sampleSize, sampleCompressedSize int64
+	numSamples := 0
+	numIssues := 0
+	issue := func(msg string) {
+		numIssues++
+		if numIssues <= 20 {
+			log.Println(msg)
+		}
+	}
+
+	written := make([]writtenEntry, 0, len(entries))
+	fileSizes := make([]int64, 0, len(entries))
+	for i, e := range entries {
+		var size int64
+		var err error
+		if archiveSizes != nil {
+			var found bool
+			if size, found = archiveSizes[i]; !found {
+				err = fmt.Errorf("it isn't in %s", archivePath)
+			}
+		} else {
+			size, err = storage.FileSize(e.LocalPath)
+		}
+		if err != nil {
+			issue(fmt.Sprintf("Can't read %s: %s", e.Name(), err))
+			continue
+		}
+		totalSize += size
+		written = append(written, writtenEntry{name: e.Name(), size: uint64(size)})
+		fileSizes = append(fileSizes, size)
+
+		if i%step != 0 && size <= maxEntrySize {
+			continue
+		}
+
+		result := compress(e.LocalPath, archiveContents[i], transformer, imageSettings, withSnippets, withHashes, withAnchors, withWordCounts)
+		if result.buf.Len() > maxEntrySize {
+			issue(fmt.Sprintf("%s is too big after compressing it: %s", e.Name(), dryrun.FormatSize(int64(result.buf.Len()))))
+		}
+
+		numSamples++
+		sampleSize += size
+		sampleCompressedSize += int64(result.buf.Len())
+		bufPool.Put(result.buf)
+	}
+	if numIssues > 0 {
+		log.Println("Found", numIssues, "entries which can't be compressed")
+	}
+
+	ratio := 0.0
+	if sampleSize > 0 {
+		ratio = float64(sampleCompressedSize) / float64(sampleSize)
+	}
+
+	// Estimate the offsets of the entries so that the size of the metadata
+	// can be estimated too.
+	estimate := int64(0)
+	for i, size := range fileSizes {
+		written[i].startOffset = uint64(estimate)
+		estimate += int64(float64(size)*ratio) + 3 // 3 for length prefix
+	}
+
+	log.Printf(
+		"Dry run: would write about %s to %s (%s before compressing, estimated from %d entries)",
+		dryrun.FormatSize(estimate),
+		filepath.Join(outputDir, "stage-1-entries.dat"),
+		dryrun.FormatSize(totalSize),
+		numSamples,
+	)
+
+	var c dryrun.Counter
+	output := bufio.NewWriter(&c)
+	writeEntryMeta(output, written)
+	if err := output.Flush(); err != nil {
+		panic(err)
+	}
+	log.Printf("Dry run: would write about %s to %s", dryrun.FormatSize(c.N), filepath.Join(outputDir, "stage-1-entry-meta.txt"))
+
+	if *binaryMeta {
+		c.N = 0
+		writeBinaryEntryMeta(output, written)
+		if err := output.Flush(); err != nil {
+			panic(err)
+		}
+		log.Printf("Dry run: would write about %s to %s", dryrun.FormatSize(c.N), filepath.Join(outputDir, "stage-1-entry-meta.bin"))
+	}
+
+	if *sizes {
+		c.N = 0
+		writeSizes(output, written)
+		if err := output.Flush(); err != nil {
+			panic(err)
+		}
+		log.Printf("Dry run: would write about %s to %s", dryrun.FormatSize(c.N), filepath.Join(outputDir, "stage-1-sizes.txt"))
+	}
+}
+
+// readArchiveSamples reads the sizes of entries from the tar archive at
+// archivePath, along with the contents of the ones which reportDryRun
+// compresses: every step entries, and the ones which could be too big.
+func readArchiveSamples(archivePath string, entries []storage.Entry, step int) (map[int]int64, map[int][]byte) {
+	indexes := make(map[string]int, len(entries))
+	for i, e := range entries {
+		indexes[e.LocalPath] = i
+	}
+
+	a, err := storage.OpenArchive(archivePath)
+	if err != nil {
+		panic(err)
+	}
+	defer a.Close()
+
+	sizes := make(map[int]int64, len(entries))
+	contents := make(map[int][]byte)
+	for {
+		h, err := a.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			panic(fmt.Sprintf("failed to read %s: %s", archivePath, err))
+		}
+
+		i, found := indexes[h.Name]
+		if !found || h.Typeflag != tar.TypeReg {
+			continue
+		}
+		sizes[i] = h.Size
+
+		if i%step != 0 && h.Size <= maxEntrySize {
+			continue
+		}
+
+		content, err := io.ReadAll(a)
+		if err != nil {
+			panic(fmt.Sprintf("failed to read %s from %s: %s", h.Name, archivePath, err))
+		}
+		contents[i] = content
+	}
+
+	return sizes, contents
+}
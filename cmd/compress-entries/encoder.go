@@ -0,0 +1,46 @@
+package main
+
+import (
+	"compress/zlib"
+	"fmt"
+
+	fastzlib "github.com/klauspost/compress/zlib"
+
+	"github.com/rsookram/wiki-builder/internal/compression"
+)
+
+// zlibEncoders create a new zlib encoder for each of the names accepted by
+// -encoder. The output of every encoder can be read by the standard library's
+// zlib reader, so the choice doesn't affect the file format.
+var zlibEncoders = map[string]func() compression.Encoder{
+	// std is the standard library's encoder.
+	"std": func() compression.Encoder { return zlib.NewWriter(nil) },
+	// fast is github.com/klauspost/compress's encoder, which is faster than
+	// std with a similar compression ratio.
+	"fast": func() compression.Encoder { return fastzlib.NewWriter(nil) },
+}
+
+// lookupEncoder returns a function which creates encoders for the compressor
+// id. name chooses the implementation of zlib, and must be std for other
+// compressors.
+func lookupEncoder(id compression.ID, name string) (func() compression.Encoder, error) {
+	if id == compression.Zlib {
+		newEncoder, found := zlibEncoders[name]
+		if !found {
+			return nil, fmt.Errorf("unknown encoder %q", name)
+		}
+
+		return newEncoder, nil
+	}
+
+	if name != "std" {
+		return nil, fmt.Errorf("encoder %q only applies to zlib", name)
+	}
+
+	c, err := compression.Lookup(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() compression.Encoder { return c.Compress(nil) }, nil
+}
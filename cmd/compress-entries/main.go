@@ -3,25 +3,98 @@
 // Output files:
 //
 // Entries
-// - each entry is zlib compressed, prefixed with its compressed length (u24)
-// and packed
+// - each entry is compressed with the compressor chosen with -compression,
+// prefixed with its compressed length (u24) and packed
+//
+// Compression
+// - the name of the compressor that the entries are compressed with, newline
 //
 // Entry metadata
 // - number of entries as a string, newline
 // - each entry name, newline separated
-// - the end offset of each entry as a string, newline separated
+// - the start offset of each entry as a string, newline separated. Entries
+// don't need to be written in the same order as their metadata.
+//
+// Binary entry metadata (only with -binary-meta)
+// - number of entries (u64)
+// - the start offset of each entry (u64)
+// - the end of the name of each entry, in UTF-16 code units from the start of
+// the names (u64)
+// - each entry name in UTF-16, packed
+// Its numbers are in little endian, so that wiki-builder can map it into
+// memory and use it without parsing it. wiki-builder uses it instead of the
+// entry metadata above when it exists.
+//
+// Content types
+// - number of entries as a string, newline
+// - the content type of each entry, newline separated
+//
+// Hashes (only with -hashes)
+// - number of entries as a string, newline
+// - the SHA-256 of the contents of each entry (after transforming it, but
+// before compressing it) in hex, newline separated
+//
+// Sizes (only with -sizes)
+// - number of entries as a string, newline
+// - the size in bytes of the contents of each entry (after transforming it,
+// but before compressing it), newline separated
+//
+// Languages (only with -languages)
+// - number of entries as a string, newline
+// - the language code of each entry from the lang attribute of its html
+// element, or an empty line if it doesn't have one, newline separated
+//
+// Word counts (only with -word-counts)
+// - number of entries as a string, newline
+// - the number of words in the text of each entry (0 for entries which aren't
+// HTML), newline separated
+//
+// Anchors (only with -anchors)
+// - number of entries as a string, newline
+// - the anchors of each entry (the IDs of its elements, and the names of its a
+// elements), tab separated, newline separated
 //
-// All strings are encoded in UTF-8. All numbers are in base-10.
+// Snippets (only with -snippets)
+// - number of entries as a string, newline
+// - the start of the text of each entry (with whitespace collapsed), newline
+// separated
+//
+// If compress-entries is cancelled, the output files only have the entries
+// compressed so far, and an empty stage-1-incomplete file is created. Pass
+// -resume to compress the rest of the entries.
+//
+// With -shard, only some of the entries are compressed, and the output files
+// are written to a directory for the shard within the input directory, along
+// with the redirects to those entries (in the same format as index-fs).
+//
+// If the input is a tar archive of the dump, the entries are read from it in
+// one pass, and written in the order that they're in it.
+//
+// With -output-dir, the output of index-fs is read from, and the output files
+// are written to, that directory instead of the input directory. With
+// -workdir, they're in the directory with that name in .wiki-builder within
+// it. The output directory is locked while the entries are compressed. With
+// -output-dir -, the output of index-fs is read from stdin, and the output
+// files (along with the redirects) are written to stdout, as tar archives.
+//
+// All strings are encoded in UTF-8, and all numbers are in base-10, apart from
+// in the binary entry metadata.
 package main
 
 import (
+	"archive/tar"
 	"bufio"
 	"bytes"
-	"compress/zlib"
+	"crypto/sha256"
 	"encoding/binary"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
+	"hash"
 	"io"
+	"io/fs"
+	"iter"
 	"log"
 	"os"
 	"path/filepath"
@@ -30,13 +103,42 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"unicode/utf16"
 
+	"github.com/rsookram/wiki-builder/internal/anchor"
+	"github.com/rsookram/wiki-builder/internal/compression"
+	"github.com/rsookram/wiki-builder/internal/dryrun"
+	"github.com/rsookram/wiki-builder/internal/images"
+	"github.com/rsookram/wiki-builder/internal/plaintext"
+	"github.com/rsookram/wiki-builder/internal/progress"
+	"github.com/rsookram/wiki-builder/internal/snippet"
 	"github.com/rsookram/wiki-builder/internal/storage"
+	"github.com/rsookram/wiki-builder/internal/transform"
+	"github.com/rsookram/wiki-builder/internal/words"
 )
 
 type writtenEntry struct {
-	name      string
-	endOffset uint64
+	name        string
+	startOffset uint64
+	contentType string
+	snippet     string
+	hash        []byte
+	size        uint64
+	language    string
+	anchors     string
+	wordCount   uint64
+}
+
+type compressedEntry struct {
+	buf         *bytes.Buffer
+	contentType string
+	snippet     string
+	hash        []byte
+	// size is the size of the contents before they're compressed.
+	size      uint64
+	language  string
+	anchors   string
+	wordCount uint64
 }
 
 var bufPool = sync.Pool{
@@ -51,14 +153,33 @@ var tmpBufPool = sync.Pool{
 	},
 }
 
-var zlibPool = sync.Pool{
-	New: func() any {
-		return zlib.NewWriter(nil)
-	},
-}
+// encoderPool has encoders of the type chosen with -compression and -encoder.
+var encoderPool sync.Pool
 
 var cpuprofile = flag.String("cpuprofile", "", "write cpu profile to file")
 var memprofile = flag.String("memprofile", "", "write memory profile to this file")
+var minify = flag.Bool("minify", false, "minify the HTML of entries before compressing them (applied after -transform)")
+var compressionName = flag.String("compression", "zlib", "the algorithm to compress entries with: "+strings.Join(compression.Names(), ", "))
+var encoderName = flag.String("encoder", "std", "the zlib encoder to compress entries with: std, or fast to use github.com/klauspost/compress (the output is compatible with both)")
+var hashes = flag.Bool("hashes", false, "store the SHA-256 of the contents of each entry, to check their integrity or find duplicates")
+var sizes = flag.Bool("sizes", false, "store the size of the contents of each entry, to tell short stubs from full articles in search results")
+var languages = flag.Bool("languages", false, "record the language of each HTML entry from the lang attribute of its html element, so that queries can be filtered by language")
+var wordCounts = flag.Bool("word-counts", false, "count the words in the text of each HTML entry, to show how long it takes to read")
+var anchors = flag.Bool("anchors", false, "extract the anchors of each HTML entry (e.g. the IDs of its headings), so that links to sections can be checked")
+var snippets = flag.Bool("snippets", false, "extract the start of the text of each entry to show in search results")
+var shard = flag.String("shard", "", "only compress the entries in shard i of n (given as i/n, e.g. 0/8), chosen by the hash of their names, and write the output to a shard-i-of-n directory in the data directory, to combine with wiki-builder merge-shards")
+var binaryMeta = flag.Bool("binary-meta", false, "also write the entry metadata in a binary format which wiki-builder maps into memory instead of parsing, for dumps with millions of entries")
+var stageOutputDir = flag.String("output-dir", "", "read the output of index-fs from, and write the output to, this directory instead of the data directory (use the same -output-dir as for index-fs), or - to read it from stdin and write the output to stdout as tar archives, to pipe between the stages")
+var workdir = flag.String("workdir", "", "read the output of index-fs from, and write the output to, the directory with this name in .wiki-builder in the data directory (use the same -workdir as for index-fs)")
+var resume = flag.Bool("resume", false, "continue compressing entries after being cancelled, with the same flags as before")
+var progressFD = flag.Int("progress-fd", -1, "write progress events as lines of JSON to this file descriptor")
+var controlFD = flag.Int("control-fd", -1, "read commands from this file descriptor; \"cancel\" stops compressing entries so that it can be resumed later")
+var dryRun = flag.Bool("dry-run", false, "check that the entries can be read and estimate the sizes of the output files by compressing a sample of the entries, without writing them")
+var jpegQuality = flag.Int("jpeg-quality", 0, "re-encode JPEG images with this quality (1-100) if that makes them smaller")
+var recompressPNG = flag.Bool("recompress-png", false, "re-encode PNG images with the best compression if that makes them smaller")
+var maxImageDimension = flag.Int("max-image-dimension", 0, "scale down JPEG and PNG images whose width or height is more than this many pixels to fit")
+var transforms = flag.String("transform", "", "comma-separated list of transformations to apply to the HTML of entries before compressing them: "+strings.Join(transform.Names(), ", "))
+var entryOrderName = flag.String("entry-order", string(orderWalk), "the order to write the entries in: walk-order (the order from index-fs), name-sorted (the order of the index, so that reading every entry by key is sequential), or size-descending (the biggest first, so that entries of similar sizes are together). Only walk-order can be resumed or used with archives.")
 
 func main() {
 	flag.Parse()
@@ -76,28 +197,205 @@ func main() {
 		panic("missing required arguments")
 	}
 
-	if !strings.HasSuffix(dataDir, string(os.PathSeparator)) {
+	reporter := progress.New("compress-entries", *progressFD, *controlFD)
+	reporter.Start()
+
+	transformer, err := transform.Lookup(*transforms)
+	if err != nil {
+		panic(err)
+	}
+	if *minify {
+		transformer = append(transformer, transform.Minify)
+	}
+
+	if *jpegQuality < 0 || *jpegQuality > 100 {
+		panic(fmt.Sprintf("-jpeg-quality must be between 1 and 100: %d", *jpegQuality))
+	}
+	imageSettings := images.Settings{JPEGQuality: *jpegQuality, PNG: *recompressPNG, MaxDimension: *maxImageDimension}
+
+	compressionID, err := compression.LookupName(*compressionName)
+	if err != nil {
+		panic(err)
+	}
+
+	newEncoder, err := lookupEncoder(compressionID, *encoderName)
+	if err != nil {
+		panic(err)
+	}
+	encoderPool.New = func() any {
+		return newEncoder()
+	}
+
+	order, ok := parseEntryOrder(*entryOrderName)
+	if !ok {
+		panic(fmt.Sprintf("unknown entry order: %s", *entryOrderName))
+	}
+	if order != orderWalk && *resume {
+		panic("-resume can't be used with -entry-order " + *entryOrderName + ", since the entries which were written before being cancelled aren't the first ones")
+	}
+
+	// Entries are read from the archive in one pass, in the order that they're
+	// in it, so they can't be resumed.
+	archivePath := ""
+	if storage.IsArchive(dataDir) {
+		if *resume {
+			panic("-resume can't be used with archives, since the entries aren't compressed in order")
+		}
+		if order != orderWalk {
+			panic("-entry-order can't be used with archives, since the entries are compressed in the order of the archive")
+		}
+		archivePath = dataDir
+	} else if !strings.HasSuffix(dataDir, string(os.PathSeparator)) {
 		dataDir = dataDir + string(os.PathSeparator)
 	}
 
-	entriesFile, err := os.Create(filepath.Join(dataDir, "stage-1-entries.dat"))
+	streamed := *stageOutputDir == storage.Stream
+	var stageDir string
+	if streamed {
+		if *resume {
+			panic("-resume can't be used with -output-dir -, since the output isn't kept after being cancelled")
+		}
+
+		stageDir = storage.ReceiveStage(os.Stdin)
+		defer os.RemoveAll(stageDir)
+	} else {
+		stageDir = storage.StageDir(dataDir, *stageOutputDir, *workdir)
+	}
+
+	rdr := bufio.NewReaderSize(nil, 1024*1024)
+	entries := storage.ReadEntries(rdr, stageDir)
+
+	output := bufio.NewWriterSize(nil, 1024*1024)
+
+	outputDir := stageDir
+	if *shard != "" {
+		spec, err := parseShard(*shard)
+		if err != nil {
+			panic(err)
+		}
+
+		var redirects []storage.Redirect
+		entries, redirects = selectShard(spec, entries, storage.ReadRedirects(rdr, stageDir))
+
+		outputDir = spec.dir(stageDir)
+		if *dryRun {
+			var c dryrun.Counter
+			output.Reset(&c)
+			writeRedirects(output, redirects)
+			if err := output.Flush(); err != nil {
+				panic(err)
+			}
+			dryrun.Report(filepath.Join(outputDir, "stage-0-redirects.txt"), c.N)
+
+			reportDryRun(outputDir, archivePath, entries, transformer, imageSettings, *snippets, *hashes, *anchors, *wordCounts)
+			reporter.Finish()
+			return
+		}
+
+		// The output of index-fs is only read, so the other shards can be
+		// compressed at the same time.
+		defer storage.LockStageDir(stageDir, true)()
+		defer storage.LockStageDir(outputDir, false)()
+
+		f, err := os.Create(filepath.Join(outputDir, "stage-0-redirects.txt"))
+		if err != nil {
+			panic(err)
+		}
+		defer f.Close()
+
+		output.Reset(f)
+
+		writeRedirects(output, redirects)
+
+		if err := output.Flush(); err != nil {
+			panic(err)
+		}
+
+		log.Println("Compressing", len(entries), "entries and", len(redirects), "redirects in shard", *shard)
+	}
+
+	if *dryRun {
+		reportDryRun(outputDir, archivePath, entries, transformer, imageSettings, *snippets, *hashes, *anchors, *wordCounts)
+		reporter.Finish()
+		return
+	}
+
+	if *shard == "" {
+		defer storage.LockStageDir(outputDir, false)()
+	}
+
+	var previous []writtenEntry
+	var entriesFile *os.File
+	entriesPath := filepath.Join(outputDir, "stage-1-entries.dat")
+	if *resume {
+		if !storage.IsIncomplete(outputDir) {
+			log.Println("Entries were already compressed, so there's nothing to resume")
+			reporter.Finish()
+			return
+		}
+
+		if c := storage.ReadCompression(outputDir); c != compressionID {
+			panic(fmt.Sprintf("the entries were compressed with a different -compression before being cancelled, so -compression %s can't be passed when resuming", *compressionName))
+		}
+
+		resumeOffset, ok := storage.ResumeOffset(outputDir)
+		if !ok {
+			panic("compress-entries was stopped before it could record where it stopped, so it can't be resumed. Run it again without -resume.")
+		}
+
+		previous = readWrittenEntries(rdr, outputDir, entries, *snippets, *hashes, *sizes, *languages, *anchors, *wordCounts)
+		log.Println("Resuming after", len(previous), "entries")
+
+		entriesFile, err = os.OpenFile(entriesPath, os.O_WRONLY|os.O_APPEND, 0)
+		if err == nil {
+			// Drop anything written after the recorded entries.
+			err = entriesFile.Truncate(resumeOffset)
+		}
+	} else {
+		entriesFile, err = os.Create(entriesPath)
+	}
 	if err != nil {
 		panic(err)
 	}
 	defer entriesFile.Close()
 
-	output := bufio.NewWriterSize(entriesFile, 1024*1024)
+	if !streamed {
+		storage.SetIncomplete(outputDir, true)
+	}
 
-	rdr := bufio.NewReaderSize(nil, 1024*1024)
-	entries := storage.ReadEntries(rdr, dataDir)
+	info, err := entriesFile.Stat()
+	if err != nil {
+		panic(err)
+	}
 
-	writtenEntries := writeEntries(output, entries)
+	output.Reset(entriesFile)
+
+	var writtenEntries []writtenEntry
+	if archivePath != "" {
+		writtenEntries = writeArchiveEntries(output, archivePath, entries, transformer, imageSettings, *snippets, *hashes, *anchors, *wordCounts, reporter)
+	} else {
+		writtenEntries = writeEntries(output, entries, previous, order.permutation(entries), uint64(info.Size()), transformer, imageSettings, *snippets, *hashes, *anchors, *wordCounts, reporter)
+	}
+	if writtenEntries == nil {
+		// The entries are left marked as incomplete, but not as resumable.
+		if streamed {
+			os.RemoveAll(stageDir)
+		}
+		log.Println("Cancelled. Run compress-entries again without -resume, since it can't be resumed when reading from an archive or with -entry-order", *entryOrderName)
+		reporter.Exit()
+	}
 
 	if err := output.Flush(); err != nil {
 		panic(err)
 	}
 
-	f, err := os.Create(filepath.Join(dataDir, "stage-1-entry-meta.txt"))
+	info, err = entriesFile.Stat()
+	if err != nil {
+		panic(err)
+	}
+	entriesSize := info.Size()
+
+	f, err := os.Create(filepath.Join(outputDir, "stage-1-entry-meta.txt"))
 	if err != nil {
 		panic(err)
 	}
@@ -105,14 +403,203 @@ func main() {
 
 	output.Reset(f)
 
-	// TODO: Eventually just write start offsets in the original order of the
-	// entries. And then entries can be written compressed out of order
 	writeEntryMeta(output, writtenEntries)
 
 	if err := output.Flush(); err != nil {
 		panic(err)
 	}
 
+	binaryMetaPath := filepath.Join(outputDir, "stage-1-entry-meta.bin")
+	if !*binaryMeta {
+		// Don't leave metadata for different entries from a previous run.
+		if err := os.Remove(binaryMetaPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			panic(err)
+		}
+	} else {
+		f, err := os.Create(binaryMetaPath)
+		if err != nil {
+			panic(err)
+		}
+		defer f.Close()
+
+		output.Reset(f)
+
+		writeBinaryEntryMeta(output, writtenEntries)
+
+		if err := output.Flush(); err != nil {
+			panic(err)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(outputDir, "stage-1-compression.txt"), []byte(*compressionName+"\n"), 0o644); err != nil {
+		panic(err)
+	}
+
+	f, err = os.Create(filepath.Join(outputDir, "stage-1-content-types.txt"))
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+
+	output.Reset(f)
+
+	writeContentTypes(output, writtenEntries)
+
+	if err := output.Flush(); err != nil {
+		panic(err)
+	}
+
+	hashesPath := filepath.Join(outputDir, "stage-1-hashes.txt")
+	if !*hashes {
+		// Don't leave hashes for different entries from a previous run.
+		if err := os.Remove(hashesPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			panic(err)
+		}
+	} else {
+		f, err := os.Create(hashesPath)
+		if err != nil {
+			panic(err)
+		}
+		defer f.Close()
+
+		output.Reset(f)
+
+		writeHashes(output, writtenEntries)
+
+		if err := output.Flush(); err != nil {
+			panic(err)
+		}
+	}
+
+	sizesPath := filepath.Join(outputDir, "stage-1-sizes.txt")
+	if !*sizes {
+		// Don't leave sizes for different entries from a previous run.
+		if err := os.Remove(sizesPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			panic(err)
+		}
+	} else {
+		f, err := os.Create(sizesPath)
+		if err != nil {
+			panic(err)
+		}
+		defer f.Close()
+
+		output.Reset(f)
+
+		writeSizes(output, writtenEntries)
+
+		if err := output.Flush(); err != nil {
+			panic(err)
+		}
+	}
+
+	languagesPath := filepath.Join(outputDir, "stage-1-languages.txt")
+	if !*languages {
+		// Don't leave languages for different entries from a previous run.
+		if err := os.Remove(languagesPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			panic(err)
+		}
+	} else {
+		f, err := os.Create(languagesPath)
+		if err != nil {
+			panic(err)
+		}
+		defer f.Close()
+
+		output.Reset(f)
+
+		writeLanguages(output, writtenEntries)
+
+		if err := output.Flush(); err != nil {
+			panic(err)
+		}
+	}
+
+	anchorsPath := filepath.Join(outputDir, "stage-1-anchors.txt")
+	if !*anchors {
+		// Don't leave anchors for different entries from a previous run.
+		if err := os.Remove(anchorsPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			panic(err)
+		}
+	} else {
+		f, err := os.Create(anchorsPath)
+		if err != nil {
+			panic(err)
+		}
+		defer f.Close()
+
+		output.Reset(f)
+
+		writeAnchors(output, writtenEntries)
+
+		if err := output.Flush(); err != nil {
+			panic(err)
+		}
+	}
+
+	wordCountsPath := filepath.Join(outputDir, "stage-1-word-counts.txt")
+	if !*wordCounts {
+		// Don't leave word counts for different entries from a previous run.
+		if err := os.Remove(wordCountsPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			panic(err)
+		}
+	} else {
+		f, err := os.Create(wordCountsPath)
+		if err != nil {
+			panic(err)
+		}
+		defer f.Close()
+
+		output.Reset(f)
+
+		writeWordCounts(output, writtenEntries)
+
+		if err := output.Flush(); err != nil {
+			panic(err)
+		}
+	}
+
+	snippetsPath := filepath.Join(outputDir, "stage-1-snippets.txt")
+	if !*snippets {
+		// Don't leave snippets for different entries from a previous run.
+		if err := os.Remove(snippetsPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			panic(err)
+		}
+	} else {
+		f, err := os.Create(snippetsPath)
+		if err != nil {
+			panic(err)
+		}
+		defer f.Close()
+
+		output.Reset(f)
+
+		writeSnippets(output, writtenEntries)
+
+		if err := output.Flush(); err != nil {
+			panic(err)
+		}
+	}
+
+	incomplete := len(writtenEntries) < len(entries)
+	if streamed {
+		if incomplete {
+			os.RemoveAll(stageDir)
+			log.Println("Cancelled after", len(writtenEntries), "of", len(entries), "entries")
+			reporter.Exit()
+		}
+
+		storage.SendStage(os.Stdout, outputDir)
+	} else {
+		if incomplete {
+			storage.SetResumable(outputDir, entriesSize)
+			log.Println("Cancelled after", len(writtenEntries), "of", len(entries), "entries. Pass -resume to continue.")
+			reporter.Exit()
+		}
+		storage.SetIncomplete(outputDir, false)
+	}
+	reporter.Finish()
+
 	if *memprofile != "" {
 		f, err := os.Create(*memprofile)
 		if err != nil {
@@ -124,38 +611,212 @@ func main() {
 	}
 }
 
-func writeEntries(w io.Writer, entries []storage.Entry) []writtenEntry {
+// writeEntries compresses the entries after the previously written ones,
+// writing them to w starting at offset, in the order of the indexes in order
+// (or of entries if it's nil). If the reporter is cancelled, it stops early and
+// only returns the entries written so far, or nil if they aren't the first of
+// entries (because of order).
+func writeEntries(
+	w io.Writer,
+	entries []storage.Entry,
+	previous []writtenEntry,
+	order []int,
+	offset uint64,
+	transformer transform.Chain,
+	imageSettings images.Settings,
+	withSnippets bool,
+	withHashes bool,
+	withAnchors bool,
+	withWordCounts bool,
+	reporter *progress.Reporter,
+) []writtenEntry {
 	writtenEntries := make([]writtenEntry, len(entries))
+	copy(writtenEntries, previous)
+
+	jobs := func(yield func(entryJob) bool) {
+		for i := len(previous); i < len(entries); i++ {
+			idx := i
+			if order != nil {
+				idx = order[i]
+			}
 
-	results := make([]chan *bytes.Buffer, len(entries))
-	for i := range results {
-		results[i] = make(chan *bytes.Buffer, 1)
+			if !yield(entryJob{idx: idx}) {
+				return
+			}
+		}
+	}
+
+	n := compressEntries(w, entries, writtenEntries, len(previous), jobs, offset, transformer, imageSettings, withSnippets, withHashes, withAnchors, withWordCounts, reporter)
+	if order != nil && len(previous)+n < len(entries) {
+		return nil
 	}
 
+	return writtenEntries[:len(previous)+n]
+}
+
+// writeArchiveEntries is like writeEntries, but reads the entries from the tar
+// archive at archivePath (by their names in it) in one pass, so they're
+// written in the order of the archive rather than of entries. It returns nil
+// if the reporter is cancelled, since the entries written so far can't be
+// resumed from.
+func writeArchiveEntries(
+	w io.Writer,
+	archivePath string,
+	entries []storage.Entry,
+	transformer transform.Chain,
+	imageSettings images.Settings,
+	withSnippets bool,
+	withHashes bool,
+	withAnchors bool,
+	withWordCounts bool,
+	reporter *progress.Reporter,
+) []writtenEntry {
+	writtenEntries := make([]writtenEntry, len(entries))
+
+	indexes := make(map[string]int, len(entries))
+	for i, e := range entries {
+		indexes[e.LocalPath] = i
+	}
+
+	a, err := storage.OpenArchive(archivePath)
+	if err != nil {
+		panic(err)
+	}
+	defer a.Close()
+
+	jobs := func(yield func(entryJob) bool) {
+		for {
+			h, err := a.Next()
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			if err != nil {
+				panic(fmt.Sprintf("failed to read %s: %s", archivePath, err))
+			}
+
+			idx, found := indexes[h.Name]
+			if !found || h.Typeflag != tar.TypeReg {
+				continue
+			}
+			// Entries are only compressed once, even if the archive
+			// has more than one file with the same name.
+			delete(indexes, h.Name)
+
+			content, err := io.ReadAll(a)
+			if err != nil {
+				panic(fmt.Sprintf("failed to read %s from %s: %s", h.Name, archivePath, err))
+			}
+
+			if !yield(entryJob{idx: idx, content: content}) {
+				return
+			}
+		}
+	}
+
+	n := compressEntries(w, entries, writtenEntries, 0, jobs, 0, transformer, imageSettings, withSnippets, withHashes, withAnchors, withWordCounts, reporter)
+	if n < len(entries) {
+		if reporter.IsCancelled() {
+			return nil
+		}
+
+		for name := range indexes {
+			panic(fmt.Sprintf("%d entries from index-fs aren't in %s (e.g. %s)", len(indexes), archivePath, name))
+		}
+	}
+
+	return writtenEntries
+}
+
+// entryJob is an entry for compressEntries to compress.
+type entryJob struct {
+	// idx is the index of the entry.
+	idx int
+	// content is the contents of the entry if they were already read (e.g.
+	// from an archive). Otherwise they're read from its path.
+	content []byte
+}
+
+// compressEntries compresses the entries from jobs in parallel, and writes
+// them to w in the same order, starting at offset. Where each one is written
+// is recorded in writtenEntries, by its index. done is the number of entries
+// which were already written, for reporting progress. It returns the number
+// of entries it wrote, which is fewer than the number of jobs if the reporter
+// is cancelled.
+func compressEntries(
+	w io.Writer,
+	entries []storage.Entry,
+	writtenEntries []writtenEntry,
+	done int,
+	jobs iter.Seq[entryJob],
+	offset uint64,
+	transformer transform.Chain,
+	imageSettings images.Settings,
+	withSnippets bool,
+	withHashes bool,
+	withAnchors bool,
+	withWordCounts bool,
+	reporter *progress.Reporter,
+) int {
+	type pendingEntry struct {
+		idx    int
+		result chan compressedEntry
+	}
+	pending := make(chan pendingEntry, runtime.NumCPU())
+
 	// Limit parallelism
 	tokens := make(chan struct{}, runtime.NumCPU())
 	for range runtime.NumCPU() {
 		tokens <- struct{}{}
 	}
 
+	// stop is closed when the entries stop being written, so that jobs
+	// aren't read after being cancelled. The jobs are done being read when
+	// pending is closed.
+	stop := make(chan struct{})
+	defer func() {
+		close(stop)
+		for range pending {
+		}
+	}()
+
 	go func() {
-		for i, e := range entries {
-			<-tokens
+		defer close(pending)
+
+		for job := range jobs {
+			select {
+			case <-tokens:
+			case <-stop:
+				return
+			case <-reporter.Cancelled():
+				return
+			}
+
+			p := pendingEntry{job.idx, make(chan compressedEntry, 1)}
+			go func(path string, content []byte) {
+				p.result <- compress(path, content, transformer, imageSettings, withSnippets, withHashes, withAnchors, withWordCounts)
+			}(entries[job.idx].LocalPath, job.content)
 
-			go func(idx int, path string) {
-				results[idx] <- compress(path)
-			}(i, e.LocalPath)
+			select {
+			case pending <- p:
+			case <-stop:
+				return
+			}
 		}
 	}()
 
 	tmp := make([]byte, 4)
-	endOffset := uint64(0)
-	for i, e := range entries {
-		buf := <-results[i]
+	n := 0
+	for p := range pending {
+		var result compressedEntry
+		select {
+		case result = <-p.result:
+		case <-reporter.Cancelled():
+			return n
+		}
+		buf := result.buf
 		tokens <- struct{}{}
 
 		sizeBytes := uint32(buf.Len())
-		endOffset += uint64(sizeBytes) + 3 // 3 for length prefix
 
 		if sizeBytes > 1<<24 {
 			panic(fmt.Sprintf("entry is too big, size=%d", sizeBytes))
@@ -174,41 +835,146 @@ func writeEntries(w io.Writer, entries []storage.Entry) []writtenEntry {
 
 		bufPool.Put(buf)
 
-		writtenEntries[i] = writtenEntry{e.Name(), endOffset}
+		e := entries[p.idx]
+		writtenEntries[p.idx] = writtenEntry{e.Name(), offset, result.contentType, result.snippet, result.hash, result.size, result.language, result.anchors, result.wordCount}
+		offset += uint64(sizeBytes) + 3 // 3 for length prefix
 
-		if i%10000 == 0 {
-			log.Println(i+1, "/", len(entries))
+		n++
+		if (done+n-1)%10000 == 0 {
+			log.Println(done+n, "/", len(entries))
 		}
+		reporter.Update(done+n, len(entries))
+	}
+	if reporter.IsCancelled() {
+		return n
 	}
 
 	log.Println(len(entries), "/", len(entries))
 
-	return writtenEntries
+	return n
 }
 
-func compress(path string) *bytes.Buffer {
+// compress compresses the entry at path. If content isn't nil, it's the
+// contents of the entry, which were already read (e.g. from an archive).
+func compress(path string, content []byte, transformer transform.Chain, imageSettings images.Settings, withSnippet bool, withHash bool, withAnchors bool, withWordCount bool) compressedEntry {
 	buf := bufPool.Get().(*bytes.Buffer)
 	buf.Reset()
 	tmp := tmpBufPool.Get().([]byte)
-	zw := zlibPool.Get().(*zlib.Writer)
+	zw := encoderPool.Get().(compression.Encoder)
 	zw.Reset(buf)
 
-	f, err := os.Open(path)
-	if err != nil {
-		panic(fmt.Sprintf("failed to open %s: %s", path, err))
+	// The hash is of the contents before they're compressed.
+	var w io.Writer = zw
+	var h hash.Hash
+	if withHash {
+		h = sha256.New()
+		w = io.MultiWriter(zw, h)
+	}
+
+	var f io.Reader
+	if content != nil {
+		f = bytes.NewReader(content)
+	} else {
+		rc, err := storage.Open(path)
+		if err != nil {
+			panic(fmt.Sprintf("failed to open %s: %s", path, err))
+		}
+		defer rc.Close()
+		f = rc
 	}
 
-	if _, err = io.CopyBuffer(zw, f, tmp); err != nil {
+	n, err := io.ReadFull(f, tmp[:512])
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
 		panic(err)
 	}
+	head := tmp[:n]
+	contentType := storage.DetectContentType(path, head)
+
+	// Transformations, snippets, anchors, and word counts only apply to HTML.
+	isHTML := storage.IsHTML(contentType)
+
+	var language string
+	if isHTML {
+		language = storage.DetectLanguage(head)
+	}
+
+	var s string
+	var entryAnchors string
+	var wordCount uint64
+	var size uint64
+	if imageSettings.Enabled() && images.Supported(contentType) {
+		rest, err := io.ReadAll(f)
+		if err != nil {
+			panic(err)
+		}
+		content := append(bytes.Clone(head), rest...)
+
+		recompressed, err := imageSettings.Recompress(contentType, content)
+		if err != nil {
+			log.Println("Failed to recompress image, so storing it as it is:", path, err)
+		} else {
+			content = recompressed
+		}
+
+		if _, err = w.Write(content); err != nil {
+			panic(err)
+		}
+		size = uint64(len(content))
+	} else if !isHTML || (len(transformer) == 0 && !withSnippet && !withAnchors && !withWordCount) {
+		if _, err = w.Write(head); err != nil {
+			panic(err)
+		}
+		n, err := io.CopyBuffer(w, f, tmp)
+		if err != nil {
+			panic(err)
+		}
+		size = uint64(len(head)) + uint64(n)
+	} else {
+		rest, err := io.ReadAll(f)
+		if err != nil {
+			panic(err)
+		}
+		content := append(bytes.Clone(head), rest...)
+
+		content, err = transformer.Transform(content)
+		if err != nil {
+			panic(fmt.Sprintf("failed to transform %s: %s", path, err))
+		}
+
+		if withSnippet {
+			s = snippet.Extract(bytes.NewReader(content), snippet.MaxLen)
+		}
+		if withAnchors {
+			entryAnchors = strings.Join(anchor.Extract(bytes.NewReader(content)), anchor.Separator)
+		}
+		if withWordCount {
+			paragraphs, err := plaintext.Extract(bytes.NewReader(content))
+			if err != nil {
+				panic(fmt.Sprintf("failed to extract the text of %s: %s", path, err))
+			}
+			for _, p := range paragraphs {
+				wordCount += uint64(words.Count(p.Text))
+			}
+		}
+
+		if _, err = w.Write(content); err != nil {
+			panic(err)
+		}
+		size = uint64(len(content))
+	}
 
 	if err = zw.Close(); err != nil {
 		panic(err)
 	}
 
-	zlibPool.Put(zw)
+	encoderPool.Put(zw)
 	tmpBufPool.Put(tmp)
-	return buf
+	var sum []byte
+	if h != nil {
+		sum = h.Sum(nil)
+	}
+
+	return compressedEntry{buf, contentType, s, sum, size, language, entryAnchors, wordCount}
 }
 
 func writeEntryMeta(output *bufio.Writer, entries []writtenEntry) {
@@ -230,7 +996,169 @@ func writeEntryMeta(output *bufio.Writer, entries []writtenEntry) {
 	}
 
 	for _, e := range entries {
-		if _, err := output.WriteString(strconv.FormatInt(int64(e.endOffset), 10)); err != nil {
+		if _, err := output.WriteString(strconv.FormatInt(int64(e.startOffset), 10)); err != nil {
+			panic(err)
+		}
+
+		if _, err := output.WriteRune('\n'); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func writeBinaryEntryMeta(output *bufio.Writer, entries []writtenEntry) {
+	buf := binary.LittleEndian.AppendUint64(nil, uint64(len(entries)))
+	if _, err := output.Write(buf); err != nil {
+		panic(err)
+	}
+
+	for _, e := range entries {
+		if _, err := output.Write(binary.LittleEndian.AppendUint64(buf[:0], e.startOffset)); err != nil {
+			panic(err)
+		}
+	}
+
+	end := uint64(0)
+	for _, e := range entries {
+		end += uint64(len(utf16.Encode([]rune(e.name))))
+		if _, err := output.Write(binary.LittleEndian.AppendUint64(buf[:0], end)); err != nil {
+			panic(err)
+		}
+	}
+
+	for _, e := range entries {
+		for _, c := range utf16.Encode([]rune(e.name)) {
+			if _, err := output.Write(binary.LittleEndian.AppendUint16(buf[:0], c)); err != nil {
+				panic(err)
+			}
+		}
+	}
+}
+
+func writeSnippets(output *bufio.Writer, entries []writtenEntry) {
+	if _, err := output.WriteString(strconv.FormatInt(int64(len(entries)), 10)); err != nil {
+		panic(err)
+	}
+	if _, err := output.WriteRune('\n'); err != nil {
+		panic(err)
+	}
+
+	for _, e := range entries {
+		if _, err := output.WriteString(e.snippet); err != nil {
+			panic(err)
+		}
+
+		if _, err := output.WriteRune('\n'); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func writeHashes(output *bufio.Writer, entries []writtenEntry) {
+	if _, err := output.WriteString(strconv.FormatInt(int64(len(entries)), 10)); err != nil {
+		panic(err)
+	}
+	if _, err := output.WriteRune('\n'); err != nil {
+		panic(err)
+	}
+
+	for _, e := range entries {
+		if _, err := output.WriteString(hex.EncodeToString(e.hash)); err != nil {
+			panic(err)
+		}
+
+		if _, err := output.WriteRune('\n'); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func writeSizes(output *bufio.Writer, entries []writtenEntry) {
+	if _, err := output.WriteString(strconv.FormatInt(int64(len(entries)), 10)); err != nil {
+		panic(err)
+	}
+	if _, err := output.WriteRune('\n'); err != nil {
+		panic(err)
+	}
+
+	for _, e := range entries {
+		if _, err := output.WriteString(strconv.FormatUint(e.size, 10)); err != nil {
+			panic(err)
+		}
+
+		if _, err := output.WriteRune('\n'); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func writeLanguages(output *bufio.Writer, entries []writtenEntry) {
+	if _, err := output.WriteString(strconv.FormatInt(int64(len(entries)), 10)); err != nil {
+		panic(err)
+	}
+	if _, err := output.WriteRune('\n'); err != nil {
+		panic(err)
+	}
+
+	for _, e := range entries {
+		if _, err := output.WriteString(e.language); err != nil {
+			panic(err)
+		}
+
+		if _, err := output.WriteRune('\n'); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func writeWordCounts(output *bufio.Writer, entries []writtenEntry) {
+	if _, err := output.WriteString(strconv.FormatInt(int64(len(entries)), 10)); err != nil {
+		panic(err)
+	}
+	if _, err := output.WriteRune('\n'); err != nil {
+		panic(err)
+	}
+
+	for _, e := range entries {
+		if _, err := output.WriteString(strconv.FormatUint(e.wordCount, 10)); err != nil {
+			panic(err)
+		}
+
+		if _, err := output.WriteRune('\n'); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func writeAnchors(output *bufio.Writer, entries []writtenEntry) {
+	if _, err := output.WriteString(strconv.FormatInt(int64(len(entries)), 10)); err != nil {
+		panic(err)
+	}
+	if _, err := output.WriteRune('\n'); err != nil {
+		panic(err)
+	}
+
+	for _, e := range entries {
+		if _, err := output.WriteString(e.anchors); err != nil {
+			panic(err)
+		}
+
+		if _, err := output.WriteRune('\n'); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func writeContentTypes(output *bufio.Writer, entries []writtenEntry) {
+	if _, err := output.WriteString(strconv.FormatInt(int64(len(entries)), 10)); err != nil {
+		panic(err)
+	}
+	if _, err := output.WriteRune('\n'); err != nil {
+		panic(err)
+	}
+
+	for _, e := range entries {
+		if _, err := output.WriteString(e.contentType); err != nil {
 			panic(err)
 		}
 
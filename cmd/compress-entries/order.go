@@ -0,0 +1,101 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/rsookram/wiki-builder/internal/storage"
+)
+
+// entryOrder is the order that entries are written in, chosen with
+// -entry-order. The entry metadata stays in the order from index-fs (which
+// redirects refer to entries by), so only where the entries are written
+// changes.
+type entryOrder string
+
+const (
+	// orderWalk keeps the order from index-fs, which is the order that it
+	// walked the dump in (or by name with index-fs -deterministic).
+	orderWalk entryOrder = "walk-order"
+	// orderName sorts entries by name, in the same order as the index, so
+	// that reading them in the order of their keys is sequential.
+	orderName entryOrder = "name-sorted"
+	// orderSizeDescending puts the biggest entries first, so that entries of
+	// similar sizes are next to each other.
+	orderSizeDescending entryOrder = "size-descending"
+)
+
+func parseEntryOrder(s string) (entryOrder, bool) {
+	o := entryOrder(s)
+	switch o {
+	case orderWalk, orderName, orderSizeDescending:
+		return o, true
+	}
+
+	return "", false
+}
+
+// sizeLookups is the number of files whose sizes are looked up at the same
+// time for orderSizeDescending, since each is a request for object storage.
+const sizeLookups = 16
+
+// permutation returns the indexes of entries in the order to write them, or
+// nil to write them in the order they're in.
+func (o entryOrder) permutation(entries []storage.Entry) []int {
+	if o == orderWalk {
+		return nil
+	}
+
+	order := make([]int, len(entries))
+	for i := range order {
+		order[i] = i
+	}
+
+	switch o {
+	case orderName:
+		names := make([][]uint16, len(entries))
+		for i, e := range entries {
+			names[i] = e.NameUTF16()
+		}
+		slices.SortStableFunc(order, func(a, b int) int {
+			return storage.CompareUTF16(names[a], names[b])
+		})
+	case orderSizeDescending:
+		sizes := fileSizes(entries)
+		slices.SortStableFunc(order, func(a, b int) int {
+			return cmp.Compare(sizes[b], sizes[a])
+		})
+	}
+
+	return order
+}
+
+// fileSizes returns the size of the file of each entry.
+func fileSizes(entries []storage.Entry) []int64 {
+	sizes := make([]int64, len(entries))
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for range sizeLookups {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				size, err := storage.FileSize(entries[i].LocalPath)
+				if err != nil {
+					panic(fmt.Sprintf("failed to get the size of %s: %s", entries[i].LocalPath, err))
+				}
+				sizes[i] = size
+			}
+		}()
+	}
+	for i := range entries {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	return sizes
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"unicode/utf16"
+
+	"github.com/rsookram/wiki-builder/internal/storage"
+)
+
+// readWrittenEntries reads the entries which were written before being
+// cancelled from the output files in dataDir. They must be the first of
+// entries.
+func readWrittenEntries(rdr *bufio.Reader, dataDir string, entries []storage.Entry, withSnippets bool, withHashes bool, withSizes bool, withLanguages bool, withAnchors bool, withWordCounts bool) []writtenEntry {
+	meta := storage.ReadEntryMetadata(rdr, dataDir)
+	contentTypes := storage.ReadContentTypes(rdr, dataDir)
+	snippets := storage.ReadSnippets(rdr, dataDir)
+	hashes := storage.ReadHashes(rdr, dataDir)
+	sizes := storage.ReadSizes(rdr, dataDir)
+	languages := storage.ReadLanguages(rdr, dataDir)
+	anchors := storage.ReadAnchors(rdr, dataDir)
+	wordCounts := storage.ReadWordCounts(rdr, dataDir)
+
+	if meta.Len() > len(entries) || len(contentTypes) != meta.Len() {
+		panic("the output files don't match the entries from index-fs, so they can't be resumed")
+	}
+	if withSnippets && meta.Len() > 0 && snippets == nil {
+		panic("-snippets wasn't passed before being cancelled, so it can't be passed when resuming")
+	}
+	if withHashes && meta.Len() > 0 && hashes == nil {
+		panic("-hashes wasn't passed before being cancelled, so it can't be passed when resuming")
+	}
+	if withSizes && meta.Len() > 0 && sizes == nil {
+		panic("-sizes wasn't passed before being cancelled, so it can't be passed when resuming")
+	}
+	if withLanguages && meta.Len() > 0 && languages == nil {
+		panic("-languages wasn't passed before being cancelled, so it can't be passed when resuming")
+	}
+	if withAnchors && meta.Len() > 0 && anchors == nil {
+		panic("-anchors wasn't passed before being cancelled, so it can't be passed when resuming")
+	}
+	if withWordCounts && meta.Len() > 0 && wordCounts == nil {
+		panic("-word-counts wasn't passed before being cancelled, so it can't be passed when resuming")
+	}
+
+	written := make([]writtenEntry, meta.Len())
+	for i := range written {
+		name := string(utf16.Decode(meta.Name(i)))
+		if name != entries[i].Name() {
+			panic(fmt.Sprintf("entry %d is %s, but it was %s before being cancelled, so it can't be resumed", i, entries[i].Name(), name))
+		}
+
+		written[i] = writtenEntry{name: name, startOffset: meta.StartOffset(i), contentType: contentTypes[i]}
+		if withSnippets {
+			written[i].snippet = snippets[i]
+		}
+		if withHashes {
+			written[i].hash = hashes[i]
+		}
+		if withSizes {
+			written[i].size = sizes[i]
+		}
+		if withLanguages {
+			written[i].language = languages[i]
+		}
+		if withAnchors {
+			written[i].anchors = anchors[i]
+		}
+		if withWordCounts {
+			written[i].wordCount = wordCounts[i]
+		}
+	}
+
+	return written
+}
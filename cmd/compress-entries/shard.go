@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"hash/fnv"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/rsookram/wiki-builder/internal/storage"
+)
+
+// shardSpec is a subset of the entries, chosen by the hash of their names, so
+// that the entries can be compressed on multiple machines.
+type shardSpec struct {
+	index int
+	count int
+}
+
+// parseShard parses a shard given as i/n, e.g. 0/8.
+func parseShard(s string) (shardSpec, error) {
+	i, n, found := strings.Cut(s, "/")
+	if !found {
+		return shardSpec{}, fmt.Errorf("expected a shard of the form i/n, got %q", s)
+	}
+
+	index, err := strconv.Atoi(i)
+	if err != nil {
+		return shardSpec{}, fmt.Errorf("invalid shard index in %q: %w", s, err)
+	}
+	count, err := strconv.Atoi(n)
+	if err != nil {
+		return shardSpec{}, fmt.Errorf("invalid shard count in %q: %w", s, err)
+	}
+	if count < 1 || index < 0 || index >= count {
+		return shardSpec{}, fmt.Errorf("shard index must be between 0 and the shard count, got %q", s)
+	}
+
+	return shardSpec{index, count}, nil
+}
+
+// dir returns the directory in dataDir that the output for the shard is
+// written to.
+func (s shardSpec) dir(dataDir string) string {
+	return filepath.Join(dataDir, fmt.Sprintf("shard-%d-of-%d", s.index, s.count)) + string(filepath.Separator)
+}
+
+func (s shardSpec) contains(name string) bool {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return int(h.Sum32()%uint32(s.count)) == s.index
+}
+
+// selectShard returns the entries in the shard, and the redirects to them
+// with their entry indexes updated to match.
+func selectShard(s shardSpec, entries []storage.Entry, redirects []storage.Redirect) ([]storage.Entry, []storage.Redirect) {
+	var selected []storage.Entry
+	newIndexes := make([]int, len(entries))
+	for i, e := range entries {
+		if !s.contains(e.Name()) {
+			newIndexes[i] = -1
+			continue
+		}
+
+		newIndexes[i] = len(selected)
+		selected = append(selected, e)
+	}
+
+	var selectedRedirects []storage.Redirect
+	for _, r := range redirects {
+		if idx := newIndexes[r.EntryIdx]; idx >= 0 {
+			selectedRedirects = append(selectedRedirects, storage.Redirect{NameUTF16: r.NameUTF16, EntryIdx: idx})
+		}
+	}
+
+	return selected, selectedRedirects
+}
+
+// writeRedirects writes redirects in the same format as index-fs, so that
+// wiki-builder can read them from the directory for the shard.
+func writeRedirects(output *bufio.Writer, redirects []storage.Redirect) {
+	if _, err := output.WriteString(strconv.FormatInt(int64(len(redirects)), 10)); err != nil {
+		panic(err)
+	}
+	if _, err := output.WriteRune('\n'); err != nil {
+		panic(err)
+	}
+
+	for _, r := range redirects {
+		if _, err := output.WriteString(string(utf16.Decode(r.NameUTF16))); err != nil {
+			panic(err)
+		}
+		if _, err := output.WriteRune('\t'); err != nil {
+			panic(err)
+		}
+
+		if _, err := output.WriteString(strconv.FormatInt(int64(r.EntryIdx), 10)); err != nil {
+			panic(err)
+		}
+		if _, err := output.WriteRune('\n'); err != nil {
+			panic(err)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/rsookram/wiki-builder/internal/storage"
+)
+
+// maxLoggedAliases is the number of aliases which are logged for each reason
+// they're skipped.
+const maxLoggedAliases = 20
+
+// readAliases reads the aliases from the file at path, which has an alias, a
+// tab, and the name of the entry it refers to on each line. Empty lines and
+// lines starting with # are ignored.
+func readAliases(path string) []rawRedirect {
+	f, err := os.Open(path)
+	if err != nil {
+		panic(fmt.Sprintf("Error reading aliases: %s", err))
+	}
+	defer f.Close()
+
+	var aliases []rawRedirect
+	scanner := bufio.NewScanner(f)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name, entryName, found := strings.Cut(line, "\t")
+		if !found || name == "" || entryName == "" || strings.Contains(entryName, "\t") {
+			panic(fmt.Sprintf("%s:%d: expected an alias, a tab, and the name of an entry", path, lineNum))
+		}
+
+		aliases = append(aliases, rawRedirect{name, entryName})
+	}
+	if err := scanner.Err(); err != nil {
+		panic(err)
+	}
+
+	return aliases
+}
+
+// addAliases returns redirects with the aliases added to it. An alias can
+// refer to an entry, or to the name of a redirect. Aliases which don't refer
+// to either, or whose names are already used by an entry or a redirect to
+// another entry, are skipped and logged.
+func addAliases(redirects []redirect, aliases []rawRedirect, entries []entry, normalization storage.Normalization) []redirect {
+	entryToID := make(map[string]int, len(entries))
+	for i, e := range entries {
+		entryToID[e.name] = i
+	}
+	redirectToID := make(map[string]int, len(redirects))
+	for _, r := range redirects {
+		redirectToID[r.name] = r.entryIdx
+	}
+
+	skipped := make(map[string]int)
+	skip := func(reason string, a rawRedirect) {
+		skipped[reason]++
+		if skipped[reason] <= maxLoggedAliases {
+			log.Printf("Skipping alias %q -> %q: %s", a.name, a.entryName, reason)
+		}
+	}
+
+	numAdded := 0
+	for _, a := range aliases {
+		name := normalization.Apply(a.name)
+		if tooLong(name) {
+			skip("the alias is too long", a)
+			continue
+		}
+
+		target, found := entryToID[normalization.Apply(a.entryName)]
+		if !found {
+			target, found = redirectToID[normalization.Apply(a.entryName)]
+		}
+		if !found {
+			skip("there's no entry with that name", a)
+			continue
+		}
+
+		if _, found := entryToID[name]; found {
+			skip("there's already an entry with that name", a)
+			continue
+		}
+		if existing, found := redirectToID[name]; found {
+			if existing != target {
+				skip("there's already a redirect to another entry with that name", a)
+			}
+			continue
+		}
+
+		redirectToID[name] = target
+		redirects = append(redirects, redirect{name: name, entryIdx: target})
+		numAdded++
+	}
+
+	for reason, n := range skipped {
+		if n > maxLoggedAliases {
+			log.Printf("Skipped %d aliases because %s", n, reason)
+		}
+	}
+	log.Println("Added", numAdded, "of", len(aliases), "aliases")
+
+	return redirects
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"archive/tar"
+	"errors"
+	"io"
+	"log"
+	"path"
+	"strings"
+
+	"github.com/rsookram/wiki-builder/internal/storage"
+)
+
+// archiveFile is a file in a dump in an archive.
+type archiveFile struct {
+	// localPath is the name of the file in the archive, which
+	// compress-entries reads it by.
+	localPath string
+	// path is relative to the root of the dump, e.g. A/Tokyo.html.
+	path string
+	size int64
+	// content is only set for files smaller than the maximum size of
+	// redirects, which are read while walking the archive.
+	content []byte
+	// linkTarget is the path of the file that a hard or symbolic link
+	// points to, relative to the root of the dump.
+	linkTarget string
+}
+
+// walkArchive calls fn with every file in the dump in the tar archive at
+// archivePath, in one pass. The contents of files smaller than redirectMaxSize
+// are read, so that redirects can be detected without reading the archive
+// again. It stops early if fn returns an error, and returns it.
+func walkArchive(archivePath string, redirectMaxSize int64, fn func(archiveFile) error) error {
+	a, err := storage.OpenArchive(archivePath)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	for {
+		h, err := a.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		p, found := storage.DumpPath(h.Name)
+		if !found {
+			continue
+		}
+
+		f := archiveFile{localPath: h.Name, path: p, size: h.Size}
+		switch h.Typeflag {
+		case tar.TypeReg:
+			if h.Size < redirectMaxSize {
+				f.content, err = io.ReadAll(a)
+				if err != nil {
+					return err
+				}
+			}
+		case tar.TypeLink:
+			f.linkTarget, found = storage.DumpPath(h.Linkname)
+			if !found {
+				log.Println("Skipping link which points outside of the dump:", h.Name)
+				continue
+			}
+		case tar.TypeSymlink:
+			// In a directory, a link to a directory redirects each
+			// file within it, but those aren't known until the whole
+			// archive has been read, so only links to files are
+			// supported.
+			target := path.Join(path.Dir(p), h.Linkname)
+			if path.IsAbs(h.Linkname) || target == ".." || strings.HasPrefix(target, "../") {
+				log.Println("Skipping symbolic link which points outside of the dump:", h.Name)
+				continue
+			}
+			f.linkTarget = target
+		default:
+			continue
+		}
+
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package main
+
+import "io/fs"
+
+// fileID identifies a file on disk, so that hard links to the same file can be
+// found.
+type fileID struct{}
+
+// hardLinkID returns false since hard links can't be detected, so they're
+// stored as separate entries.
+func hardLinkID(info fs.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
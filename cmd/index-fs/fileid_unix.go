@@ -0,0 +1,26 @@
+//go:build unix
+
+package main
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// fileID identifies a file on disk, so that hard links to the same file can be
+// found.
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+// hardLinkID returns the ID of the file with info, and whether it has more than
+// one hard link.
+func hardLinkID(info fs.FileInfo) (fileID, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || st.Nlink < 2 {
+		return fileID{}, false
+	}
+
+	return fileID{dev: uint64(st.Dev), ino: uint64(st.Ino)}, true
+}
@@ -1,10 +1,16 @@
-// Input: Path of directory to dumped wiki contents
+// Input: Path of directory to dumped wiki contents, a tar archive of them
+// (optionally compressed with gzip or zstd), or the URL of a prefix in
+// S3-compatible object storage with them (e.g. s3://bucket/dump/)
 //
 // Output files:
 //
 // Entries
 // - number of entries in base-10 as a string, newline
-// - newline separated entries (each is a path to the file on disk)
+// - newline separated entries
+//   - path to the file on disk (or its URL in object storage, or its name in
+//     the archive)
+//   - tab separator
+//   - name of the entry (after normalization), newline
 //
 // Redirects
 // - number of redirects in base-10 as a string, newline
@@ -13,27 +19,63 @@
 //   - tab separator
 //   - index into entries from above in base-10 as a string, newline
 //
+// Skipped titles (entries and redirects whose names are too long for the index)
+// - number of skipped titles in base-10 as a string, newline
+// - newline separated titles
+//   - "entry" or "redirect"
+//   - tab separator
+//   - name (after normalization), newline
+//
+// The output files are written to the input directory (or to the directory
+// passed with -output-dir), or to .wiki-builder/<name> within it with -workdir
+// <name>. The directory is locked while they're written, so that a concurrent
+// build in it fails instead of overwriting them. With -output-dir -, they're
+// written to stdout as a tar archive instead.
+//
 // All strings are encoded in UTF-8
 package main
 
 import (
 	"bufio"
+	"errors"
 	"flag"
-	"io"
+	"fmt"
 	"io/fs"
-	"net/url"
+	"log"
 	"os"
+	"path"
 	"path/filepath"
 	"runtime/pprof"
+	"slices"
 	"strconv"
 	"strings"
-	"unicode/utf16"
+
+	"github.com/rsookram/wiki-builder/internal/dryrun"
+	"github.com/rsookram/wiki-builder/internal/progress"
+	"github.com/rsookram/wiki-builder/internal/s3"
+	"github.com/rsookram/wiki-builder/internal/storage"
 )
 
 var cpuprofile = flag.String("cpuprofile", "", "write cpu profile to file")
 var memprofile = flag.String("memprofile", "", "write memory profile to this file")
+var filter storage.NameFilter
+var normalization storage.Normalization
+var redirectMaxSize = flag.Int64("redirect-max-size", 1024, "files smaller than this many bytes are checked for whether they're redirects")
+var progressFD = flag.Int("progress-fd", -1, "write progress events as lines of JSON to this file descriptor")
+var controlFD = flag.Int("control-fd", -1, "read commands from this file descriptor; \"cancel\" stops without writing the output")
+var aliasesPath = flag.String("aliases", "", "a file with an alias, a tab, and the name of an entry on each line, to add as redirects (e.g. common misspellings)")
+var dryRun = flag.Bool("dry-run", false, "walk the dump and report the sizes of the output files and any problems, without writing them")
+var maxSkipped = flag.Int("max-skipped", -1, "fail if more than this many entries and redirects are skipped because their names are too long (-1 for no limit)")
+var dedupeResources = flag.Bool("dedupe-resources", false, "store resources (entries which aren't HTML, e.g. images) with the same contents once, with the others as redirects to it")
+var pruneUnused = flag.Bool("prune-unused", false, "drop resources (entries which aren't HTML, e.g. images) which no page or stylesheet refers to, along with the redirects to them")
+var stageOutputDir = flag.String("output-dir", "", "write the output to this directory instead of the data directory, e.g. when the dump is on a read-only mount, or - to write it to stdout as a tar archive to pipe into compress-entries")
+var workdir = flag.String("workdir", "", "write the output to the directory with this name in .wiki-builder in the data directory, so that builds with different -workdir (e.g. with different flags) can run at the same time")
+var deterministic = flag.Bool("deterministic", false, "sort entries and redirects by name so that the output doesn't depend on the order of the file system walk")
 
 func main() {
+	flag.Var(&filter.Include, "include", "only keep entries whose names match this glob pattern (can be repeated)")
+	flag.Var(&filter.Exclude, "exclude", "drop entries whose names match this glob pattern (can be repeated)")
+	flag.Var(&normalization, "normalize", "comma-separated list of normalizations to apply to names: percent, spaces, underscores, nfc")
 	flag.Parse()
 	if *cpuprofile != "" {
 		f, err := os.Create(*cpuprofile)
@@ -48,23 +90,102 @@ func main() {
 	if dataDir == "" {
 		panic("missing required arguments")
 	}
+	if s3.IsURL(dataDir) && !strings.HasSuffix(dataDir, "/") && !storage.IsArchive(dataDir) {
+		dataDir += "/"
+	}
+	if storage.IsArchive(dataDir) && (*dedupeResources || *pruneUnused) {
+		panic("-dedupe-resources and -prune-unused can't be used with archives, since they read the entries again")
+	}
+
+	streamed := *stageOutputDir == storage.Stream
+	var stageDir string
+	if streamed {
+		stageDir = storage.TempStageDir()
+		defer os.RemoveAll(stageDir)
+	} else {
+		stageDir = storage.StageDir(dataDir, *stageOutputDir, *workdir)
+	}
+	if !*dryRun {
+		defer storage.LockStageDir(stageDir, false)()
+	}
+
+	// Read the aliases first so that mistakes in the file are found before
+	// walking the dump.
+	var aliases []rawRedirect
+	if *aliasesPath != "" {
+		aliases = readAliases(*aliasesPath)
+	}
+
+	reporter := progress.New("index-fs", *progressFD, *controlFD)
+	reporter.Start()
+
+	entries, redirects, skipped := readData(dataDir, filter, normalization, *redirectMaxSize, *deterministic, reporter)
+	if reporter.IsCancelled() {
+		// The output from a previous run is left as it was.
+		if streamed {
+			os.RemoveAll(stageDir)
+		}
+		reporter.Exit()
+	}
+
+	var skippedFile *storage.PartialFile
+	if len(skipped) > 0 {
+		log.Println("Skipped", len(skipped), "entries and redirects whose names are too long")
+	}
+	if *dryRun {
+		for _, s := range skipped[:min(len(skipped), 20)] {
+			log.Println("Skipped", s.kind, s.name)
+		}
+	} else {
+		skippedFile = writeSkippedTitles(stageDir, skipped)
+		defer skippedFile.Discard()
+	}
+	if *maxSkipped >= 0 && len(skipped) > *maxSkipped {
+		// The report is kept so that the skipped titles can be seen.
+		if skippedFile != nil {
+			skippedFile.Commit()
+		}
+		panic(fmt.Sprintf("%d titles were skipped, which is more than -max-skipped (%d). See %s", len(skipped), *maxSkipped, filepath.Join(stageDir, "stage-0-skipped.txt")))
+	}
 
-	entriesFile, err := os.Create(filepath.Join(dataDir, "stage-0-entries.txt"))
+	if aliases != nil {
+		redirects = addAliases(redirects, aliases, entries, normalization)
+	}
+
+	if *dedupeResources || *pruneUnused {
+		entries, redirects = processResources(entries, redirects, normalization, *dedupeResources, *pruneUnused)
+	}
+
+	if *dryRun {
+		reportDryRun(stageDir, entries, redirects)
+		reporter.Finish()
+		return
+	}
+
+	if reporter.IsCancelled() {
+		skippedFile.Discard()
+		if streamed {
+			os.RemoveAll(stageDir)
+		}
+		reporter.Exit()
+	}
+
+	// The stage files are only moved into place once they're all written, so
+	// that the entries and redirects from different runs aren't mixed.
+	entriesFile, err := storage.CreatePartial(filepath.Join(stageDir, "stage-0-entries.txt"))
 	if err != nil {
 		panic(err)
 	}
-	defer entriesFile.Close()
+	defer entriesFile.Discard()
 
-	redirectsFile, err := os.Create(filepath.Join(dataDir, "stage-0-redirects.txt"))
+	redirectsFile, err := storage.CreatePartial(filepath.Join(stageDir, "stage-0-redirects.txt"))
 	if err != nil {
 		panic(err)
 	}
-	defer redirectsFile.Close()
+	defer redirectsFile.Discard()
 
 	output := bufio.NewWriterSize(entriesFile, 1024*1024)
 
-	entries, redirects := readData(dataDir)
-
 	writeEntries(output, entries)
 
 	if err := output.Flush(); err != nil {
@@ -79,6 +200,18 @@ func main() {
 		panic(err)
 	}
 
+	for _, f := range []*storage.PartialFile{skippedFile, entriesFile, redirectsFile} {
+		if err := f.Commit(); err != nil {
+			panic(err)
+		}
+	}
+
+	if streamed {
+		storage.SendStage(os.Stdout, stageDir)
+	}
+
+	reporter.Finish()
+
 	if *memprofile != "" {
 		f, err := os.Create(*memprofile)
 		if err != nil {
@@ -90,6 +223,27 @@ func main() {
 	}
 }
 
+// reportDryRun logs what would be written for entries and redirects.
+func reportDryRun(stageDir string, entries []entry, redirects []redirect) {
+	log.Println("Found", len(entries), "entries and", len(redirects), "redirects")
+
+	var c dryrun.Counter
+	output := bufio.NewWriter(&c)
+
+	writeEntries(output, entries)
+	if err := output.Flush(); err != nil {
+		panic(err)
+	}
+	dryrun.Report(filepath.Join(stageDir, "stage-0-entries.txt"), c.N)
+
+	c.N = 0
+	writeRedirects(output, redirects)
+	if err := output.Flush(); err != nil {
+		panic(err)
+	}
+	dryrun.Report(filepath.Join(stageDir, "stage-0-redirects.txt"), c.N)
+}
+
 func writeEntries(output *bufio.Writer, entries []entry) {
 	if _, err := output.WriteString(strconv.FormatInt(int64(len(entries)), 10)); err != nil {
 		panic(err)
@@ -102,6 +256,13 @@ func writeEntries(output *bufio.Writer, entries []entry) {
 		if _, err := output.WriteString(e.localPath); err != nil {
 			panic(err)
 		}
+		if _, err := output.WriteRune('\t'); err != nil {
+			panic(err)
+		}
+
+		if _, err := output.WriteString(e.name); err != nil {
+			panic(err)
+		}
 
 		if _, err := output.WriteRune('\n'); err != nil {
 			panic(err)
@@ -136,6 +297,7 @@ func writeRedirects(output *bufio.Writer, redirects []redirect) {
 
 type entry struct {
 	localPath string
+	name      string
 }
 
 type exceptionEntry struct {
@@ -154,84 +316,264 @@ type redirect struct {
 	entryIdx int
 }
 
-func readData(dataDir string) ([]entry, []redirect) {
-	dir := filepath.Join(dataDir, "A")
-
+// readData walks the dump in dataDir (which can be the URL of a prefix in
+// object storage) for entries and redirects, along with the ones which were
+// skipped because their names are too long. The walk stops early if the
+// reporter is cancelled.
+func readData(dataDir string, filter storage.NameFilter, normalization storage.Normalization, redirectMaxSize int64, deterministic bool, reporter *progress.Reporter) ([]entry, []redirect, []skippedTitle) {
 	var entries []entry
 	entryToID := make(map[string]int)
 	var rawRedirects []rawRedirect
-	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
-		if d.IsDir() {
-			return nil
+	var skipped []skippedTitle
+	// hardLinks are the names of the first files found with more than one hard
+	// link, so that the others can be redirects to them.
+	hardLinks := make(map[fileID]string)
+
+	// addFile adds the file at localPath, with the entry name, as an entry or
+	// a redirect. info is only set for local files, and content is only set
+	// for small files in archives.
+	addFile := func(localPath string, name string, size int64, info fs.FileInfo, content []byte) {
+		// Check for redirect
+		if target, found := detectRedirect(localPath, name, size, redirectMaxSize, content); found {
+			rawRedirects = append(rawRedirects, rawRedirect{name, resolveTarget(name, target)})
+			return
 		}
 
-		info, err := d.Info()
-		if err != nil {
+		rawName := name
+		name = normalization.Apply(name)
+		if !filter.Keep(name) {
+			return
+		}
+		if tooLong(name) {
+			skipped = append(skipped, skippedTitle{"entry", name})
+			return
+		}
+
+		if info != nil {
+			if id, linked := hardLinkID(info); linked {
+				if first, found := hardLinks[id]; found {
+					// The contents would be the same, so it's only
+					// stored once.
+					rawRedirects = append(rawRedirects, rawRedirect{rawName, first})
+					return
+				}
+				hardLinks[id] = rawName
+			}
+		}
+
+		entryToID[name] = len(entries)
+		entries = append(entries, entry{localPath: localPath, name: name})
+	}
+
+	numFiles := 0
+	var exceptions []exceptionFile
+	if storage.IsArchive(dataDir) {
+		err := walkArchive(dataDir, redirectMaxSize, func(f archiveFile) error {
+			if reporter.IsCancelled() {
+				return errCancelled
+			}
+
+			if fileName, found := strings.CutPrefix(f.path, "_exceptions/"); found {
+				// Like a directory, only the files directly within it
+				// are included.
+				if !strings.Contains(fileName, "/") && f.linkTarget == "" {
+					exceptions = append(exceptions, exceptionFile{f.localPath, fileName, f.size, f.content})
+				}
+				return nil
+			}
+
+			name, found := strings.CutPrefix(f.path, "A/")
+			if !found {
+				return nil
+			}
+
+			numFiles++
+			reporter.Update(numFiles, 0)
+
+			if f.linkTarget != "" {
+				target, found := strings.CutPrefix(f.linkTarget, "A/")
+				if !found {
+					log.Println("Skipping link which points outside of the dump:", f.localPath)
+					return nil
+				}
+
+				rawRedirects = append(rawRedirects, rawRedirect{name: name, entryName: target})
+				return nil
+			}
+
+			addFile(f.localPath, name, f.size, nil, f.content)
+			return nil
+		})
+		if err != nil && !errors.Is(err, errCancelled) {
 			panic(err)
 		}
+	} else if s3.IsURL(dataDir) {
+		dir := dataDir + "A/"
+		err := walkObjects(dir, func(localPath string, size int64) error {
+			if reporter.IsCancelled() {
+				return errCancelled
+			}
 
-		name, _ := strings.CutPrefix(path, dir+"/")
+			numFiles++
+			reporter.Update(numFiles, 0)
 
-		nameUTF16 := utf16.Encode([]rune(name))
-		if len(nameUTF16) > 127 {
+			addFile(localPath, strings.TrimPrefix(localPath, dir), size, nil, nil)
 			return nil
+		})
+		if err != nil && !errors.Is(err, errCancelled) {
+			panic(err)
+		}
+	} else {
+		dir := filepath.Join(dataDir, "A")
+		resolvedDir, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			panic(err)
 		}
 
-		// Check for redirect
-		fileSize := info.Size()
-		if fileSize < 1024 {
-			target := getRedirect(path, fileSize)
-			originalTarget := target
-			if target == ".." {
-				target = filepath.Dir(name)
-			} else if target == "../.." {
-				// This case is extremely rare (one instance in the small version), and
-				// this way of handling it seems fine.
-				target = filepath.Dir(name)
+		err = filepath.WalkDir(dir, func(localPath string, d fs.DirEntry, err error) error {
+			if reporter.IsCancelled() {
+				return filepath.SkipAll
 			}
+			if d.IsDir() {
+				return nil
+			}
+
+			numFiles++
+			reporter.Update(numFiles, 0)
 
-			if strings.HasPrefix(target, "../") {
-				// Example:
-				// - name: JAWS/ジョーズ
-				// - target: ../ジョーズ
-				// - newTarget: ジョーズ
-				newTarget := filepath.Join(filepath.Dir(name), target)
-				// Sometimes there's an extra "../", so remove it.
-				target, _ = strings.CutPrefix(newTarget, "../")
+			if d.Type()&fs.ModeSymlink != 0 {
+				rawRedirects = append(rawRedirects, symlinkRedirects(dir, resolvedDir, localPath)...)
+				return nil
 			}
 
-			if strings.Contains(name, "/") && !strings.HasPrefix(originalTarget, "..") {
-				target = filepath.Join(filepath.Dir(name), target)
+			info, err := d.Info()
+			if err != nil {
+				panic(err)
 			}
 
-			rawRedirects = append(rawRedirects, rawRedirect{name, target})
+			addFile(localPath, entryName(dir, localPath), info.Size(), info, nil)
 			return nil
+		})
+		if err != nil {
+			panic(err)
 		}
+	}
 
-		entryToID[name] = len(entries)
-		entries = append(entries, entry{localPath: path})
-
-		return nil
-	})
-	if err != nil {
-		panic(err)
+	if !storage.IsArchive(dataDir) {
+		exceptions = listExceptions(dataDir)
 	}
 
-	exceptionEntries, exceptionRawRedirects := processExceptions(dataDir)
+	exceptionEntries, exceptionRawRedirects := processExceptions(exceptions, redirectMaxSize)
 	for _, e := range exceptionEntries {
-		entryToID[e.name] = len(entries)
-		entries = append(entries, entry{e.localPath})
+		name := normalization.Apply(e.name)
+		if !filter.Keep(name) {
+			continue
+		}
+		if tooLong(name) {
+			skipped = append(skipped, skippedTitle{"entry", name})
+			continue
+		}
+
+		entryToID[name] = len(entries)
+		entries = append(entries, entry{e.localPath, name})
 	}
 	for _, r := range exceptionRawRedirects {
 		rawRedirects = append(rawRedirects, r)
 	}
 
-	redirects := createRedirects(rawRedirects, entryToID)
+	if deterministic {
+		slices.SortStableFunc(entries, func(a, b entry) int {
+			return strings.Compare(a.name, b.name)
+		})
+		for i, e := range entries {
+			entryToID[e.name] = i
+		}
+	}
 
-	return entries, redirects
+	redirects, skippedRedirects := createRedirects(rawRedirects, entryToID, normalization)
+	skipped = append(skipped, skippedRedirects...)
+
+	if deterministic {
+		slices.SortStableFunc(redirects, func(a, b redirect) int {
+			return strings.Compare(a.name, b.name)
+		})
+		slices.SortStableFunc(skipped, func(a, b skippedTitle) int {
+			return strings.Compare(a.name, b.name)
+		})
+	}
+
+	return entries, redirects, skipped
 }
 
-func processExceptions(dataDir string) ([]exceptionEntry, []rawRedirect) {
+// resolveTarget returns the name of the entry that the redirect named name
+// refers to with target, which is relative to the redirect's directory.
+func resolveTarget(name string, target string) string {
+	originalTarget := target
+	if target == ".." || target == "../.." {
+		// "../.." is extremely rare (one instance in the small version), and
+		// treating it like ".." seems fine.
+		target = path.Dir(name)
+	}
+
+	if strings.HasPrefix(target, "../") {
+		// Example:
+		// - name: JAWS/ジョーズ
+		// - target: ../ジョーズ
+		// - newTarget: ジョーズ
+		newTarget := path.Join(path.Dir(name), target)
+		// Sometimes there's an extra "../", so remove it.
+		target, _ = strings.CutPrefix(newTarget, "../")
+	}
+
+	if strings.Contains(name, "/") && !strings.HasPrefix(originalTarget, "..") {
+		target = path.Join(path.Dir(name), target)
+	}
+
+	return target
+}
+
+// entryName returns the name of the entry for the file at localPath within
+// dir. Names always use forward slashes, whatever the separator of the OS is.
+func entryName(dir string, localPath string) string {
+	rel, err := filepath.Rel(dir, localPath)
+	if err != nil {
+		panic(err)
+	}
+
+	return filepath.ToSlash(rel)
+}
+
+// exceptionFile is a file in the _exceptions directory of a dump.
+type exceptionFile struct {
+	localPath string
+	name      string
+	size      int64
+	// content is only set for small files in archives, like for
+	// detectRedirect.
+	content []byte
+}
+
+// listExceptions returns the files in the _exceptions directory in dataDir.
+func listExceptions(dataDir string) []exceptionFile {
+	var files []exceptionFile
+
+	if s3.IsURL(dataDir) {
+		dir := dataDir + "_exceptions/"
+		err := walkObjects(dir, func(localPath string, size int64) error {
+			// Like a directory, only the files directly within it are
+			// included.
+			if name := strings.TrimPrefix(localPath, dir); !strings.Contains(name, "/") {
+				files = append(files, exceptionFile{localPath: localPath, name: name, size: size})
+			}
+			return nil
+		})
+		if err != nil {
+			panic(err)
+		}
+
+		return files
+	}
+
 	dir := filepath.Join(dataDir, "_exceptions")
 
 	dirEntries, err := os.ReadDir(dir)
@@ -239,57 +581,40 @@ func processExceptions(dataDir string) ([]exceptionEntry, []rawRedirect) {
 		panic(err)
 	}
 
-	var entries []exceptionEntry
-	var rawRedirects []rawRedirect
-
 	for _, dirEntry := range dirEntries {
 		info, err := dirEntry.Info()
 		if err != nil {
 			panic(err)
 		}
 
-		fileName := dirEntry.Name()
-		if strings.HasPrefix(fileName, "X") {
-			continue
-		}
+		files = append(files, exceptionFile{localPath: filepath.Join(dir, dirEntry.Name()), name: dirEntry.Name(), size: info.Size()})
+	}
 
-		localPath := filepath.Join(dir, fileName)
-		path := strings.Replace(fileName, "%2f", "/", -1)
+	return files
+}
 
-		entryName, _ := strings.CutPrefix(path, "A/")
+func processExceptions(files []exceptionFile, redirectMaxSize int64) ([]exceptionEntry, []rawRedirect) {
+	var entries []exceptionEntry
+	var rawRedirects []rawRedirect
 
-		nameUTF16 := utf16.Encode([]rune(entryName))
-		if len(nameUTF16) > 127 {
+	for _, file := range files {
+		fileName := file.name
+		if strings.HasPrefix(fileName, "X") {
 			continue
 		}
 
+		localPath := file.localPath
+		name := strings.Replace(fileName, "%2f", "/", -1)
+
+		entryName, _ := strings.CutPrefix(name, "A/")
+
 		// Check for redirect
-		fileSize := info.Size()
-		if fileSize < 1024 {
-			target := getRedirect(localPath, fileSize)
-			originalTarget := target
-			if target == ".." {
-				target = filepath.Dir(entryName)
-			} else if target == "../.." {
-				target = filepath.Dir(entryName)
-			} else if target == "/" {
+		if target, found := detectRedirect(localPath, entryName, file.size, redirectMaxSize, file.content); found {
+			if target == "/" {
 				// I've only seen one case of this in the small version.
 				target = entryName + "/"
 			}
-
-			if strings.HasPrefix(target, "../") {
-				// Example:
-				// - name: JAWS/ジョーズ
-				// - target: ../ジョーズ
-				// - newTarget: ジョーズ
-				newTarget := filepath.Join(filepath.Dir(entryName), target)
-				target, _ = strings.CutPrefix(newTarget, "../")
-			}
-
-			if strings.Contains(entryName, "/") && !strings.HasPrefix(originalTarget, "..") {
-				target = filepath.Join(filepath.Dir(entryName), target)
-			}
-
+			target = resolveTarget(entryName, target)
 			target, _ = strings.CutPrefix(target, "/")
 
 			rawRedirects = append(rawRedirects, rawRedirect{entryName, target})
@@ -305,48 +630,30 @@ func processExceptions(dataDir string) ([]exceptionEntry, []rawRedirect) {
 	return entries, rawRedirects
 }
 
-func createRedirects(rawRedirects []rawRedirect, entryToID map[string]int) []redirect {
+// createRedirects resolves the targets of rawRedirects, returning the
+// redirects along with the ones which were skipped because their names are too
+// long. Redirects to missing entries are dropped.
+func createRedirects(rawRedirects []rawRedirect, entryToID map[string]int, normalization storage.Normalization) ([]redirect, []skippedTitle) {
 	redirects := make([]redirect, 0, len(rawRedirects))
+	var skipped []skippedTitle
 	for _, r := range rawRedirects {
-		if t, found := entryToID[r.entryName]; found {
-			redirects = append(redirects, redirect{name: r.name, entryIdx: t})
+		t, found := entryToID[normalization.Apply(r.entryName)]
+		if !found {
+			continue
 		}
-	}
-
-	return redirects
-}
-
-var redirectBuf = make([]byte, 1024)
-
-func getRedirect(path string, size int64) string {
-	f, err := os.Open(path)
-	if err != nil {
-		panic(err)
-	}
 
-	_, err = io.ReadAtLeast(f, redirectBuf, int(size))
-	if err != nil {
-		panic(err)
-	}
-
-	content := string(redirectBuf[:size])
-	startStr := `http-equiv="refresh" content="0;url=`
-	idx := strings.Index(content, startStr)
-	if idx < 0 {
-		panic("couldn't find startStr")
-	}
-
-	content = content[idx+len(startStr):]
+		name := normalization.Apply(r.name)
+		if tooLong(name) {
+			skipped = append(skipped, skippedTitle{"redirect", name})
+			continue
+		}
 
-	end := strings.IndexByte(content, '"')
-	if end < 0 {
-		panic("couldn't find end quote")
+		redirects = append(redirects, redirect{name: name, entryIdx: t})
 	}
 
-	unescaped, err := url.PathUnescape(content[:end])
-	if err != nil {
-		panic(err)
+	if n := len(rawRedirects) - len(redirects) - len(skipped); n > 0 {
+		log.Println("Dropped", n, "redirects to entries which aren't in the dump (or were excluded)")
 	}
 
-	return unescaped
+	return redirects, skipped
 }
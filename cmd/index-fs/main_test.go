@@ -0,0 +1,71 @@
+package main
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestEntryName(t *testing.T) {
+	tests := []struct {
+		dir       string
+		localPath string
+		want      string
+		// windows is whether the paths only have separators on Windows.
+		windows bool
+	}{
+		{dir: "dump/A", localPath: "dump/A/Apple", want: "Apple"},
+		{dir: "dump/A", localPath: "dump/A/JAWS/ジョーズ", want: "JAWS/ジョーズ"},
+		{dir: "dump/A", localPath: "dump/A/a/b/c", want: "a/b/c"},
+		{dir: "/data/dump/A/", localPath: "/data/dump/A/Dir/Page", want: "Dir/Page"},
+		{dir: `dump\A`, localPath: `dump\A\Apple`, want: "Apple", windows: true},
+		{dir: `C:\dumps\wiki\A`, localPath: `C:\dumps\wiki\A\JAWS\ジョーズ`, want: "JAWS/ジョーズ", windows: true},
+		{dir: `C:\dumps\wiki\A`, localPath: `C:\dumps\wiki\A\a\b\c`, want: "a/b/c", windows: true},
+	}
+
+	for _, tt := range tests {
+		if tt.windows && runtime.GOOS != "windows" {
+			continue
+		}
+
+		if got := entryName(tt.dir, tt.localPath); got != tt.want {
+			t.Errorf("entryName(%q, %q) = %q, want %q", tt.dir, tt.localPath, got, tt.want)
+		}
+	}
+}
+
+func TestEntryNameKeepsBackslashesOnUnix(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("backslashes are separators on Windows")
+	}
+
+	// Backslashes are part of file names rather than separators, so they're
+	// part of the entry name too.
+	if got, want := entryName("dump/A", `dump/A/C:\Windows`), `C:\Windows`; got != want {
+		t.Errorf("entryName = %q, want %q", got, want)
+	}
+}
+
+func TestResolveTarget(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		want   string
+	}{
+		{name: "Apple", target: "Cherry", want: "Cherry"},
+		{name: "Apple", target: "../Cherry", want: "Cherry"},
+		{name: "JAWS/ジョーズ", target: "../ジョーズ", want: "ジョーズ"},
+		{name: "Fruit/Apple", target: "Cherry", want: "Fruit/Cherry"},
+		{name: "Fruit/Apple", target: "../Cherry", want: "Cherry"},
+		{name: "Fruit/Red/Apple", target: "../Cherry", want: "Fruit/Cherry"},
+		{name: "Fruit/Apple", target: "./Cherry", want: "Fruit/Cherry"},
+		{name: "Fruit/Apple", target: "..", want: "Fruit"},
+		{name: "Fruit/Red/Apple", target: "../..", want: "Fruit/Red"},
+		{name: "Fruit/Apple", target: "../../Cherry", want: "Cherry"},
+	}
+
+	for _, tt := range tests {
+		if got := resolveTarget(tt.name, tt.target); got != tt.want {
+			t.Errorf("resolveTarget(%q, %q) = %q, want %q", tt.name, tt.target, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/rsookram/wiki-builder/internal/s3"
+	"github.com/rsookram/wiki-builder/internal/storage"
+)
+
+// errCancelled stops walkObjects when the reporter is cancelled.
+var errCancelled = errors.New("cancelled")
+
+// walkObjects calls fn with the URL and size of every object in object storage
+// whose URL starts with dir (e.g. s3://bucket/dump/A/), in the order of their
+// keys. Markers for directories (keys ending with a slash) are skipped.
+func walkObjects(dir string, fn func(localPath string, size int64) error) error {
+	bucket, prefix, err := s3.ParseURL(dir)
+	if err != nil {
+		return err
+	}
+
+	return storage.S3().List(context.Background(), bucket, prefix, func(o s3.Object) error {
+		if strings.HasSuffix(o.Key, "/") {
+			return nil
+		}
+
+		return fn(s3.Scheme+bucket+"/"+o.Key, o.Size)
+	})
+}
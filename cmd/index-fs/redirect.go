@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/url"
+	"path"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+
+	"github.com/rsookram/wiki-builder/internal/storage"
+)
+
+// detectRedirect checks whether the file at path, which is the entry named
+// name, is a redirect, returning its (unescaped) target if it is. Only files
+// smaller than maxSize are considered to be redirects. If content isn't nil,
+// it's the contents of the file, which were already read (e.g. from an
+// archive). It's safe to call concurrently.
+func detectRedirect(path string, name string, size int64, maxSize int64, content []byte) (string, bool) {
+	if size >= maxSize {
+		return "", false
+	}
+
+	if content == nil {
+		// The file could have grown since its size was read, so no more
+		// than maxSize bytes are read.
+		f, err := storage.OpenHead(path, maxSize)
+		if err != nil {
+			panic(err)
+		}
+		defer f.Close()
+
+		content, err = io.ReadAll(f)
+		if err != nil {
+			panic(err)
+		}
+	}
+	if int64(len(content)) >= maxSize {
+		return "", false
+	}
+
+	target, found := parseRedirect(content, name)
+	if !found {
+		return "", false
+	}
+
+	unescaped, err := url.PathUnescape(target)
+	if err != nil {
+		log.Println("Invalid redirect target, so treating it as an entry:", path, err)
+		return "", false
+	}
+
+	return unescaped, true
+}
+
+// parseRedirect finds the target of a redirect page in content, which is the
+// entry named name. The target of a meta refresh is used if there is one.
+// Otherwise a relative canonical link is used, unless it refers to the page
+// itself (which stubs often have).
+func parseRedirect(content []byte, name string) (string, bool) {
+	var canonical string
+
+	z := html.NewTokenizer(bytes.NewReader(content))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			return canonical, canonical != ""
+		}
+
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			continue
+		}
+
+		tok := z.Token()
+		switch tok.DataAtom {
+		case atom.Meta:
+			if !strings.EqualFold(attr(tok, "http-equiv"), "refresh") {
+				continue
+			}
+
+			if target, found := refreshTarget(attr(tok, "content")); found {
+				return target, true
+			}
+		case atom.Link:
+			if !strings.EqualFold(attr(tok, "rel"), "canonical") {
+				continue
+			}
+
+			href := attr(tok, "href")
+			if u, err := url.Parse(href); err == nil && href != "" && u.Scheme == "" && u.Host == "" && !isSelf(u, name) {
+				canonical = href
+			}
+		}
+	}
+}
+
+// isSelf returns whether u, which is relative, refers to the entry named name.
+func isSelf(u *url.URL, name string) bool {
+	if strings.HasPrefix(u.Path, "/") {
+		return false
+	}
+
+	return u.Path == "" || path.Join(path.Dir(name), u.Path) == name
+}
+
+// refreshTarget returns the URL in the content of a meta refresh, which looks
+// like `0;url=target`. The URL may be quoted.
+func refreshTarget(content string) (string, bool) {
+	_, rest, found := strings.Cut(content, ";")
+	if !found {
+		return "", false
+	}
+
+	rest = strings.TrimSpace(rest)
+	if len(rest) < 4 || !strings.EqualFold(rest[:4], "url=") {
+		return "", false
+	}
+
+	target := strings.Trim(strings.TrimSpace(rest[4:]), `'"`)
+	return target, target != ""
+}
+
+func attr(tok html.Token, key string) string {
+	for _, a := range tok.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"path"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+
+	nethtml "golang.org/x/net/html"
+
+	"github.com/rsookram/wiki-builder/internal/storage"
+)
+
+// Resources are the entries which aren't HTML (e.g. images, stylesheets, and
+// scripts), which pages refer to.
+
+// resource is what's read from an entry to dedupe and count references to
+// resources.
+type resource struct {
+	isHTML bool
+	// hash is the SHA-256 of the contents of a resource, when deduping.
+	hash [sha256.Size]byte
+	// refs are the names that the entry refers to, when pruning.
+	refs []string
+}
+
+// cssURLPattern matches url(...) in stylesheets and style attributes.
+var cssURLPattern = regexp.MustCompile(`url\(\s*['"]?([^'")]+)['"]?\s*\)`)
+
+// processResources returns entries and redirects with the resources which have
+// the same contents stored once, with the others as redirects to it (if dedupe
+// is set), and without the resources which no page or stylesheet refers to
+// (if prune is set), along with the redirects to them. References are counted
+// for the contents of a resource, so a resource is kept if any of its names
+// (including redirects) are referred to.
+func processResources(entries []entry, redirects []redirect, normalization storage.Normalization, dedupe bool, prune bool) ([]entry, []redirect) {
+	resources := readResources(entries, dedupe, prune)
+
+	// canonical is the index of the entry whose contents are stored for each
+	// entry.
+	canonical := make([]int, len(entries))
+	firstByHash := make(map[[sha256.Size]byte]int)
+	numDuplicates := 0
+	for i, r := range resources {
+		canonical[i] = i
+		if !dedupe || r.isHTML {
+			continue
+		}
+
+		if first, found := firstByHash[r.hash]; found {
+			canonical[i] = first
+			numDuplicates++
+			continue
+		}
+		firstByHash[r.hash] = i
+	}
+	if numDuplicates > 0 {
+		log.Println("Found", numDuplicates, "resources with the same contents as another, which are stored once")
+	}
+
+	keep := make([]bool, len(entries))
+	for i, r := range resources {
+		keep[i] = !prune || r.isHTML
+	}
+	if prune {
+		nameToID := make(map[string]int, len(entries)+len(redirects))
+		for i, e := range entries {
+			nameToID[e.name] = i
+		}
+		for _, r := range redirects {
+			nameToID[r.name] = r.entryIdx
+		}
+
+		refCounts := make([]int, len(entries))
+		for _, r := range resources {
+			for _, ref := range r.refs {
+				if id, found := nameToID[normalization.Apply(ref)]; found {
+					refCounts[canonical[id]]++
+				}
+			}
+		}
+
+		numPruned := 0
+		for i := range entries {
+			if canonical[i] == i && !keep[i] {
+				if refCounts[i] > 0 {
+					keep[i] = true
+				} else {
+					numPruned++
+				}
+			}
+		}
+		log.Println("Pruned", numPruned, "resources which nothing refers to")
+	}
+
+	newIDs := make([]int, len(entries))
+	keptEntries := make([]entry, 0, len(entries))
+	for i, e := range entries {
+		newIDs[i] = -1
+		if canonical[i] == i && keep[i] {
+			newIDs[i] = len(keptEntries)
+			keptEntries = append(keptEntries, e)
+		}
+	}
+
+	keptRedirects := make([]redirect, 0, len(redirects)+numDuplicates)
+	for _, r := range redirects {
+		if id := newIDs[canonical[r.entryIdx]]; id >= 0 {
+			keptRedirects = append(keptRedirects, redirect{name: r.name, entryIdx: id})
+		}
+	}
+	for i, e := range entries {
+		if canonical[i] == i {
+			continue
+		}
+		if id := newIDs[canonical[i]]; id >= 0 {
+			keptRedirects = append(keptRedirects, redirect{name: e.name, entryIdx: id})
+		}
+	}
+
+	return keptEntries, keptRedirects
+}
+
+// readResources reads what's needed from each entry in parallel: the hashes of
+// resources if dedupe is set, and the references from pages and stylesheets if
+// prune is set.
+func readResources(entries []entry, dedupe bool, prune bool) []resource {
+	resources := make([]resource, len(entries))
+
+	ids := make(chan int)
+	var wg sync.WaitGroup
+	for range runtime.NumCPU() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range ids {
+				resources[i] = readResource(entries[i], dedupe, prune)
+			}
+		}()
+	}
+
+	for i := range entries {
+		ids <- i
+
+		if i%100000 == 0 {
+			log.Println("Reading resources", i+1, "/", len(entries))
+		}
+	}
+	close(ids)
+	wg.Wait()
+
+	return resources
+}
+
+func readResource(e entry, dedupe bool, prune bool) resource {
+	f, err := storage.Open(e.localPath)
+	if err != nil {
+		panic(fmt.Sprintf("failed to open %s: %s", e.localPath, err))
+	}
+	defer f.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		panic(err)
+	}
+	head = head[:n]
+
+	contentType := storage.DetectContentType(e.localPath, head)
+	r := resource{isHTML: storage.IsHTML(contentType)}
+	isCSS := strings.HasPrefix(contentType, "text/css")
+	if !(dedupe && !r.isHTML) && !(prune && (r.isHTML || isCSS)) {
+		return r
+	}
+
+	rest, err := io.ReadAll(f)
+	if err != nil {
+		panic(err)
+	}
+	content := append(head, rest...)
+
+	if dedupe && !r.isHTML {
+		r.hash = sha256.Sum256(content)
+	}
+
+	if prune && r.isHTML {
+		r.refs = htmlRefs(e.name, content)
+	} else if prune && isCSS {
+		r.refs = cssRefs(e.name, string(content))
+	}
+
+	return r
+}
+
+// htmlRefs returns the names of the entries that the page named name refers
+// to with links, embedded resources, and inline styles.
+func htmlRefs(name string, content []byte) []string {
+	var refs []string
+	inStyle := false
+	z := nethtml.NewTokenizer(bytes.NewReader(content))
+	for {
+		tt := z.Next()
+		switch tt {
+		case nethtml.ErrorToken:
+			// A page which can't be parsed further still refers to what was
+			// found so far.
+			return refs
+		case nethtml.TextToken:
+			if inStyle {
+				refs = append(refs, cssRefs(name, string(z.Text()))...)
+			}
+		case nethtml.EndTagToken:
+			inStyle = false
+		case nethtml.StartTagToken, nethtml.SelfClosingTagToken:
+			tok := z.Token()
+			inStyle = tt == nethtml.StartTagToken && tok.Data == "style"
+
+			for _, a := range tok.Attr {
+				switch a.Key {
+				case "src", "href", "poster", "data":
+					refs = appendRef(refs, name, a.Val)
+				case "srcset":
+					for _, candidate := range strings.Split(a.Val, ",") {
+						if fields := strings.Fields(candidate); len(fields) > 0 {
+							refs = appendRef(refs, name, fields[0])
+						}
+					}
+				case "style":
+					refs = append(refs, cssRefs(name, a.Val)...)
+				}
+			}
+		}
+	}
+}
+
+// cssRefs returns the names of the entries that the url(...) values in css
+// refer to, relative to the entry named name.
+func cssRefs(name string, css string) []string {
+	var refs []string
+	for _, m := range cssURLPattern.FindAllStringSubmatch(css, -1) {
+		refs = appendRef(refs, name, m[1])
+	}
+	return refs
+}
+
+// appendRef appends the name of the entry that the relative URL ref points to
+// when it's in the entry named name, if it's a relative URL.
+func appendRef(refs []string, name string, ref string) []string {
+	u, err := url.Parse(strings.TrimSpace(ref))
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" || strings.HasPrefix(u.Path, "/") {
+		return refs
+	}
+
+	// Resolve the reference the same way a browser would for the entry served
+	// at /<name>.
+	return append(refs, strings.TrimPrefix(path.Join("/", path.Dir(name), u.Path), "/"))
+}
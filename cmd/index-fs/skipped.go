@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bufio"
+	"path/filepath"
+	"strconv"
+	"unicode/utf16"
+
+	"github.com/rsookram/wiki-builder/internal/storage"
+	"github.com/rsookram/wiki-builder/wikifile"
+)
+
+// skippedTitle is an entry or redirect which was dropped because its name is
+// too long to be a key in the index.
+type skippedTitle struct {
+	// kind is "entry" or "redirect".
+	kind string
+	name string
+}
+
+// tooLong returns whether name has too many UTF-16 code units to be a key in
+// the index.
+func tooLong(name string) bool {
+	return len(utf16.Encode([]rune(name))) > wikifile.MaxLongKeyLen
+}
+
+// writeSkippedTitles writes the report of the skipped titles to stageDir. It's
+// written even if there aren't any, so that a report from a previous run isn't
+// left behind. The file is returned to be committed with the other stage
+// files.
+func writeSkippedTitles(stageDir string, skipped []skippedTitle) *storage.PartialFile {
+	f, err := storage.CreatePartial(filepath.Join(stageDir, "stage-0-skipped.txt"))
+	if err != nil {
+		panic(err)
+	}
+
+	output := bufio.NewWriter(f)
+
+	if _, err := output.WriteString(strconv.FormatInt(int64(len(skipped)), 10)); err != nil {
+		panic(err)
+	}
+	if _, err := output.WriteRune('\n'); err != nil {
+		panic(err)
+	}
+
+	for _, s := range skipped {
+		if _, err := output.WriteString(s.kind); err != nil {
+			panic(err)
+		}
+		if _, err := output.WriteRune('\t'); err != nil {
+			panic(err)
+		}
+
+		if _, err := output.WriteString(s.name); err != nil {
+			panic(err)
+		}
+		if _, err := output.WriteRune('\n'); err != nil {
+			panic(err)
+		}
+	}
+
+	if err := output.Flush(); err != nil {
+		panic(err)
+	}
+
+	return f
+}
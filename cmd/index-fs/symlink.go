@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// symlinkRedirects returns the redirects for the symbolic link at localPath
+// within dir (which resolves to resolvedDir), since some dumps use them instead
+// of redirect pages. A link to a file redirects to its entry, and a link to a
+// directory redirects each file within it. Links which are broken or point
+// outside of dir are logged and skipped.
+func symlinkRedirects(dir string, resolvedDir string, localPath string) []rawRedirect {
+	target, err := filepath.EvalSymlinks(localPath)
+	if err != nil {
+		log.Println("Skipping broken symbolic link:", localPath, err)
+		return nil
+	}
+
+	rel, err := filepath.Rel(resolvedDir, target)
+	if err != nil || !filepath.IsLocal(rel) {
+		log.Println("Skipping symbolic link which points outside of the dump:", localPath)
+		return nil
+	}
+
+	name := entryName(dir, localPath)
+	targetName := filepath.ToSlash(rel)
+
+	info, err := os.Stat(target)
+	if err != nil {
+		panic(err)
+	}
+	if !info.IsDir() {
+		return []rawRedirect{{name: name, entryName: targetName}}
+	}
+
+	var redirects []rawRedirect
+	err = filepath.WalkDir(target, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// Links within the directory are handled where they're walked in the
+		// dump.
+		if d.IsDir() || d.Type()&fs.ModeSymlink != 0 {
+			return nil
+		}
+
+		rel := entryName(target, p)
+		redirects = append(redirects, rawRedirect{name: path.Join(name, rel), entryName: path.Join(targetName, rel)})
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	return redirects
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// credentials are what requests need to have to be served, from -auth and
+// -auth-token. Only the SHA-256 of the password and the token are kept, so
+// that they aren't visible in the command line.
+type credentials struct {
+	user string
+	// passwordHash is nil unless basic auth is accepted.
+	passwordHash []byte
+	// tokenHash is nil unless bearer tokens are accepted.
+	tokenHash []byte
+}
+
+// parseCredentials parses the flags for access control. auth is a user and the
+// SHA-256 of their password in hex, separated by a colon, and token is the
+// SHA-256 of a bearer token in hex. Either can be empty, and nil is returned if
+// both are, for no access control.
+func parseCredentials(auth string, token string) (*credentials, error) {
+	if auth == "" && token == "" {
+		return nil, nil
+	}
+
+	var c credentials
+	if auth != "" {
+		user, hash, found := strings.Cut(auth, ":")
+		if !found || user == "" {
+			return nil, errors.New("-auth must be a user and the SHA-256 of their password, separated by a colon")
+		}
+
+		b, err := parseSHA256(hash)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -auth password hash: %w", err)
+		}
+		c.user = user
+		c.passwordHash = b
+	}
+
+	if token != "" {
+		b, err := parseSHA256(token)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -auth-token: %w", err)
+		}
+		c.tokenHash = b
+	}
+
+	return &c, nil
+}
+
+func parseSHA256(s string) ([]byte, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != sha256.Size {
+		return nil, fmt.Errorf("a SHA-256 in hex has %d characters, not %d", sha256.Size*2, len(s))
+	}
+
+	return b, nil
+}
+
+// allowed returns whether r has the credentials. They're compared in constant
+// time, so that how long it takes doesn't reveal how much of them is right.
+func (c *credentials) allowed(r *http.Request) bool {
+	if c.tokenHash != nil {
+		if token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); found {
+			return matchesHash(token, c.tokenHash)
+		}
+	}
+
+	if c.passwordHash != nil {
+		if user, password, ok := r.BasicAuth(); ok {
+			userOK := subtle.ConstantTimeCompare([]byte(user), []byte(c.user))
+			passwordOK := matchesHash(password, c.passwordHash)
+			return userOK == 1 && passwordOK
+		}
+	}
+
+	return false
+}
+
+func matchesHash(s string, hash []byte) bool {
+	sum := sha256.Sum256([]byte(s))
+	return subtle.ConstantTimeCompare(sum[:], hash) == 1
+}
+
+// requireCredentials wraps h so that only requests with c are served. The
+// others get a 401 from unauthorized, which prompts browsers to log in if
+// basic auth is accepted.
+func requireCredentials(c *credentials, h http.Handler, unauthorized func(http.ResponseWriter, *http.Request)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c.allowed(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		if c.passwordHash != nil {
+			w.Header().Set("WWW-Authenticate", `Basic realm="wiki", charset="UTF-8"`)
+		} else {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="wiki"`)
+		}
+		unauthorized(w, r)
+	})
+}
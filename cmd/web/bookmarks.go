@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io/fs"
+	"os"
+	"slices"
+	"sync"
+)
+
+type Bookmark struct {
+	Name   string `json:"name"`
+	Offset int64  `json:"offset"`
+}
+
+// URL returns the path to the entry for the bookmark. It links to the entry by
+// name, since bookmarks are kept when the wiki is rebuilt, which can move its
+// entries to other offsets.
+func (b Bookmark) URL() string {
+	return keyURL(b.Name).String()
+}
+
+// bookmarkStore keeps bookmarks in a JSON file, which is rewritten on every
+// change.
+type bookmarkStore struct {
+	path string
+
+	mu        sync.Mutex
+	bookmarks []Bookmark
+}
+
+func openBookmarks(path string) (*bookmarkStore, error) {
+	s := &bookmarkStore{path: path}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bookmarks from %s: %w", path, err)
+	}
+
+	if err := json.Unmarshal(b, &s.bookmarks); err != nil {
+		return nil, fmt.Errorf("failed to parse bookmarks in %s: %w", path, err)
+	}
+
+	return s, nil
+}
+
+func (s *bookmarkStore) list() []Bookmark {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.Clone(s.bookmarks)
+}
+
+func (s *bookmarkStore) has(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.indexOf(name) >= 0
+}
+
+func (s *bookmarkStore) add(b Bookmark) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.indexOf(b.Name) >= 0 {
+		return nil
+	}
+
+	s.bookmarks = append(s.bookmarks, b)
+	return s.save()
+}
+
+func (s *bookmarkStore) remove(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.indexOf(name)
+	if i < 0 {
+		return nil
+	}
+
+	s.bookmarks = slices.Delete(s.bookmarks, i, i+1)
+	return s.save()
+}
+
+func (s *bookmarkStore) indexOf(name string) int {
+	return slices.IndexFunc(s.bookmarks, func(b Bookmark) bool {
+		return b.Name == name
+	})
+}
+
+// save writes the bookmarks to a temporary file first so that the existing
+// bookmarks aren't lost if writing fails part way through.
+func (s *bookmarkStore) save() error {
+	b, err := json.MarshalIndent(s.bookmarks, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode bookmarks: %w", err)
+	}
+
+	tmpPath := s.path + ".tmp"
+	if err := os.WriteFile(tmpPath, b, 0o644); err != nil {
+		return fmt.Errorf("failed to write bookmarks to %s: %w", tmpPath, err)
+	}
+
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("failed to replace bookmarks at %s: %w", s.path, err)
+	}
+
+	return nil
+}
+
+// bookmarkForm returns the HTML for a button which adds or removes a bookmark
+// for the entry with name.
+func bookmarkForm(name string, bookmarked bool) string {
+	action, label := "add", "☆"
+	if bookmarked {
+		action, label = "remove", "★"
+	}
+
+	return fmt.Sprintf(
+		`<form class="wiki-bookmark" action="/-/bookmarks" method="post">`+
+			`<input type="hidden" name="action" value="%s">`+
+			`<input type="hidden" name="name" value="%s">`+
+			`<input type="submit" value="%s"> <a href="/-/bookmarks">ブックマーク</a></form>`,
+		action,
+		html.EscapeString(name),
+		label,
+	)
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// acceptsEncoding returns whether the Accept-Encoding header of r allows a
+// response with the content coding name (e.g. "br").
+func acceptsEncoding(r *http.Request, name string) bool {
+	for _, v := range r.Header.Values("Accept-Encoding") {
+		for _, part := range strings.Split(v, ",") {
+			coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+			if !strings.EqualFold(strings.TrimSpace(coding), name) {
+				continue
+			}
+
+			// A quality of 0 means that the coding isn't acceptable.
+			key, value, _ := strings.Cut(strings.TrimSpace(params), "=")
+			if strings.TrimSpace(key) == "q" {
+				q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+				return err == nil && q > 0
+			}
+			return true
+		}
+	}
+
+	return false
+}
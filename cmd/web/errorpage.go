@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	_ "embed"
+	"encoding/hex"
+	"html/template"
+	"log/slog"
+	"net/http"
+
+	"github.com/rsookram/wiki-builder/internal/reader"
+)
+
+//go:embed "error.html"
+var errorHtmlTemplate string
+
+// numSuggestions is the number of keys suggested on the page for an entry
+// which isn't found.
+const numSuggestions = 10
+
+// errorPage is an error as exposed to the error template.
+type errorPage struct {
+	Status     int
+	StatusText string
+	// Name is the name of the entry which wasn't found, for a 404 for one.
+	Name string
+	// Suggestions are the keys which are most like Name.
+	Suggestions []reader.SearchResult
+	// RequestID identifies the request in the log, for server errors.
+	RequestID string
+	ThemeCSS  template.CSS
+}
+
+func newErrorPage(r *http.Request, status int, t theme) errorPage {
+	page := errorPage{Status: status, StatusText: http.StatusText(status), ThemeCSS: template.CSS(t.css())}
+	if status >= 500 {
+		page.RequestID = requestID(r.Context())
+	}
+
+	return page
+}
+
+// writeErrorPage responds with page, replacing any headers for the response
+// it was going to send.
+func writeErrorPage(w http.ResponseWriter, r *http.Request, tmpl *template.Template, page errorPage) {
+	w.Header().Del("Content-Encoding")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(page.Status)
+
+	if err := tmpl.Execute(w, page); err != nil {
+		requestLog(r).Error("failed to execute error page", "error", err)
+	}
+}
+
+// suggestions returns the keys which start with the longest prefix of name
+// that any key starts with, down to half of it, for an entry that isn't
+// found (e.g. because of a typo at the end of its name).
+func suggestions(ctx context.Context, wiki *reader.Wiki, name string, opts reader.QueryOptions) []reader.SearchResult {
+	opts.Limit = numSuggestions
+
+	runes := []rune(name)
+	for n := len(runes); n > 0 && n >= len(runes)/2; n-- {
+		results, err := wiki.QueryContext(ctx, string(runes[:n]), opts)
+		if err != nil {
+			return nil
+		}
+		if len(results) > 0 {
+			return results
+		}
+	}
+
+	return nil
+}
+
+type requestIDKey struct{}
+
+// withRequestIDs wraps h so that each request has a random ID, which is sent
+// in the X-Request-Id header, shown on error pages, and logged with errors by
+// requestLog, so that a report of an error can be matched to its log line.
+func withRequestIDs(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var b [8]byte
+		rand.Read(b[:])
+		id := hex.EncodeToString(b[:])
+
+		w.Header().Set("X-Request-Id", id)
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// requestID returns the ID of the request with ctx, or an empty string if it
+// doesn't have one.
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestLog returns the logger for r, which adds its ID to each line.
+func requestLog(r *http.Request) *slog.Logger {
+	return slog.Default().With("request_id", requestID(r.Context()))
+}
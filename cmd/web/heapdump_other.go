@@ -0,0 +1,6 @@
+//go:build !unix
+
+package main
+
+// handleHeapDumpSignal does nothing since SIGUSR1 isn't available.
+func handleHeapDumpSignal(dir string) {}
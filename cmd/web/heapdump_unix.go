@@ -0,0 +1,43 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime/pprof"
+	"syscall"
+	"time"
+)
+
+// handleHeapDumpSignal writes a heap profile to dir every time SIGUSR1 is
+// received. The profile is written outside of the signal handler, so it's safe
+// to take as long as needed.
+func handleHeapDumpSignal(dir string) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+
+	go func() {
+		for range signals {
+			path := filepath.Join(dir, fmt.Sprintf("web-heap-%d.pprof", time.Now().UnixNano()))
+			if err := writeHeapProfile(path); err != nil {
+				slog.Error("failed to write heap profile", "path", path, "error", err)
+				continue
+			}
+			slog.Info("wrote heap profile", "path", path)
+		}
+	}()
+}
+
+func writeHeapProfile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return pprof.WriteHeapProfile(f)
+}
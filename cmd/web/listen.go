@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd for socket
+// activation.
+const listenFDsStart = 3
+
+// listen returns the listeners to serve on. When started through systemd
+// socket activation, the socket that it passed is used. Otherwise addrs is a
+// comma-separated list of addresses, each of which is either a TCP address
+// (see listenTCP), or the path to a Unix socket prefixed with "unix:".
+func listen(addrs string, port uint) ([]net.Listener, error) {
+	if l, err := activationListener(); l != nil || err != nil {
+		return []net.Listener{l}, err
+	}
+
+	var listeners []net.Listener
+	for addr := range strings.SplitSeq(addrs, ",") {
+		var ls []net.Listener
+		var err error
+		if path, isUnix := strings.CutPrefix(addr, "unix:"); isUnix {
+			var l net.Listener
+			l, err = listenUnix(path)
+			ls = []net.Listener{l}
+		} else {
+			ls, err = listenTCP(strings.TrimSpace(addr), port)
+		}
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+		}
+
+		listeners = append(listeners, ls...)
+	}
+
+	return listeners, nil
+}
+
+// listenTCP listens on addr, which is a host and a port. The port can be left
+// out to use port instead, and the host can be:
+//   - an IPv4 or IPv6 address, e.g. 0.0.0.0 or [::1]:9454 (IPv6 addresses need
+//     brackets with a port),
+//   - a host name, which is listened on at each of its addresses (e.g.
+//     127.0.0.1 and ::1 for localhost), skipping the ones which can't be bound
+//     (e.g. ::1 when IPv6 is disabled) as long as one can,
+//   - or empty (e.g. :9454) to listen on every interface over both IPv4 and
+//     IPv6.
+func listenTCP(addr string, port uint) ([]net.Listener, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		// There's no port (or an IPv6 address without brackets).
+		host = strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+		portStr = strconv.FormatUint(uint64(port), 10)
+	}
+
+	if host == "" {
+		l, err := net.Listen("tcp", ":"+portStr)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{l}, nil
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		l, err := listenIP(ip, portStr)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{l}, nil
+	}
+
+	ips, err := net.DefaultResolver.LookupIPAddr(context.Background(), host)
+	if err != nil {
+		return nil, err
+	}
+
+	var listeners []net.Listener
+	var errs []error
+	seen := map[string]bool{}
+	for _, ip := range ips {
+		if seen[ip.String()] {
+			continue
+		}
+		seen[ip.String()] = true
+
+		l, err := listenIP(ip.IP, portStr)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		listeners = append(listeners, l)
+	}
+	if len(listeners) == 0 {
+		return nil, errors.Join(errs...)
+	}
+	for _, err := range errs {
+		slog.Warn("skipping an address of the host", "host", host, "error", err)
+	}
+
+	return listeners, nil
+}
+
+// listenIP listens on ip alone, since Go would otherwise also listen on [::]
+// for 0.0.0.0.
+func listenIP(ip net.IP, port string) (net.Listener, error) {
+	network := "tcp6"
+	if ip.To4() != nil {
+		network = "tcp4"
+	}
+
+	return net.Listen(network, net.JoinHostPort(ip.String(), port))
+}
+
+// listenUnix listens on the Unix socket at path.
+func listenUnix(path string) (net.Listener, error) {
+	// Remove the socket left behind if the previous run didn't exit cleanly.
+	if info, err := os.Stat(path); err == nil && info.Mode()&fs.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	} else if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	return net.Listen("unix", path)
+}
+
+// activationListener returns the socket passed by systemd, or nil if the
+// process wasn't started through socket activation. See sd_listen_fds(3).
+func activationListener() (net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, nil
+	}
+	if n > 1 {
+		return nil, fmt.Errorf("expected 1 socket from systemd, but got %d", n)
+	}
+
+	// Don't pass the sockets on to child processes.
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	f := os.NewFile(listenFDsStart, "systemd-socket")
+	defer f.Close()
+
+	return net.FileListener(f)
+}
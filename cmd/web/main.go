@@ -1,121 +1,781 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	_ "embed"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"html/template"
 	"io"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rsookram/wiki-builder/internal/compression"
+	"github.com/rsookram/wiki-builder/internal/query"
+	"github.com/rsookram/wiki-builder/internal/reader"
+	"github.com/rsookram/wiki-builder/internal/storage"
 )
 
 //go:embed "index.html"
 var indexHtmlTemplate string
 
-//go:embed "style.css"
-var css string
+//go:embed "bookmarks.html"
+var bookmarksHtmlTemplate string
+
+// statusForError returns the HTTP status code to respond with for an error
+// from the reader.
+func statusForError(err error) int {
+	switch {
+	case errors.Is(err, reader.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, reader.ErrOutOfRange):
+		return http.StatusBadRequest
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+type indexPage struct {
+	// Title is the query when there is one, and the name of the wiki
+	// otherwise.
+	Title   string
+	Query   string
+	Results []searchResult
+	// Error explains why the query couldn't be parsed, if it couldn't.
+	Error string
+	// Languages are the languages that entries can be filtered by, which is
+	// empty unless the wiki was built with languages.
+	Languages []string
+	// Language is the language that the results are filtered by, or empty for
+	// all of them.
+	Language string
+	// Popular are the most popular entries, which are listed when there isn't
+	// a query if the wiki was built with page views.
+	Popular  []reader.SearchResult
+	Theme    theme
+	ThemeCSS template.CSS
+}
+
+// apiSearchResult is a search result returned by /-/search.
+type apiSearchResult struct {
+	Key     string `json:"key"`
+	Offset  int64  `json:"offset"`
+	Snippet string `json:"snippet,omitempty"`
+	// Size is the uncompressed size of the entry in bytes, which is missing if
+	// the wiki was built without sizes.
+	Size *int64 `json:"size,omitempty"`
+	// WordCount is the number of words in the text of the entry, which is
+	// missing if the wiki was built without word counts.
+	WordCount *int64 `json:"wordCount,omitempty"`
+}
+
+// apiMeta is the provenance of the wiki file returned by /api/meta.
+type apiMeta struct {
+	BuildID     string     `json:"buildId,omitempty"`
+	Source      string     `json:"source,omitempty"`
+	BuildTime   *time.Time `json:"buildTime,omitempty"`
+	ToolVersion string     `json:"toolVersion,omitempty"`
+	MainPage    string     `json:"mainPage,omitempty"`
+}
+
+// apiPrefix is a key of the first level index returned by /api/prefixes.
+type apiPrefix struct {
+	Key string `json:"key"`
+	// ApproxKeys is about how many keys are from Key up to the next one.
+	ApproxKeys int `json:"approxKeys"`
+}
+
+type bookmarksPage struct {
+	Bookmarks []Bookmark
+	ThemeCSS  template.CSS
+}
+
+// numPopularEntries is the number of the most popular entries listed on the
+// index page.
+const numPopularEntries = 20
+
+// numTuningReads is the number of reads of each kind timed by -tune-reads.
+const numTuningReads = 32
+
+// bufPool has the buffers that entries are read into when they need to be
+// modified or served in parts, so that they aren't allocated for each request.
+var bufPool = sync.Pool{
+	New: func() any {
+		return bytes.NewBuffer(make([]byte, 0, 64*1024))
+	},
+}
+
+// maxPooledBufferSize is the capacity above which buffers aren't returned to
+// bufPool, so that a few huge entries don't keep their memory around.
+const maxPooledBufferSize = 1024 * 1024
+
+func getBuffer() *bytes.Buffer {
+	buf := bufPool.Get().(*bytes.Buffer)
+	buf.Reset()
+
+	return buf
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() <= maxPooledBufferSize {
+		bufPool.Put(buf)
+	}
+}
+
+// popularEntries returns the most popular entries of wiki to list on the index
+// page.
+func popularEntries(wiki *reader.Wiki) []reader.SearchResult {
+	popular := wiki.PopularEntries()
+	return popular[:min(len(popular), numPopularEntries)]
+}
+
+// entryURL returns the path to the entry at offset, which has key.
+func entryURL(key string, offset int64) string {
+	u := keyURL(key)
+	u.RawQuery = "offset=" + strconv.FormatInt(offset, 10)
+	return u.String()
+}
+
+// keyURL returns the path to the entry with key, which is looked up when it's
+// requested.
+func keyURL(key string) *url.URL {
+	u := &url.URL{Path: "/" + key}
+	if rest, found := strings.CutPrefix(key, "/"); found {
+		// The path would start with //, which browsers treat as a URL on
+		// another host, so the slash is escaped.
+		u.RawPath = "/%2F" + (&url.URL{Path: rest}).EscapedPath()
+	}
+	return u
+}
+
+// exactMatch returns the result whose key is exactly text, if there is one.
+func exactMatch(text string, results []reader.SearchResult) (reader.SearchResult, bool) {
+	i := slices.IndexFunc(results, func(r reader.SearchResult) bool { return r.Key == text })
+	if i == -1 {
+		return reader.SearchResult{}, false
+	}
+
+	return results[i], true
+}
+
+func newIndexPage(title string, t theme) indexPage {
+	return indexPage{Title: title, Theme: t, ThemeCSS: template.CSS(t.css())}
+}
 
 func main() {
 	port := flag.Uint("port", 9454, "the port to serve on")
+	listenAddr := flag.String("listen", "localhost", "comma-separated addresses to serve on, each a host name (served on all of its addresses, e.g. 127.0.0.1 and ::1 for localhost), an IPv4 or IPv6 address (e.g. 0.0.0.0 or [::1]), either of those with a port (overriding -port), :<port> for every interface over IPv4 and IPv6, or unix:<path> for a Unix socket. A socket passed by systemd socket activation takes precedence.")
+	themeFlag := flag.String("theme", "auto", "the default theme: light, dark, or auto to follow the browser")
+	wrap := flag.Bool("wrap", false, "add a header with the title, a table of contents, and a bookmark button to entries")
+	bookmarksPath := flag.String("bookmarks", "", "the file to store bookmarks in (defaults to the path of the wiki file with .bookmarks.json appended)")
+	prefetch := flag.Int("prefetch", 0, "the number of entries following each opened entry, and of search results, to read ahead in the background")
+	enablePprof := flag.Bool("pprof", false, "serve profiling data at /debug/pprof/")
+	sitemap := flag.Bool("sitemap", false, "serve a sitemap of every title at /sitemap.xml, and a robots.txt which points to it, for search engines on the network to crawl")
+	templatesDir := flag.String("templates-dir", "", "a directory containing index.html, bookmarks.html, error.html, or style.css to use instead of the defaults")
+	watch := flag.Bool("watch", false, "reload the wiki file when it changes, in addition to when SIGHUP is received")
+	heapDumpDir := flag.String("heap-dump-dir", os.TempDir(), "the directory to write a heap profile to when SIGUSR1 is received")
+	rank := flag.Bool("rank", false, "order search results by how well they match (an exact match, then shorter titles, then entries before redirects) rather than by title")
+	readTimeout := flag.Duration("read-timeout", 30*time.Second, "the longest time to spend reading the wiki file for a request before giving up on it (e.g. when the disk is stuck), or 0 for no limit")
+	trace := flag.Bool("trace", false, "log how long each request spends seeking in the index, scanning it, and decompressing entries")
+	entries := flag.String("entries", "", "the file or HTTP(S) URL to read entries from, overriding the one referenced by the wiki file")
+	auth := flag.String("auth", "", "require HTTP basic auth for every request, with a user and the SHA-256 of their password in hex, separated by a colon (e.g. reader:$(printf %s password | sha256sum | cut -d' ' -f1))")
+	authToken := flag.String("auth-token", "", "require a bearer token in the Authorization header of every request, given as the SHA-256 of the token in hex (with -auth too, either is accepted)")
+	selfTest := flag.Int("selftest", 0, "before serving (and after reloading), check the first level index and read this many random entries, so that a broken wiki file fails at startup instead of on requests")
+	scanBuffer := flag.Int("scan-buffer", reader.DefaultScanBufferSize, "the size in bytes of the buffer that the index is read with for each search; larger ones help when seeks are slow (e.g. on SD cards and network storage)")
+	tuneReads := flag.Bool("tune-reads", false, "before serving (and after reloading), time reads of the wiki file and decompressing some entries, and pick -scan-buffer and -prefetch for the storage it's on, unless they're passed")
+	var normalization storage.Normalization
+	flag.Var(&normalization, "normalize", "comma-separated list of normalizations to apply to lookups; this should match what index-fs used")
 	flag.Parse()
 	path := flag.Arg(0)
 
+	passed := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		passed[f.Name] = true
+	})
+
 	if path == "" {
 		slog.Error("missing path to wiki file")
 		os.Exit(1)
 	}
 
-	addr := fmt.Sprintf("127.0.0.1:%d", *port)
-	slog.Info("starting", "addr", addr, "path", path)
+	defaultTheme, err := parseTheme(*themeFlag)
+	if err != nil {
+		slog.Error("invalid theme", "error", err)
+		os.Exit(1)
+	}
+
+	creds, err := parseCredentials(*auth, *authToken)
+	if err != nil {
+		slog.Error("invalid credentials", "error", err)
+		os.Exit(1)
+	}
+
+	tmpl, err := loadTemplates(*templatesDir)
+	if err != nil {
+		slog.Error("error loading templates", "dir", *templatesDir, "error", err)
+		os.Exit(1)
+	}
+	indexTmpl := tmpl.index
+	bookmarksTmpl := tmpl.bookmarks
+
+	// serveError responds to r with the error page for status.
+	serveError := func(w http.ResponseWriter, r *http.Request, status int) {
+		writeErrorPage(w, r, tmpl.error, newErrorPage(r, status, requestTheme(r, defaultTheme)))
+	}
+
+	wikiName := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+
+	if *bookmarksPath == "" {
+		*bookmarksPath = path + ".bookmarks.json"
+	}
+
+	bookmarks, err := openBookmarks(*bookmarksPath)
+	if err != nil {
+		slog.Error("error opening bookmarks", "path", *bookmarksPath, "error", err)
+		os.Exit(1)
+	}
+
+	var tracer reader.Tracer
+	if *trace {
+		tracer = &logTracer{}
+	}
+
+	wikis, err := newWikiHolder(func() (*reader.Wiki, error) {
+		wiki, err := reader.OpenWikiWithEntries(path, *entries)
+		if err != nil {
+			return nil, err
+		}
+		if *selfTest > 0 {
+			if err := wiki.SelfTest(context.Background(), *selfTest); err != nil {
+				wiki.Close()
+				return nil, fmt.Errorf("self-test failed: %w", err)
+			}
+			slog.Info("self-test passed", "entries", *selfTest)
+		}
+		settings := reader.ReadSettings{ScanBufferSize: *scanBuffer, Prefetch: *prefetch}
+		if *tuneReads {
+			profile, err := wiki.MeasureReads(context.Background(), numTuningReads)
+			if err != nil {
+				wiki.Close()
+				return nil, fmt.Errorf("failed to measure reads: %w", err)
+			}
 
-	indexTmpl := template.Must(template.New("index").Parse(indexHtmlTemplate))
+			tuned := profile.Settings()
+			if !passed["scan-buffer"] {
+				settings.ScanBufferSize = tuned.ScanBufferSize
+			}
+			if !passed["prefetch"] {
+				settings.Prefetch = tuned.Prefetch
+			}
+			slog.Info("tuned reads", "latency", profile.Latency, "throughput_mb_s", int(profile.Throughput/1e6), "entry_read", profile.EntryRead, "decompress", profile.Decompress, "scan_buffer", settings.ScanBufferSize, "prefetch", settings.Prefetch)
+		}
+		wiki.SetScanBufferSize(settings.ScanBufferSize)
+		wiki.SetPrefetch(settings.Prefetch)
+		if tracer != nil {
+			wiki.SetTracer(tracer)
+		}
 
-	wiki, err := OpenWiki(path)
+		return &wiki, nil
+	})
 	if err != nil {
 		slog.Error("error opening wiki", "path", path, "error", err)
 		os.Exit(1)
 	}
 
-	http.HandleFunc("POST /", func(w http.ResponseWriter, r *http.Request) {
-		query := r.PostFormValue("query")
-		if query == "" {
-			if err := indexTmpl.Execute(w, nil); err != nil {
-				slog.Error("POST: failed to execute index", "error", err)
+	// The port is only bound once the wiki is open (and has passed the
+	// self-test), so that a broken file doesn't get any requests.
+	listeners, err := listen(*listenAddr, *port)
+	if err != nil {
+		slog.Error("error listening", "addr", *listenAddr, "error", err)
+		os.Exit(1)
+	}
+	addrs := make([]string, 0, len(listeners))
+	for _, l := range listeners {
+		addrs = append(addrs, l.Addr().String())
+	}
+	slog.Info("starting", "addrs", strings.Join(addrs, ", "), "path", path)
+
+	wikis.handleReloadSignal()
+	if *watch {
+		if err := wikis.watch(path); err != nil {
+			slog.Error("error watching wiki", "path", path, "error", err)
+			os.Exit(1)
+		}
+	}
+
+	handleHeapDumpSignal(*heapDumpDir)
+
+	queryOpts := reader.QueryOptions{Rank: *rank, Sizes: true, WordCounts: true}
+
+	// readContext returns the context to read the wiki with for r, which is
+	// done once the read timeout passes.
+	readContext := func(r *http.Request) (context.Context, context.CancelFunc) {
+		if *readTimeout <= 0 {
+			return context.WithCancel(r.Context())
+		}
+
+		return context.WithTimeout(r.Context(), *readTimeout)
+	}
+
+	mux := http.NewServeMux()
+	if *enablePprof {
+		registerPprof(mux)
+	}
+	if *sitemap {
+		registerSitemap(mux, wikis)
+	}
+
+	mux.HandleFunc("POST /", func(w http.ResponseWriter, r *http.Request) {
+		page := newIndexPage(wikiName, requestTheme(r, defaultTheme))
+
+		wiki := wikis.acquire()
+		defer wikis.release(wiki)
+
+		page.Languages = wiki.Languages()
+		page.Language = r.PostFormValue("language")
+
+		input := r.PostFormValue("query")
+		if input == "" {
+			page.Popular = popularEntries(wiki.Wiki)
+			if err := indexTmpl.Execute(w, page); err != nil {
+				requestLog(r).Error("POST: failed to execute index", "error", err)
+			}
+			return
+		}
+
+		page.Title = input
+		page.Query = input
+		q, err := query.Parse(input)
+		if err != nil {
+			page.Error = err.Error()
+			w.WriteHeader(http.StatusBadRequest)
+			if err := indexTmpl.Execute(w, page); err != nil {
+				requestLog(r).Error("POST: failed to execute index", "error", err)
+			}
+			return
+		}
+		q.Normalize(normalization.Apply)
+
+		ctx, cancel := readContext(r)
+		defer cancel()
+
+		opts := queryOpts
+		opts.Language = page.Language
+		results, err := q.Run(ctx, wiki.Wiki, opts)
+		if errors.Is(err, query.ErrNoRedirectFlags) {
+			page.Error = err.Error()
+			w.WriteHeader(http.StatusBadRequest)
+			if err := indexTmpl.Execute(w, page); err != nil {
+				requestLog(r).Error("POST: failed to execute index", "error", err)
 			}
 			return
 		}
+		if err != nil {
+			requestLog(r).Error("POST: query failed", "query", input, "error", err)
+			serveError(w, r, statusForError(err))
+			return
+		}
+
+		// I'm Feeling Lucky goes straight to the entry that the query names,
+		// if there is one.
+		if r.PostFormValue("lucky") != "" {
+			if match, found := exactMatch(q.Text, results); found {
+				http.Redirect(w, r, entryURL(match.Key, match.EntryOffset), http.StatusFound)
+				return
+			}
+		}
+
+		offsets := make([]int64, 0, len(results))
+		for _, r := range results {
+			offsets = append(offsets, r.EntryOffset)
+		}
+		wiki.Prefetch(offsets)
+
+		page.Results = make([]searchResult, 0, len(results))
+		for _, r := range results {
+			page.Results = append(page.Results, searchResult{SearchResult: r, wiki: wiki.Wiki, ctx: ctx})
+		}
+		if err := indexTmpl.Execute(w, page); err != nil {
+			requestLog(r).Error("POST: failed to execute index", "error", err)
+		}
+	})
+
+	mux.HandleFunc("GET /-/search", func(w http.ResponseWriter, r *http.Request) {
+		input := r.URL.Query().Get("query")
+		q, err := query.Parse(input)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		q.Normalize(normalization.Apply)
+
+		wiki := wikis.acquire()
+		defer wikis.release(wiki)
 
-		results, err := wiki.query(query)
+		ctx, cancel := readContext(r)
+		defer cancel()
+
+		opts := queryOpts
+		opts.Language = r.URL.Query().Get("language")
+		results, err := q.Run(ctx, wiki.Wiki, opts)
+		if errors.Is(err, query.ErrNoRedirectFlags) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		if err != nil {
-			slog.Error("POST: query failed", "query", query, "error", err)
-			w.WriteHeader(http.StatusInternalServerError)
+			requestLog(r).Error("GET: search failed", "query", input, "error", err)
+			w.WriteHeader(statusForError(err))
 			return
 		}
 
-		if err := indexTmpl.Execute(w, results); err != nil {
-			slog.Error("POST: failed to execute index", "error", err)
+		apiResults := make([]apiSearchResult, 0, len(results))
+		for _, r := range results {
+			result := apiSearchResult{Key: r.Key, Offset: r.EntryOffset, Snippet: r.Snippet}
+			if r.Size >= 0 {
+				result.Size = &r.Size
+			}
+			if r.WordCount >= 0 {
+				result.WordCount = &r.WordCount
+			}
+			apiResults = append(apiResults, result)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(apiResults); err != nil {
+			requestLog(r).Error("GET: failed to encode search results", "query", input, "error", err)
 		}
 	})
 
-	http.HandleFunc("GET /-/{path...}", func(w http.ResponseWriter, r *http.Request) {
+	// The metadata is served at /api/meta, and also at /-/meta next to the
+	// other JSON endpoints, since /-/ can't be the start of an entry's name
+	// (unlike /api/).
+	serveMeta := func(w http.ResponseWriter, r *http.Request) {
+		wiki := wikis.acquire()
+		info := wiki.BuildInfo()
+		mainPage := wiki.MainPage()
+		wikis.release(wiki)
+
+		meta := apiMeta{BuildID: info.ID, Source: info.Source, ToolVersion: info.ToolVersion, MainPage: mainPage}
+		if !info.Time.IsZero() {
+			meta.BuildTime = &info.Time
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(meta); err != nil {
+			requestLog(r).Error("GET: failed to encode meta", "error", err)
+		}
+	}
+	mux.HandleFunc("GET /api/meta", serveMeta)
+	mux.HandleFunc("GET /-/meta", serveMeta)
+
+	// Like the metadata, the prefixes are served at both /api/prefixes and
+	// /-/prefixes.
+	servePrefixes := func(w http.ResponseWriter, r *http.Request) {
+		wiki := wikis.acquire()
+		defer wikis.release(wiki)
+
+		ctx, cancel := readContext(r)
+		defer cancel()
+
+		keys, err := wiki.FirstLevelKeys(ctx)
+		if err != nil {
+			requestLog(r).Error("GET: failed to list prefixes", "error", err)
+			w.WriteHeader(statusForError(err))
+			return
+		}
+
+		prefixes := make([]apiPrefix, 0, len(keys))
+		for _, k := range keys {
+			prefixes = append(prefixes, apiPrefix{Key: k.Key, ApproxKeys: k.ApproxKeys})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(prefixes); err != nil {
+			requestLog(r).Error("GET: failed to encode prefixes", "error", err)
+		}
+	}
+	mux.HandleFunc("GET /api/prefixes", servePrefixes)
+	mux.HandleFunc("GET /-/prefixes", servePrefixes)
+
+	mux.HandleFunc("GET /-/{path...}", func(w http.ResponseWriter, r *http.Request) {
 		name := r.PathValue("path")
 		if name == "style.css" {
 			w.Header().Set("Content-Type", "text/css")
-			if _, err := w.Write([]byte(css)); err != nil {
-				slog.Error("GET: Write failed for CSS", "error", err)
+			themeCSS := requestTheme(r, defaultTheme).css()
+			if _, err := w.Write([]byte(tmpl.styleCSS + themeCSS)); err != nil {
+				requestLog(r).Error("GET: Write failed for CSS", "error", err)
 			}
 			return
 		}
 
-		w.WriteHeader(http.StatusNotFound)
+		found, err := servePWAFile(w, name)
+		if err != nil {
+			requestLog(r).Error("GET: Write failed for PWA file", "name", name, "error", err)
+		}
+		if found {
+			return
+		}
+
+		serveError(w, r, http.StatusNotFound)
 	})
 
-	http.HandleFunc("GET /{name...}", func(w http.ResponseWriter, r *http.Request) {
-		name := r.PathValue("name")
+	mux.HandleFunc("GET /-/bookmarks", func(w http.ResponseWriter, r *http.Request) {
+		page := bookmarksPage{
+			Bookmarks: bookmarks.list(),
+			ThemeCSS:  template.CSS(requestTheme(r, defaultTheme).css()),
+		}
+		if err := bookmarksTmpl.Execute(w, page); err != nil {
+			requestLog(r).Error("GET: failed to execute bookmarks", "error", err)
+		}
+	})
+
+	mux.HandleFunc("POST /-/bookmarks", func(w http.ResponseWriter, r *http.Request) {
+		name := r.PostFormValue("name")
 		if name == "" {
-			if err := indexTmpl.Execute(w, nil); err != nil {
-				slog.Error("GET: failed to execute index", "error", err)
+			serveError(w, r, http.StatusBadRequest)
+			return
+		}
+
+		b := Bookmark{Name: name}
+		var err error
+		switch r.PostFormValue("action") {
+		case "add":
+			// Only keys of the wiki can be bookmarked, since bookmarks link
+			// to them. The offset is looked up rather than taken from the
+			// form, since the page it's on could have been requested with the
+			// offset of another entry.
+			ctx, cancel := readContext(r)
+			wiki := wikis.acquire()
+			b.Offset, err = wiki.EntryOffsetContext(ctx, normalization.Apply(name))
+			wikis.release(wiki)
+			cancel()
+			if err != nil {
+				requestLog(r).Warn("POST: bookmark of an unknown entry", "name", name, "error", err)
+				serveError(w, r, http.StatusBadRequest)
+				return
 			}
+			err = bookmarks.add(b)
+		case "remove":
+			err = bookmarks.remove(name)
+		default:
+			serveError(w, r, http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			requestLog(r).Error("POST: failed to update bookmarks", "name", name, "error", err)
+			serveError(w, r, http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, b.URL(), http.StatusSeeOther)
+	})
+
+	mux.HandleFunc("POST /-/theme", func(w http.ResponseWriter, r *http.Request) {
+		t, err := parseTheme(r.PostFormValue("theme"))
+		if err != nil {
+			serveError(w, r, http.StatusBadRequest)
 			return
 		}
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     themeCookie,
+			Value:    string(t),
+			Path:     "/",
+			MaxAge:   365 * 24 * 60 * 60,
+			SameSite: http.SameSiteLaxMode,
+		})
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	})
+
+	mux.HandleFunc("GET /{name...}", func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
 		if name == "favicon.ico" {
 			w.WriteHeader(http.StatusNoContent)
 			return
 		}
 
+		// The wiki is released once the entry is read, rather than once it's
+		// sent, so that a slow client doesn't keep a replaced wiki open.
+		wiki := wikis.acquire()
+		release := sync.OnceFunc(func() { wikis.release(wiki) })
+		defer release()
+
+		// The main page is shown at / with a search box, if the wiki has one.
+		isMainPage := name == "" && wiki.MainPage() != ""
+		if isMainPage {
+			name = wiki.MainPage()
+		} else if name == "" {
+			page := newIndexPage(wikiName, requestTheme(r, defaultTheme))
+			page.Languages = wiki.Languages()
+			page.Popular = popularEntries(wiki.Wiki)
+
+			if err := indexTmpl.Execute(w, page); err != nil {
+				requestLog(r).Error("GET: failed to execute index", "error", err)
+			}
+			return
+		}
+
+		ctx, cancel := readContext(r)
+		defer cancel()
+
 		offsetStr := r.URL.Query().Get("offset")
 
 		var offset int64
+		var err error
 		if offsetStr == "" {
-			offset, err = wiki.entryOffset(name)
+			offset, err = wiki.EntryOffsetContext(ctx, normalization.Apply(name))
 			if err != nil {
-				slog.Error("GET: entryOffset failed", "name", name, "error", err)
-				w.WriteHeader(http.StatusInternalServerError)
+				requestLog(r).Error("GET: entryOffset failed", "name", name, "error", err)
+				page := newErrorPage(r, statusForError(err), requestTheme(r, defaultTheme))
+				if errors.Is(err, reader.ErrNotFound) {
+					page.Name = name
+					page.Suggestions = suggestions(ctx, wiki.Wiki, normalization.Apply(name), queryOpts)
+				}
+				writeErrorPage(w, r, tmpl.error, page)
 				return
 			}
 		} else {
 			offset, err = strconv.ParseInt(offsetStr, 10, 64)
 			if err != nil {
-				slog.Error("GET: ParseInt failed", "name", name, "offset", offsetStr, "error", err)
-				w.WriteHeader(http.StatusInternalServerError)
+				requestLog(r).Error("GET: ParseInt failed", "name", name, "offset", offsetStr, "error", err)
+				serveError(w, r, http.StatusBadRequest)
 				return
 			}
 		}
 
-		rdr, err := wiki.entryAt(offset)
+		contentType := wiki.ContentType(offset)
+
+		if wiki.Compression() == compression.Brotli {
+			w.Header().Add("Vary", "Accept-Encoding")
+
+			// Entries are sent as they're stored to clients which can
+			// decompress them, unless they're modified (HTML entries get a
+			// bookmark button) or only part of one is requested.
+			modified := storage.IsHTML(contentType)
+			if !modified && r.Header.Get("Range") == "" && acceptsEncoding(r, "br") {
+				_, raw, err := wiki.RawEntryContext(ctx, offset)
+				if err != nil {
+					requestLog(r).Error("GET: rawEntry failed", "name", name, "offset", offset, "error", err)
+					serveError(w, r, statusForError(err))
+					return
+				}
+
+				buf := getBuffer()
+				defer putBuffer(buf)
+				if _, err = io.Copy(buf, raw); err != nil {
+					requestLog(r).Error("GET: reading raw entry failed", "name", name, "offset", offset, "error", err)
+					serveError(w, r, statusForError(err))
+					return
+				}
+				cancel()
+				release()
+
+				w.Header().Set("Content-Type", contentType)
+				w.Header().Set("Content-Encoding", "br")
+				w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+				if _, err = w.Write(buf.Bytes()); err != nil {
+					requestLog(r).Error("GET: Write failed", "name", name, "offset", offset, "error", err)
+				}
+				return
+			}
+		}
+
+		rdr, err := wiki.EntryAtContext(ctx, offset)
 		if err != nil {
-			slog.Error("GET: entryAt failed", "name", name, "offset", offset, "error", err)
-			w.WriteHeader(http.StatusInternalServerError)
+			requestLog(r).Error("GET: entryAt failed", "name", name, "offset", offset, "error", err)
+			serveError(w, r, statusForError(err))
 			return
 		}
 
-		if _, err = io.Copy(w, rdr); err != nil {
-			slog.Error("GET: Copy failed", "name", name, "offset", offset, "error", err)
+		w.Header().Set("Content-Type", contentType)
+
+		if storage.IsHTML(contentType) {
+			// Articles get a button to bookmark them, which is in the header
+			// with -wrap, and at the start of the body otherwise.
+			actions := bookmarkForm(name, bookmarks.has(name))
+			insert := actions
+			if *wrap {
+				buf := getBuffer()
+				defer putBuffer(buf)
+				if count, err := wiki.EntryWordCountAt(offset); err == nil && count > 0 {
+					actions += `<p class="wiki-reading-time">` + readingTime(count) + `</p>`
+				}
+				if err := decorate(buf, rdr, name, actions); err != nil {
+					requestLog(r).Error("GET: decorate failed", "name", name, "offset", offset, "error", err)
+					serveError(w, r, http.StatusInternalServerError)
+					return
+				}
+				rdr = buf
+				insert = ""
+			}
+			if isMainPage {
+				insert = searchForm + insert
+			}
+
+			if insert != "" {
+				buf := getBuffer()
+				defer putBuffer(buf)
+				if err := insertAtBodyStart(buf, rdr, insert); err != nil {
+					requestLog(r).Error("GET: insertAtBodyStart failed", "name", name, "offset", offset, "error", err)
+					serveError(w, r, http.StatusInternalServerError)
+					return
+				}
+				rdr = buf
+			}
+		}
+
+		// The whole entry is decompressed before any of it is sent, so that
+		// a slow client can't hold the read open (and run into the read
+		// timeout). It also means that its size is known, to serve ranges of
+		// it.
+		buf, ok := rdr.(*bytes.Buffer)
+		if !ok {
+			buf = getBuffer()
+			defer putBuffer(buf)
+			if _, err = io.Copy(buf, rdr); err != nil {
+				requestLog(r).Error("GET: decompressing failed", "name", name, "offset", offset, "error", err)
+				serveError(w, r, statusForError(err))
+				return
+			}
 		}
+		cancel()
+		release()
+
+		http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(buf.Bytes()))
 	})
 
-	slog.Error("exiting", "error", http.ListenAndServe(addr, nil))
+	var handler http.Handler = mux
+	if tracer != nil {
+		handler = traceRequests(tracer, mux)
+	}
+	if creds != nil {
+		handler = requireCredentials(creds, handler, func(w http.ResponseWriter, r *http.Request) {
+			requestLog(r).Warn("unauthorized request", "method", r.Method, "path", r.URL.Path, "remote", r.RemoteAddr)
+			serveError(w, r, http.StatusUnauthorized)
+		})
+	}
+	handler = withRequestIDs(handler)
+
+	server := &http.Server{Handler: handler}
+	errs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func() {
+			errs <- server.Serve(l)
+		}()
+	}
+
+	slog.Error("exiting", "error", <-errs)
 }
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"strings"
+
+	nethtml "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+type heading struct {
+	level int
+	id    string
+	text  string
+}
+
+// decorate copies the HTML of an entry from r to w, adding a link to the
+// stylesheet, and a header with the title of the entry and a table of contents
+// generated from its h2 and h3 headings. name is used as the title when the
+// entry doesn't have one. actions is HTML which is added to the header.
+func decorate(w io.Writer, r io.Reader, name string, actions string) error {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("failed to read entry: %w", err)
+	}
+
+	title, headings, hasBody := scanPage(content)
+	if title == "" {
+		title = name
+	}
+
+	header := pageHeader(title, headings, actions)
+	if !hasBody {
+		if _, err := io.WriteString(w, header); err != nil {
+			return err
+		}
+	}
+
+	z := nethtml.NewTokenizer(bytes.NewReader(content))
+	headingIdx := 0
+	for {
+		tt := z.Next()
+		if tt == nethtml.ErrorToken {
+			if z.Err() == io.EOF {
+				return nil
+			}
+			return z.Err()
+		}
+
+		raw := z.Raw()
+		var inject string
+		if tt == nethtml.StartTagToken {
+			tok := z.Token()
+			switch tok.DataAtom {
+			case atom.Head:
+				inject = `<link rel="stylesheet" href="/-/style.css">`
+			case atom.Body:
+				inject = header
+			case atom.H2, atom.H3:
+				if attr(tok, "id") == "" {
+					tok.Attr = append(tok.Attr, nethtml.Attribute{Key: "id", Val: headings[headingIdx].id})
+					raw = []byte(tok.String())
+				}
+				headingIdx++
+			}
+		}
+
+		if _, err := w.Write(raw); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, inject); err != nil {
+			return err
+		}
+	}
+}
+
+// searchForm is the search box which is added to the main page.
+const searchForm = `<form class="wiki-search" action="/" method="post">` +
+	`<input type="text" name="query" placeholder="Enter your query">` +
+	`<input type="submit" value="検索"></form>`
+
+// insertAtBodyStart copies the HTML of an entry from r to w, adding the HTML in
+// s to the start of its body (or the start of the page if it doesn't have
+// one).
+func insertAtBodyStart(w io.Writer, r io.Reader, s string) error {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("failed to read entry: %w", err)
+	}
+
+	// Find the end of the body start tag.
+	end := 0
+	z := nethtml.NewTokenizer(bytes.NewReader(content))
+	for {
+		tt := z.Next()
+		if tt == nethtml.ErrorToken {
+			end = 0
+			break
+		}
+
+		end += len(z.Raw())
+		if tt == nethtml.StartTagToken {
+			if name, _ := z.TagName(); atom.Lookup(name) == atom.Body {
+				break
+			}
+		}
+	}
+
+	if _, err := w.Write(content[:end]); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, s); err != nil {
+		return err
+	}
+	_, err = w.Write(content[end:])
+	return err
+}
+
+// scanPage finds the title and the headings (with IDs filled in) of the page
+// in content.
+func scanPage(content []byte) (string, []heading, bool) {
+	var title string
+	var headings []heading
+	hasBody := false
+
+	var text strings.Builder
+	inTitle := false
+	inHeading := false
+
+	z := nethtml.NewTokenizer(bytes.NewReader(content))
+	for {
+		tt := z.Next()
+		if tt == nethtml.ErrorToken {
+			return title, headings, hasBody
+		}
+
+		tok := z.Token()
+		switch tt {
+		case nethtml.StartTagToken:
+			switch tok.DataAtom {
+			case atom.Body:
+				hasBody = true
+			case atom.Title:
+				inTitle = true
+				text.Reset()
+			case atom.H2, atom.H3:
+				level := 2
+				if tok.DataAtom == atom.H3 {
+					level = 3
+				}
+
+				id := attr(tok, "id")
+				if id == "" {
+					id = fmt.Sprintf("toc-%d", len(headings))
+				}
+
+				headings = append(headings, heading{level: level, id: id})
+				inHeading = true
+				text.Reset()
+			}
+		case nethtml.TextToken:
+			if inTitle || inHeading {
+				text.WriteString(tok.Data)
+			}
+		case nethtml.EndTagToken:
+			switch tok.DataAtom {
+			case atom.Title:
+				if inTitle {
+					title = strings.TrimSpace(text.String())
+					inTitle = false
+				}
+			case atom.H2, atom.H3:
+				if inHeading {
+					headings[len(headings)-1].text = strings.TrimSpace(text.String())
+					inHeading = false
+				}
+			}
+		}
+	}
+}
+
+func pageHeader(title string, headings []heading, actions string) string {
+	var sb strings.Builder
+	sb.WriteString(`<header class="wiki-header"><h1>`)
+	sb.WriteString(html.EscapeString(title))
+	sb.WriteString(`</h1>`)
+	sb.WriteString(actions)
+
+	if len(headings) > 0 {
+		sb.WriteString(`<nav class="wiki-toc"><ul>`)
+		for _, h := range headings {
+			fmt.Fprintf(
+				&sb,
+				`<li class="wiki-toc-h%d"><a href="#%s">%s</a></li>`,
+				h.level,
+				html.EscapeString(h.id),
+				html.EscapeString(h.text),
+			)
+		}
+		sb.WriteString(`</ul></nav>`)
+	}
+
+	sb.WriteString(`</header>`)
+	return sb.String()
+}
+
+func attr(tok nethtml.Token, key string) string {
+	for _, a := range tok.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+
+	return ""
+}
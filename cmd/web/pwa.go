@@ -0,0 +1,48 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+//go:embed "manifest.webmanifest"
+var manifest string
+
+//go:embed "sw.js"
+var serviceWorker string
+
+//go:embed "icon.svg"
+var icon string
+
+type staticFile struct {
+	contentType string
+	content     string
+}
+
+// pwaFiles are the files which are needed to install the web UI as a
+// progressive web app, keyed by their path under /-/.
+var pwaFiles = map[string]staticFile{
+	"manifest.webmanifest": {"application/manifest+json", manifest},
+	"sw.js":                {"text/javascript", serviceWorker},
+	"icon.svg":             {"image/svg+xml", icon},
+}
+
+// servePWAFile writes the PWA file with the given name, returning false if
+// there isn't one.
+func servePWAFile(w http.ResponseWriter, name string) (bool, error) {
+	f, found := pwaFiles[name]
+	if !found {
+		return false, nil
+	}
+
+	w.Header().Set("Content-Type", f.contentType)
+	if name == "sw.js" {
+		// Allow the service worker to control every page even though it's
+		// served from /-/.
+		w.Header().Set("Service-Worker-Allowed", "/")
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+
+	_, err := w.Write([]byte(f.content))
+	return true, err
+}
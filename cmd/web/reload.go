@@ -0,0 +1,149 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/rsookram/wiki-builder/internal/reader"
+)
+
+// openWiki opens the wiki to serve, configured from the flags.
+type openWiki func() (*reader.Wiki, error)
+
+// wikiRef is a wiki along with the requests which are using it, so that it can
+// be closed once they're done after it's replaced.
+type wikiRef struct {
+	*reader.Wiki
+	inFlight sync.WaitGroup
+}
+
+// wikiHolder holds the wiki being served, which can be replaced while serving
+// requests.
+type wikiHolder struct {
+	open openWiki
+	// reloadMu ensures that only one reload happens at a time.
+	reloadMu sync.Mutex
+
+	mu      sync.Mutex
+	current *wikiRef
+}
+
+func newWikiHolder(open openWiki) (*wikiHolder, error) {
+	wiki, err := open()
+	if err != nil {
+		return nil, err
+	}
+
+	return &wikiHolder{open: open, current: &wikiRef{Wiki: wiki}}, nil
+}
+
+// acquire returns the current wiki. release must be called on it once the
+// request is done with it.
+func (h *wikiHolder) acquire() *wikiRef {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ref := h.current
+	ref.inFlight.Add(1)
+	return ref
+}
+
+func (h *wikiHolder) release(ref *wikiRef) {
+	ref.inFlight.Done()
+}
+
+// reload opens the wiki again, and swaps it in for new requests. The previous
+// wiki is closed after the requests using it finish. The previous wiki is kept
+// if the new one can't be opened (e.g. because it's still being written).
+func (h *wikiHolder) reload() {
+	h.reloadMu.Lock()
+	defer h.reloadMu.Unlock()
+
+	wiki, err := h.open()
+	if err != nil {
+		slog.Error("failed to reload wiki, so continuing to serve the previous one", "error", err)
+		return
+	}
+
+	h.mu.Lock()
+	old := h.current
+	h.current = &wikiRef{Wiki: wiki}
+	h.mu.Unlock()
+
+	slog.Info("reloaded wiki")
+
+	go func() {
+		old.inFlight.Wait()
+		if err := old.Close(); err != nil {
+			slog.Error("failed to close previous wiki", "error", err)
+		}
+	}()
+}
+
+// handleReloadSignal reloads the wiki every time SIGHUP is received.
+func (h *wikiHolder) handleReloadSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	go func() {
+		for range signals {
+			h.reload()
+		}
+	}()
+}
+
+// reloadDelay is how long to wait after the last change to the wiki file
+// before reloading it, so that it isn't reloaded while it's being written.
+const reloadDelay = time.Second
+
+// watch reloads the wiki when the file at path changes. The directory is
+// watched rather than the file so that replacing the file (e.g. by renaming a
+// new build over it) is noticed.
+func (h *wikiHolder) watch(path string) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return err
+	}
+
+	go func() {
+		defer watcher.Close()
+
+		var timer *time.Timer
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) != filepath.Clean(path) || !event.Has(fsnotify.Write|fsnotify.Create) {
+					continue
+				}
+
+				if timer == nil {
+					timer = time.AfterFunc(reloadDelay, h.reload)
+				} else {
+					timer.Reset(reloadDelay)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				slog.Error("error watching wiki file", "path", path, "error", err)
+			}
+		}
+	}()
+
+	return nil
+}
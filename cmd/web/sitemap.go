@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/rsookram/wiki-builder/internal/reader"
+)
+
+// sitemapPageSize is the number of URLs in each page of the sitemap, which is
+// the most that the sitemap protocol allows in one file.
+const sitemapPageSize = 50000
+
+// errPageFull stops reading keys once a page of the sitemap is full.
+var errPageFull = errors.New("sitemap page is full")
+
+// registerSitemap serves a sitemap of every key at /sitemap.xml, and a
+// robots.txt which points crawlers to it. The sitemap is an index of pages of
+// up to sitemapPageSize URLs, which are served at /sitemap.xml?page=<n>
+// (starting from 1) since sitemaps can only list URLs in their directory.
+func registerSitemap(mux *http.ServeMux, wikis *wikiHolder) {
+	mux.HandleFunc("GET /robots.txt", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintf(w, "User-agent: *\nDisallow: /-/\n\nSitemap: %s/sitemap.xml\n", baseURL(r))
+	})
+
+	mux.HandleFunc("GET /sitemap.xml", func(w http.ResponseWriter, r *http.Request) {
+		wiki := wikis.acquire()
+		defer wikis.release(wiki)
+
+		pageStr := r.URL.Query().Get("page")
+		if pageStr == "" {
+			if err := writeSitemapIndex(w, wiki.Wiki, baseURL(r)); err != nil {
+				slog.Error("GET: failed to write sitemap index", "error", err)
+			}
+			return
+		}
+
+		page, err := strconv.Atoi(pageStr)
+		if err != nil || page < 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if err := writeSitemapPage(w, wiki.Wiki, baseURL(r), page); err != nil {
+			slog.Error("GET: failed to write sitemap page", "page", page, "error", err)
+		}
+	})
+}
+
+// writeSitemapIndex writes a sitemap index with a page for every
+// sitemapPageSize keys in wiki.
+func writeSitemapIndex(w http.ResponseWriter, wiki *reader.Wiki, base string) error {
+	numKeys := 0
+	err := wiki.Keys(func(reader.SearchResult) error {
+		numKeys++
+		return nil
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	out := bufio.NewWriter(w)
+	out.WriteString(xml.Header)
+	out.WriteString(`<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">`)
+	numPages := max(1, (numKeys+sitemapPageSize-1)/sitemapPageSize)
+	for page := 1; page <= numPages; page++ {
+		out.WriteString("<sitemap><loc>")
+		xml.EscapeText(out, []byte(base+"/sitemap.xml?page="+strconv.Itoa(page)))
+		out.WriteString("</loc></sitemap>")
+	}
+	out.WriteString("</sitemapindex>\n")
+
+	return out.Flush()
+}
+
+// writeSitemapPage writes the URLs of the keys on page of the sitemap as
+// they're read from wiki. Pages past the last one are not found.
+func writeSitemapPage(w http.ResponseWriter, wiki *reader.Wiki, base string, page int) error {
+	out := bufio.NewWriter(w)
+	started := false
+	startURLSet := func() {
+		w.Header().Set("Content-Type", "application/xml")
+		out.WriteString(xml.Header)
+		out.WriteString(`<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">`)
+		started = true
+	}
+
+	start := (page - 1) * sitemapPageSize
+	i := 0
+	err := wiki.Keys(func(r reader.SearchResult) error {
+		if i == start+sitemapPageSize {
+			return errPageFull
+		}
+		i++
+		if i <= start {
+			return nil
+		}
+
+		if !started {
+			startURLSet()
+		}
+
+		u := url.URL{Path: "/" + r.Key}
+		out.WriteString("<url><loc>")
+		xml.EscapeText(out, []byte(base+u.EscapedPath()))
+		_, err := out.WriteString("</loc></url>")
+		return err
+	})
+	if err != nil && !errors.Is(err, errPageFull) {
+		if !started {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return err
+	}
+
+	if !started {
+		// The first page of an empty wiki is empty rather than missing.
+		if page > 1 {
+			w.WriteHeader(http.StatusNotFound)
+			return nil
+		}
+		startURLSet()
+	}
+	out.WriteString("</urlset>\n")
+
+	return out.Flush()
+}
+
+// baseURL returns the scheme and host that r was sent to, for the absolute
+// URLs that sitemaps need.
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	return scheme + "://" + r.Host
+}
@@ -0,0 +1,38 @@
+// Caches pages as they're viewed so that they can be loaded again when the
+// server isn't running. The network is always tried first so that the cache
+// doesn't serve stale pages while the server is available.
+const CACHE = 'wiki-v1';
+const PRECACHE = ['/', '/-/style.css', '/-/bookmarks', '/-/manifest.webmanifest', '/-/icon.svg'];
+
+self.addEventListener('install', (event) => {
+  event.waitUntil(caches.open(CACHE).then((cache) => cache.addAll(PRECACHE)));
+  self.skipWaiting();
+});
+
+self.addEventListener('activate', (event) => {
+  event.waitUntil(
+    caches.keys()
+      .then((keys) => Promise.all(keys.filter((k) => k !== CACHE).map((k) => caches.delete(k))))
+      .then(() => self.clients.claim())
+  );
+});
+
+self.addEventListener('fetch', (event) => {
+  const request = event.request;
+  if (request.method !== 'GET' || new URL(request.url).origin !== self.location.origin) {
+    return;
+  }
+
+  event.respondWith(
+    fetch(request)
+      .then((response) => {
+        // Partial responses can't be cached.
+        if (response.ok && response.status !== 206) {
+          const copy = response.clone();
+          caches.open(CACHE).then((cache) => cache.put(request, copy));
+        }
+        return response;
+      })
+      .catch(() => caches.match(request).then((cached) => cached || caches.match('/')))
+  );
+});
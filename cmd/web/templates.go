@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rsookram/wiki-builder/internal/assets"
+	"github.com/rsookram/wiki-builder/internal/reader"
+	"github.com/rsookram/wiki-builder/internal/snippet"
+	"github.com/rsookram/wiki-builder/internal/words"
+)
+
+// templates are the files used to render the UI, which can be overridden by
+// files with the same names in a directory.
+type templates struct {
+	index     *template.Template
+	bookmarks *template.Template
+	error     *template.Template
+	styleCSS  string
+}
+
+// loadTemplates reads index.html, bookmarks.html, error.html, and style.css
+// from dir,
+// falling back to the embedded defaults for any which don't exist. An empty
+// dir uses the defaults for all of them.
+func loadTemplates(dir string) (templates, error) {
+	var t templates
+
+	indexHtml, err := readOverride(dir, "index.html", indexHtmlTemplate)
+	if err != nil {
+		return t, err
+	}
+	t.index, err = template.New("index").Parse(indexHtml)
+	if err != nil {
+		return t, fmt.Errorf("failed to parse index.html: %w", err)
+	}
+
+	bookmarksHtml, err := readOverride(dir, "bookmarks.html", bookmarksHtmlTemplate)
+	if err != nil {
+		return t, err
+	}
+	t.bookmarks, err = template.New("bookmarks").Parse(bookmarksHtml)
+	if err != nil {
+		return t, fmt.Errorf("failed to parse bookmarks.html: %w", err)
+	}
+
+	errorHtml, err := readOverride(dir, "error.html", errorHtmlTemplate)
+	if err != nil {
+		return t, err
+	}
+	t.error, err = template.New("error").Parse(errorHtml)
+	if err != nil {
+		return t, fmt.Errorf("failed to parse error.html: %w", err)
+	}
+
+	t.styleCSS, err = readOverride(dir, "style.css", assets.StyleCSS)
+	if err != nil {
+		return t, err
+	}
+
+	return t, nil
+}
+
+func readOverride(dir string, name string, def string) (string, error) {
+	if dir == "" {
+		return def, nil
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return def, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", name, err)
+	}
+
+	slog.Info("using template override", "name", name)
+	return string(b), nil
+}
+
+// searchResult is a search result as exposed to the index template.
+type searchResult struct {
+	reader.SearchResult
+	wiki *reader.Wiki
+	// ctx is the context of the request, which reading the entry for its
+	// snippet stops at.
+	ctx context.Context
+}
+
+type breadcrumb struct {
+	Name string
+	Key  string
+}
+
+// Name returns the last segment of the key.
+func (r searchResult) Name() string {
+	return r.Key[strings.LastIndex(r.Key, "/")+1:]
+}
+
+// Breadcrumbs returns the keys of the parents of the key (e.g. "A" and "A/B"
+// for "A/B/C").
+func (r searchResult) Breadcrumbs() []breadcrumb {
+	var crumbs []breadcrumb
+	for i, ch := range r.Key {
+		if ch == '/' && i > 0 {
+			crumbs = append(crumbs, breadcrumb{
+				Name: r.Key[strings.LastIndex(r.Key[:i], "/")+1 : i],
+				Key:  r.Key[:i],
+			})
+		}
+	}
+
+	return crumbs
+}
+
+// stubSize is the uncompressed size in bytes below which an entry is shown as a
+// stub in search results.
+const stubSize = 4 * 1024
+
+// Stub returns whether the entry is short enough to be a stub, which is only
+// known if the wiki was built with sizes.
+func (r searchResult) Stub() bool {
+	return r.Size >= 0 && r.Size < stubSize
+}
+
+// ReadingTime returns about how long the entry takes to read, or an empty
+// string if it's unknown (i.e. the wiki was built without word counts) or the
+// entry doesn't have any text.
+func (r searchResult) ReadingTime() string {
+	if r.WordCount <= 0 {
+		return ""
+	}
+
+	return readingTime(r.WordCount)
+}
+
+// readingTime formats the time it takes to read count words.
+func readingTime(count int64) string {
+	return fmt.Sprintf("約%d分", words.ReadingMinutes(count))
+}
+
+// Snippet returns the start of the text of the entry. If the wiki wasn't built
+// with snippets, it's extracted from the entry, which is only read when the
+// template uses it.
+func (r searchResult) Snippet() string {
+	if r.SearchResult.Snippet != "" {
+		return r.SearchResult.Snippet
+	}
+
+	rdr, err := r.wiki.EntryAtContext(r.ctx, r.EntryOffset)
+	if err != nil {
+		slog.Error("failed to read entry for snippet", "key", r.Key, "offset", r.EntryOffset, "error", err)
+		return ""
+	}
+
+	return snippet.Extract(rdr, snippet.MaxLen)
+}
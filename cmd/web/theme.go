@@ -0,0 +1,59 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"net/http"
+)
+
+//go:embed "dark.css"
+var darkCSS string
+
+const themeCookie = "theme"
+
+type theme string
+
+const (
+	themeLight theme = "light"
+	themeDark  theme = "dark"
+	// themeAuto follows the color scheme preferred by the browser.
+	themeAuto theme = "auto"
+)
+
+func parseTheme(s string) (theme, error) {
+	switch t := theme(s); t {
+	case themeLight, themeDark, themeAuto:
+		return t, nil
+	default:
+		return "", fmt.Errorf("unknown theme %q", s)
+	}
+}
+
+// requestTheme returns the theme chosen by the user through the theme cookie,
+// falling back to def when there isn't a valid one.
+func requestTheme(r *http.Request, def theme) theme {
+	c, err := r.Cookie(themeCookie)
+	if err != nil {
+		return def
+	}
+
+	t, err := parseTheme(c.Value)
+	if err != nil {
+		return def
+	}
+
+	return t
+}
+
+// css returns the CSS that needs to be added on top of the default (light)
+// styles to apply the theme.
+func (t theme) css() string {
+	switch t {
+	case themeDark:
+		return darkCSS
+	case themeAuto:
+		return "@media (prefers-color-scheme: dark) {\n" + darkCSS + "}\n"
+	default:
+		return ""
+	}
+}
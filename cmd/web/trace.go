@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/rsookram/wiki-builder/internal/reader"
+)
+
+// logTracer logs the duration of each span, along with the ID of the request
+// it's part of, so that slow requests can be broken down into their steps.
+type logTracer struct {
+	nextRequestID atomic.Uint64
+}
+
+type logSpanKey struct{}
+
+type logSpan struct {
+	name      string
+	requestID uint64
+	start     time.Time
+}
+
+func (t *logTracer) Start(ctx context.Context, name string) (context.Context, reader.Span) {
+	span := &logSpan{name: name, start: time.Now()}
+	if parent, ok := ctx.Value(logSpanKey{}).(*logSpan); ok {
+		span.requestID = parent.requestID
+	} else {
+		span.requestID = t.nextRequestID.Add(1)
+	}
+
+	return context.WithValue(ctx, logSpanKey{}, span), span
+}
+
+func (s *logSpan) End() {
+	slog.Info("span", "request", s.requestID, "name", s.name, "duration", time.Since(s.start))
+}
+
+// traceRequests wraps h so that each request is a span of t, which the spans
+// of the reader are children of.
+func traceRequests(t reader.Tracer, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := t.Start(r.Context(), r.Method+" "+r.URL.Path)
+		defer span.End()
+
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
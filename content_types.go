@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"slices"
+
+	"github.com/rsookram/wiki-builder/internal/storage"
+	"github.com/rsookram/wiki-builder/wikifile"
+)
+
+// contentTypeRows are the content types of entries which aren't HTML, in the
+// order that they were read.
+type contentTypeRows struct {
+	types   []string
+	offsets []uint64
+	indexes []byte
+}
+
+// append adds the content types of the entries from a source whose entries
+// start at baseOffset.
+func (c *contentTypeRows) append(entries storage.EntryMetadata, contentTypes []string, baseOffset uint64) {
+	if len(contentTypes) != entries.Len() {
+		panic(fmt.Sprintf("number of content types (%d) doesn't match the number of entries (%d)", len(contentTypes), entries.Len()))
+	}
+
+	for i, t := range contentTypes {
+		c.add(baseOffset+entries.StartOffset(i), t)
+	}
+}
+
+// add adds the content type of the entry at offset.
+func (c *contentTypeRows) add(offset uint64, t string) {
+	if t == storage.DefaultContentType {
+		return
+	}
+
+	idx := slices.Index(c.types, t)
+	if idx < 0 {
+		if len(c.types) == math.MaxUint8 {
+			panic("too many content types")
+		}
+		if len(t) > math.MaxUint8 {
+			panic(fmt.Sprintf("content type is too long: %s", t))
+		}
+
+		idx = len(c.types)
+		c.types = append(c.types, t)
+	}
+
+	c.offsets = append(c.offsets, offset)
+	c.indexes = append(c.indexes, byte(idx))
+}
+
+func (c *contentTypeRows) encode(offsetWidth byte) []byte {
+	bb := []byte{byte(len(c.types))}
+	for _, t := range c.types {
+		bb = append(bb, byte(len(t)))
+		bb = append(bb, t...)
+	}
+
+	bb = binary.LittleEndian.AppendUint32(bb, uint32(len(c.offsets)))
+	for _, i := range sortedByOffset(c.offsets) {
+		bb = wikifile.AppendOffset(bb, c.offsets[i], offsetWidth)
+		bb = append(bb, c.indexes[i])
+	}
+
+	return bb
+}
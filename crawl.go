@@ -0,0 +1,667 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf16"
+
+	nethtml "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+
+	"github.com/rsookram/wiki-builder/internal/progress"
+	"github.com/rsookram/wiki-builder/internal/s3"
+	"github.com/rsookram/wiki-builder/internal/storage"
+	"github.com/rsookram/wiki-builder/wikifile"
+)
+
+// crawlUserAgent is sent with every request, and matched against the groups in
+// robots.txt.
+const crawlUserAgent = "wiki-builder"
+
+// crawlTimeout bounds each request, so that a stalled server doesn't stop the
+// crawl.
+const crawlTimeout = 30 * time.Second
+
+// maxRedirectHops bounds the chains of redirects which are followed to find
+// the entry that a redirect points at.
+const maxRedirectHops = 10
+
+// crawlLinkAttrs are the attributes of the elements with links to other pages
+// and resources which are crawled.
+var crawlLinkAttrs = map[atom.Atom]string{
+	atom.A:      "href",
+	atom.Area:   "href",
+	atom.Link:   "href",
+	atom.Img:    "src",
+	atom.Script: "src",
+	atom.Source: "src",
+	atom.Audio:  "src",
+	atom.Video:  "src",
+	atom.Iframe: "src",
+}
+
+// crawl fetches the page at startURL, and the pages and resources it links to
+// whose URLs start with prefix, to dataDir. The stage files for
+// compress-entries are written like index-fs does, with the pages as entries
+// and the HTTP redirects between them as redirects. Entries are named by their
+// URL relative to prefix, and links between them are rewritten to be relative,
+// so that they work in the wiki file.
+//
+// Requests are sent at most concurrency at a time, with at least delay between
+// them (or the Crawl-delay in robots.txt, if it's longer), and the paths that
+// robots.txt disallows aren't fetched. If maxPages is positive, no more than
+// that many URLs are fetched.
+func crawl(startURL string, dataDir string, prefix string, delay time.Duration, concurrency int, maxPages int, normalization storage.Normalization, reporter *progress.Reporter) {
+	start, err := url.Parse(startURL)
+	if err != nil || (start.Scheme != "http" && start.Scheme != "https") || start.Host == "" {
+		panic(fmt.Sprintf("invalid start URL %q: it must be an HTTP(S) URL", startURL))
+	}
+	start.Fragment = ""
+	if prefix == "" {
+		p := *start
+		p.RawQuery = ""
+		p.Path = p.Path[:strings.LastIndex(p.Path, "/")+1]
+		p.RawPath = ""
+		prefix = p.String()
+	}
+	if concurrency < 1 {
+		panic("-concurrency must be at least 1")
+	}
+	if s3.IsURL(dataDir) {
+		panic("crawl writes the pages it fetches to a local directory, so it can't be in object storage")
+	}
+
+	c := newCrawler(prefix)
+	if !c.inScope(start) {
+		panic(fmt.Sprintf("the start URL %s isn't within -prefix %s (or robots.txt disallows it)", start, prefix))
+	}
+	if c.robots.delay > delay {
+		log.Println("Waiting", c.robots.delay, "between requests, as robots.txt asks")
+		delay = c.robots.delay
+	}
+	if delay > 0 {
+		ticker := time.NewTicker(delay)
+		defer ticker.Stop()
+		c.limiter = ticker.C
+	}
+
+	streamed := *stageOutputDir == storage.Stream
+	var stageDir string
+	if streamed {
+		stageDir = storage.TempStageDir()
+		defer os.RemoveAll(stageDir)
+	} else {
+		stageDir = storage.StageDir(dataDir, *stageOutputDir, *workdir)
+	}
+	defer storage.LockStageDir(stageDir, false)()
+
+	// The pages are fetched to a new directory, which replaces the one from
+	// the previous crawl once it's done, so that its stage files still work
+	// if this one is cancelled.
+	pagesDir := filepath.Join(dataDir, "crawl")
+	c.pagesDir = pagesDir + ".new"
+	if err := os.RemoveAll(c.pagesDir); err != nil {
+		panic(err)
+	}
+	if err := os.MkdirAll(c.pagesDir, 0o755); err != nil {
+		panic(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	jobs := make(chan crawlJob)
+	results := make(chan crawlResult)
+	var wg sync.WaitGroup
+	for range concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				results <- c.fetch(ctx, job)
+			}
+		}()
+	}
+
+	seen := map[string]bool{}
+	var queue []crawlJob
+	enqueue := func(u *url.URL) {
+		name := c.name(u)
+		key := normalization.Apply(name)
+		if seen[key] || strings.ContainsAny(name, "\t\n") {
+			return
+		}
+		seen[key] = true
+
+		queue = append(queue, crawlJob{id: len(seen), u: u, name: name})
+	}
+	enqueue(start)
+
+	var pages []crawlResult
+	var redirects []crawlRedirect
+	outOfScope := 0
+	fetched := 0
+	inFlight := 0
+	for inFlight > 0 || (len(queue) > 0 && (maxPages <= 0 || fetched < maxPages)) {
+		var send chan<- crawlJob
+		var next crawlJob
+		if len(queue) > 0 && (maxPages <= 0 || fetched < maxPages) {
+			send = jobs
+			next = queue[0]
+		}
+
+		select {
+		case send <- next:
+			queue = queue[1:]
+			fetched++
+			inFlight++
+		case r := <-results:
+			inFlight--
+			switch {
+			case r.target != nil && c.inScope(r.target):
+				redirects = append(redirects, crawlRedirect{name: r.job.name, target: c.name(r.target)})
+				enqueue(r.target)
+			case r.target != nil:
+				outOfScope++
+			case r.localPath != "":
+				pages = append(pages, r)
+				for _, u := range r.links {
+					enqueue(u)
+				}
+			}
+			reporter.Update(fetched-inFlight, fetched+len(queue))
+		case <-reporter.Cancelled():
+			cancel()
+			for ; inFlight > 0; inFlight-- {
+				<-results
+			}
+			close(jobs)
+			wg.Wait()
+
+			// The stage files from the previous crawl are left as they were.
+			os.RemoveAll(c.pagesDir)
+			if streamed {
+				os.RemoveAll(stageDir)
+			}
+			reporter.Exit()
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if len(queue) > 0 {
+		log.Println("Stopped after fetching", fetched, "URLs (-max-pages), with", len(queue), "left")
+	}
+	if outOfScope > 0 {
+		log.Println("Dropped", outOfScope, "redirects to URLs outside of -prefix")
+	}
+
+	if err := os.RemoveAll(pagesDir); err != nil {
+		panic(err)
+	}
+	if err := os.Rename(c.pagesDir, pagesDir); err != nil {
+		panic(err)
+	}
+
+	entries, redirectLines, skipped := crawlStage(pages, redirects, pagesDir, normalization)
+	log.Println("Crawled", len(entries), "entries and", len(redirectLines), "redirects")
+	if len(skipped) > 0 {
+		log.Println("Skipped", len(skipped), "entries and redirects whose names are too long")
+	}
+
+	// The stage files are only moved into place once they're all written,
+	// so that the entries and redirects from different crawls aren't mixed.
+	files := []*storage.PartialFile{
+		writeCrawlStageFile(filepath.Join(stageDir, "stage-0-entries.txt"), entries),
+		writeCrawlStageFile(filepath.Join(stageDir, "stage-0-redirects.txt"), redirectLines),
+		writeCrawlStageFile(filepath.Join(stageDir, "stage-0-skipped.txt"), skipped),
+	}
+	for _, f := range files {
+		if err := f.Commit(); err != nil {
+			panic(err)
+		}
+	}
+
+	if streamed {
+		storage.SendStage(os.Stdout, stageDir)
+	}
+}
+
+// crawlStage returns the lines of the stage-0 files for the crawled pages and
+// redirects (see index-fs). Entries are sorted by name so that they don't
+// depend on the order that the pages were fetched in.
+func crawlStage(pages []crawlResult, redirects []crawlRedirect, pagesDir string, normalization storage.Normalization) (entries []string, redirectLines []string, skipped []string) {
+	slices.SortFunc(pages, func(a, b crawlResult) int {
+		return strings.Compare(normalization.Apply(a.job.name), normalization.Apply(b.job.name))
+	})
+
+	entryToID := map[string]int{}
+	for _, p := range pages {
+		name := normalization.Apply(p.job.name)
+		if crawlNameTooLong(name) {
+			skipped = append(skipped, "entry\t"+name)
+			continue
+		}
+
+		entryToID[name] = len(entries)
+		entries = append(entries, filepath.Join(pagesDir, filepath.Base(p.localPath))+"\t"+name)
+	}
+
+	targets := map[string]string{}
+	for _, r := range redirects {
+		targets[normalization.Apply(r.name)] = normalization.Apply(r.target)
+	}
+
+	dropped := 0
+	for _, r := range redirects {
+		name := normalization.Apply(r.name)
+		target := targets[name]
+		for range maxRedirectHops {
+			if _, found := entryToID[target]; found || targets[target] == "" {
+				break
+			}
+			target = targets[target]
+		}
+
+		idx, found := entryToID[target]
+		if !found {
+			dropped++
+			continue
+		}
+		if crawlNameTooLong(name) {
+			skipped = append(skipped, "redirect\t"+name)
+			continue
+		}
+
+		redirectLines = append(redirectLines, name+"\t"+strconv.Itoa(idx))
+	}
+	if dropped > 0 {
+		log.Println("Dropped", dropped, "redirects to pages which weren't crawled")
+	}
+
+	return entries, redirectLines, skipped
+}
+
+// crawlNameTooLong returns whether name has too many UTF-16 code units to be a
+// key in the index.
+func crawlNameTooLong(name string) bool {
+	return len(utf16.Encode([]rune(name))) > wikifile.MaxLongKeyLen
+}
+
+// writeCrawlStageFile writes a stage file in the format of index-fs, which is
+// the number of lines, and then the lines. The file is returned to be
+// committed by the caller.
+func writeCrawlStageFile(path string, lines []string) *storage.PartialFile {
+	f, err := storage.CreatePartial(path)
+	if err != nil {
+		panic(err)
+	}
+	output := bufio.NewWriter(f)
+	if _, err := output.WriteString(strconv.Itoa(len(lines)) + "\n"); err != nil {
+		panic(err)
+	}
+	for _, l := range lines {
+		if _, err := output.WriteString(l + "\n"); err != nil {
+			panic(err)
+		}
+	}
+
+	if err := output.Flush(); err != nil {
+		panic(err)
+	}
+
+	return f
+}
+
+// crawler fetches the URLs within a prefix. It's safe to use concurrently.
+type crawler struct {
+	client *http.Client
+	prefix *url.URL
+	robots robotsRules
+	// limiter is received from before each request, to wait between them.
+	// It's nil if there's no delay.
+	limiter  <-chan time.Time
+	pagesDir string
+}
+
+func newCrawler(prefix string) *crawler {
+	p, err := url.Parse(prefix)
+	if err != nil || (p.Scheme != "http" && p.Scheme != "https") || p.Host == "" {
+		panic(fmt.Sprintf("invalid -prefix %q: it must be an HTTP(S) URL", prefix))
+	}
+
+	c := &crawler{
+		client: &http.Client{
+			Timeout: crawlTimeout,
+			// Redirects are recorded rather than followed.
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		prefix: p,
+	}
+	c.robots = c.fetchRobots()
+
+	return c
+}
+
+// crawlJob is a URL to fetch.
+type crawlJob struct {
+	// id is unique to the job, and names the file that it's fetched to.
+	id   int
+	u    *url.URL
+	name string
+}
+
+// crawlResult is the outcome of a crawlJob. Either target is set for a
+// redirect, or localPath for a page. Neither is set if the request failed.
+type crawlResult struct {
+	job       crawlJob
+	target    *url.URL
+	localPath string
+	// links are the URLs within the prefix which the page links to.
+	links []*url.URL
+}
+
+// crawlRedirect is a redirect from the entry named name to the one named
+// target, which can be a redirect too.
+type crawlRedirect struct {
+	name   string
+	target string
+}
+
+// inScope returns whether u is within the prefix and robots.txt allows it to
+// be fetched. URLs with queries aren't, since they're usually for actions
+// (e.g. editing) rather than pages.
+func (c *crawler) inScope(u *url.URL) bool {
+	return strings.EqualFold(u.Scheme, c.prefix.Scheme) &&
+		strings.EqualFold(u.Host, c.prefix.Host) &&
+		u.RawQuery == "" &&
+		strings.HasPrefix(u.Path, c.prefix.Path) &&
+		c.robots.allowed(u.EscapedPath())
+}
+
+// name returns the name of the entry for u, which is in scope. It's the
+// unescaped path of u relative to the prefix, with index.html added to paths
+// of directories.
+func (c *crawler) name(u *url.URL) string {
+	name := strings.TrimPrefix(strings.TrimPrefix(u.Path, c.prefix.Path), "/")
+	if name == "" || strings.HasSuffix(name, "/") {
+		name += "index.html"
+	}
+
+	return name
+}
+
+// fetch sends the request for job, saving the page to pagesDir.
+func (c *crawler) fetch(ctx context.Context, job crawlJob) crawlResult {
+	result := crawlResult{job: job}
+
+	if c.limiter != nil {
+		select {
+		case <-c.limiter:
+		case <-ctx.Done():
+			return result
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, job.u.String(), nil)
+	if err != nil {
+		panic(err)
+	}
+	req.Header.Set("User-Agent", crawlUserAgent)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Println("Failed to fetch", job.u, err)
+		}
+		return result
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 && resp.StatusCode < 400 {
+		target, err := resp.Location()
+		if err != nil {
+			log.Println("Redirect without a valid location, so skipping it:", job.u, resp.Status)
+			return result
+		}
+		target.Fragment = ""
+		result.target = target
+		return result
+	}
+	if resp.StatusCode != http.StatusOK {
+		log.Println("Failed to fetch", job.u, resp.Status)
+		return result
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		log.Println("Failed to fetch", job.u, err)
+		return result
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType == "text/html" {
+		body, result.links = c.rewriteLinks(job, body)
+	}
+
+	localPath := filepath.Join(c.pagesDir, strconv.Itoa(job.id)+crawlFileExt(job.name, mediaType))
+	if err := os.WriteFile(localPath, body, 0o644); err != nil {
+		panic(err)
+	}
+	result.localPath = localPath
+
+	return result
+}
+
+// crawlFileExt returns the extension of the file for the entry named name with
+// mediaType, which compress-entries detects its content type by. It's the one
+// in the name if it's for mediaType.
+func crawlFileExt(name string, mediaType string) string {
+	ext := path.Ext(name)
+	if t, _, _ := mime.ParseMediaType(mime.TypeByExtension(ext)); ext != "" && t == mediaType {
+		return ext
+	}
+	if storage.IsHTML(mediaType) {
+		return ".html"
+	}
+
+	if exts, _ := mime.ExtensionsByType(mediaType); len(exts) > 0 {
+		return exts[0]
+	}
+
+	return ""
+}
+
+// rewriteLinks returns the page for job with the links to URLs within the
+// prefix rewritten to be relative links between the entries, along with those
+// URLs.
+func (c *crawler) rewriteLinks(job crawlJob, body []byte) ([]byte, []*url.URL) {
+	var out bytes.Buffer
+	var links []*url.URL
+
+	z := nethtml.NewTokenizer(bytes.NewReader(body))
+	for {
+		tt := z.Next()
+		if tt == nethtml.ErrorToken {
+			return out.Bytes(), links
+		}
+
+		raw := z.Raw()
+		if tt == nethtml.StartTagToken || tt == nethtml.SelfClosingTagToken {
+			tok := z.Token()
+			if key, ok := crawlLinkAttrs[tok.DataAtom]; ok {
+				if u, ok := c.rewriteLink(job, tok.Attr, key); ok {
+					raw = []byte(tok.String())
+					links = append(links, u)
+				}
+			}
+		}
+
+		out.Write(raw)
+	}
+}
+
+// rewriteLink rewrites the attribute key in attrs if it links to a URL within
+// the prefix, returning the URL.
+func (c *crawler) rewriteLink(job crawlJob, attrs []nethtml.Attribute, key string) (*url.URL, bool) {
+	for i, a := range attrs {
+		if a.Key != key {
+			continue
+		}
+
+		ref, err := url.Parse(strings.TrimSpace(a.Val))
+		// Links within the page are left as they are.
+		if err != nil || (ref.Scheme == "" && ref.Host == "" && ref.Path == "") {
+			return nil, false
+		}
+
+		u := job.u.ResolveReference(ref)
+		fragment := u.Fragment
+		u.Fragment = ""
+		if !c.inScope(u) {
+			return nil, false
+		}
+
+		attrs[i].Val = crawlLink(job.name, c.name(u), fragment)
+		return u, true
+	}
+
+	return nil, false
+}
+
+// crawlLink returns a relative link to the entry named to from the one named
+// from.
+func crawlLink(from, to, fragment string) string {
+	rel, err := filepath.Rel(filepath.Dir(filepath.FromSlash(from)), filepath.FromSlash(to))
+	if err != nil {
+		panic(err)
+	}
+
+	u := url.URL{Path: filepath.ToSlash(rel), Fragment: fragment}
+	return u.String()
+}
+
+// robotsRules are the rules in robots.txt which apply to the crawler.
+type robotsRules struct {
+	rules []robotsRule
+	// delay is the Crawl-delay.
+	delay time.Duration
+}
+
+type robotsRule struct {
+	allow bool
+	path  string
+}
+
+// fetchRobots fetches the robots.txt of the site with the prefix. There aren't
+// any rules if it doesn't have one.
+func (c *crawler) fetchRobots() robotsRules {
+	u := url.URL{Scheme: c.prefix.Scheme, Host: c.prefix.Host, Path: "/robots.txt"}
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		panic(err)
+	}
+	req.Header.Set("User-Agent", crawlUserAgent)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		panic(fmt.Sprintf("Error fetching %s: %s", u.String(), err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return robotsRules{}
+	}
+
+	return parseRobots(resp.Body)
+}
+
+// parseRobots returns the rules in the robots.txt read from r for the group of
+// the crawler's user agent, or else the group for every user agent.
+func parseRobots(r io.Reader) robotsRules {
+	var own, all robotsRules
+	hasOwn := false
+
+	// groups are the rules that the current group of lines adds to.
+	var groups []*robotsRules
+	inAgents := false
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		if key == "user-agent" {
+			if !inAgents {
+				groups = nil
+				inAgents = true
+			}
+			if value == "*" {
+				groups = append(groups, &all)
+			} else if strings.EqualFold(value, crawlUserAgent) {
+				groups = append(groups, &own)
+				hasOwn = true
+			}
+			continue
+		}
+		inAgents = false
+
+		for _, g := range groups {
+			switch key {
+			case "allow", "disallow":
+				if value != "" {
+					g.rules = append(g.rules, robotsRule{allow: key == "allow", path: value})
+				}
+			case "crawl-delay":
+				if s, err := strconv.ParseFloat(value, 64); err == nil && s > 0 {
+					g.delay = time.Duration(s * float64(time.Second))
+				}
+			}
+		}
+	}
+
+	if hasOwn {
+		return own
+	}
+	return all
+}
+
+// allowed returns whether the (escaped) path p can be fetched. The longest
+// rule which matches it wins, with Allow winning ties.
+func (r robotsRules) allowed(p string) bool {
+	allowed := true
+	longest := -1
+	for _, rule := range r.rules {
+		if !strings.HasPrefix(p, rule.path) {
+			continue
+		}
+
+		if len(rule.path) > longest || (len(rule.path) == longest && rule.allow) {
+			allowed = rule.allow
+			longest = len(rule.path)
+		}
+	}
+
+	return allowed
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/rsookram/wiki-builder/wikifile"
+)
+
+// duplicatePolicy is how to resolve keys which refer to more than one entry.
+type duplicatePolicy string
+
+const (
+	// duplicatesError fails the build.
+	duplicatesError duplicatePolicy = "error"
+	// duplicatesKeepFirst keeps the row that was added first, i.e. from the
+	// first source, with entries before redirects.
+	duplicatesKeepFirst duplicatePolicy = "keep-first"
+	// duplicatesPreferEntry keeps the first row for an entry rather than a
+	// redirect, falling back to the first row.
+	duplicatesPreferEntry duplicatePolicy = "prefer-entry"
+)
+
+func parseDuplicatePolicy(s string) (duplicatePolicy, bool) {
+	p := duplicatePolicy(s)
+	switch p {
+	case duplicatesError, duplicatesKeepFirst, duplicatesPreferEntry:
+		return p, true
+	}
+
+	return "", false
+}
+
+// pick returns the index of the row to keep out of rows with the same key.
+func (p duplicatePolicy) pick(rows []wikifile.IndexRow) int {
+	if p == duplicatesPreferEntry {
+		if i := slices.IndexFunc(rows, func(r wikifile.IndexRow) bool { return !r.Redirect }); i >= 0 {
+			return i
+		}
+	}
+
+	return 0
+}
+
+// pruneRedundantRows removes redirects with the same key and entry as another
+// row, which only take up space in the index. They come from redirects whose
+// names are the same as their targets' (e.g. once they're normalized), and from
+// the same redirect being in a dump more than once (e.g. also in -aliases).
+// Entries are kept over redirects. Entries with the same key and offset as each
+// other are left to removeDuplicateRows. rows must be sorted with
+// wikifile.SortIndexRows.
+func pruneRedundantRows(rows []wikifile.IndexRow) []wikifile.IndexRow {
+	numSelfRedirects := 0
+	numDuplicateRedirects := 0
+
+	pruned := rows[:0]
+	for start := 0; start < len(rows); {
+		end := start + 1
+		for end < len(rows) && slices.Equal(rows[start].Name, rows[end].Name) {
+			end++
+		}
+
+		groupStart := len(pruned)
+		for _, r := range rows[start:end] {
+			i := slices.IndexFunc(pruned[groupStart:], func(kept wikifile.IndexRow) bool { return kept.Offset == r.Offset })
+			if i < 0 || (!r.Redirect && !pruned[groupStart+i].Redirect) {
+				pruned = append(pruned, r)
+				continue
+			}
+
+			kept := &pruned[groupStart+i]
+			switch {
+			case kept.Redirect && !r.Redirect:
+				*kept = r
+				numSelfRedirects++
+			case !kept.Redirect:
+				numSelfRedirects++
+			default:
+				numDuplicateRedirects++
+			}
+		}
+
+		start = end
+	}
+
+	if numSelfRedirects > 0 {
+		log.Println("Removed", numSelfRedirects, "redirects with the same name as their entries")
+	}
+	if numDuplicateRedirects > 0 {
+		log.Println("Removed", numDuplicateRedirects, "duplicate redirects")
+	}
+
+	return pruned
+}
+
+// maxReportedDuplicates is the number of duplicate keys which are logged by
+// name. The rest are only counted.
+const maxReportedDuplicates = 20
+
+// removeDuplicateRows removes rows with the same key as another row, keeping
+// the one chosen by policy, and logs them. rows must be sorted with
+// wikifile.SortIndexRows.
+func removeDuplicateRows(rows []wikifile.IndexRow, policy duplicatePolicy) []wikifile.IndexRow {
+	var report []string
+	numDuplicates := 0
+
+	deduped := rows[:0]
+	for start := 0; start < len(rows); {
+		end := start + 1
+		for end < len(rows) && slices.Equal(rows[start].Name, rows[end].Name) {
+			end++
+		}
+
+		group := rows[start:end]
+		kept := 0
+		if len(group) > 1 {
+			kept = policy.pick(group)
+
+			numDuplicates++
+			if len(report) < maxReportedDuplicates {
+				if policy == duplicatesError {
+					// Nothing is kept since the build fails.
+					report = append(report, describeDuplicate(group, -1))
+				} else {
+					report = append(report, describeDuplicate(group, kept))
+				}
+			}
+		}
+
+		deduped = append(deduped, group[kept])
+		start = end
+	}
+
+	if numDuplicates == 0 {
+		return deduped
+	}
+
+	if policy == duplicatesError {
+		panic(fmt.Sprintf("found %d keys which refer to more than one entry:\n%s", numDuplicates, strings.Join(report, "\n")))
+	}
+
+	log.Println("Resolved", numDuplicates, "keys which refer to more than one entry")
+	for _, r := range report {
+		log.Println(r)
+	}
+	if numDuplicates > len(report) {
+		log.Println("...and", numDuplicates-len(report), "more")
+	}
+
+	return deduped
+}
+
+// describeDuplicate returns a line describing the rows for a key, and which
+// of them was kept (if any).
+func describeDuplicate(group []wikifile.IndexRow, kept int) string {
+	var sb strings.Builder
+	sb.WriteString(string(utf16.Decode(group[0].Name)))
+	sb.WriteString(":")
+	for i, r := range group {
+		kind := "entry"
+		if r.Redirect {
+			kind = "redirect"
+		}
+		fmt.Fprintf(&sb, " %s@%d", kind, r.Offset)
+		if i == kept {
+			sb.WriteString(" (kept)")
+		}
+	}
+
+	return sb.String()
+}
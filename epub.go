@@ -0,0 +1,403 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	nethtml "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+
+	"github.com/rsookram/wiki-builder/internal/reader"
+	"github.com/rsookram/wiki-builder/internal/storage"
+)
+
+// exportEPUB writes the entry for title in the wiki file at wikiPath to
+// outPath as an EPUB, along with the images and stylesheets it uses. When
+// title isn't a key, it's treated as a category, and every entry under it
+// (i.e. with keys starting with title/) is exported as a chapter instead.
+//
+// Links between exported entries point at their chapters, and other relative
+// links are removed since there's nothing in the book for them to open.
+func exportEPUB(wikiPath, title, outPath string, normalization storage.Normalization) {
+	wiki, err := reader.OpenWiki(wikiPath)
+	if err != nil {
+		panic(err)
+	}
+	defer wiki.Close()
+
+	var keys []reader.SearchResult
+	err = wiki.Keys(func(r reader.SearchResult) error {
+		keys = append(keys, r)
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	canonical := canonicalKeys(keys)
+	offsets := make(map[string]int64, len(keys))
+	for _, k := range keys {
+		offsets[k.Key] = k.EntryOffset
+	}
+
+	name := normalization.Apply(title)
+	var articles []reader.SearchResult
+	if offset, found := offsets[name]; found {
+		articles = append(articles, reader.SearchResult{Key: canonical[offset], EntryOffset: offset})
+	} else {
+		for offset, key := range canonical {
+			if strings.HasPrefix(key, name+"/") && storage.IsHTML(wiki.ContentType(offset)) {
+				articles = append(articles, reader.SearchResult{Key: key, EntryOffset: offset})
+			}
+		}
+		slices.SortFunc(articles, func(a, b reader.SearchResult) int {
+			return strings.Compare(a.Key, b.Key)
+		})
+	}
+	if len(articles) == 0 {
+		panic(fmt.Sprintf("no entries for %s", title))
+	}
+
+	b := epubBook{
+		wiki:      &wiki,
+		offsets:   offsets,
+		chapters:  make(map[int64]string, len(articles)),
+		resources: make(map[string]epubItem),
+	}
+	for i, a := range articles {
+		b.chapters[a.EntryOffset] = fmt.Sprintf("text/%04d.xhtml", i+1)
+	}
+
+	f, err := os.Create(outPath)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+
+	z := zip.NewWriter(f)
+
+	// The mimetype file has to come first, uncompressed, so that the file can
+	// be identified from its first bytes.
+	w, err := z.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		panic(err)
+	}
+	if _, err := io.WriteString(w, "application/epub+zip"); err != nil {
+		panic(err)
+	}
+
+	writeEPUBFile(z, "META-INF/container.xml", []byte(epubContainer))
+
+	for _, a := range articles {
+		writeEPUBFile(z, "OEBPS/"+b.chapters[a.EntryOffset], b.chapter(a))
+	}
+
+	for _, r := range b.order {
+		item := b.resources[r]
+
+		rdr, err := wiki.EntryAt(b.offsets[r])
+		if err != nil {
+			panic(err)
+		}
+		contents, err := io.ReadAll(rdr)
+		if err != nil {
+			panic(err)
+		}
+
+		writeEPUBFile(z, "OEBPS/"+item.href, contents)
+	}
+
+	language, err := wiki.EntryLanguageAt(articles[0].EntryOffset)
+	if err != nil {
+		panic(err)
+	}
+	if language == "" {
+		language = "und"
+	}
+
+	bookTitle := name
+	if len(articles) == 1 {
+		bookTitle = articles[0].Key
+	}
+
+	writeEPUBFile(z, "OEBPS/nav.xhtml", b.nav(bookTitle, language, articles))
+	writeEPUBFile(z, "OEBPS/content.opf", b.packageDocument(bookTitle, language, articles))
+
+	if err := z.Close(); err != nil {
+		panic(err)
+	}
+
+	log.Println("Exported", len(articles), "entries and", len(b.order), "resources to", outPath)
+}
+
+const epubContainer = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`
+
+// epubBook tracks the files in an EPUB as its chapters are written.
+type epubBook struct {
+	wiki    *reader.Wiki
+	offsets map[string]int64
+
+	// chapters is the file of each exported entry, by entry offset.
+	chapters map[int64]string
+
+	// resources are the images and stylesheets used by the chapters, by key,
+	// in the order they were first used.
+	resources map[string]epubItem
+	order     []string
+}
+
+type epubItem struct {
+	href      string
+	mediaType string
+}
+
+// chapter returns the entry for a as an XHTML document, with its links and
+// resources rewritten to point at the files in the book.
+func (b *epubBook) chapter(a reader.SearchResult) []byte {
+	rdr, err := b.wiki.EntryAt(a.EntryOffset)
+	if err != nil {
+		panic(err)
+	}
+
+	doc, err := nethtml.Parse(rdr)
+	if err != nil {
+		panic(fmt.Sprintf("failed to parse %s: %s", a.Key, err))
+	}
+
+	b.rewrite(doc, a.Key)
+
+	var buf bytes.Buffer
+	buf.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	if err := nethtml.Render(&buf, doc); err != nil {
+		panic(err)
+	}
+
+	return buf.Bytes()
+}
+
+// rewrite prepares the tree under n, from the entry for key, to be written as
+// XHTML.
+func (b *epubBook) rewrite(n *nethtml.Node, key string) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		// Scripts don't run in most readers, and comments may contain text
+		// (e.g. "--") which isn't allowed in XML.
+		if c.Type == nethtml.CommentNode || c.DataAtom == atom.Script {
+			n.RemoveChild(c)
+		} else {
+			b.rewrite(c, key)
+		}
+		c = next
+	}
+
+	if n.Type != nethtml.ElementNode {
+		return
+	}
+
+	if n.DataAtom == atom.Html {
+		n.Attr = slices.DeleteFunc(n.Attr, func(a nethtml.Attribute) bool { return a.Key == "xmlns" })
+		n.Attr = append(n.Attr, nethtml.Attribute{Key: "xmlns", Val: "http://www.w3.org/1999/xhtml"})
+	}
+
+	n.Attr = slices.DeleteFunc(n.Attr, func(a nethtml.Attribute) bool {
+		return a.Namespace != "" || !isXMLName(a.Key)
+	})
+
+	for i := 0; i < len(n.Attr); i++ {
+		a := &n.Attr[i]
+		switch {
+		case a.Key == "href" && n.DataAtom == atom.A:
+			href, ok := b.linkHref(key, a.Val)
+			if !ok {
+				n.Attr = slices.Delete(n.Attr, i, i+1)
+				i--
+				continue
+			}
+			a.Val = href
+		case a.Key == "src" || (a.Key == "href" && n.DataAtom == atom.Link):
+			if href, ok := b.resourceHref(key, a.Val); ok {
+				a.Val = href
+			}
+		case a.Key == "srcset":
+			// Only src is used, since the resources in srcset would have to be
+			// included too.
+			n.Attr = slices.Delete(n.Attr, i, i+1)
+			i--
+		}
+	}
+}
+
+// linkHref returns where the link href in the entry for key should point in
+// the book, or false if it should be removed.
+func (b *epubBook) linkHref(key, href string) (string, bool) {
+	u, ok := parseRelativeLink(href)
+	if !ok {
+		return href, true
+	}
+
+	target := strings.TrimPrefix(path.Join("/", path.Dir(key), u.Path), "/")
+	offset, found := b.offsets[target]
+	if !found {
+		return "", false
+	}
+	chapter, found := b.chapters[offset]
+	if !found {
+		return "", false
+	}
+
+	href = path.Base(chapter)
+	if u.Fragment != "" {
+		href += "#" + u.EscapedFragment()
+	}
+	return href, true
+}
+
+// resourceHref returns where the resource at href in the entry for key is in
+// the book, adding it if it's an entry which hasn't been used yet.
+func (b *epubBook) resourceHref(key, href string) (string, bool) {
+	u, ok := parseRelativeLink(href)
+	if !ok {
+		return "", false
+	}
+
+	target := strings.TrimPrefix(path.Join("/", path.Dir(key), u.Path), "/")
+	if item, found := b.resources[target]; found {
+		return "../" + item.href, true
+	}
+
+	offset, found := b.offsets[target]
+	if !found {
+		return "", false
+	}
+	contentType := b.wiki.ContentType(offset)
+	if storage.IsHTML(contentType) {
+		return "", false
+	}
+
+	item := epubItem{
+		href:      fmt.Sprintf("res/%04d%s", len(b.order)+1, path.Ext(target)),
+		mediaType: zimMimeType(contentType),
+	}
+	b.resources[target] = item
+	b.order = append(b.order, target)
+
+	return "../" + item.href, true
+}
+
+func (b *epubBook) nav(title, language string, articles []reader.SearchResult) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(
+		&buf,
+		`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" lang="%[2]s" xml:lang="%[2]s">
+<head><title>%[1]s</title></head>
+<body>
+<nav epub:type="toc" id="toc">
+<h1>%[1]s</h1>
+<ol>
+`,
+		html.EscapeString(title),
+		html.EscapeString(language),
+	)
+
+	for _, a := range articles {
+		fmt.Fprintf(&buf, "<li><a href=\"%s\">%s</a></li>\n", html.EscapeString(b.chapters[a.EntryOffset]), html.EscapeString(a.Key))
+	}
+
+	buf.WriteString("</ol>\n</nav>\n</body>\n</html>\n")
+	return buf.Bytes()
+}
+
+// packageDocument returns the content.opf file, which lists the files in the
+// book and the order to read the chapters in.
+func (b *epubBook) packageDocument(title, language string, articles []reader.SearchResult) []byte {
+	id := make([]byte, 16)
+	rand.Read(id)
+	// Mark it as a random UUID (version 4).
+	id[6] = (id[6] & 0x0f) | 0x40
+	id[8] = (id[8] & 0x3f) | 0x80
+
+	var buf bytes.Buffer
+	fmt.Fprintf(
+		&buf,
+		`<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="id">
+<metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+<dc:identifier id="id">urn:uuid:%x-%x-%x-%x-%x</dc:identifier>
+<dc:title>%s</dc:title>
+<dc:language>%s</dc:language>
+<meta property="dcterms:modified">%s</meta>
+</metadata>
+<manifest>
+<item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+`,
+		id[0:4], id[4:6], id[6:8], id[8:10], id[10:],
+		html.EscapeString(title),
+		html.EscapeString(language),
+		time.Now().UTC().Format("2006-01-02T15:04:05Z"),
+	)
+
+	for i, a := range articles {
+		fmt.Fprintf(&buf, "<item id=\"c%d\" href=\"%s\" media-type=\"application/xhtml+xml\"/>\n", i+1, html.EscapeString(b.chapters[a.EntryOffset]))
+	}
+	for i, r := range b.order {
+		item := b.resources[r]
+		fmt.Fprintf(&buf, "<item id=\"r%d\" href=\"%s\" media-type=\"%s\"/>\n", i+1, html.EscapeString(item.href), html.EscapeString(item.mediaType))
+	}
+
+	buf.WriteString("</manifest>\n<spine>\n")
+	for i := range articles {
+		fmt.Fprintf(&buf, "<itemref idref=\"c%d\"/>\n", i+1)
+	}
+	buf.WriteString("</spine>\n</package>\n")
+
+	return buf.Bytes()
+}
+
+func writeEPUBFile(z *zip.Writer, name string, contents []byte) {
+	w, err := z.Create(name)
+	if err != nil {
+		panic(err)
+	}
+
+	if _, err := w.Write(contents); err != nil {
+		panic(err)
+	}
+}
+
+// isXMLName returns whether s can be used as an attribute name in XML. HTML
+// parsers accept attribute names (e.g. from malformed markup) which XML
+// doesn't.
+func isXMLName(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for i, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_', r == ':', r >= 0x80:
+		case i > 0 && (r >= '0' && r <= '9' || r == '-' || r == '.'):
+		default:
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/rsookram/wiki-builder/internal/reader"
+	"github.com/rsookram/wiki-builder/internal/storage"
+)
+
+// exportSome writes the entries for the titles listed in the file at
+// titlesPath from the wiki file at wikiPath to outDir, along with the entries
+// they link to if withLinks is set. The output has the same layout as a dump
+// written by zimdump, so index-fs can read it to build a smaller wiki. Titles
+// which are redirects are written as redirects to their entry.
+func exportSome(wikiPath, titlesPath, outDir string, withLinks bool, normalization storage.Normalization) {
+	titles := readTitleList(titlesPath)
+
+	wiki, err := reader.OpenWiki(wikiPath)
+	if err != nil {
+		panic(err)
+	}
+	defer wiki.Close()
+
+	var keys []reader.SearchResult
+	offsets := make(map[string]int64)
+	err = wiki.Keys(func(r reader.SearchResult) error {
+		keys = append(keys, r)
+		offsets[r.Key] = r.EntryOffset
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	canonical := canonicalKeys(keys)
+
+	selected := make(map[string]bool)
+	selectKey := func(key string) {
+		selected[key] = true
+		// A redirect needs its entry to refer to.
+		selected[canonical[offsets[key]]] = true
+	}
+
+	numMissing := 0
+	for _, t := range titles {
+		key := normalization.Apply(t)
+		if _, found := offsets[key]; !found {
+			log.Println("Skipping title which isn't in the wiki:", t)
+			numMissing++
+			continue
+		}
+
+		selectKey(key)
+	}
+
+	if withLinks {
+		var linkedFrom []string
+		for key := range selected {
+			if canonical[offsets[key]] == key {
+				linkedFrom = append(linkedFrom, key)
+			}
+		}
+
+		for _, key := range linkedFrom {
+			for _, target := range entryLinks(&wiki, reader.SearchResult{Key: key, EntryOffset: offsets[key]}) {
+				target = normalization.Apply(target)
+				if _, found := offsets[target]; found {
+					selectKey(target)
+				}
+			}
+		}
+	}
+
+	exported := make([]string, 0, len(selected))
+	for key := range selected {
+		if !filepath.IsLocal(filepath.FromSlash(key)) {
+			log.Println("Skipping key which isn't a local path:", key)
+			continue
+		}
+		exported = append(exported, key)
+	}
+	slices.Sort(exported)
+
+	// index-fs expects this directory, even when it's empty.
+	if err := os.MkdirAll(filepath.Join(outDir, "_exceptions"), 0o755); err != nil {
+		panic(err)
+	}
+
+	for i, key := range exported {
+		offset := offsets[key]
+		outPath := dumpPath(outDir, key, exported)
+
+		if entryKey := canonical[offset]; entryKey != key {
+			writeStaticFile(outPath, func(w io.Writer) error {
+				return writeDumpRedirect(w, key, entryKey)
+			})
+			continue
+		}
+
+		rdr, err := wiki.EntryAt(offset)
+		if err != nil {
+			panic(err)
+		}
+		writeStaticFile(outPath, func(w io.Writer) error {
+			_, err := io.Copy(w, rdr)
+			return err
+		})
+
+		if i%10000 == 0 {
+			log.Println(i+1, "/", len(exported))
+		}
+	}
+
+	log.Println("Exported", len(exported), "keys")
+	if numMissing > 0 {
+		log.Println("Skipped", numMissing, "titles which aren't in the wiki")
+	}
+}
+
+// readTitleList reads the file at path, which has a title on each line. Empty
+// lines and lines starting with # are ignored.
+func readTitleList(path string) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+
+	var titles []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		titles = append(titles, line)
+	}
+	if err := scanner.Err(); err != nil {
+		panic(fmt.Sprintf("failed to read %s: %s", path, err))
+	}
+
+	return titles
+}
+
+// dumpPath returns the path to write key to in a dump at outDir. Like zimdump,
+// keys which are also the parent directory of another key are written to
+// _exceptions, with their slashes escaped, since a file can't also be a
+// directory. keys must be sorted.
+func dumpPath(outDir string, key string, keys []string) string {
+	// If any key starts with key + "/", the first key after it does.
+	i, _ := slices.BinarySearch(keys, key+"/")
+	if i < len(keys) && strings.HasPrefix(keys[i], key+"/") {
+		return filepath.Join(outDir, "_exceptions", "A%2f"+strings.ReplaceAll(key, "/", "%2f"))
+	}
+
+	return filepath.Join(outDir, "A", filepath.FromSlash(key))
+}
+
+// writeDumpRedirect writes a page which index-fs reads as a redirect from key
+// to entryKey. Its target is relative to the directory of key.
+func writeDumpRedirect(w io.Writer, key string, entryKey string) error {
+	target := entryKey
+	if dir := path.Dir(key); dir != "." {
+		rel, err := filepath.Rel(filepath.FromSlash(dir), filepath.FromSlash(entryKey))
+		if err != nil {
+			return err
+		}
+		target = filepath.ToSlash(rel)
+	}
+
+	_, err := fmt.Fprintf(
+		w,
+		`<!DOCTYPE html><html><head><meta charset="utf-8"><meta http-equiv="refresh" content="0;url=%s"></head></html>`,
+		html.EscapeString(url.PathEscape(target)),
+	)
+	return err
+}
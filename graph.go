@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/rsookram/wiki-builder/internal/reader"
+	"github.com/rsookram/wiki-builder/internal/storage"
+)
+
+// writeLinkGraph writes the links between the entries in the wiki file at
+// wikiPath to w, with the key of the entry that links and the key of the entry
+// it links to on each line, separated by a tab. Entries are named by their
+// canonical key (see canonicalKeys), so links through redirects point at the
+// entry they redirect to. Each link is written once per entry, and links to
+// missing entries, sections of the same entry, and other files (e.g. images)
+// are left out.
+func writeLinkGraph(w io.Writer, wikiPath string, normalization storage.Normalization) {
+	wiki, err := reader.OpenWiki(wikiPath)
+	if err != nil {
+		panic(err)
+	}
+	defer wiki.Close()
+
+	var keys []reader.SearchResult
+	err = wiki.Keys(func(r reader.SearchResult) error {
+		keys = append(keys, r)
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	log.Println("Read", len(keys), "keys")
+
+	canonical := canonicalKeys(keys)
+	offsets := make(map[string]int64, len(keys))
+	var entryKeys []reader.SearchResult
+	for _, k := range keys {
+		offsets[k.Key] = k.EntryOffset
+		if canonical[k.EntryOffset] == k.Key && storage.IsHTML(wiki.ContentType(k.EntryOffset)) {
+			entryKeys = append(entryKeys, k)
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	numEdges := 0
+	readEntryLinks(&wiki, entryKeys, func(k reader.SearchResult, targets []string) {
+		var linked []string
+		for _, t := range targets {
+			name, _, _ := strings.Cut(t, "#")
+			offset, found := offsets[normalization.Apply(name)]
+			if !found || offset == k.EntryOffset || !storage.IsHTML(wiki.ContentType(offset)) {
+				continue
+			}
+			linked = append(linked, canonical[offset])
+		}
+		slices.Sort(linked)
+
+		for _, target := range slices.Compact(linked) {
+			if _, err := fmt.Fprintf(bw, "%s\t%s\n", k.Key, target); err != nil {
+				panic(err)
+			}
+			numEdges++
+		}
+	})
+
+	if err := bw.Flush(); err != nil {
+		panic(err)
+	}
+
+	log.Println("Wrote", numEdges, "links between", len(entryKeys), "entries")
+}
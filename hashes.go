@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/rsookram/wiki-builder/internal/storage"
+	"github.com/rsookram/wiki-builder/wikifile"
+)
+
+// hashRows are the SHA-256 hashes of the contents of entries, in the order
+// that they were read.
+type hashRows struct {
+	offsets []uint64
+	hashes  [][]byte
+}
+
+// append adds the hashes of the entries from a source whose entries start at
+// baseOffset.
+func (h *hashRows) append(entries storage.EntryMetadata, hashes [][]byte, baseOffset uint64) {
+	if len(hashes) != entries.Len() {
+		panic(fmt.Sprintf("number of hashes (%d) doesn't match the number of entries (%d)", len(hashes), entries.Len()))
+	}
+
+	for i, hash := range hashes {
+		h.add(baseOffset+entries.StartOffset(i), hash)
+	}
+}
+
+// add adds the hash of the entry at offset.
+func (h *hashRows) add(offset uint64, hash []byte) {
+	if len(hash) != wikifile.HashLen {
+		panic(fmt.Sprintf("hash of entry at %d has %d bytes, but should have %d", offset, len(hash), wikifile.HashLen))
+	}
+
+	h.offsets = append(h.offsets, offset)
+	h.hashes = append(h.hashes, hash)
+}
+
+// encode returns the hashes section, with the rows sorted by offset.
+func (h *hashRows) encode(offsetWidth byte) []byte {
+	bb := make([]byte, 0, 4+len(h.offsets)*(int(offsetWidth)+wikifile.HashLen))
+	bb = binary.LittleEndian.AppendUint32(bb, uint32(len(h.offsets)))
+	for _, i := range sortedByOffset(h.offsets) {
+		bb = wikifile.AppendOffset(bb, h.offsets[i], offsetWidth)
+		bb = append(bb, h.hashes[i]...)
+	}
+
+	return bb
+}
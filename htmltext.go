@@ -0,0 +1,109 @@
+package main
+
+import (
+	"io"
+	"strings"
+
+	"golang.org/x/text/width"
+
+	"github.com/rsookram/wiki-builder/internal/plaintext"
+)
+
+// textLine is a line of text converted from HTML, to show in a terminal.
+type textLine struct {
+	text    string
+	heading bool
+}
+
+// htmlToText converts the HTML from r to lines of text which fit in the given
+// number of columns. Each paragraph is separated by a blank line.
+func htmlToText(r io.Reader, columns int) ([]textLine, error) {
+	paragraphs, err := plaintext.Extract(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []textLine
+	for i, p := range paragraphs {
+		if i > 0 {
+			lines = append(lines, textLine{})
+		}
+		for _, l := range wrapText(p.Text, columns) {
+			lines = append(lines, textLine{l, p.Heading > 0})
+		}
+	}
+
+	return lines, nil
+}
+
+// wrapText splits text into lines of at most the given number of columns,
+// breaking at spaces where possible. Words which are too long (e.g. in
+// languages without spaces) are broken between characters.
+func wrapText(text string, columns int) []string {
+	columns = max(columns, 1)
+
+	var lines []string
+	var line strings.Builder
+	lineWidth := 0
+	for _, word := range strings.Split(text, " ") {
+		w := stringWidth(word)
+		if lineWidth > 0 && lineWidth+1+w > columns {
+			lines = append(lines, line.String())
+			line.Reset()
+			lineWidth = 0
+		}
+		if lineWidth > 0 {
+			line.WriteByte(' ')
+			lineWidth++
+		}
+
+		for _, r := range word {
+			rw := runeWidth(r)
+			if lineWidth+rw > columns {
+				lines = append(lines, line.String())
+				line.Reset()
+				lineWidth = 0
+			}
+			line.WriteRune(r)
+			lineWidth += rw
+		}
+	}
+	if line.Len() > 0 {
+		lines = append(lines, line.String())
+	}
+
+	return lines
+}
+
+// truncateText returns the start of s which fits in the given number of
+// columns.
+func truncateText(s string, columns int) string {
+	w := 0
+	for i, r := range s {
+		w += runeWidth(r)
+		if w > columns {
+			return s[:i]
+		}
+	}
+
+	return s
+}
+
+func stringWidth(s string) int {
+	w := 0
+	for _, r := range s {
+		w += runeWidth(r)
+	}
+
+	return w
+}
+
+// runeWidth returns the number of columns that r takes up in a terminal.
+func runeWidth(r rune) int {
+	switch width.LookupRune(r).Kind() {
+	case width.EastAsianWide, width.EastAsianFullwidth:
+		return 2
+	}
+
+	return 1
+}
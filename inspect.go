@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/rsookram/wiki-builder/internal/reader"
+)
+
+// inspect writes the provenance of the wiki file at wikiPath to w, along with
+// the number of keys in it.
+func inspect(w io.Writer, wikiPath string) {
+	wiki, err := reader.OpenWiki(wikiPath)
+	if err != nil {
+		panic(err)
+	}
+	defer wiki.Close()
+
+	numKeys := 0
+	err = wiki.Keys(func(reader.SearchResult) error {
+		numKeys++
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	info := wiki.BuildInfo()
+	builtAt := ""
+	if !info.Time.IsZero() {
+		builtAt = info.Time.Format(time.RFC3339)
+	}
+
+	fmt.Fprintf(w, "Build ID:     %s\n", orUnknown(info.ID))
+	fmt.Fprintf(w, "Source:       %s\n", orUnknown(info.Source))
+	fmt.Fprintf(w, "Built at:     %s\n", orUnknown(builtAt))
+	fmt.Fprintf(w, "Tool version: %s\n", orUnknown(info.ToolVersion))
+	fmt.Fprintf(w, "Keys:         %d\n", numKeys)
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+
+	return s
+}
@@ -0,0 +1,54 @@
+// Package anchor extracts the anchors of an entry, which the fragments of
+// links to it (e.g. "#History") refer to.
+package anchor
+
+import (
+	"io"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Separator separates the anchors of an entry when they're stored together.
+// IDs can't contain whitespace, so it can't be part of an anchor.
+const Separator = "\t"
+
+// Extract returns the anchors of the page read from r: the IDs of its elements
+// (e.g. of its headings), and the names of its a elements, in the order that
+// they appear, without duplicates.
+func Extract(r io.Reader) []string {
+	var anchors []string
+	seen := make(map[string]bool)
+
+	z := html.NewTokenizer(r)
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			return anchors
+		}
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			continue
+		}
+
+		tok := z.Token()
+		for _, a := range tok.Attr {
+			if a.Key != "id" && !(a.Key == "name" && tok.DataAtom == atom.A) {
+				continue
+			}
+			if a.Val == "" || strings.ContainsAny(a.Val, " \t\n\f\r") || seen[a.Val] {
+				continue
+			}
+
+			seen[a.Val] = true
+			anchors = append(anchors, a.Val)
+		}
+	}
+}
+
+// Contains returns whether anchors, which are joined by Separator, contains
+// anchor.
+func Contains(anchors string, anchor string) bool {
+	return anchor != "" && slices.Contains(strings.Split(anchors, Separator), anchor)
+}
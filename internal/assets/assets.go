@@ -0,0 +1,10 @@
+// Package assets contains files which are referenced by entries, and shared
+// by the commands which serve or export them.
+package assets
+
+import _ "embed"
+
+// StyleCSS is served at -/style.css, relative to the root of the entries.
+//
+//go:embed "style.css"
+var StyleCSS string
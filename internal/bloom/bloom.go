@@ -0,0 +1,83 @@
+// Package bloom implements the bloom filter over the keys of a wiki file, which
+// tells readers that a key isn't in the file without reading the index.
+package bloom
+
+import (
+	"errors"
+	"math"
+)
+
+// Filter is a bloom filter over UTF-16 keys. It can have false positives, but
+// no false negatives.
+type Filter struct {
+	numHashes byte
+	bits      []byte
+}
+
+// New returns an empty filter sized for numKeys keys with bitsPerKey bits for
+// each (e.g. 10 bits gives ~1% false positives).
+func New(numKeys int, bitsPerKey int) *Filter {
+	numBytes := max((numKeys*bitsPerKey+7)/8, 1)
+
+	// This number of hashes minimizes the false positive rate.
+	numHashes := byte(min(max(math.Round(float64(bitsPerKey)*math.Ln2), 1), 30))
+
+	return &Filter{numHashes: numHashes, bits: make([]byte, numBytes)}
+}
+
+// Decode returns the filter encoded in b by Encode. The filter refers to b.
+func Decode(b []byte) (*Filter, error) {
+	if len(b) < 2 || b[0] == 0 {
+		return nil, errors.New("invalid bloom filter")
+	}
+
+	return &Filter{numHashes: b[0], bits: b[1:]}, nil
+}
+
+// Encode returns the number of hashes (u8) followed by the bits of f.
+func (f *Filter) Encode() []byte {
+	return append([]byte{f.numHashes}, f.bits...)
+}
+
+// Add adds key to f.
+func (f *Filter) Add(key []uint16) {
+	h1, h2 := hash(key)
+	numBits := uint64(len(f.bits)) * 8
+	for i := range uint64(f.numHashes) {
+		bit := (h1 + i*h2) % numBits
+		f.bits[bit/8] |= 1 << (bit % 8)
+	}
+}
+
+// MayContain returns false if key definitely wasn't added to f.
+func (f *Filter) MayContain(key []uint16) bool {
+	h1, h2 := hash(key)
+	numBits := uint64(len(f.bits)) * 8
+	for i := range uint64(f.numHashes) {
+		bit := (h1 + i*h2) % numBits
+		if f.bits[bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// hash returns two 32-bit hashes of key from the 64-bit FNV-1a hash of its
+// UTF-16LE bytes, which are combined to get the position for each hash
+// function.
+func hash(key []uint16) (uint64, uint64) {
+	const offset64 = 14695981039346656037
+	const prime64 = 1099511628211
+
+	h := uint64(offset64)
+	for _, c := range key {
+		h ^= uint64(c & 0xff)
+		h *= prime64
+		h ^= uint64(c >> 8)
+		h *= prime64
+	}
+
+	// The second hash is odd so that the positions don't repeat early.
+	return h & math.MaxUint32, h>>32 | 1
+}
@@ -0,0 +1,63 @@
+package compression
+
+import (
+	"io"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Brotli is the ID of the brotli compressor. It compresses HTML better than
+// zlib, at the cost of compressing more slowly, and browsers can decompress
+// it themselves.
+const Brotli ID = 1
+
+func init() {
+	Register(Brotli, brotliCompressor{})
+}
+
+// brotliCompressor compresses with the highest quality, since entries are
+// compressed once and read many times.
+type brotliCompressor struct{}
+
+// brotliReaders has the readers of closed brotliReaders, to reuse them instead
+// of allocating new ones for each entry.
+var brotliReaders sync.Pool
+
+// brotliReader returns its reader to brotliReaders when it's first closed.
+type brotliReader struct {
+	*brotli.Reader
+}
+
+func (r *brotliReader) Close() error {
+	if r.Reader == nil {
+		// It was already closed, and its reader could be in use elsewhere.
+		return nil
+	}
+
+	brotliReaders.Put(r.Reader)
+	r.Reader = nil
+
+	return nil
+}
+
+func (brotliCompressor) Name() string {
+	return "brotli"
+}
+
+func (brotliCompressor) Compress(w io.Writer) Encoder {
+	return brotli.NewWriterLevel(w, brotli.BestCompression)
+}
+
+func (brotliCompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	if br, ok := brotliReaders.Get().(*brotli.Reader); ok {
+		if err := br.Reset(r); err != nil {
+			brotliReaders.Put(br)
+			return nil, err
+		}
+
+		return &brotliReader{br}, nil
+	}
+
+	return &brotliReader{brotli.NewReader(r)}, nil
+}
@@ -0,0 +1,89 @@
+// Package compression has the algorithms that entries can be compressed with.
+// Each one is registered under an ID, which wiki files store in their header,
+// so that code which writes or reads entries doesn't depend on the algorithm.
+package compression
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// ID identifies a Compressor in wiki files.
+type ID byte
+
+// Zlib is the ID of the zlib compressor, which wiki files without a
+// compression field in their header use.
+const Zlib ID = 0
+
+// Compressor compresses and decompresses entries. Its methods are called
+// concurrently for different entries, so implementations must be safe for
+// concurrent use.
+type Compressor interface {
+	// Name returns the name which selects the compressor, e.g. with
+	// compress-entries -compression.
+	Name() string
+	// Compress returns an Encoder which writes the compressed form of what's
+	// written to it to w.
+	Compress(w io.Writer) Encoder
+	// NewReader returns a reader of the decompressed contents of r. Closing
+	// it lets the compressor reuse it, after which it can't be read.
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+// Encoder compresses what's written to it. Close must be called to write the
+// end of the compressed data.
+type Encoder interface {
+	io.WriteCloser
+	// Reset discards the encoder's state, and makes it write to w, so that it
+	// can be reused.
+	Reset(w io.Writer)
+}
+
+var registry = map[ID]Compressor{}
+
+// Register makes a Compressor available by id and by its name. It panics if id
+// or the name is already registered.
+func Register(id ID, c Compressor) {
+	if _, found := registry[id]; found {
+		panic(fmt.Sprintf("compressor %d is already registered", id))
+	}
+	if _, err := LookupName(c.Name()); err == nil {
+		panic(fmt.Sprintf("compressor %q is already registered", c.Name()))
+	}
+
+	registry[id] = c
+}
+
+// Names returns the names of all the registered compressors, sorted.
+func Names() []string {
+	var names []string
+	for _, c := range registry {
+		names = append(names, c.Name())
+	}
+	slices.Sort(names)
+
+	return names
+}
+
+// Lookup returns the compressor registered as id.
+func Lookup(id ID) (Compressor, error) {
+	c, found := registry[id]
+	if !found {
+		return nil, fmt.Errorf("unknown compressor %d", id)
+	}
+
+	return c, nil
+}
+
+// LookupName returns the ID of the compressor with the given name.
+func LookupName(name string) (ID, error) {
+	for id, c := range registry {
+		if c.Name() == name {
+			return id, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown compressor %q. Available: %s", name, strings.Join(Names(), ", "))
+}
@@ -0,0 +1,110 @@
+package compression
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"testing"
+)
+
+// compressed returns content compressed with the compressor with id.
+func compressed(t testing.TB, id ID, content []byte) []byte {
+	t.Helper()
+
+	c, err := Lookup(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	enc := c.Compress(&buf)
+	if _, err := enc.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestReaderCloseTwice(t *testing.T) {
+	content := bytes.Repeat([]byte("entry "), 100)
+
+	for _, id := range []ID{Zlib, Brotli} {
+		c, err := Lookup(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := compressed(t, id, content)
+
+		r, err := c.NewReader(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.Copy(io.Discard, r); err != nil {
+			t.Fatal(err)
+		}
+		r.Close()
+		if err := r.Close(); err != nil {
+			t.Errorf("%s: second Close failed: %s", c.Name(), err)
+		}
+
+		// The reader which was returned to the pool once can still be used.
+		for range 2 {
+			r, err := c.NewReader(bytes.NewReader(b))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, content) {
+				t.Errorf("%s: read %q, want %q", c.Name(), got, content)
+			}
+			r.Close()
+		}
+	}
+}
+
+// BenchmarkZlibReader compares decompressing an entry with a reader from the
+// pool against allocating a new one for each entry, like entries were read
+// before readers were pooled.
+func BenchmarkZlibReader(b *testing.B) {
+	b.Run("pooled", func(b *testing.B) {
+		c, err := Lookup(Zlib)
+		if err != nil {
+			b.Fatal(err)
+		}
+		content := compressed(b, Zlib, bytes.Repeat([]byte("entry "), 1000))
+
+		b.ReportAllocs()
+		for range b.N {
+			r, err := c.NewReader(bytes.NewReader(content))
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := io.Copy(io.Discard, r); err != nil {
+				b.Fatal(err)
+			}
+			r.Close()
+		}
+	})
+
+	b.Run("unpooled", func(b *testing.B) {
+		content := compressed(b, Zlib, bytes.Repeat([]byte("entry "), 1000))
+
+		b.ReportAllocs()
+		for range b.N {
+			r, err := zlib.NewReader(bytes.NewReader(content))
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := io.Copy(io.Discard, r); err != nil {
+				b.Fatal(err)
+			}
+			r.Close()
+		}
+	})
+}
@@ -0,0 +1,62 @@
+package compression
+
+import (
+	"compress/zlib"
+	"io"
+	"sync"
+)
+
+func init() {
+	Register(Zlib, zlibCompressor{})
+}
+
+// zlibCompressor uses the standard library's zlib implementation.
+type zlibCompressor struct{}
+
+// zlibReaders has the readers of closed zlibReaders, to reuse them instead of
+// allocating new ones for each entry.
+var zlibReaders sync.Pool
+
+// zlibReader returns its reader to zlibReaders when it's first closed.
+type zlibReader struct {
+	io.ReadCloser
+}
+
+func (r *zlibReader) Close() error {
+	if r.ReadCloser == nil {
+		// It was already closed, and its reader could be in use elsewhere.
+		return nil
+	}
+
+	err := r.ReadCloser.Close()
+	zlibReaders.Put(r.ReadCloser)
+	r.ReadCloser = nil
+
+	return err
+}
+
+func (zlibCompressor) Name() string {
+	return "zlib"
+}
+
+func (zlibCompressor) Compress(w io.Writer) Encoder {
+	return zlib.NewWriter(w)
+}
+
+func (zlibCompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	if zr, ok := zlibReaders.Get().(io.ReadCloser); ok {
+		if err := zr.(zlib.Resetter).Reset(r, nil); err != nil {
+			zlibReaders.Put(zr)
+			return nil, err
+		}
+
+		return &zlibReader{zr}, nil
+	}
+
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return &zlibReader{zr}, nil
+}
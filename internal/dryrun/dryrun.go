@@ -0,0 +1,40 @@
+// Package dryrun is used by the commands to report what they would write when
+// they're run with -dry-run.
+package dryrun
+
+import (
+	"fmt"
+	"log"
+)
+
+// Counter is an io.Writer which discards what's written to it, and counts the
+// number of bytes.
+type Counter struct {
+	N int64
+}
+
+func (c *Counter) Write(p []byte) (int, error) {
+	c.N += int64(len(p))
+	return len(p), nil
+}
+
+// Report logs the size of the file that would have been written to path.
+func Report(path string, size int64) {
+	log.Printf("Dry run: would write %s to %s", FormatSize(size), path)
+}
+
+// FormatSize formats a number of bytes for people to read, e.g. 1.5 GiB.
+func FormatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit && exp < 4; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTP"[exp])
+}
@@ -0,0 +1,131 @@
+// Package images makes images smaller before they're stored, since they're
+// usually most of the size of a dump with resources.
+package images
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+)
+
+// Settings are how to recompress images of each type. The zero value leaves
+// images as they are.
+type Settings struct {
+	// JPEGQuality re-encodes JPEGs with this quality (1-100), or keeps them
+	// as they are if it's 0.
+	JPEGQuality int
+	// PNG re-encodes PNGs with the best compression.
+	PNG bool
+	// MaxDimension scales down images whose width or height is bigger than
+	// this many pixels to fit, keeping their aspect ratio, or doesn't scale
+	// them if it's 0.
+	MaxDimension int
+}
+
+// Enabled returns whether s changes any images.
+func (s Settings) Enabled() bool {
+	return s.JPEGQuality > 0 || s.PNG || s.MaxDimension > 0
+}
+
+// Supported returns whether images with contentType can be recompressed.
+func Supported(contentType string) bool {
+	return contentType == "image/jpeg" || contentType == "image/png"
+}
+
+// Recompress returns content, which has contentType, recompressed with s. The
+// image is kept in its format. Content which isn't a JPEG or PNG, or which
+// isn't smaller once it's recompressed (unless it's scaled down), is returned
+// as it is.
+func (s Settings) Recompress(contentType string, content []byte) ([]byte, error) {
+	var isJPEG bool
+	switch contentType {
+	case "image/jpeg":
+		isJPEG = true
+		if s.JPEGQuality == 0 && s.MaxDimension == 0 {
+			return content, nil
+		}
+	case "image/png":
+		if !s.PNG && s.MaxDimension == 0 {
+			return content, nil
+		}
+	default:
+		return content, nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", contentType, err)
+	}
+
+	scaled := s.MaxDimension > 0 && max(img.Bounds().Dx(), img.Bounds().Dy()) > s.MaxDimension
+	if scaled {
+		img = scaleDown(img, s.MaxDimension)
+	}
+
+	var out bytes.Buffer
+	if isJPEG {
+		err = jpeg.Encode(&out, img, &jpeg.Options{Quality: cmp.Or(s.JPEGQuality, jpeg.DefaultQuality)})
+	} else {
+		enc := png.Encoder{CompressionLevel: png.DefaultCompression}
+		if s.PNG {
+			enc.CompressionLevel = png.BestCompression
+		}
+		err = enc.Encode(&out, img)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s: %w", contentType, err)
+	}
+
+	if !scaled && out.Len() >= len(content) {
+		return content, nil
+	}
+	return out.Bytes(), nil
+}
+
+// scaleDown returns img scaled so that neither its width nor height is more
+// than maxDimension, by averaging the pixels which each pixel of the result
+// covers.
+func scaleDown(img image.Image, maxDimension int) image.Image {
+	b := img.Bounds()
+	srcW, srcH := b.Dx(), b.Dy()
+
+	dstW, dstH := maxDimension, maxDimension
+	if srcW > srcH {
+		dstH = max(1, srcH*maxDimension/srcW)
+	} else {
+		dstW = max(1, srcW*maxDimension/srcH)
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, dstW, dstH))
+	for y := range dstH {
+		y0 := b.Min.Y + y*srcH/dstH
+		y1 := max(y0+1, b.Min.Y+(y+1)*srcH/dstH)
+		for x := range dstW {
+			x0 := b.Min.X + x*srcW/dstW
+			x1 := max(x0+1, b.Min.X+(x+1)*srcW/dstW)
+
+			// The colours are averaged with alpha premultiplied, so that
+			// transparent pixels don't tint the result.
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := img.At(sx, sy).RGBA()
+					r += uint64(pr)
+					g += uint64(pg)
+					bl += uint64(pb)
+					a += uint64(pa)
+					n++
+				}
+			}
+
+			c := color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(bl / n), A: uint16(a / n)}
+			dst.Set(x, y, c)
+		}
+	}
+
+	return dst
+}
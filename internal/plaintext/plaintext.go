@@ -0,0 +1,123 @@
+// Package plaintext converts the HTML of entries to readable plain text, e.g.
+// to show in a terminal or to feed into other tools.
+package plaintext
+
+import (
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Paragraph is a block of text from a page, with its whitespace collapsed.
+type Paragraph struct {
+	Text string
+
+	// Heading is the level of the heading (1 to 6) that the text is from, or 0
+	// if it isn't from a heading.
+	Heading int
+}
+
+// Extract returns the paragraphs of text in the page read from r. Each block
+// element (including headings, list items, and line breaks) starts a new
+// paragraph, and the contents of the head, scripts, and styles are skipped.
+func Extract(r io.Reader) ([]Paragraph, error) {
+	var paragraphs []Paragraph
+	var paragraph strings.Builder
+	heading := 0
+	skipDepth := 0
+
+	flush := func() {
+		text := strings.Join(strings.Fields(paragraph.String()), " ")
+		paragraph.Reset()
+		if text == "" {
+			return
+		}
+
+		paragraphs = append(paragraphs, Paragraph{text, heading})
+	}
+
+	z := html.NewTokenizer(r)
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			if z.Err() == io.EOF {
+				flush()
+				return paragraphs, nil
+			}
+			return nil, z.Err()
+		case html.TextToken:
+			if skipDepth == 0 {
+				paragraph.Write(z.Text())
+			}
+		case html.StartTagToken, html.EndTagToken, html.SelfClosingTagToken:
+			name, _ := z.TagName()
+			a := atom.Lookup(name)
+			switch a {
+			case atom.Head, atom.Script, atom.Style:
+				if tt == html.StartTagToken {
+					skipDepth++
+				} else if tt == html.EndTagToken && skipDepth > 0 {
+					skipDepth--
+				}
+			case atom.Body:
+				// Recover from unclosed elements in head.
+				skipDepth = 0
+			case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+				flush()
+				heading = 0
+				if tt == html.StartTagToken {
+					heading = int(name[1] - '0')
+				}
+			case atom.Li:
+				flush()
+				if tt == html.StartTagToken {
+					paragraph.WriteString("• ")
+				}
+			case atom.Br:
+				flush()
+			case atom.Td, atom.Th:
+				// Keep the contents of adjacent cells apart.
+				paragraph.WriteByte(' ')
+			default:
+				if isBlock(a) {
+					flush()
+				}
+			}
+		}
+	}
+}
+
+func isBlock(a atom.Atom) bool {
+	switch a {
+	case atom.P, atom.Div, atom.Section, atom.Article, atom.Blockquote, atom.Pre,
+		atom.Ul, atom.Ol, atom.Dl, atom.Dt, atom.Dd, atom.Table, atom.Tr,
+		atom.Figure, atom.Figcaption, atom.Hr:
+		return true
+	}
+
+	return false
+}
+
+// Format returns paragraphs as text, separated by blank lines. Headings are
+// marked with a # for each level, as in Markdown.
+func Format(paragraphs []Paragraph) string {
+	var b strings.Builder
+	for i, p := range paragraphs {
+		if i > 0 {
+			b.WriteString("\n\n")
+		}
+		if p.Heading > 0 {
+			b.WriteString(strings.Repeat("#", p.Heading))
+			b.WriteByte(' ')
+		}
+		b.WriteString(p.Text)
+	}
+	if b.Len() > 0 {
+		b.WriteByte('\n')
+	}
+
+	return b.String()
+}
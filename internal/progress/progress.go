@@ -0,0 +1,165 @@
+// Package progress reports the progress of the build commands as lines of
+// JSON, for frontends which wrap them, and lets them be cancelled.
+package progress
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Event is written as a line of JSON for each update.
+type Event struct {
+	Stage string `json:"stage"`
+	// Type is one of "start", "progress", "done", or "cancelled".
+	Type  string `json:"type"`
+	Done  int    `json:"done,omitempty"`
+	Total int    `json:"total,omitempty"`
+}
+
+// ExitCancelled is the status that the build commands exit with when they're
+// cancelled, like a shell uses for a process stopped by SIGINT, so that
+// scripts can tell it apart from a failure.
+const ExitCancelled = 130
+
+// updateInterval is the minimum time between progress events, so that
+// frontends aren't flooded with them.
+const updateInterval = 100 * time.Millisecond
+
+// Reporter reports the progress of a stage of the build. Its methods are safe
+// to call concurrently.
+type Reporter struct {
+	stage string
+
+	mu         sync.Mutex
+	enc        *json.Encoder
+	lastUpdate time.Time
+
+	cancelOnce sync.Once
+	cancelled  chan struct{}
+}
+
+// New returns a Reporter for stage which writes events to the file descriptor
+// progressFD, and reads commands from controlFD. Either can be negative to
+// disable them. The only command is "cancel", which has the same effect as
+// SIGINT or SIGTERM. A second signal stops the process immediately, in which
+// case its outputs are left marked as incomplete instead of being cleaned up.
+func New(stage string, progressFD, controlFD int) *Reporter {
+	r := &Reporter{stage: stage, cancelled: make(chan struct{})}
+
+	if progressFD >= 0 {
+		r.enc = json.NewEncoder(os.NewFile(uintptr(progressFD), "progress"))
+	}
+
+	if controlFD >= 0 {
+		go r.readCommands(os.NewFile(uintptr(controlFD), "control"))
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		// Let the next signal stop the process as usual.
+		signal.Stop(signals)
+		r.cancel()
+	}()
+
+	return r
+}
+
+func (r *Reporter) readCommands(f *os.File) {
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		switch cmd := strings.TrimSpace(scanner.Text()); cmd {
+		case "cancel":
+			r.cancel()
+		case "":
+		default:
+			log.Println("Unknown command:", cmd)
+		}
+	}
+}
+
+func (r *Reporter) cancel() {
+	r.cancelOnce.Do(func() {
+		log.Println("Cancelling", r.stage)
+		close(r.cancelled)
+	})
+}
+
+// Cancelled returns a channel which is closed once the stage is cancelled.
+func (r *Reporter) Cancelled() <-chan struct{} {
+	return r.cancelled
+}
+
+// IsCancelled returns whether the stage has been cancelled.
+func (r *Reporter) IsCancelled() bool {
+	select {
+	case <-r.cancelled:
+		return true
+	default:
+		return false
+	}
+}
+
+// Start reports that the stage started.
+func (r *Reporter) Start() {
+	r.write(Event{Stage: r.stage, Type: "start"})
+}
+
+// Update reports that done out of total items have been processed. total is 0
+// if it isn't known yet. Updates are dropped if they're too frequent, except
+// for the last one.
+func (r *Reporter) Update(done, total int) {
+	if r.enc == nil {
+		return
+	}
+
+	r.mu.Lock()
+	now := time.Now()
+	last := total > 0 && done == total
+	if !last && now.Sub(r.lastUpdate) < updateInterval {
+		r.mu.Unlock()
+		return
+	}
+	r.lastUpdate = now
+	r.mu.Unlock()
+
+	r.write(Event{Stage: r.stage, Type: "progress", Done: done, Total: total})
+}
+
+// Finish reports that the stage finished.
+func (r *Reporter) Finish() {
+	r.write(Event{Stage: r.stage, Type: "done"})
+}
+
+// ReportCancelled reports that the stage stopped after being cancelled.
+func (r *Reporter) ReportCancelled() {
+	r.write(Event{Stage: r.stage, Type: "cancelled"})
+}
+
+// Exit reports that the stage stopped after being cancelled, and exits with
+// ExitCancelled. The outputs need to have been cleaned up first.
+func (r *Reporter) Exit() {
+	r.ReportCancelled()
+	os.Exit(ExitCancelled)
+}
+
+func (r *Reporter) write(e Event) {
+	if r.enc == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.enc.Encode(e); err != nil {
+		log.Println("Failed to write progress:", err)
+	}
+}
@@ -0,0 +1,307 @@
+// Package query parses the search syntax of web, and runs queries written in
+// it against a wiki.
+//
+// A query is a list of terms separated by whitespace, which all have to match
+// (they can also be joined by AND). The terms are:
+//   - prefix:foo matches keys which start with foo
+//   - intitle:bar matches keys which contain bar, ignoring case
+//   - is:redirect matches keys of redirects, and is:entry matches the others
+//     (only for wikis which record which keys are redirects)
+//   - lang:ja matches the entries with the language code ja
+//
+// Other words are searched for like a plain query, e.g. matching keys with a
+// word which starts with them. Values with spaces can be quoted, e.g.
+// intitle:"New York".
+package query
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/rsookram/wiki-builder/internal/reader"
+)
+
+// Query is a parsed query.
+type Query struct {
+	// Text is searched for like a plain query, if it isn't empty.
+	Text string
+	// Prefix is what every key starts with, if it isn't empty.
+	Prefix string
+	// InTitle are strings which every key contains, ignoring case.
+	InTitle []string
+	// Redirect only matches keys of redirects if it's true, and only the
+	// other keys if it's false. Both match if it's nil.
+	Redirect *bool
+	// Language is the language code that every entry has, if it isn't empty.
+	Language string
+}
+
+// ErrNoRedirectFlags is returned by Run for queries with is: terms against
+// wikis which don't record which keys are from redirects, e.g. ones built
+// before they were recorded.
+var ErrNoRedirectFlags = errors.New("the wiki doesn't record which keys are redirects, so is: can't be used (build it again to search with it)")
+
+// ErrEmpty is returned by Parse for queries without any text to search for,
+// i.e. only is: or lang: terms.
+var ErrEmpty = errors.New("query needs text, a prefix, or intitle to search for")
+
+// defaultLimit is the number of results that Run returns unless chosen
+// otherwise, which matches reader.QueryOptions.
+const defaultLimit = 32
+
+// candidates is the number of keys which are read for each result when the
+// results are filtered, so that enough of them match.
+const candidates = 8
+
+// Parse parses s. Words with a colon which aren't one of the terms (e.g.
+// "Category:Cities") are searched for as text.
+func Parse(s string) (Query, error) {
+	var q Query
+	var text []string
+
+	words := split(s)
+	for i, w := range words {
+		if w == "AND" && i > 0 && i < len(words)-1 {
+			continue
+		}
+
+		field, value, found := strings.Cut(w, ":")
+		if !found {
+			text = append(text, unquote(w))
+			continue
+		}
+		value = unquote(value)
+
+		switch field {
+		case "prefix":
+			if q.Prefix != "" {
+				return q, fmt.Errorf("query has more than one prefix: %q and %q", q.Prefix, value)
+			}
+			q.Prefix = value
+		case "intitle":
+			q.InTitle = append(q.InTitle, value)
+		case "is":
+			var redirect bool
+			switch value {
+			case "redirect":
+				redirect = true
+			case "entry":
+				redirect = false
+			default:
+				return q, fmt.Errorf("unknown is: term %q. Available: redirect, entry", value)
+			}
+			if q.Redirect != nil && *q.Redirect != redirect {
+				return q, errors.New("query has both is:redirect and is:entry")
+			}
+			q.Redirect = &redirect
+		case "lang":
+			if q.Language != "" && q.Language != value {
+				return q, fmt.Errorf("query has more than one language: %q and %q", q.Language, value)
+			}
+			q.Language = value
+		default:
+			text = append(text, unquote(w))
+			continue
+		}
+
+		if value == "" {
+			return q, fmt.Errorf("%s: term without a value", field)
+		}
+	}
+
+	q.Text = strings.Join(text, " ")
+	if q.Text == "" && q.Prefix == "" && len(q.InTitle) == 0 {
+		return q, ErrEmpty
+	}
+
+	return q, nil
+}
+
+// split splits s at whitespace which isn't within double quotes.
+func split(s string) []string {
+	var words []string
+	var word strings.Builder
+	quoted := false
+	for _, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			word.WriteRune(r)
+		case !quoted && (r == ' ' || r == '\t' || r == '\n' || r == '\r'):
+			if word.Len() > 0 {
+				words = append(words, word.String())
+				word.Reset()
+			}
+		default:
+			word.WriteRune(r)
+		}
+	}
+	if word.Len() > 0 {
+		words = append(words, word.String())
+	}
+
+	return words
+}
+
+// unquote removes the double quotes around s, if it has them.
+func unquote(s string) string {
+	if len(s) >= 2 && strings.HasPrefix(s, `"`) && strings.HasSuffix(s, `"`) {
+		return s[1 : len(s)-1]
+	}
+
+	return s
+}
+
+// Normalize applies normalize (e.g. storage.Normalization.Apply) to the text,
+// prefix, and intitle terms of q, so that they're spelled like the keys.
+func (q *Query) Normalize(normalize func(string) string) {
+	if q.Text != "" {
+		q.Text = normalize(q.Text)
+	}
+	if q.Prefix != "" {
+		q.Prefix = normalize(q.Prefix)
+	}
+	for i, t := range q.InTitle {
+		q.InTitle[i] = normalize(t)
+	}
+}
+
+// Run returns the keys in wiki which match q, with the limit and ranking
+// chosen by opts. The language of q overrides the one in opts.
+//
+// The wiki is queried for the text of q (or else its prefix, or its first
+// intitle term), which uses its word and transliteration indexes when it has
+// them, and the results are then filtered by the other terms. Queries with
+// only intitle terms read every key instead if the wiki doesn't have a word
+// index, since they can match anywhere in a key.
+func (q Query) Run(ctx context.Context, wiki *reader.Wiki, opts reader.QueryOptions) ([]reader.SearchResult, error) {
+	if q.Redirect != nil && !wiki.HasRedirectFlags() {
+		return nil, ErrNoRedirectFlags
+	}
+
+	opts.Language = cmp.Or(q.Language, opts.Language)
+	limit := cmp.Or(opts.Limit, defaultLimit)
+
+	search := cmp.Or(q.Text, q.Prefix)
+	if search == "" {
+		if !wiki.HasWordIndex() {
+			return q.scan(ctx, wiki, opts, limit)
+		}
+		search = q.InTitle[0]
+	}
+
+	filtered := q.Prefix != "" || len(q.InTitle) > 0 || q.Redirect != nil
+	if filtered {
+		opts.Limit = limit * candidates
+	}
+
+	results, err := wiki.QueryContext(ctx, search, opts)
+	if err != nil || !filtered {
+		return results, err
+	}
+
+	matches := results[:0]
+	for _, r := range results {
+		if q.Redirect != nil {
+			// Results from the word and transliteration indexes don't have
+			// the flags of the keys that they refer to.
+			r.Redirect, err = wiki.IsRedirect(ctx, r.Key)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if q.matches(r) {
+			matches = append(matches, r)
+		}
+	}
+
+	return matches[:min(len(matches), limit)], nil
+}
+
+// errLimit stops scan once it has enough results.
+var errLimit = errors.New("reached the limit")
+
+// scan returns the first limit keys in wiki which match q, and whose entries
+// have the language from opts (if it's set), in the order of the keys. Like
+// query results, they have snippets, sizes if opts.Sizes is set, and word
+// counts if opts.WordCounts is set.
+func (q Query) scan(ctx context.Context, wiki *reader.Wiki, opts reader.QueryOptions, limit int) ([]reader.SearchResult, error) {
+	var results []reader.SearchResult
+	err := wiki.Keys(func(r reader.SearchResult) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if !q.matches(r) {
+			return nil
+		}
+
+		if opts.Language != "" {
+			l, err := wiki.EntryLanguageAt(r.EntryOffset)
+			if err != nil {
+				return err
+			}
+			if l != opts.Language {
+				return nil
+			}
+		}
+
+		results = append(results, r)
+		if len(results) == limit {
+			return errLimit
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errLimit) {
+		return nil, err
+	}
+
+	for i, r := range results {
+		snippet, err := wiki.EntrySnippetAt(r.EntryOffset)
+		if err != nil {
+			return nil, err
+		}
+		results[i].Snippet = snippet
+
+		if opts.Sizes {
+			size, err := wiki.EntrySizeAt(r.EntryOffset)
+			if errors.Is(err, reader.ErrNotFound) {
+				size = -1
+			} else if err != nil {
+				return nil, err
+			}
+			results[i].Size = size
+		}
+
+		if opts.WordCounts {
+			count, err := wiki.EntryWordCountAt(r.EntryOffset)
+			if errors.Is(err, reader.ErrNotFound) {
+				count = -1
+			} else if err != nil {
+				return nil, err
+			}
+			results[i].WordCount = count
+		}
+	}
+
+	return results, nil
+}
+
+func (q Query) matches(r reader.SearchResult) bool {
+	if q.Prefix != "" && !strings.HasPrefix(r.Key, q.Prefix) {
+		return false
+	}
+
+	key := strings.ToLower(r.Key)
+	for _, t := range q.InTitle {
+		if !strings.Contains(key, strings.ToLower(t)) {
+			return false
+		}
+	}
+
+	return q.Redirect == nil || *q.Redirect == r.Redirect
+}
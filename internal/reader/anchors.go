@@ -0,0 +1,52 @@
+package reader
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rsookram/wiki-builder/internal/anchor"
+)
+
+// EntryAnchorsAt returns the anchors of the entry at offset, separated by
+// anchor.Separator, or an empty string if the wiki was built without anchors or
+// the entry doesn't have any.
+func (w *Wiki) EntryAnchorsAt(offset int64) (string, error) {
+	if w.anchors == nil {
+		return "", nil
+	}
+
+	return w.anchors.get(offset)
+}
+
+// HasAnchors returns whether the wiki was built with anchors, which is needed
+// for LookupSection to check fragments.
+func (w *Wiki) HasAnchors() bool {
+	return w.anchors != nil
+}
+
+// LookupSection returns the offset of the entry that link (a key, optionally
+// followed by # and an anchor, e.g. "Title#History") refers to. The error wraps
+// ErrNotFound if there isn't an entry with the key, or if the entry doesn't have
+// the anchor. The anchor isn't checked if the wiki was built without anchors.
+func (w *Wiki) LookupSection(link string) (int64, error) {
+	name, fragment, _ := strings.Cut(link, "#")
+
+	offset, err := w.EntryOffset(name)
+	if err != nil {
+		return -1, err
+	}
+
+	if fragment == "" || w.anchors == nil {
+		return offset, nil
+	}
+
+	anchors, err := w.anchors.get(offset)
+	if err != nil {
+		return -1, err
+	}
+	if !anchor.Contains(anchors, fragment) {
+		return -1, fmt.Errorf("%w: %s doesn't have the anchor %s", ErrNotFound, name, fragment)
+	}
+
+	return offset, nil
+}
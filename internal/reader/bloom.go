@@ -0,0 +1,24 @@
+package reader
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/rsookram/wiki-builder/internal/bloom"
+)
+
+// readBloomFilter reads the bloom filter section at offset into memory, so
+// that missing keys can be rejected without reading from the file.
+func readBloomFilter(r io.ReaderAt, offset int64, size int64) (*bloom.Filter, error) {
+	b := make([]byte, size)
+	if _, err := r.ReadAt(b, offset); err != nil {
+		return nil, fmt.Errorf("failed to read bloom filter: %w", err)
+	}
+
+	f, err := bloom.Decode(b)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCorrupt, err)
+	}
+
+	return f, nil
+}
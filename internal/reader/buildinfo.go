@@ -0,0 +1,28 @@
+package reader
+
+import (
+	"fmt"
+	"time"
+)
+
+// BuildInfo is the provenance of a wiki file, from its header. Fields are
+// empty when they weren't recorded (e.g. in files built before they were
+// added).
+type BuildInfo struct {
+	// ID is a random UUID for the build, in the canonical form (e.g.
+	// 123e4567-e89b-12d3-a456-426614174000).
+	ID string
+	// Source identifies the dump that the file was built from.
+	Source      string
+	Time        time.Time
+	ToolVersion string
+}
+
+// BuildInfo returns the provenance of the wiki file.
+func (w *Wiki) BuildInfo() BuildInfo {
+	return w.buildInfo
+}
+
+func formatUUID(b []byte) string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
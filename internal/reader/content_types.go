@@ -0,0 +1,78 @@
+package reader
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/rsookram/wiki-builder/internal/storage"
+)
+
+// contentTypes are the content types of entries which aren't HTML. They're
+// read into memory since there's usually few of them.
+type contentTypes struct {
+	types   []string
+	offsets []int64
+	indexes []byte
+}
+
+func readContentTypes(r io.ReaderAt, offset int64, size int64, offsetWidth int) (*contentTypes, error) {
+	b := make([]byte, size)
+	if _, err := r.ReadAt(b, offset); err != nil {
+		return nil, fmt.Errorf("failed to read content types: %w", err)
+	}
+
+	corrupt := fmt.Errorf("%w: content types section is truncated", ErrCorrupt)
+
+	var c contentTypes
+	if len(b) < 1 {
+		return nil, corrupt
+	}
+	numTypes := int(b[0])
+	b = b[1:]
+	for range numTypes {
+		if len(b) < 1 || len(b) < 1+int(b[0]) {
+			return nil, corrupt
+		}
+		c.types = append(c.types, string(b[1:][:b[0]]))
+		b = b[1+int(b[0]):]
+	}
+
+	if len(b) < 4 {
+		return nil, corrupt
+	}
+	numRows := int(binary.LittleEndian.Uint32(b))
+	b = b[4:]
+	if len(b) != numRows*(offsetWidth+1) {
+		return nil, corrupt
+	}
+
+	c.offsets = make([]int64, numRows)
+	c.indexes = make([]byte, numRows)
+	for i := range numRows {
+		row := b[i*(offsetWidth+1):]
+		c.offsets[i] = int64(entryOffsetToUInt64(row, 0, offsetWidth))
+		c.indexes[i] = row[offsetWidth]
+		if int(c.indexes[i]) >= numTypes {
+			return nil, fmt.Errorf("%w: unknown content type index %d", ErrCorrupt, c.indexes[i])
+		}
+	}
+
+	return &c, nil
+}
+
+// ContentType returns the content type of the entry at offset. Entries are
+// HTML unless the wiki was built with a different content type for them.
+func (w *Wiki) ContentType(offset int64) string {
+	if w.contentTypes == nil {
+		return storage.DefaultContentType
+	}
+
+	i, found := slices.BinarySearch(w.contentTypes.offsets, offset)
+	if !found {
+		return storage.DefaultContentType
+	}
+
+	return w.contentTypes.types[w.contentTypes.indexes[i]]
+}
@@ -0,0 +1,92 @@
+package reader
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// readerAtContext is implemented by sources of entries which can cancel a read
+// themselves (e.g. HTTP requests), rather than having it abandoned.
+type readerAtContext interface {
+	ReadAtContext(ctx context.Context, p []byte, off int64) (int, error)
+}
+
+// withContext returns a reader for r which stops reading once ctx is done. A
+// read which is in progress when ctx is done is abandoned, so that a stuck
+// disk doesn't block the caller.
+func withContext(ctx context.Context, r io.ReaderAt) io.ReaderAt {
+	if ctx.Done() == nil {
+		// ctx can never be done.
+		return r
+	}
+
+	return contextReaderAt{ctx: ctx, r: r}
+}
+
+type contextReaderAt struct {
+	ctx context.Context
+	r   io.ReaderAt
+}
+
+// maxPooledReadSize is the size of the largest buffer that's kept for reuse by
+// abandonable reads, so that reading a large entry doesn't keep its buffer
+// around.
+const maxPooledReadSize = 1024 * 1024
+
+// pendingReads holds the buffers and channels of abandonable reads which
+// finished before they were abandoned, so that each read (e.g. each refill of
+// the buffer that the index is scanned with) doesn't allocate new ones.
+var pendingReads = sync.Pool{
+	New: func() any {
+		return &pendingRead{done: make(chan readResult, 1)}
+	},
+}
+
+// pendingRead is a read which runs in its own goroutine so that it can be
+// abandoned. The read could finish after it's abandoned, so it reads into buf
+// rather than the caller's buffer, and it's only reused once its result has
+// been received.
+type pendingRead struct {
+	buf  []byte
+	done chan readResult
+}
+
+type readResult struct {
+	n   int
+	err error
+}
+
+func (p *pendingRead) run(r io.ReaderAt, off int64) {
+	n, err := r.ReadAt(p.buf, off)
+	p.done <- readResult{n, err}
+}
+
+func (c contextReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	if r, ok := c.r.(readerAtContext); ok {
+		return r.ReadAtContext(c.ctx, p, off)
+	}
+
+	read := pendingReads.Get().(*pendingRead)
+	if cap(read.buf) < len(p) {
+		read.buf = make([]byte, len(p))
+	}
+	read.buf = read.buf[:len(p)]
+	go read.run(c.r, off)
+
+	select {
+	case res := <-read.done:
+		copy(p, read.buf[:res.n])
+		if cap(read.buf) <= maxPooledReadSize {
+			pendingReads.Put(read)
+		}
+		return res.n, res.err
+	case <-c.ctx.Done():
+		// The read still owns read, so it isn't put back in the pool.
+		return 0, c.ctx.Err()
+	}
+}
@@ -0,0 +1,21 @@
+package reader
+
+import "errors"
+
+var (
+	// ErrNotFound is returned when there isn't an entry with a given name.
+	ErrNotFound = errors.New("not found")
+	// ErrNotWiki is returned when a file doesn't have the header of a wiki
+	// file.
+	ErrNotWiki = errors.New("not a wiki file")
+	// ErrCorrupt is returned when the file doesn't match the expected format.
+	ErrCorrupt = errors.New("corrupt wiki file")
+	// ErrUnsupportedVersion is returned when a wiki file was written in a
+	// newer version of the format than the reader supports.
+	ErrUnsupportedVersion = errors.New("unsupported wiki file version")
+	// ErrOutOfRange is returned when an offset isn't within the entries.
+	ErrOutOfRange = errors.New("offset out of range")
+	// ErrNotText is returned when the contents of an entry can't be converted
+	// to text.
+	ErrNotText = errors.New("entry isn't text")
+)
@@ -0,0 +1,118 @@
+package reader
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"slices"
+	"unicode/utf16"
+
+	"github.com/rsookram/wiki-builder/internal/storage"
+)
+
+type firstLevelIndex struct {
+	keyLen   int
+	keyChars []uint16
+	offsets  []uint32
+
+	// firstKeys are the first keys of the buckets which have the same key as
+	// the bucket before them, and nil for the other buckets. It's nil if
+	// there aren't any.
+	firstKeys [][]uint16
+}
+
+func decodeFirstLevelIndex(r io.Reader, numEntries uint16, keyLen int) (firstLevelIndex, error) {
+	buf := make([]byte, max(keyLen*2, 4))
+	var index firstLevelIndex
+
+	index.keyLen = keyLen
+	index.keyChars = make([]uint16, int(numEntries)*keyLen)
+	index.offsets = make([]uint32, numEntries)
+
+	for i := range numEntries {
+		if _, err := io.ReadFull(r, buf[:keyLen*2]); err != nil {
+			return index, fmt.Errorf("failed to read key char %d: %w", i, err)
+		}
+
+		offsetIntoKeyChars := int(i) * keyLen
+
+		for j := range keyLen {
+			ch := binary.LittleEndian.Uint16(buf[2*j:])
+			index.keyChars[offsetIntoKeyChars+j] = ch
+		}
+	}
+
+	for i := range numEntries {
+		if _, err := io.ReadFull(r, buf[:4]); err != nil {
+			return index, fmt.Errorf("failed to read offset %d: %w", i, err)
+		}
+
+		index.offsets[i] = binary.LittleEndian.Uint32(buf[:4])
+	}
+
+	return index, nil
+}
+
+// key returns the key of the ith bucket, padded with zeros.
+func (index firstLevelIndex) key(i int) []uint16 {
+	return index.keyChars[i*index.keyLen:][:index.keyLen]
+}
+
+// bucket returns the index of the part of the second level index where keys
+// which are >= chars start.
+func (index firstLevelIndex) bucket(chars []uint16) int {
+	i := index.keyBucket(chars)
+
+	// Buckets with the same key split the keys which start with it, so the
+	// last one which starts at or before chars is used.
+	for index.firstKeys != nil && index.firstKeys[i] != nil && storage.CompareUTF16(index.firstKeys[i], chars) > 0 {
+		i--
+	}
+
+	return i
+}
+
+// keyBucket returns the index of the last bucket whose key is <= chars.
+func (index firstLevelIndex) keyBucket(chars []uint16) int {
+	for i := range index.offsets {
+		if storage.CompareUTF16(index.key(i), chars) > 0 {
+			if i == 0 {
+				// chars is before the first key (or a prefix of it, e.g. when
+				// chars is shorter than the key), so it can only be in the
+				// first part.
+				return 0
+			}
+
+			return i - 1
+		}
+	}
+
+	// chars is after the last key
+	return len(index.offsets) - 1
+}
+
+// readSplitBuckets reads the first key of each bucket which has the same first
+// level key as the one before it, so that queries can choose between them.
+func (w *Wiki) readSplitBuckets() error {
+	for i := 1; i < len(w.first.offsets); i++ {
+		if !slices.Equal(w.first.key(i), w.first.key(i-1)) {
+			continue
+		}
+
+		s := w.scan(context.Background(), int64(w.first.offsets[i]))
+		if err := s.next(); err != nil {
+			s.close()
+			return fmt.Errorf("failed to read the first key of bucket %d: %w", i, err)
+		}
+		key := utf16.Encode([]rune(s.result().Key))
+		s.close()
+
+		if w.first.firstKeys == nil {
+			w.first.firstKeys = make([][]uint16, len(w.first.offsets))
+		}
+		w.first.firstKeys[i] = key
+	}
+
+	return nil
+}
@@ -0,0 +1,99 @@
+package reader
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// zstdDecoder decompresses the buckets of second level indexes. DecodeAll can
+// be called on it concurrently.
+var zstdDecoder = sync.OnceValue(func() *zstd.Decoder {
+	d, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+	if err != nil {
+		panic(err)
+	}
+	return d
+})
+
+var bucketFramesPool = sync.Pool{
+	New: func() any {
+		return new(bucketFrames)
+	},
+}
+
+// bucketFrames reads the rows of a second level index whose buckets are
+// compressed as separate zstd frames, one bucket at a time, so that a query
+// only reads and decompresses the buckets that it scans.
+type bucketFrames struct {
+	// index is the second level index, without its length.
+	index   *io.SectionReader
+	offsets []uint32
+	// next is the bucket to decompress next, or len(offsets) after the last
+	// one. It's -1 if the scan didn't start at a bucket.
+	next int
+	// start is the offset that the scan started at.
+	start int64
+	// end is the offset of the end of the last frame that was decompressed.
+	end int64
+
+	compressed []byte
+	buf        []byte
+	// rows reads the decompressed rows of the current bucket.
+	rows bytes.Reader
+}
+
+// reset makes f read index from the bucket which starts at offset, keeping its
+// buffers.
+func (f *bucketFrames) reset(index *io.SectionReader, offsets []uint32, offset int64) {
+	f.index = index
+	f.offsets = offsets
+	f.start = offset
+	f.end = offset
+	f.rows.Reset(nil)
+
+	f.next = -1
+	if i, found := slices.BinarySearch(offsets, uint32(offset)); found && int64(uint32(offset)) == offset {
+		f.next = i
+	}
+}
+
+// load reads and decompresses the next bucket. It returns io.EOF after the
+// last one.
+func (f *bucketFrames) load() error {
+	if f.next < 0 {
+		return fmt.Errorf("second level index scan starts at %d, which isn't the start of a bucket", f.start)
+	}
+	if f.next >= len(f.offsets) {
+		return io.EOF
+	}
+
+	start := int64(f.offsets[f.next])
+	end := f.index.Size()
+	if f.next+1 < len(f.offsets) {
+		end = int64(f.offsets[f.next+1])
+	}
+
+	f.compressed = slices.Grow(f.compressed[:0], int(end-start))[:end-start]
+	if _, err := f.index.ReadAt(f.compressed, start); err != nil {
+		return fmt.Errorf("failed to read second level index bucket at %d: %w", start, err)
+	}
+
+	buf, err := zstdDecoder().DecodeAll(f.compressed, f.buf[:0])
+	if err != nil {
+		return fmt.Errorf("%w: failed to decompress second level index bucket at %d: %w", ErrCorrupt, start, err)
+	}
+	if len(buf) == 0 {
+		return fmt.Errorf("%w: second level index bucket at %d is empty", ErrCorrupt, start)
+	}
+
+	f.buf = buf
+	f.rows.Reset(buf)
+	f.end = end
+	f.next++
+	return nil
+}
@@ -0,0 +1,114 @@
+package reader
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// hashLen is the number of bytes in each hash (SHA-256).
+const hashLen = 32
+
+// hashes reads the hashes section of a wiki file. It's safe for concurrent
+// use.
+type hashes struct {
+	r           io.ReaderAt
+	numRows     int
+	rowsOffset  int64
+	offsetWidth int
+}
+
+func openHashes(r io.ReaderAt, offset int64, size int64, offsetWidth int) (*hashes, error) {
+	var buf [4]byte
+	if _, err := r.ReadAt(buf[:], offset); err != nil {
+		return nil, fmt.Errorf("failed to read number of hashes: %w", err)
+	}
+
+	h := &hashes{
+		r:           r,
+		numRows:     int(binary.LittleEndian.Uint32(buf[:])),
+		rowsOffset:  offset + 4,
+		offsetWidth: offsetWidth,
+	}
+
+	if 4+int64(h.numRows)*int64(h.rowSize()) != size {
+		return nil, fmt.Errorf("%w: %d hash rows don't fit in %d B", ErrCorrupt, h.numRows, size)
+	}
+
+	return h, nil
+}
+
+func (h *hashes) rowSize() int {
+	return h.offsetWidth + hashLen
+}
+
+// row returns the entry offset and the hash of the ith row.
+func (h *hashes) row(i int) (int64, []byte, error) {
+	buf := make([]byte, h.rowSize())
+	if _, err := h.r.ReadAt(buf, h.rowsOffset+int64(i)*int64(len(buf))); err != nil {
+		return 0, nil, fmt.Errorf("failed to read hash row %d: %w", i, err)
+	}
+
+	return int64(entryOffsetToUInt64(buf, 0, h.offsetWidth)), buf[h.offsetWidth:], nil
+}
+
+// get returns the hash of the entry at offset, or nil if it doesn't have one.
+func (h *hashes) get(offset int64) ([]byte, error) {
+	var err error
+	i := sort.Search(h.numRows, func(i int) bool {
+		if err != nil {
+			return true
+		}
+
+		var rowOffset int64
+		rowOffset, _, err = h.row(i)
+		return rowOffset >= offset
+	})
+	if err != nil {
+		return nil, err
+	}
+	if i == h.numRows {
+		return nil, nil
+	}
+
+	rowOffset, hash, err := h.row(i)
+	if err != nil {
+		return nil, err
+	}
+	if rowOffset != offset {
+		return nil, nil
+	}
+
+	return hash, nil
+}
+
+// EntryHash returns the SHA-256 of the uncompressed contents of the entry with
+// the given name. It returns an error wrapping ErrNotFound if there isn't an
+// entry with the name, or if the wiki was built without hashes.
+func (w *Wiki) EntryHash(name string) ([]byte, error) {
+	offset, err := w.EntryOffset(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return w.EntryHashAt(offset)
+}
+
+// EntryHashAt is like EntryHash, but for the entry at offset. Entries with the
+// same hash have the same contents, so it can be used to find duplicates.
+func (w *Wiki) EntryHashAt(offset int64) ([]byte, error) {
+	if w.hashes == nil {
+		return nil, fmt.Errorf("%w: the wiki was built without hashes", ErrNotFound)
+	}
+
+	hash, err := w.hashes.get(offset)
+	if err != nil {
+		return nil, err
+	}
+	if hash == nil {
+		return nil, fmt.Errorf("%w: no hash for the entry at %d", ErrNotFound, offset)
+	}
+
+	return hash, nil
+}
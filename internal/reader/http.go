@@ -0,0 +1,86 @@
+package reader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// isURL returns whether the location of an entries file is an HTTP(S) URL
+// rather than a path.
+func isURL(location string) bool {
+	return strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://")
+}
+
+// httpEntries reads an entries file from a static file host using Range
+// requests.
+type httpEntries struct {
+	client *http.Client
+	url    string
+	size   int64
+}
+
+// openHTTPEntries makes a HEAD request for the entries file at url to find its
+// size.
+func openHTTPEntries(url string) (*httpEntries, error) {
+	e := &httpEntries{client: http.DefaultClient, url: url}
+
+	resp, err := e.client.Head(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to request %s: %w", url, err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status for %s: %s", url, resp.Status)
+	}
+	if resp.ContentLength < 0 {
+		return nil, fmt.Errorf("missing Content-Length for %s", url)
+	}
+	e.size = resp.ContentLength
+
+	return e, nil
+}
+
+// ReadAt reads len(p) bytes starting at off with a single Range request.
+func (e *httpEntries) ReadAt(p []byte, off int64) (int, error) {
+	return e.ReadAtContext(context.Background(), p, off)
+}
+
+// ReadAtContext is like ReadAt, but the request is cancelled once ctx is done.
+func (e *httpEntries) ReadAtContext(ctx context.Context, p []byte, off int64) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if off >= e.size {
+		return 0, io.EOF
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, e.url, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+int64(len(p))-1))
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to request %d B at %d: %w", len(p), off, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		// A 200 means that the server ignored the range, and would send the
+		// whole file.
+		return 0, fmt.Errorf("unexpected status for range request at %d: %s", off, resp.Status)
+	}
+
+	n, err := io.ReadFull(resp.Body, p)
+	if err == io.ErrUnexpectedEOF && off+int64(n) == e.size {
+		// The range extended past the end of the file.
+		err = io.EOF
+	}
+
+	return n, err
+}
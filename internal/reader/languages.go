@@ -0,0 +1,152 @@
+package reader
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// languages reads the languages section of a wiki file. The language codes are
+// read into memory, and the rows are read as they're needed since there's
+// usually one for each entry. It's safe for concurrent use.
+type languages struct {
+	codes       []string
+	r           io.ReaderAt
+	numRows     int
+	rowsOffset  int64
+	offsetWidth int
+}
+
+func openLanguages(r io.ReaderAt, offset int64, size int64, offsetWidth int) (*languages, error) {
+	// The codes are at most 255 B each, with a length prefix, and they're
+	// followed by the number of rows.
+	b := make([]byte, min(size, 1+math.MaxUint8*(1+math.MaxUint8)+4))
+	if _, err := r.ReadAt(b, offset); err != nil {
+		return nil, fmt.Errorf("failed to read languages: %w", err)
+	}
+
+	corrupt := fmt.Errorf("%w: languages section is truncated", ErrCorrupt)
+
+	l := &languages{r: r, offsetWidth: offsetWidth}
+	if len(b) < 1 {
+		return nil, corrupt
+	}
+	numCodes := int(b[0])
+	headerLen := 1
+	for range numCodes {
+		rest := b[headerLen:]
+		if len(rest) < 1 || len(rest) < 1+int(rest[0]) {
+			return nil, corrupt
+		}
+		l.codes = append(l.codes, string(rest[1:][:rest[0]]))
+		headerLen += 1 + int(rest[0])
+	}
+
+	if len(b) < headerLen+4 {
+		return nil, corrupt
+	}
+	l.numRows = int(binary.LittleEndian.Uint32(b[headerLen:]))
+	l.rowsOffset = offset + int64(headerLen) + 4
+
+	if int64(headerLen)+4+int64(l.numRows)*int64(l.rowSize()) != size {
+		return nil, fmt.Errorf("%w: %d language rows don't fit in %d B", ErrCorrupt, l.numRows, size)
+	}
+
+	return l, nil
+}
+
+// withContext returns a copy of l which stops reading once ctx is done.
+func (l *languages) withContext(ctx context.Context) *languages {
+	c := *l
+	c.r = withContext(ctx, l.r)
+	return &c
+}
+
+func (l *languages) rowSize() int {
+	return l.offsetWidth + 1
+}
+
+// row returns the entry offset and the index of the language of the ith row.
+func (l *languages) row(i int) (int64, int, error) {
+	buf := make([]byte, l.rowSize())
+	if _, err := l.r.ReadAt(buf, l.rowsOffset+int64(i)*int64(len(buf))); err != nil {
+		return 0, 0, fmt.Errorf("failed to read language row %d: %w", i, err)
+	}
+
+	return int64(entryOffsetToUInt64(buf, 0, l.offsetWidth)), int(buf[l.offsetWidth]), nil
+}
+
+// get returns the language of the entry at offset, or an empty string if it
+// doesn't have one.
+func (l *languages) get(offset int64) (string, error) {
+	var err error
+	i := sort.Search(l.numRows, func(i int) bool {
+		if err != nil {
+			return true
+		}
+
+		var rowOffset int64
+		rowOffset, _, err = l.row(i)
+		return rowOffset >= offset
+	})
+	if err != nil {
+		return "", err
+	}
+	if i == l.numRows {
+		return "", nil
+	}
+
+	rowOffset, idx, err := l.row(i)
+	if err != nil {
+		return "", err
+	}
+	if rowOffset != offset {
+		return "", nil
+	}
+	if idx >= len(l.codes) {
+		return "", fmt.Errorf("%w: unknown language index %d", ErrCorrupt, idx)
+	}
+
+	return l.codes[idx], nil
+}
+
+// Languages returns the language codes that entries of the wiki are tagged
+// with, or nil if it was built without languages.
+func (w *Wiki) Languages() []string {
+	if w.languages == nil {
+		return nil
+	}
+
+	return w.languages.codes
+}
+
+// EntryLanguageAt returns the language code of the entry at offset, or an empty
+// string if the wiki was built without languages or the entry doesn't have one.
+func (w *Wiki) EntryLanguageAt(offset int64) (string, error) {
+	if w.languages == nil {
+		return "", nil
+	}
+
+	return w.languages.get(offset)
+}
+
+// languageFilter returns a filter for the entries with the given language.
+// Entries without a language don't match.
+func (w *Wiki) languageFilter(ctx context.Context, language string) entryFilter {
+	if w.languages == nil {
+		return func(int64) (bool, error) { return false, nil }
+	}
+
+	l := w.languages.withContext(ctx)
+	return func(offset int64) (bool, error) {
+		entryLanguage, err := l.get(offset)
+		if err != nil {
+			return false, fmt.Errorf("failed to read language: %w", err)
+		}
+
+		return entryLanguage == language, nil
+	}
+}
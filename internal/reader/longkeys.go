@@ -0,0 +1,46 @@
+package reader
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// readLongKeys reads the long keys section at offset into memory, returning
+// each key in UTF-16LE.
+func readLongKeys(r io.ReaderAt, offset int64, size int64) ([][]byte, error) {
+	b := make([]byte, size)
+	if _, err := r.ReadAt(b, offset); err != nil {
+		return nil, fmt.Errorf("failed to read long keys: %w", err)
+	}
+
+	corrupt := fmt.Errorf("%w: long keys section is truncated", ErrCorrupt)
+
+	if len(b) < 4 {
+		return nil, corrupt
+	}
+	numKeys := binary.LittleEndian.Uint32(b)
+	b = b[4:]
+
+	// Each key takes at least 2 B for its length.
+	if int64(numKeys) > int64(len(b)/2) {
+		return nil, corrupt
+	}
+
+	keys := make([][]byte, numKeys)
+	for i := range keys {
+		if len(b) < 2 {
+			return nil, corrupt
+		}
+		n := 2 * int(binary.LittleEndian.Uint16(b))
+		b = b[2:]
+
+		if len(b) < n {
+			return nil, corrupt
+		}
+		keys[i] = b[:n:n]
+		b = b[n:]
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,42 @@
+package reader
+
+import "fmt"
+
+// EntryPageRankAt returns the PageRank of the entry at offset, scaled so that
+// the average entry has a rank of 1000. It returns an error wrapping
+// ErrNotFound if the wiki was built without page ranks, or if the entry doesn't
+// have one.
+func (w *Wiki) EntryPageRankAt(offset int64) (int64, error) {
+	if w.pageRanks == nil {
+		return 0, fmt.Errorf("%w: the wiki was built without page ranks", ErrNotFound)
+	}
+
+	rank, found, err := w.pageRanks.get(offset)
+	if err != nil {
+		return 0, err
+	}
+	if !found {
+		return 0, fmt.Errorf("%w: no page rank for the entry at %d", ErrNotFound, offset)
+	}
+
+	return rank, nil
+}
+
+// ranks returns the page rank of the entry of each result, by entry offset.
+// Entries without one have a rank of 0.
+func (s *sizes) ranks(results []SearchResult) (map[int64]int64, error) {
+	ranks := make(map[int64]int64, len(results))
+	for _, r := range results {
+		if _, found := ranks[r.EntryOffset]; found {
+			continue
+		}
+
+		rank, _, err := s.get(r.EntryOffset)
+		if err != nil {
+			return nil, err
+		}
+		ranks[r.EntryOffset] = rank
+	}
+
+	return ranks, nil
+}
@@ -0,0 +1,161 @@
+package reader
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// popularity reads the popularity section of a wiki file. The popular entries
+// are read into memory, and the rows are read as they're needed. It's safe
+// for concurrent use.
+type popularity struct {
+	r           io.ReaderAt
+	numRows     int
+	rowsOffset  int64
+	offsetWidth int
+	popular     []SearchResult
+}
+
+func openPopularity(r io.ReaderAt, offset int64, size int64, offsetWidth int) (*popularity, error) {
+	var buf [4]byte
+	if _, err := r.ReadAt(buf[:], offset); err != nil {
+		return nil, fmt.Errorf("failed to read number of weights: %w", err)
+	}
+
+	p := &popularity{
+		r:           r,
+		numRows:     int(binary.LittleEndian.Uint32(buf[:])),
+		rowsOffset:  offset + 4,
+		offsetWidth: offsetWidth,
+	}
+
+	popularOffset := p.rowsOffset + int64(p.numRows)*int64(p.rowSize())
+	if popularOffset > offset+size {
+		return nil, fmt.Errorf("%w: %d weight rows don't fit in %d B", ErrCorrupt, p.numRows, size)
+	}
+
+	b := make([]byte, offset+size-popularOffset)
+	if _, err := r.ReadAt(b, popularOffset); err != nil {
+		return nil, fmt.Errorf("failed to read popular entries: %w", err)
+	}
+
+	corrupt := fmt.Errorf("%w: popular entries are truncated", ErrCorrupt)
+	if len(b) < 2 {
+		return nil, corrupt
+	}
+	numPopular := int(binary.LittleEndian.Uint16(b))
+	b = b[2:]
+	for range numPopular {
+		if len(b) < offsetWidth+2 {
+			return nil, corrupt
+		}
+		entryOffset := int64(entryOffsetToUInt64(b, 0, offsetWidth))
+		keyLen := int(binary.LittleEndian.Uint16(b[offsetWidth:]))
+		b = b[offsetWidth+2:]
+		if len(b) < keyLen {
+			return nil, corrupt
+		}
+
+		p.popular = append(p.popular, SearchResult{Key: string(b[:keyLen]), EntryOffset: entryOffset})
+		b = b[keyLen:]
+	}
+	if len(b) != 0 {
+		return nil, fmt.Errorf("%w: %d B after the popular entries", ErrCorrupt, len(b))
+	}
+
+	return p, nil
+}
+
+// withContext returns a copy of p which stops reading once ctx is done.
+func (p *popularity) withContext(ctx context.Context) *popularity {
+	c := *p
+	c.r = withContext(ctx, p.r)
+	return &c
+}
+
+func (p *popularity) rowSize() int {
+	return p.offsetWidth + 4
+}
+
+// row returns the entry offset and the weight of the ith row.
+func (p *popularity) row(i int) (int64, uint32, error) {
+	buf := make([]byte, p.rowSize())
+	if _, err := p.r.ReadAt(buf, p.rowsOffset+int64(i)*int64(len(buf))); err != nil {
+		return 0, 0, fmt.Errorf("failed to read weight row %d: %w", i, err)
+	}
+
+	return int64(entryOffsetToUInt64(buf, 0, p.offsetWidth)), binary.LittleEndian.Uint32(buf[p.offsetWidth:]), nil
+}
+
+// get returns the weight of the entry at offset, which is 0 if it doesn't have
+// one.
+func (p *popularity) get(offset int64) (uint32, error) {
+	var err error
+	i := sort.Search(p.numRows, func(i int) bool {
+		if err != nil {
+			return true
+		}
+
+		var rowOffset int64
+		rowOffset, _, err = p.row(i)
+		return rowOffset >= offset
+	})
+	if err != nil {
+		return 0, err
+	}
+	if i == p.numRows {
+		return 0, nil
+	}
+
+	rowOffset, weight, err := p.row(i)
+	if err != nil {
+		return 0, err
+	}
+	if rowOffset != offset {
+		return 0, nil
+	}
+
+	return weight, nil
+}
+
+// weights returns the weight of the entry of each result, by entry offset.
+func (p *popularity) weights(results []SearchResult) (map[int64]uint32, error) {
+	weights := make(map[int64]uint32, len(results))
+	for _, r := range results {
+		if _, found := weights[r.EntryOffset]; found {
+			continue
+		}
+
+		weight, err := p.get(r.EntryOffset)
+		if err != nil {
+			return nil, err
+		}
+		weights[r.EntryOffset] = weight
+	}
+
+	return weights, nil
+}
+
+// EntryWeightAt returns the weight (e.g. the number of page views) of the
+// entry at offset, or 0 if the wiki was built without weights or the entry
+// doesn't have one.
+func (w *Wiki) EntryWeightAt(offset int64) (uint32, error) {
+	if w.popularity == nil {
+		return 0, nil
+	}
+
+	return w.popularity.get(offset)
+}
+
+// PopularEntries returns the keys of up to 100 of the entries with the highest
+// weights, the highest first, or nil if the wiki was built without weights.
+func (w *Wiki) PopularEntries() []SearchResult {
+	if w.popularity == nil {
+		return nil
+	}
+
+	return w.popularity.popular
+}
@@ -0,0 +1,202 @@
+package reader
+
+import (
+	"context"
+	"slices"
+	"sync"
+)
+
+// entryCache holds the compressed bytes of entries which were read ahead of
+// being requested. The oldest entries are evicted once it's full.
+type entryCache struct {
+	// window is the number of entries to read ahead after each entry that's
+	// read.
+	window   int
+	capacity int
+
+	mu      sync.Mutex
+	entries map[int64][]byte
+	order   []int64
+
+	// requests are read ahead one at a time by a single worker. There's only
+	// room for one waiting request, and requests made while it's taken are
+	// dropped, so that reading ahead never falls behind the entries which are
+	// being read.
+	requests chan prefetchRequest
+	// stop stops the worker, abandoning the request it's reading.
+	stop context.CancelFunc
+
+	// readingFrom and readingTo are the offsets of the entries that the worker
+	// has read ahead from and up to (exclusive) for its current request, which
+	// are both 0 when it's idle. They're guarded by mu.
+	readingFrom int64
+	readingTo   int64
+}
+
+// prefetchRequest is a request to read ahead either the entries at offsets or,
+// if it's nil, the window of entries starting at next.
+type prefetchRequest struct {
+	offsets []int64
+	next    int64
+}
+
+func newEntryCache(window int) *entryCache {
+	return &entryCache{
+		window:   window,
+		capacity: max(64, 4*window),
+		entries:  make(map[int64][]byte),
+		requests: make(chan prefetchRequest, 1),
+	}
+}
+
+func (c *entryCache) get(offset int64) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b, found := c.entries[offset]
+	return b, found
+}
+
+func (c *entryCache) put(offset int64, b []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, found := c.entries[offset]; found {
+		return
+	}
+
+	if len(c.order) >= c.capacity {
+		delete(c.entries, c.order[0])
+		c.order = slices.Delete(c.order, 0, 1)
+	}
+
+	c.entries[offset] = b
+	c.order = append(c.order, offset)
+}
+
+// request queues req for the worker, unless it's already busy with another
+// request and has one waiting.
+func (c *entryCache) request(req prefetchRequest) {
+	select {
+	case c.requests <- req:
+	default:
+	}
+}
+
+// reading returns whether the worker has already read ahead past offset for its
+// current request, so that reading ahead after it again would be redundant.
+func (c *entryCache) reading(offset int64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.readingFrom <= offset && offset < c.readingTo
+}
+
+// setReading records the offsets that the worker has read ahead between.
+func (c *entryCache) setReading(from int64, to int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.readingFrom = from
+	c.readingTo = to
+}
+
+// SetPrefetch makes EntryAt read the compressed bytes of the next n entries in
+// the file in the background, so that reading them later doesn't need to wait
+// for the disk. This helps on slow storage (e.g. SD cards) when entries are
+// read in order. Entries are read ahead by one goroutine, which runs until w is
+// closed, and reads that it can't keep up with aren't read ahead after. n = 0
+// disables prefetching. It must be called before any entries are read.
+func (w *Wiki) SetPrefetch(n int) {
+	if w.cache != nil {
+		w.cache.stop()
+	}
+	if n <= 0 {
+		w.cache = nil
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w.cache = newEntryCache(n)
+	w.cache.stop = cancel
+	go w.prefetchWorker(ctx, w.cache)
+}
+
+// Prefetch reads the compressed bytes of the first n entries at offsets in the
+// background (e.g. for the results of a query), where n was passed to
+// SetPrefetch. It does nothing unless prefetching was enabled.
+func (w *Wiki) Prefetch(offsets []int64) {
+	if w.cache == nil || len(offsets) == 0 {
+		return
+	}
+
+	w.cache.request(prefetchRequest{offsets: slices.Clone(offsets[:min(len(offsets), w.cache.window)])})
+}
+
+// readAhead requests that the entries which follow the one at offset, which
+// has the given compressed size, are read ahead.
+func (w *Wiki) readAhead(offset int64, compressedSize int) {
+	if w.cache.reading(offset) {
+		return
+	}
+
+	w.cache.request(prefetchRequest{next: offset + 3 + int64(compressedSize)})
+}
+
+// prefetchWorker reads ahead the requests for c until ctx is done.
+func (w *Wiki) prefetchWorker(ctx context.Context, c *entryCache) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case req := <-c.requests:
+			if req.offsets != nil {
+				w.prefetchOffsets(ctx, c, req.offsets)
+			} else {
+				w.prefetchWindow(ctx, c, req.next)
+			}
+			c.setReading(0, 0)
+		}
+	}
+}
+
+// prefetchOffsets caches the compressed bytes of the entries at offsets.
+func (w *Wiki) prefetchOffsets(ctx context.Context, c *entryCache, offsets []int64) {
+	for _, offset := range offsets {
+		if _, found := c.get(offset); found {
+			continue
+		}
+
+		b, err := w.readCompressed(ctx, offset)
+		if err != nil {
+			// This is only an optimization, so the error will be reported
+			// if the entry is actually read.
+			return
+		}
+		c.put(offset, b)
+	}
+}
+
+// prefetchWindow caches the compressed bytes of the window of entries which
+// starts at next.
+func (w *Wiki) prefetchWindow(ctx context.Context, c *entryCache, next int64) {
+	from := next
+	for range c.window {
+		if next >= w.entriesLen {
+			return
+		}
+
+		b, found := c.get(next)
+		if !found {
+			var err error
+			b, err = w.readCompressed(ctx, next)
+			if err != nil {
+				return
+			}
+			c.put(next, b)
+		}
+
+		next += 3 + int64(len(b))
+		c.setReading(from, next)
+	}
+}
@@ -0,0 +1,105 @@
+package reader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"unicode/utf16"
+)
+
+// numSampledBuckets is the number of buckets whose rows are counted by
+// FirstLevelKeys to estimate the size of a row.
+const numSampledBuckets = 16
+
+// FirstLevelKey is a key of the first level index, which is where a part of
+// the second level index starts, along with about how many keys are in it.
+type FirstLevelKey struct {
+	// Key is the start of the first key in the part, up to the first level
+	// key length (see wiki-builder -first-level-key-len).
+	Key string
+	// ApproxKeys is about how many keys (including redirects) are from Key up
+	// to the next FirstLevelKey. It's exact if its part was one of the ones
+	// sampled.
+	ApproxKeys int
+}
+
+// FirstLevelKeys returns the keys of the first level index in sorted order, so
+// that the keys can be split into ranges of about the same size without
+// scanning the whole index (e.g. for the thumb index of a dictionary). The
+// number of keys in each range is estimated from the size of its part of the
+// second level index, using the size of the rows in a few evenly spaced
+// parts.
+func (w *Wiki) FirstLevelKeys(ctx context.Context) ([]FirstLevelKey, error) {
+	numBuckets := len(w.first.offsets)
+	bucketLen := func(i int) int64 {
+		if i+1 < numBuckets {
+			return int64(w.first.offsets[i+1]) - int64(w.first.offsets[i])
+		}
+		return w.secondLevelIndexLen - int64(w.first.offsets[i])
+	}
+
+	counts := make(map[int]int)
+	var sampledLen int64
+	var sampledRows int
+	for j := range min(numBuckets, numSampledBuckets) {
+		i := j * numBuckets / min(numBuckets, numSampledBuckets)
+		n, err := w.countRows(ctx, i)
+		if err != nil {
+			return nil, err
+		}
+
+		counts[i] = n
+		sampledLen += bucketLen(i)
+		sampledRows += n
+	}
+
+	var keys []FirstLevelKey
+	for i := range numBuckets {
+		n, sampled := counts[i]
+		if !sampled && sampledRows > 0 {
+			n = int(max(1, bucketLen(i)*int64(sampledRows)/sampledLen))
+		}
+
+		// Buckets with the same key split the keys which start with it, so
+		// they're combined.
+		if i > 0 && slices.Equal(w.first.key(i), w.first.key(i-1)) {
+			keys[len(keys)-1].ApproxKeys += n
+			continue
+		}
+
+		key := w.first.key(i)
+		if end := slices.Index(key, 0); end >= 0 {
+			key = key[:end]
+		}
+		keys = append(keys, FirstLevelKey{Key: string(utf16.Decode(key)), ApproxKeys: n})
+	}
+
+	return keys, nil
+}
+
+// countRows returns the number of rows in the ith bucket.
+func (w *Wiki) countRows(ctx context.Context, i int) (int, error) {
+	end := w.secondLevelIndexLen
+	if i+1 < len(w.first.offsets) {
+		end = int64(w.first.offsets[i+1])
+	}
+
+	s := w.scan(ctx, int64(w.first.offsets[i]))
+	defer s.close()
+
+	n := 0
+	for s.pos < end {
+		err := s.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to count the rows of bucket %d: %w", i, err)
+		}
+		n++
+	}
+
+	return n, nil
+}
@@ -0,0 +1,56 @@
+package reader
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// rankCandidates is the number of keys which are read for each result when
+// ranking, so that better matches which come later in the index can still be
+// returned.
+const rankCandidates = 8
+
+// rankResults sorts results so that the best matches for prefix come first: an
+// exact match, then entries with higher weights (if weights isn't nil), then
+// entries with higher page ranks (if ranks isn't nil), then shorter keys, then
+// entries before redirects. Results which are ranked the same keep their order.
+// The file format doesn't distinguish between entries and redirects, so of the
+// results which refer to the same entry, the one with the fewest path segments
+// is treated as the entry (like the exports do).
+func rankResults(prefix string, results []SearchResult, weights map[int64]uint32, ranks map[int64]int64) {
+	canonical := make(map[int64]string, len(results))
+	for _, r := range results {
+		existing, found := canonical[r.EntryOffset]
+		if !found || strings.Count(r.Key, "/") < strings.Count(existing, "/") {
+			canonical[r.EntryOffset] = r.Key
+		}
+	}
+
+	isRedirect := func(r SearchResult) bool {
+		return canonical[r.EntryOffset] != r.Key
+	}
+
+	slices.SortStableFunc(results, func(a, b SearchResult) int {
+		return cmp.Or(
+			compareBools(a.Key != prefix, b.Key != prefix),
+			cmp.Compare(weights[b.EntryOffset], weights[a.EntryOffset]),
+			cmp.Compare(ranks[b.EntryOffset], ranks[a.EntryOffset]),
+			cmp.Compare(utf8.RuneCountInString(a.Key), utf8.RuneCountInString(b.Key)),
+			compareBools(isRedirect(a), isRedirect(b)),
+		)
+	})
+}
+
+// compareBools compares a and b with false before true.
+func compareBools(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return 1
+	default:
+		return -1
+	}
+}
@@ -0,0 +1,79 @@
+package reader
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"unicode/utf16"
+)
+
+// redirectFlags is the redirects section, which has a bit for each row of the
+// second level index that tells whether its key is from a redirect.
+type redirectFlags struct {
+	// bucketStarts is the index of the first row of each bucket, so that
+	// scanners which start at a bucket know the index of each row they read.
+	bucketStarts []uint32
+	bits         []byte
+}
+
+// readRedirectFlags reads the redirects section at offset into memory. It has
+// to have a row index for each of the numBuckets buckets of the first level
+// index.
+func readRedirectFlags(r io.ReaderAt, offset int64, size int64, numBuckets int) (*redirectFlags, error) {
+	b := make([]byte, size)
+	if _, err := r.ReadAt(b, offset); err != nil {
+		return nil, fmt.Errorf("failed to read redirects: %w", err)
+	}
+
+	if len(b) < 4 {
+		return nil, fmt.Errorf("%w: redirects section is too short: %d B", ErrCorrupt, len(b))
+	}
+	n := int64(binary.LittleEndian.Uint32(b))
+	if n != int64(numBuckets) || 4+4*n > int64(len(b)) {
+		return nil, fmt.Errorf("%w: redirects section has %d buckets, but the first level index has %d", ErrCorrupt, n, numBuckets)
+	}
+
+	flags := &redirectFlags{bucketStarts: make([]uint32, n), bits: b[4+4*n:]}
+	for i := range flags.bucketStarts {
+		flags.bucketStarts[i] = binary.LittleEndian.Uint32(b[4+4*i:])
+	}
+
+	return flags, nil
+}
+
+// isRedirect returns whether the row with index i is a redirect.
+func (f *redirectFlags) isRedirect(i int64) bool {
+	if i < 0 || i/8 >= int64(len(f.bits)) {
+		return false
+	}
+
+	return f.bits[i/8]&(1<<(i%8)) != 0
+}
+
+// HasRedirectFlags returns whether the wiki records which of its keys are from
+// redirects. Wikis built before redirects were recorded (or without any) don't.
+func (w *Wiki) HasRedirectFlags() bool {
+	return w.redirects != nil
+}
+
+// IsRedirect returns whether key is from a redirect rather than the name of an
+// entry, or an error wrapping ErrNotFound if it isn't one of the keys. It's
+// always false unless the wiki has redirect flags.
+func (w *Wiki) IsRedirect(ctx context.Context, key string) (bool, error) {
+	chars := utf16.Encode([]rune(key))
+
+	start, end := w.bucket(chars)
+	s := w.scan(ctx, start)
+	defer s.close()
+
+	found, err := s.seek(chars, end)
+	if err != nil {
+		return false, fmt.Errorf("isRedirect failed: %w", err)
+	}
+	if !found || s.compare(chars) != 0 {
+		return false, fmt.Errorf("%w: %s isn't in the second level index", ErrNotFound, key)
+	}
+
+	return s.result().Redirect, nil
+}
@@ -0,0 +1,262 @@
+package reader
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"sync"
+	"unicode/utf16"
+)
+
+// DefaultScanBufferSize is the size of the buffer that the second level index
+// is read with, unless it's changed with SetScanBufferSize.
+const DefaultScanBufferSize = 16 * 1024
+
+// scanReaders holds a pool of the buffered readers used by indexScanners for
+// each buffer size, so that a new buffer isn't allocated for each query.
+var scanReaders sync.Map
+
+func scanReaderPool(size int) *sync.Pool {
+	if pool, found := scanReaders.Load(size); found {
+		return pool.(*sync.Pool)
+	}
+
+	pool, _ := scanReaders.LoadOrStore(size, &sync.Pool{
+		New: func() any {
+			return bufio.NewReaderSize(nil, size)
+		},
+	})
+	return pool.(*sync.Pool)
+}
+
+// SetScanBufferSize sets the size of the buffer that the second level index is
+// read with for each query. Larger buffers need fewer reads to scan a bucket,
+// which helps when seeks are slow (e.g. on SD cards and network storage), but
+// read more past the rows that are needed. Wikis with compressed buckets read
+// a whole bucket at a time instead. It must be called before the wiki is used.
+func (w *Wiki) SetScanBufferSize(n int) {
+	w.scanBufferSize = n
+	if w.translit != nil {
+		w.translit.scanBufferSize = n
+	}
+	if w.words != nil {
+		w.words.scanBufferSize = n
+	}
+}
+
+// indexScanner reads the rows of the second level index in order. Each query
+// uses its own scanner, so queries can run concurrently.
+type indexScanner struct {
+	// rdr reads the rows. It's frames.rows when the buckets are compressed,
+	// and a pooled *bufio.Reader otherwise.
+	rdr         io.Reader
+	frames      *bucketFrames
+	offsetWidth int
+	// pos is the offset of the next row in the second level index. When the
+	// buckets are compressed, rows don't have offsets, so it's the offset of
+	// the frame with the next row instead.
+	pos int64
+
+	// buf holds the key of the last row that was read in UTF-16LE, followed
+	// by its entry offset. For long keys, it only holds the start of the key,
+	// which the next row can reuse.
+	buf         [2*2*math.MaxUint8 + 8]byte
+	numKeyBytes int
+
+	// redirects is the wiki's redirect flags, or nil if it doesn't have them.
+	redirects *redirectFlags
+	// row is the index of the last row that was read in the second level
+	// index, to look up its redirect flag.
+	row int64
+
+	// longKeys are the keys of the long keys section in UTF-16LE.
+	longKeys [][]byte
+	// longKey is the key of the last row that was read if it's a long key,
+	// and nil otherwise.
+	longKey []byte
+}
+
+// longKeySentinel is the length of the key in rows whose keys are in the long
+// keys section.
+const longKeySentinel = math.MaxUint8
+
+// maxKeyLen is the maximum number of UTF-16 code units in a key which is stored
+// in the second level index.
+const maxKeyLen = 127
+
+// scan returns a scanner for the second level index which starts at the row
+// at offset, and stops reading once ctx is done. close must be called on it
+// once it's no longer needed.
+func (w *Wiki) scan(ctx context.Context, offset int64) *indexScanner {
+	s := &indexScanner{offsetWidth: w.offsetWidth, pos: offset, longKeys: w.longKeys}
+	if w.redirects != nil {
+		// Scans start at a bucket, whose first row has a known index.
+		if i, found := slices.BinarySearch(w.first.offsets, uint32(offset)); found {
+			s.redirects = w.redirects
+			s.row = int64(w.redirects.bucketStarts[i]) - 1
+		}
+	}
+	if w.compressedBuckets {
+		s.frames = bucketFramesPool.Get().(*bucketFrames)
+		s.frames.reset(io.NewSectionReader(withContext(ctx, w.file), w.secondLevelIndexStart, w.secondLevelIndexLen), w.first.offsets, offset)
+		s.rdr = &s.frames.rows
+		return s
+	}
+
+	size := w.scanBufferSize
+	if size <= 0 {
+		size = DefaultScanBufferSize
+	}
+
+	rdr := scanReaderPool(size).Get().(*bufio.Reader)
+	rdr.Reset(io.NewSectionReader(withContext(ctx, w.file), w.secondLevelIndexStart+offset, w.secondLevelIndexLen-offset))
+	s.rdr = rdr
+
+	return s
+}
+
+func (s *indexScanner) close() {
+	if s.frames != nil {
+		s.frames.reset(nil, nil, 0)
+		bucketFramesPool.Put(s.frames)
+		return
+	}
+
+	rdr := s.rdr.(*bufio.Reader)
+	rdr.Reset(nil)
+	scanReaderPool(rdr.Size()).Put(rdr)
+}
+
+// next reads the next row. It returns io.EOF after the last row.
+func (s *indexScanner) next() error {
+	if s.frames != nil && s.frames.rows.Len() == 0 {
+		// The rows of the last frame have all been read.
+		if err := s.frames.load(); err != nil {
+			return err
+		}
+	}
+
+	var headerBuf [2]byte
+	if _, err := io.ReadFull(s.rdr, headerBuf[:]); err != nil {
+		if err == io.EOF {
+			return io.EOF
+		}
+		return fmt.Errorf("failed to read second level index row header at %d: %w", s.pos, err)
+	}
+
+	commonPrefixLen := int(headerBuf[0])
+	numRemainingChars := int(headerBuf[1])
+	if numRemainingChars == longKeySentinel {
+		return s.nextLong()
+	}
+	s.longKey = nil
+
+	if commonPrefixLen*2 > s.numKeyBytes {
+		return fmt.Errorf("%w: second level index row at %d reuses %d chars of a key with %d", ErrCorrupt, s.pos, commonPrefixLen, s.numKeyBytes/2)
+	}
+
+	// Read string and offset at once.
+	n := numRemainingChars*2 + s.offsetWidth
+	if _, err := io.ReadFull(s.rdr, s.buf[commonPrefixLen*2:][:n]); err != nil {
+		if err == io.EOF {
+			// Only the end of the header is the end of the index.
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("failed to read second level index key at %d: %w", s.pos, err)
+	}
+
+	s.numKeyBytes = (commonPrefixLen + numRemainingChars) * 2
+	s.advance(2 + n)
+	return nil
+}
+
+// nextLong reads the rest of a row whose key is in the long keys section.
+func (s *indexScanner) nextLong() error {
+	var rowBuf [4 + 8]byte
+	n := 4 + s.offsetWidth
+	if _, err := io.ReadFull(s.rdr, rowBuf[:n]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("failed to read second level index long key at %d: %w", s.pos, err)
+	}
+
+	i := binary.LittleEndian.Uint32(rowBuf[:])
+	if int64(i) >= int64(len(s.longKeys)) {
+		return fmt.Errorf("%w: second level index row at %d refers to long key %d, but there are %d", ErrCorrupt, s.pos, i, len(s.longKeys))
+	}
+	s.longKey = s.longKeys[i]
+
+	// The next row can reuse the start of the key, like with other keys.
+	s.numKeyBytes = copy(s.buf[:2*maxKeyLen], s.longKey)
+	copy(s.buf[s.numKeyBytes:], rowBuf[4:n])
+
+	s.advance(2 + n)
+	return nil
+}
+
+// advance moves pos past a row of n bytes which was just read.
+func (s *indexScanner) advance(n int) {
+	s.row++
+	if s.frames == nil {
+		s.pos += int64(n)
+	} else if s.frames.rows.Len() == 0 {
+		s.pos = s.frames.end
+	}
+}
+
+// keyBytes returns the key of the last row that was read in UTF-16LE.
+func (s *indexScanner) keyBytes() []byte {
+	if s.longKey != nil {
+		return s.longKey
+	}
+
+	return s.buf[:s.numKeyBytes]
+}
+
+// seek reads rows until one with a key that's >= chars, returning whether
+// there is one. Only the rows which start at or before end are read. That's
+// enough when end is the end of the bucket of chars, since the key of the
+// first row of the next bucket is after chars.
+func (s *indexScanner) seek(chars []uint16, end int64) (bool, error) {
+	for s.pos <= end {
+		err := s.next()
+		if errors.Is(err, io.EOF) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+
+		if s.compare(chars) >= 0 {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// compare compares the key of the last row that was read to chars.
+func (s *indexScanner) compare(chars []uint16) int {
+	return compareTo(s.keyBytes(), chars)
+}
+
+// result returns the last row that was read.
+func (s *indexScanner) result() SearchResult {
+	key := s.keyBytes()
+	chars := make([]uint16, 0, len(key)/2)
+	for i := 0; i < len(key); i += 2 {
+		chars = append(chars, binary.LittleEndian.Uint16(key[i:]))
+	}
+
+	return SearchResult{
+		Key:         string(utf16.Decode(chars)),
+		EntryOffset: int64(entryOffsetToUInt64(s.buf[:], s.numKeyBytes, s.offsetWidth)),
+		Redirect:    s.redirects != nil && s.redirects.isRedirect(s.row),
+	}
+}
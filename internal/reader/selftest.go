@@ -0,0 +1,132 @@
+package reader
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"slices"
+	"unicode/utf16"
+
+	"github.com/rsookram/wiki-builder/internal/storage"
+)
+
+// selfTestMaxSkip bounds how many rows into a bucket the keys checked by
+// SelfTest are, so that choosing one doesn't read much of the index.
+const selfTestMaxSkip = 64
+
+// SelfTest checks that the wiki file can be read, so that a broken file can be
+// found before serving it. It validates the first level index, and then looks
+// up n random keys and decompresses their entries, comparing them to their
+// hashes if the wiki was built with them. The error wraps ErrCorrupt if the
+// file doesn't match the format.
+func (w *Wiki) SelfTest(ctx context.Context, n int) error {
+	if err := w.checkFirstLevelIndex(); err != nil {
+		return err
+	}
+
+	for range n {
+		if err := w.checkRandomKey(ctx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkFirstLevelIndex checks that the buckets of the first level index are
+// sorted and within the second level index.
+func (w *Wiki) checkFirstLevelIndex() error {
+	offsets := w.first.offsets
+	if len(offsets) == 0 {
+		return fmt.Errorf("%w: the first level index is empty", ErrCorrupt)
+	}
+	if offsets[0] != 0 {
+		return fmt.Errorf("%w: the first bucket starts at %d instead of 0", ErrCorrupt, offsets[0])
+	}
+
+	for i := 1; i < len(offsets); i++ {
+		if offsets[i] <= offsets[i-1] {
+			return fmt.Errorf("%w: bucket %d starts at %d, which isn't after bucket %d at %d", ErrCorrupt, i, offsets[i], i-1, offsets[i-1])
+		}
+		if storage.CompareUTF16(w.first.key(i-1), w.first.key(i)) > 0 {
+			return fmt.Errorf("%w: the key of bucket %d is before the key of bucket %d", ErrCorrupt, i, i-1)
+		}
+	}
+
+	if last := int64(offsets[len(offsets)-1]); last >= w.secondLevelIndexLen {
+		return fmt.Errorf("%w: the last bucket starts at %d, past the end of the second level index at %d", ErrCorrupt, last, w.secondLevelIndexLen)
+	}
+
+	return nil
+}
+
+// checkRandomKey checks the first row of a random bucket, and then a key near
+// the start of it, which is looked up and whose entry is decompressed.
+func (w *Wiki) checkRandomKey(ctx context.Context) error {
+	i := rand.IntN(len(w.first.offsets))
+	start, end := int64(w.first.offsets[i]), w.secondLevelIndexLen
+	if i+1 < len(w.first.offsets) {
+		end = int64(w.first.offsets[i+1])
+	}
+
+	s := w.scan(ctx, start)
+	defer s.close()
+
+	if err := s.next(); err != nil {
+		return fmt.Errorf("failed to read the first row of bucket %d: %w", i, err)
+	}
+
+	// The key of the bucket is the start of its first key, padded with zeros.
+	first := utf16.Encode([]rune(s.result().Key))
+	bucketKey := make([]uint16, w.first.keyLen)
+	copy(bucketKey, storage.TruncateUTF16(first, w.first.keyLen))
+	if !slices.Equal(bucketKey, w.first.key(i)) {
+		return fmt.Errorf("%w: the first key of bucket %d (%q) doesn't start with the key of the bucket", ErrCorrupt, i, s.result().Key)
+	}
+
+	for skip := rand.IntN(selfTestMaxSkip); skip > 0 && s.pos < end; skip-- {
+		err := s.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read bucket %d: %w", i, err)
+		}
+	}
+	r := s.result()
+
+	offset, err := w.EntryOffsetContext(ctx, r.Key)
+	if err != nil {
+		return fmt.Errorf("failed to look up %q: %w", r.Key, err)
+	}
+	if offset != r.EntryOffset {
+		return fmt.Errorf("%w: looking up %q found the entry at %d instead of %d", ErrCorrupt, r.Key, offset, r.EntryOffset)
+	}
+
+	entry, err := w.EntryAtContext(ctx, offset)
+	if err != nil {
+		return fmt.Errorf("failed to read the entry of %q: %w", r.Key, err)
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, entry); err != nil {
+		return fmt.Errorf("%w: failed to decompress the entry of %q: %w", ErrCorrupt, r.Key, err)
+	}
+
+	if w.hashes == nil {
+		return nil
+	}
+	want, err := w.hashes.get(offset)
+	if err != nil {
+		return fmt.Errorf("failed to read the hash of %q: %w", r.Key, err)
+	}
+	if want != nil && !bytes.Equal(h.Sum(nil), want) {
+		return fmt.Errorf("%w: the entry of %q doesn't match its hash", ErrCorrupt, r.Key)
+	}
+
+	return nil
+}
@@ -0,0 +1,118 @@
+package reader
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// sizes reads the entry sizes section of a wiki file. It's safe for concurrent
+// use.
+type sizes struct {
+	r           io.ReaderAt
+	numRows     int
+	rowsOffset  int64
+	offsetWidth int
+}
+
+func openSizes(r io.ReaderAt, offset int64, size int64, offsetWidth int) (*sizes, error) {
+	var buf [4]byte
+	if _, err := r.ReadAt(buf[:], offset); err != nil {
+		return nil, fmt.Errorf("failed to read number of entry sizes: %w", err)
+	}
+
+	s := &sizes{
+		r:           r,
+		numRows:     int(binary.LittleEndian.Uint32(buf[:])),
+		rowsOffset:  offset + 4,
+		offsetWidth: offsetWidth,
+	}
+
+	if 4+int64(s.numRows)*int64(s.rowSize()) != size {
+		return nil, fmt.Errorf("%w: %d entry size rows don't fit in %d B", ErrCorrupt, s.numRows, size)
+	}
+
+	return s, nil
+}
+
+// withContext returns a copy of s which stops reading once ctx is done.
+func (s *sizes) withContext(ctx context.Context) *sizes {
+	c := *s
+	c.r = withContext(ctx, s.r)
+	return &c
+}
+
+func (s *sizes) rowSize() int {
+	return s.offsetWidth + 4
+}
+
+// row returns the entry offset and the uncompressed size of the ith row.
+func (s *sizes) row(i int) (int64, int64, error) {
+	buf := make([]byte, s.rowSize())
+	if _, err := s.r.ReadAt(buf, s.rowsOffset+int64(i)*int64(len(buf))); err != nil {
+		return 0, 0, fmt.Errorf("failed to read entry size row %d: %w", i, err)
+	}
+
+	offset := int64(entryOffsetToUInt64(buf, 0, s.offsetWidth))
+	size := int64(binary.LittleEndian.Uint32(buf[s.offsetWidth:]))
+	return offset, size, nil
+}
+
+// get returns the uncompressed size of the entry at offset, and whether it has
+// one.
+func (s *sizes) get(offset int64) (int64, bool, error) {
+	var err error
+	i := sort.Search(s.numRows, func(i int) bool {
+		if err != nil {
+			return true
+		}
+
+		var rowOffset int64
+		rowOffset, _, err = s.row(i)
+		return rowOffset >= offset
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	if i == s.numRows {
+		return 0, false, nil
+	}
+
+	rowOffset, size, err := s.row(i)
+	if err != nil {
+		return 0, false, err
+	}
+
+	return size, rowOffset == offset, nil
+}
+
+// EntrySize returns the size in bytes of the uncompressed contents of the entry
+// with the given name. It returns an error wrapping ErrNotFound if there isn't
+// an entry with the name, or if the wiki was built without sizes.
+func (w *Wiki) EntrySize(name string) (int64, error) {
+	offset, err := w.EntryOffset(name)
+	if err != nil {
+		return 0, err
+	}
+
+	return w.EntrySizeAt(offset)
+}
+
+// EntrySizeAt is like EntrySize, but for the entry at offset.
+func (w *Wiki) EntrySizeAt(offset int64) (int64, error) {
+	if w.sizes == nil {
+		return 0, fmt.Errorf("%w: the wiki was built without sizes", ErrNotFound)
+	}
+
+	size, found, err := w.sizes.get(offset)
+	if err != nil {
+		return 0, err
+	}
+	if !found {
+		return 0, fmt.Errorf("%w: no size for the entry at %d", ErrNotFound, offset)
+	}
+
+	return size, nil
+}
@@ -0,0 +1,122 @@
+package reader
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// snippets reads the snippets section of a wiki file. It's safe for
+// concurrent use.
+type snippets struct {
+	r           io.ReaderAt
+	numRows     int
+	rowsOffset  int64
+	textOffset  int64
+	textLen     int64
+	offsetWidth int
+}
+
+func openSnippets(r io.ReaderAt, offset int64, size int64, offsetWidth int) (*snippets, error) {
+	var buf [4]byte
+	if _, err := r.ReadAt(buf[:], offset); err != nil {
+		return nil, fmt.Errorf("failed to read number of snippets: %w", err)
+	}
+
+	s := &snippets{
+		r:           r,
+		numRows:     int(binary.LittleEndian.Uint32(buf[:])),
+		rowsOffset:  offset + 4,
+		offsetWidth: offsetWidth,
+	}
+
+	s.textOffset = s.rowsOffset + int64(s.numRows)*int64(s.rowSize())
+	s.textLen = offset + size - s.textOffset
+	if s.textLen < 0 {
+		return nil, fmt.Errorf("%w: %d snippet rows don't fit in %d B", ErrCorrupt, s.numRows, size)
+	}
+
+	return s, nil
+}
+
+// withContext returns a copy of s which stops reading once ctx is done.
+func (s *snippets) withContext(ctx context.Context) *snippets {
+	c := *s
+	c.r = withContext(ctx, s.r)
+	return &c
+}
+
+func (s *snippets) rowSize() int {
+	return s.offsetWidth + 4
+}
+
+// row returns the entry offset, and the end of the snippet of the ith row.
+func (s *snippets) row(i int) (int64, int64, error) {
+	buf := make([]byte, s.rowSize())
+	if _, err := s.r.ReadAt(buf, s.rowsOffset+int64(i)*int64(len(buf))); err != nil {
+		return 0, 0, fmt.Errorf("failed to read snippet row %d: %w", i, err)
+	}
+
+	offset := int64(entryOffsetToUInt64(buf, 0, s.offsetWidth))
+	end := int64(binary.LittleEndian.Uint32(buf[s.offsetWidth:]))
+	return offset, end, nil
+}
+
+// get returns the snippet of the entry at offset, or an empty string if it
+// doesn't have one.
+func (s *snippets) get(offset int64) (string, error) {
+	var err error
+	i := sort.Search(s.numRows, func(i int) bool {
+		if err != nil {
+			return true
+		}
+
+		var rowOffset int64
+		rowOffset, _, err = s.row(i)
+		return rowOffset >= offset
+	})
+	if err != nil {
+		return "", err
+	}
+	if i == s.numRows {
+		return "", nil
+	}
+
+	rowOffset, end, err := s.row(i)
+	if err != nil {
+		return "", err
+	}
+	if rowOffset != offset {
+		return "", nil
+	}
+
+	start := int64(0)
+	if i > 0 {
+		if _, start, err = s.row(i - 1); err != nil {
+			return "", err
+		}
+	}
+
+	if start > end || end > s.textLen {
+		return "", fmt.Errorf("%w: snippet for %d is out of range", ErrCorrupt, offset)
+	}
+
+	b := make([]byte, end-start)
+	if _, err := s.r.ReadAt(b, s.textOffset+start); err != nil {
+		return "", fmt.Errorf("failed to read snippet for %d: %w", offset, err)
+	}
+
+	return string(b), nil
+}
+
+// EntrySnippetAt returns the snippet of the entry at offset, or an empty string
+// if the wiki was built without snippets or the entry doesn't have one.
+func (w *Wiki) EntrySnippetAt(offset int64) (string, error) {
+	if w.snippets == nil {
+		return "", nil
+	}
+
+	return w.snippets.get(offset)
+}
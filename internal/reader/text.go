@@ -0,0 +1,50 @@
+package reader
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/rsookram/wiki-builder/internal/plaintext"
+	"github.com/rsookram/wiki-builder/internal/storage"
+)
+
+// EntryText returns the contents of the entry with the given name as plain
+// text. See EntryTextAt.
+func (w *Wiki) EntryText(name string) (string, error) {
+	offset, err := w.EntryOffset(name)
+	if err != nil {
+		return "", err
+	}
+
+	return w.EntryTextAt(offset)
+}
+
+// EntryTextAt returns the contents of the entry at offset as plain text. HTML
+// is converted with plaintext.Extract and formatted with plaintext.Format,
+// other text is returned as is, and the error wraps ErrNotText for entries
+// which aren't text (e.g. images).
+func (w *Wiki) EntryTextAt(offset int64) (string, error) {
+	contentType := w.ContentType(offset)
+	isHTML := storage.IsHTML(contentType)
+	if !isHTML && !strings.HasPrefix(contentType, "text/") {
+		return "", fmt.Errorf("%w: the entry at %d is %s", ErrNotText, offset, contentType)
+	}
+
+	rdr, err := w.EntryAt(offset)
+	if err != nil {
+		return "", err
+	}
+
+	if !isHTML {
+		b, err := io.ReadAll(rdr)
+		return string(b), err
+	}
+
+	paragraphs, err := plaintext.Extract(rdr)
+	if err != nil {
+		return "", err
+	}
+
+	return plaintext.Format(paragraphs), nil
+}
@@ -0,0 +1,80 @@
+package reader
+
+import (
+	"context"
+	"io"
+)
+
+// Tracer starts spans for the steps of reading a wiki (seeking in the index,
+// scanning a bucket, and decompressing an entry), so that they can be timed.
+// It has the same shape as an OpenTelemetry tracer, which can be adapted to it
+// by passing on ctx and name.
+type Tracer interface {
+	// Start starts a span named name as a child of the span in ctx (if any),
+	// returning a context containing the new span.
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a step started by a Tracer.
+type Span interface {
+	End()
+}
+
+// The names of the spans started by the reader.
+const (
+	SpanQuery      = "reader.query"
+	SpanSeek       = "reader.seek"
+	SpanScan       = "reader.scan"
+	SpanDecompress = "reader.decompress"
+)
+
+// SetTracer makes the reader start spans with t. It must be called before the
+// wiki is used.
+func (w *Wiki) SetTracer(t Tracer) {
+	w.tracer = t
+	if w.translit != nil {
+		w.translit.tracer = t
+	}
+	if w.words != nil {
+		w.words.tracer = t
+	}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) End() {}
+
+// startSpan starts a span named name with the tracer of w, if it has one.
+func (w *Wiki) startSpan(ctx context.Context, name string) (context.Context, Span) {
+	if w.tracer == nil {
+		return ctx, noopSpan{}
+	}
+
+	return w.tracer.Start(ctx, name)
+}
+
+// spanReader ends span once r is read to the end, or fails. It also closes r
+// then, so that the decompressor can be reused for another entry.
+type spanReader struct {
+	r    io.ReadCloser
+	span Span
+	// err is the error that r failed with (e.g. io.EOF), which is returned
+	// from every Read after r is closed.
+	err error
+}
+
+func (s *spanReader) Read(p []byte) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+
+	n, err := s.r.Read(p)
+	if err != nil {
+		s.err = err
+		s.span.End()
+		s.r.Close()
+		s.r = nil
+	}
+
+	return n, err
+}
@@ -0,0 +1,205 @@
+package reader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"math/rand/v2"
+	"runtime"
+	"slices"
+	"sync"
+	"time"
+)
+
+// The limits of the settings picked by ReadProfile.Settings.
+const (
+	minScanBufferSize = DefaultScanBufferSize
+	maxScanBufferSize = 1024 * 1024
+	maxTunedPrefetch  = 16
+)
+
+// The sizes of the reads done by MeasureReads.
+const (
+	// seekReadSize is the size of the reads at random offsets, which is
+	// small enough that their time is mostly spent seeking.
+	seekReadSize = 4 * 1024
+	// sequentialReadSize is the size of the read which measures throughput.
+	sequentialReadSize = 4 * 1024 * 1024
+)
+
+// ReadProfile is how fast a wiki can be read, as measured by MeasureReads.
+type ReadProfile struct {
+	// Latency is the median time to read a few KB at a random offset in the
+	// wiki file.
+	Latency time.Duration
+	// Throughput is how many bytes per second are read from the wiki file
+	// sequentially.
+	Throughput float64
+	// EntryRead is the median time to read the compressed bytes of an entry.
+	EntryRead time.Duration
+	// Decompress is the median time to decompress an entry, with an entry
+	// being decompressed on each CPU at the same time (like when serving
+	// requests).
+	Decompress time.Duration
+}
+
+// ReadSettings are the sizes to read a wiki with, as picked by
+// ReadProfile.Settings.
+type ReadSettings struct {
+	// ScanBufferSize is passed to SetScanBufferSize.
+	ScanBufferSize int
+	// Prefetch is passed to SetPrefetch.
+	Prefetch int
+}
+
+// MeasureReads times reads of the wiki file at random offsets, a sequential
+// read, and reading and decompressing n random entries, so that the settings
+// can be picked for the storage that it's on. The file may already be in the
+// page cache, in which case the reads are as fast as the memory.
+func (w *Wiki) MeasureReads(ctx context.Context, n int) (ReadProfile, error) {
+	var p ReadProfile
+
+	info, err := w.file.Stat()
+	if err != nil {
+		return p, err
+	}
+	file := withContext(ctx, w.file)
+
+	buf := make([]byte, sequentialReadSize)
+	latencies := make([]time.Duration, n)
+	for i := range latencies {
+		offset := rand.Int64N(max(1, info.Size()-seekReadSize))
+		start := time.Now()
+		if _, err := file.ReadAt(buf[:seekReadSize], offset); err != nil && !errors.Is(err, io.EOF) {
+			return p, fmt.Errorf("failed to read at %d: %w", offset, err)
+		}
+		latencies[i] = time.Since(start)
+	}
+	p.Latency = median(latencies)
+
+	// The sequential read starts at a random offset too, so that it isn't of
+	// what was just read.
+	offset := rand.Int64N(max(1, info.Size()-sequentialReadSize))
+	start := time.Now()
+	read, err := file.ReadAt(buf, offset)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return p, fmt.Errorf("failed to read at %d: %w", offset, err)
+	}
+	if elapsed := time.Since(start); elapsed > 0 {
+		p.Throughput = float64(read) / elapsed.Seconds()
+	}
+
+	entries, reads, err := w.readRandomEntries(ctx, n)
+	if err != nil {
+		return p, err
+	}
+	p.EntryRead = median(reads)
+
+	p.Decompress, err = w.measureDecompress(entries)
+	if err != nil {
+		return p, err
+	}
+
+	return p, nil
+}
+
+// readRandomEntries reads the compressed bytes of the first entry in n random
+// buckets, returning them along with how long each took to read.
+func (w *Wiki) readRandomEntries(ctx context.Context, n int) ([][]byte, []time.Duration, error) {
+	entries := make([][]byte, 0, n)
+	times := make([]time.Duration, 0, n)
+	for range n {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+
+		i := rand.IntN(len(w.first.offsets))
+		s := w.scan(ctx, int64(w.first.offsets[i]))
+		err := s.next()
+		offset := s.result().EntryOffset
+		s.close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read the first row of bucket %d: %w", i, err)
+		}
+
+		start := time.Now()
+		b, err := w.readCompressed(ctx, offset)
+		if err != nil {
+			return nil, nil, err
+		}
+		times = append(times, time.Since(start))
+		entries = append(entries, b)
+	}
+
+	return entries, times, nil
+}
+
+// measureDecompress returns the median time to decompress entries, which are
+// decompressed in parallel by a goroutine for each CPU.
+func (w *Wiki) measureDecompress(entries [][]byte) (time.Duration, error) {
+	times := make([]time.Duration, len(entries))
+	errs := make([]error, len(entries))
+
+	var wg sync.WaitGroup
+	next := make(chan int)
+	for range runtime.GOMAXPROCS(0) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				start := time.Now()
+				r, err := w.compressor.NewReader(bytes.NewReader(entries[i]))
+				if err == nil {
+					_, err = io.Copy(io.Discard, r)
+					r.Close()
+				}
+				times[i] = time.Since(start)
+				errs[i] = err
+			}
+		}()
+	}
+	for i := range entries {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		return 0, fmt.Errorf("%w: failed to decompress an entry: %w", ErrCorrupt, err)
+	}
+
+	return median(times), nil
+}
+
+func median(times []time.Duration) time.Duration {
+	if len(times) == 0 {
+		return 0
+	}
+
+	times = slices.Clone(times)
+	slices.Sort(times)
+	return times[len(times)/2]
+}
+
+// Settings picks the settings to read a wiki on storage with p.
+func (p ReadProfile) Settings() ReadSettings {
+	// A buffer which takes as long to fill as a seek takes keeps the time
+	// spent seeking to about half of the time spent scanning, without
+	// reading much past the rows which are needed.
+	size := int(p.Throughput * p.Latency.Seconds())
+	size = min(max(size, minScanBufferSize), maxScanBufferSize)
+	// Round up to a power of two.
+	size = 1 << bits.Len(uint(size-1))
+
+	// Reading ahead only helps when an entry takes longer to read than to
+	// decompress, and then enough are read ahead to hide the wait.
+	prefetch := 0
+	if p.Decompress > 0 && p.EntryRead > p.Decompress {
+		prefetch = min(int((p.EntryRead+p.Decompress-1)/p.Decompress), maxTunedPrefetch)
+	}
+
+	return ReadSettings{ScanBufferSize: size, Prefetch: prefetch}
+}
@@ -0,0 +1,1165 @@
+// Package reader reads the file format written by wiki-builder.
+package reader
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/rsookram/wiki-builder/internal/bloom"
+	"github.com/rsookram/wiki-builder/internal/compression"
+	"github.com/rsookram/wiki-builder/internal/storage"
+	"github.com/rsookram/wiki-builder/internal/translit"
+	"github.com/rsookram/wiki-builder/internal/words"
+)
+
+// The types of the optional fields in the header.
+const (
+	headerFieldEntriesFile     = 1
+	headerFieldSnippetsLen     = 2
+	headerFieldContentTypesLen = 3
+	headerFieldTranslitLen     = 4
+	headerFieldHashesLen       = 5
+	headerFieldBuildID         = 6
+	headerFieldSource          = 7
+	headerFieldBuildTime       = 8
+	headerFieldToolVersion     = 9
+	headerFieldFormat          = 10
+	headerFieldWordsLen        = 11
+	headerFieldBloomLen        = 12
+	headerFieldLongKeysLen     = 13
+	headerFieldSizesLen        = 14
+	headerFieldLanguagesLen    = 15
+	headerFieldPopularityLen   = 16
+	headerFieldMainPage        = 17
+	headerFieldAnchorsLen      = 18
+	headerFieldCompression     = 19
+	headerFieldWordCountsLen   = 20
+	headerFieldPageRanksLen    = 21
+	headerFieldIndexFormat     = 22
+	headerFieldRedirectsLen    = 23
+)
+
+// indexFormatZstdBuckets is the value of the index format header field for
+// second level indexes whose buckets are zstd frames.
+const indexFormatZstdBuckets = 1
+
+// formatMagic starts the value of the format header field, which is followed
+// by the version of the format.
+const formatMagic = "WIKI"
+
+// formatVersion is the newest version of the format that can be read.
+const formatVersion = 4
+
+// Wiki is an open wiki file. Its methods are safe for concurrent use, apart
+// from SetPrefetch, SetScanBufferSize, SetTracer, and Close.
+type Wiki struct {
+	first firstLevelIndex
+	// secondLevelIndexStart is where the rows of the second level index start
+	// in file.
+	secondLevelIndexStart int64
+	// secondLevelIndexLen is the number of bytes used by the rows of the second
+	// level index (excluding its length).
+	secondLevelIndexLen int64
+	// compressedBuckets is whether each bucket of the second level index is a
+	// zstd frame, in which case the offsets of the first level index and
+	// secondLevelIndexLen are of the frames rather than the rows.
+	compressedBuckets bool
+
+	// entriesOffset is where the entries start in entries. It's after the
+	// header when the entries are in the same file as the indexes, and 0 when
+	// they're in a separate file.
+	entriesOffset int64
+	// entriesLen is the number of bytes used by the entries.
+	entriesLen  int64
+	offsetWidth int
+
+	// snippets is nil unless the wiki was built with snippets.
+	snippets *snippets
+	// contentTypes is nil unless the wiki has entries which aren't HTML.
+	contentTypes *contentTypes
+	// hashes is nil unless the wiki was built with hashes.
+	hashes *hashes
+	// sizes is nil unless the wiki was built with entry sizes.
+	sizes *sizes
+	// languages is nil unless the wiki was built with languages.
+	languages *languages
+	// popularity is nil unless the wiki was built with weights for entries.
+	popularity *popularity
+	// anchors is nil unless the wiki was built with anchors. It has the same
+	// layout as snippets, with the anchors of each entry separated by
+	// anchor.Separator.
+	anchors *snippets
+	// wordCounts is nil unless the wiki was built with word counts. It has the
+	// same layout as sizes, with the number of words in each entry.
+	wordCounts *sizes
+	// pageRanks is nil unless the wiki was built with page ranks. It has the
+	// same layout as sizes, with the PageRank of each entry.
+	pageRanks *sizes
+
+	buildInfo BuildInfo
+	// mainPage is the key of the landing page, or empty if there isn't one.
+	mainPage string
+	// compressionID identifies compressor, which decompresses the entries.
+	compressionID compression.ID
+	compressor    compression.Compressor
+	// translit is the transliteration index, which is nil unless the wiki was
+	// built with one. Its keys are a spelling and a key of w, separated by
+	// translit.Separator.
+	translit *Wiki
+	// words is the word index, which is nil unless the wiki was built with
+	// one. Its keys are the part of a key of w starting at one of its words,
+	// and the key, separated by words.Separator.
+	words *Wiki
+	// bloom is a bloom filter over the keys, which is nil unless the wiki was
+	// built with one.
+	bloom *bloom.Filter
+	// redirects tells which keys are from redirects. It's nil unless the wiki
+	// was built with them.
+	redirects *redirectFlags
+	// longKeys are the keys which are too long to be stored in the second
+	// level index, in UTF-16LE. It's nil unless the wiki has any.
+	longKeys [][]byte
+
+	// tracer is nil unless tracing is enabled.
+	tracer Tracer
+
+	// cache is nil unless prefetching is enabled.
+	cache *entryCache
+	// scanBufferSize is the size of the buffer that the second level index is
+	// read with, or 0 for DefaultScanBufferSize.
+	scanBufferSize int
+
+	file    *os.File
+	entries io.ReaderAt
+	// remote is whether entries is read over the network, where each read is
+	// a round trip.
+	remote bool
+}
+
+// OpenWiki opens the wiki file at path and reads its header and first level
+// index. If the header references a separate entries file, it's opened from
+// the same directory as path, or fetched with Range requests if it's an
+// HTTP(S) URL.
+func OpenWiki(path string) (Wiki, error) {
+	return OpenWikiWithEntries(path, "")
+}
+
+// OpenWikiWithEntries is like OpenWiki, but reads the entries from the file or
+// HTTP(S) URL at entries instead of the one in the header. An empty entries
+// uses the one in the header.
+func OpenWikiWithEntries(path string, entries string) (Wiki, error) {
+	var wiki Wiki
+
+	f, err := os.Open(path)
+	if err != nil {
+		return wiki, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	wiki.file = f
+
+	var sizeBuf [2]byte
+	_, err = io.ReadFull(f, sizeBuf[:])
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return wiki, fmt.Errorf("%w: %s is too small", ErrNotWiki, path)
+	}
+	if err != nil {
+		return wiki, fmt.Errorf("failed to read header size: %w", err)
+	}
+
+	headerSize := binary.LittleEndian.Uint16(sizeBuf[:])
+	if headerSize < 4 {
+		return wiki, fmt.Errorf("%w: header is too small: %d B", ErrNotWiki, headerSize)
+	}
+
+	buf := make([]byte, headerSize-2)
+	_, err = io.ReadFull(f, buf)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return wiki, fmt.Errorf("%w: file truncated in header", ErrNotWiki)
+	}
+	if err != nil {
+		return wiki, fmt.Errorf("failed to read header: %w", err)
+	}
+
+	wiki.offsetWidth = int(buf[0])
+	if wiki.offsetWidth < 5 || wiki.offsetWidth > 8 {
+		return wiki, fmt.Errorf("%w: unsupported entry offset width: %d", ErrNotWiki, wiki.offsetWidth)
+	}
+	wiki.entriesOffset = int64(headerSize)
+	wiki.entries = f
+
+	firstLevelKeyLen := uint16(buf[1])
+	if firstLevelKeyLen == 0 || firstLevelKeyLen > 8 {
+		return wiki, fmt.Errorf("%w: invalid first level key length: %d", ErrNotWiki, firstLevelKeyLen)
+	}
+
+	var entriesName string
+	var snippetsLen int64
+	var contentTypesLen int64
+	var translitLen int64
+	var hashesLen int64
+	var wordsLen int64
+	var bloomLen int64
+	var longKeysLen int64
+	var sizesLen int64
+	var languagesLen int64
+	var popularityLen int64
+	var anchorsLen int64
+	var wordCountsLen int64
+	var pageRanksLen int64
+	var redirectsLen int64
+	fields := buf[2 : headerSize-2]
+	for len(fields) > 0 {
+		if len(fields) < 2 || 2+int(fields[1]) > len(fields) {
+			return wiki, fmt.Errorf("%w: header field extends past the header", ErrCorrupt)
+		}
+		value := fields[2:][:fields[1]]
+
+		switch fields[0] {
+		case headerFieldEntriesFile:
+			entriesName = string(value)
+		case headerFieldSnippetsLen:
+			if len(value) != 8 {
+				return wiki, fmt.Errorf("%w: invalid snippets length field", ErrCorrupt)
+			}
+			snippetsLen = int64(binary.LittleEndian.Uint64(value))
+		case headerFieldContentTypesLen:
+			if len(value) != 8 {
+				return wiki, fmt.Errorf("%w: invalid content types length field", ErrCorrupt)
+			}
+			contentTypesLen = int64(binary.LittleEndian.Uint64(value))
+		case headerFieldTranslitLen:
+			if len(value) != 8 {
+				return wiki, fmt.Errorf("%w: invalid transliteration index length field", ErrCorrupt)
+			}
+			translitLen = int64(binary.LittleEndian.Uint64(value))
+		case headerFieldHashesLen:
+			if len(value) != 8 {
+				return wiki, fmt.Errorf("%w: invalid hashes length field", ErrCorrupt)
+			}
+			hashesLen = int64(binary.LittleEndian.Uint64(value))
+		case headerFieldWordsLen:
+			if len(value) != 8 {
+				return wiki, fmt.Errorf("%w: invalid word index length field", ErrCorrupt)
+			}
+			wordsLen = int64(binary.LittleEndian.Uint64(value))
+		case headerFieldBloomLen:
+			if len(value) != 8 {
+				return wiki, fmt.Errorf("%w: invalid bloom filter length field", ErrCorrupt)
+			}
+			bloomLen = int64(binary.LittleEndian.Uint64(value))
+		case headerFieldLongKeysLen:
+			if len(value) != 8 {
+				return wiki, fmt.Errorf("%w: invalid long keys length field", ErrCorrupt)
+			}
+			longKeysLen = int64(binary.LittleEndian.Uint64(value))
+		case headerFieldSizesLen:
+			if len(value) != 8 {
+				return wiki, fmt.Errorf("%w: invalid entry sizes length field", ErrCorrupt)
+			}
+			sizesLen = int64(binary.LittleEndian.Uint64(value))
+		case headerFieldLanguagesLen:
+			if len(value) != 8 {
+				return wiki, fmt.Errorf("%w: invalid languages length field", ErrCorrupt)
+			}
+			languagesLen = int64(binary.LittleEndian.Uint64(value))
+		case headerFieldPopularityLen:
+			if len(value) != 8 {
+				return wiki, fmt.Errorf("%w: invalid popularity length field", ErrCorrupt)
+			}
+			popularityLen = int64(binary.LittleEndian.Uint64(value))
+		case headerFieldAnchorsLen:
+			if len(value) != 8 {
+				return wiki, fmt.Errorf("%w: invalid anchors length field", ErrCorrupt)
+			}
+			anchorsLen = int64(binary.LittleEndian.Uint64(value))
+		case headerFieldWordCountsLen:
+			if len(value) != 8 {
+				return wiki, fmt.Errorf("%w: invalid word counts length field", ErrCorrupt)
+			}
+			wordCountsLen = int64(binary.LittleEndian.Uint64(value))
+		case headerFieldPageRanksLen:
+			if len(value) != 8 {
+				return wiki, fmt.Errorf("%w: invalid page ranks length field", ErrCorrupt)
+			}
+			pageRanksLen = int64(binary.LittleEndian.Uint64(value))
+		case headerFieldRedirectsLen:
+			if len(value) != 8 {
+				return wiki, fmt.Errorf("%w: invalid redirects length field", ErrCorrupt)
+			}
+			redirectsLen = int64(binary.LittleEndian.Uint64(value))
+		case headerFieldBuildID:
+			if len(value) != 16 {
+				return wiki, fmt.Errorf("%w: invalid build ID field", ErrCorrupt)
+			}
+			wiki.buildInfo.ID = formatUUID(value)
+		case headerFieldSource:
+			wiki.buildInfo.Source = string(value)
+		case headerFieldBuildTime:
+			if len(value) != 8 {
+				return wiki, fmt.Errorf("%w: invalid build time field", ErrCorrupt)
+			}
+			wiki.buildInfo.Time = time.Unix(int64(binary.LittleEndian.Uint64(value)), 0).UTC()
+		case headerFieldMainPage:
+			wiki.mainPage = string(value)
+		case headerFieldCompression:
+			if len(value) != 1 {
+				return wiki, fmt.Errorf("%w: invalid compression field", ErrCorrupt)
+			}
+			wiki.compressionID = compression.ID(value[0])
+		case headerFieldIndexFormat:
+			if len(value) != 1 {
+				return wiki, fmt.Errorf("%w: invalid index format field", ErrCorrupt)
+			}
+			if value[0] != indexFormatZstdBuckets {
+				return wiki, fmt.Errorf("%w: unknown index format: %d", ErrUnsupportedVersion, value[0])
+			}
+			wiki.compressedBuckets = true
+		case headerFieldToolVersion:
+			wiki.buildInfo.ToolVersion = string(value)
+		case headerFieldFormat:
+			if len(value) != len(formatMagic)+1 || string(value[:len(formatMagic)]) != formatMagic {
+				return wiki, fmt.Errorf("%w: invalid format field", ErrNotWiki)
+			}
+			if v := value[len(formatMagic)]; v > formatVersion {
+				return wiki, fmt.Errorf("%w: the file is version %d, but only up to version %d can be read", ErrUnsupportedVersion, v, formatVersion)
+			}
+		}
+
+		fields = fields[2+len(value):]
+	}
+
+	wiki.compressor, err = compression.Lookup(wiki.compressionID)
+	if err != nil {
+		return wiki, fmt.Errorf("%w: %w", ErrUnsupportedVersion, err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return wiki, fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+
+	sectionsLen := contentTypesLen + snippetsLen + translitLen + hashesLen + wordsLen + bloomLen + longKeysLen + sizesLen + languagesLen + popularityLen + anchorsLen + wordCountsLen + pageRanksLen + redirectsLen
+	if contentTypesLen < 0 || snippetsLen < 0 || translitLen < 0 || hashesLen < 0 || wordsLen < 0 || bloomLen < 0 || longKeysLen < 0 || sizesLen < 0 || languagesLen < 0 || popularityLen < 0 || anchorsLen < 0 || wordCountsLen < 0 || pageRanksLen < 0 || redirectsLen < 0 || int64(headerSize)+sectionsLen > info.Size() {
+		return wiki, fmt.Errorf("%w: file truncated: the sections in the header need %d B, but the file is %d B", ErrCorrupt, int64(headerSize)+sectionsLen, info.Size())
+	}
+
+	if err := wiki.readIndexes(int64(headerSize)+sectionsLen, info.Size(), int(firstLevelKeyLen)); err != nil {
+		return wiki, err
+	}
+
+	// The sections before the indexes are in the order: content types,
+	// snippets, transliteration index, hashes, word index, bloom filter, long
+	// keys, entry sizes, languages, popularity, anchors, word counts, page
+	// ranks, and redirects.
+	redirectsStart := wiki.secondLevelIndexStart - redirectsLen
+	if redirectsLen > 0 {
+		wiki.redirects, err = readRedirectFlags(f, redirectsStart, redirectsLen, len(wiki.first.offsets))
+		if err != nil {
+			return wiki, err
+		}
+	}
+
+	pageRanksStart := redirectsStart - pageRanksLen
+	if pageRanksLen > 0 {
+		wiki.pageRanks, err = openSizes(f, pageRanksStart, pageRanksLen, wiki.offsetWidth)
+		if err != nil {
+			return wiki, err
+		}
+	}
+
+	wordCountsStart := pageRanksStart - wordCountsLen
+	if wordCountsLen > 0 {
+		wiki.wordCounts, err = openSizes(f, wordCountsStart, wordCountsLen, wiki.offsetWidth)
+		if err != nil {
+			return wiki, err
+		}
+	}
+
+	anchorsStart := wordCountsStart - anchorsLen
+	if anchorsLen > 0 {
+		wiki.anchors, err = openSnippets(f, anchorsStart, anchorsLen, wiki.offsetWidth)
+		if err != nil {
+			return wiki, err
+		}
+	}
+
+	popularityStart := anchorsStart - popularityLen
+	if popularityLen > 0 {
+		wiki.popularity, err = openPopularity(f, popularityStart, popularityLen, wiki.offsetWidth)
+		if err != nil {
+			return wiki, err
+		}
+	}
+
+	languagesStart := popularityStart - languagesLen
+	if languagesLen > 0 {
+		wiki.languages, err = openLanguages(f, languagesStart, languagesLen, wiki.offsetWidth)
+		if err != nil {
+			return wiki, err
+		}
+	}
+
+	sizesStart := languagesStart - sizesLen
+	if sizesLen > 0 {
+		wiki.sizes, err = openSizes(f, sizesStart, sizesLen, wiki.offsetWidth)
+		if err != nil {
+			return wiki, err
+		}
+	}
+
+	longKeysStart := sizesStart - longKeysLen
+	if longKeysLen > 0 {
+		wiki.longKeys, err = readLongKeys(f, longKeysStart, longKeysLen)
+		if err != nil {
+			return wiki, err
+		}
+	}
+
+	// The first keys of split buckets can be long keys.
+	if err := wiki.readSplitBuckets(); err != nil {
+		return wiki, err
+	}
+
+	bloomStart := longKeysStart - bloomLen
+	if bloomLen > 0 {
+		wiki.bloom, err = readBloomFilter(f, bloomStart, bloomLen)
+		if err != nil {
+			return wiki, err
+		}
+	}
+
+	wordsStart := bloomStart - wordsLen
+	if wordsLen > 0 {
+		wiki.words = &Wiki{
+			offsetWidth: wiki.offsetWidth,
+			file:        f,
+		}
+		err := wiki.words.readIndexes(wordsStart, bloomStart, int(firstLevelKeyLen))
+		if err == nil {
+			err = wiki.words.readSplitBuckets()
+		}
+		if err != nil {
+			return wiki, fmt.Errorf("failed to read word index: %w", err)
+		}
+	}
+
+	hashesStart := wordsStart - hashesLen
+	if hashesLen > 0 {
+		wiki.hashes, err = openHashes(f, hashesStart, hashesLen, wiki.offsetWidth)
+		if err != nil {
+			return wiki, err
+		}
+	}
+
+	if translitLen > 0 {
+		wiki.translit = &Wiki{
+			offsetWidth: wiki.offsetWidth,
+			file:        f,
+		}
+		err := wiki.translit.readIndexes(hashesStart-translitLen, hashesStart, int(firstLevelKeyLen))
+		if err == nil {
+			err = wiki.translit.readSplitBuckets()
+		}
+		if err != nil {
+			return wiki, fmt.Errorf("failed to read transliteration index: %w", err)
+		}
+	}
+
+	snippetsStart := hashesStart - translitLen - snippetsLen
+	if snippetsLen > 0 {
+		wiki.snippets, err = openSnippets(f, snippetsStart, snippetsLen, wiki.offsetWidth)
+		if err != nil {
+			return wiki, err
+		}
+	}
+	contentTypesStart := snippetsStart - contentTypesLen
+	if contentTypesLen > 0 {
+		wiki.contentTypes, err = readContentTypes(f, contentTypesStart, contentTypesLen, wiki.offsetWidth)
+		if err != nil {
+			return wiki, err
+		}
+	}
+	wiki.entriesLen = contentTypesStart - wiki.entriesOffset
+
+	if entries == "" && entriesName != "" {
+		entries = entriesName
+		if !isURL(entries) {
+			entries = filepath.Join(filepath.Dir(path), filepath.FromSlash(entries))
+		}
+	}
+
+	if entries != "" {
+		wiki.entriesOffset = 0
+
+		if isURL(entries) {
+			e, err := openHTTPEntries(entries)
+			if err != nil {
+				return wiki, fmt.Errorf("failed to open entries: %w", err)
+			}
+
+			wiki.entries = e
+			wiki.entriesLen = e.size
+			wiki.remote = true
+			return wiki, nil
+		}
+
+		entriesFile, err := os.Open(entries)
+		if err != nil {
+			return wiki, fmt.Errorf("failed to open entries file %s: %w", entries, err)
+		}
+
+		info, err := entriesFile.Stat()
+		if err != nil {
+			return wiki, fmt.Errorf("failed to stat %s: %w", entries, err)
+		}
+
+		wiki.entries = entriesFile
+		wiki.entriesLen = info.Size()
+	}
+
+	return wiki, nil
+}
+
+// readIndexes reads the sizes of the second level index and first level index
+// which are between start and end in w.file, and decodes the first level
+// index.
+func (w *Wiki) readIndexes(start, end int64, firstLevelKeyLen int) error {
+	// The smallest indexes have the sizes of both levels, and one first level
+	// row.
+	firstLevelIndexRowSize := uint16(firstLevelKeyLen*2 + 4)
+	if end-start < 4+2+int64(firstLevelIndexRowSize) {
+		return fmt.Errorf("%w: file truncated at index: there are only %d B for it", ErrCorrupt, end-start)
+	}
+
+	var sizeBuf [4]byte
+	if _, err := w.file.ReadAt(sizeBuf[:2], end-2); err != nil {
+		return fmt.Errorf("failed to read first level index size: %w", err)
+	}
+
+	firstLevelIndexSize := binary.LittleEndian.Uint16(sizeBuf[:])
+	if firstLevelIndexSize < 2+firstLevelIndexRowSize || (firstLevelIndexSize-2)%firstLevelIndexRowSize != 0 {
+		return fmt.Errorf("%w: invalid first level index size: %d B (the file may be truncated)", ErrCorrupt, firstLevelIndexSize)
+	}
+	numFirstLevelIndexEntries := (firstLevelIndexSize - 2) / firstLevelIndexRowSize
+
+	firstLevelIndexStart := end - int64(firstLevelIndexSize)
+	if firstLevelIndexStart-4 < start {
+		return fmt.Errorf("%w: file truncated at index: the first level index is %d B, but there are only %d B for the indexes", ErrCorrupt, firstLevelIndexSize, end-start)
+	}
+	if _, err := w.file.ReadAt(sizeBuf[:], firstLevelIndexStart-4); err != nil {
+		return fmt.Errorf("failed to read second level index size: %w", err)
+	}
+
+	secondLevelIndexSize := binary.LittleEndian.Uint32(sizeBuf[:])
+	if secondLevelIndexSize < 4 || firstLevelIndexStart-int64(secondLevelIndexSize) < start {
+		return fmt.Errorf("%w: file truncated at index: the second level index is %d B, but there are only %d B for it", ErrCorrupt, secondLevelIndexSize, firstLevelIndexStart-start)
+	}
+
+	r := io.NewSectionReader(w.file, firstLevelIndexStart, int64(firstLevelIndexSize))
+	firstLevelIndex, err := decodeFirstLevelIndex(r, numFirstLevelIndexEntries, firstLevelKeyLen)
+	if err != nil {
+		return fmt.Errorf("failed to decode first level index: %w", err)
+	}
+
+	prev := uint32(0)
+	for _, offset := range firstLevelIndex.offsets {
+		if offset < prev || offset > secondLevelIndexSize-4 {
+			return fmt.Errorf("%w: first level index offset %d is out of order or past the second level index", ErrCorrupt, offset)
+		}
+		prev = offset
+	}
+
+	w.first = firstLevelIndex
+	w.secondLevelIndexStart = firstLevelIndexStart - int64(secondLevelIndexSize)
+	w.secondLevelIndexLen = int64(secondLevelIndexSize) - 4
+
+	return nil
+}
+
+// Close stops reading ahead, and closes the wiki file, and the entries file if
+// it's separate. w can't be used afterwards.
+func (w *Wiki) Close() error {
+	if w.cache != nil {
+		w.cache.stop()
+	}
+
+	err := w.file.Close()
+	if c, ok := w.entries.(io.Closer); ok && w.entries != w.file {
+		err = errors.Join(err, c.Close())
+	}
+
+	return err
+}
+
+type SearchResult struct {
+	Key         string
+	EntryOffset int64
+	// Redirect is whether the key is from a redirect rather than the name of
+	// an entry. It's only set for keys read from the main index (e.g. by Keys
+	// and IsRedirect), and only if the wiki has redirect flags.
+	Redirect bool
+	// Snippet is the start of the text of the entry. It's only set by Query,
+	// and is empty unless the wiki was built with snippets.
+	Snippet string
+	// Size is the size in bytes of the uncompressed contents of the entry,
+	// e.g. to tell stubs from full articles. It's only set by Query when
+	// QueryOptions.Sizes is, and is -1 if the wiki was built without sizes.
+	Size int64
+	// WordCount is the number of words in the text of the entry, e.g. to
+	// estimate how long it takes to read. It's only set by Query when
+	// QueryOptions.WordCounts is, and is -1 if the wiki was built without word
+	// counts.
+	WordCount int64
+}
+
+// defaultQueryLimit is the number of results returned by a query unless
+// chosen otherwise.
+const defaultQueryLimit = 32
+
+// QueryOptions configure QueryWithOptions. The zero value returns up to 32
+// results in the order of their keys.
+type QueryOptions struct {
+	// Limit is the maximum number of results, or 0 for 32.
+	Limit int
+	// Rank orders the results by how well they match the prefix: an exact
+	// match first, then more popular entries (if the wiki was built with
+	// weights), then entries with higher page ranks (if the wiki was built
+	// with them), then shorter keys, then entries before redirects.
+	Rank bool
+	// Sizes sets the Size of each result, which reads a row of the entry sizes
+	// section for each.
+	Sizes bool
+	// WordCounts sets the WordCount of each result, which reads a row of the
+	// word counts section for each.
+	WordCounts bool
+	// Language only returns keys of entries with this language code (e.g.
+	// "en"), if it's set. Entries without a language don't match, so nothing
+	// matches if the wiki was built without languages.
+	Language string
+}
+
+// entryFilter returns whether to include the entry at offset in the results of
+// a query.
+type entryFilter func(offset int64) (bool, error)
+
+// DefaultQueryOptions are the options used by Query.
+var DefaultQueryOptions = QueryOptions{}
+
+// Query returns up to 32 keys which start with prefix, along with the offsets
+// and snippets of their entries, in the order of their keys. If the wiki
+// has a word index, keys with a later word which starts with prefix are
+// returned too. If no keys match, keys with a transliteration which starts
+// with it are returned instead.
+func (w *Wiki) Query(prefix string) ([]SearchResult, error) {
+	return w.QueryWithOptions(prefix, DefaultQueryOptions)
+}
+
+// QueryWithOptions is like Query, but with the limit and ranking of the
+// results chosen by opts.
+func (w *Wiki) QueryWithOptions(prefix string, opts QueryOptions) ([]SearchResult, error) {
+	return w.QueryContext(context.Background(), prefix, opts)
+}
+
+// QueryContext is like QueryWithOptions, but stops reading and returns an
+// error once ctx is done.
+func (w *Wiki) QueryContext(ctx context.Context, prefix string, opts QueryOptions) ([]SearchResult, error) {
+	if prefix == "" {
+		panic("tried to query for an empty string")
+	}
+
+	ctx, span := w.startSpan(ctx, SpanQuery)
+	defer span.End()
+
+	limit := cmp.Or(opts.Limit, defaultQueryLimit)
+	numCandidates := limit
+	if opts.Rank {
+		numCandidates = limit * rankCandidates
+	}
+
+	var filter entryFilter
+	if opts.Language != "" {
+		filter = w.languageFilter(ctx, opts.Language)
+	}
+
+	results, err := w.query(ctx, prefix, numCandidates, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	if w.words != nil && len(results) < numCandidates {
+		results, err = w.appendWordMatches(ctx, results, prefix, numCandidates, filter)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(results) == 0 && w.translit != nil {
+		results, err = w.queryTranslit(ctx, prefix, numCandidates, filter)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.Rank {
+		var weights map[int64]uint32
+		if w.popularity != nil {
+			weights, err = w.popularity.withContext(ctx).weights(results)
+			if err != nil {
+				return nil, fmt.Errorf("query failed to read weights: %w", err)
+			}
+		}
+
+		var ranks map[int64]int64
+		if w.pageRanks != nil {
+			ranks, err = w.pageRanks.withContext(ctx).ranks(results)
+			if err != nil {
+				return nil, fmt.Errorf("query failed to read page ranks: %w", err)
+			}
+		}
+
+		rankResults(prefix, results, weights, ranks)
+	}
+	results = results[:min(len(results), limit)]
+
+	if w.snippets != nil {
+		snippets := w.snippets.withContext(ctx)
+		for i := range results {
+			snippet, err := snippets.get(results[i].EntryOffset)
+			if err != nil {
+				return nil, fmt.Errorf("query failed to read snippet: %w", err)
+			}
+			results[i].Snippet = snippet
+		}
+	}
+
+	if opts.Sizes {
+		var sizes *sizes
+		if w.sizes != nil {
+			sizes = w.sizes.withContext(ctx)
+		}
+		for i := range results {
+			results[i].Size = -1
+			if sizes == nil {
+				continue
+			}
+
+			size, found, err := sizes.get(results[i].EntryOffset)
+			if err != nil {
+				return nil, fmt.Errorf("query failed to read entry size: %w", err)
+			}
+			if found {
+				results[i].Size = size
+			}
+		}
+	}
+
+	if opts.WordCounts {
+		var wordCounts *sizes
+		if w.wordCounts != nil {
+			wordCounts = w.wordCounts.withContext(ctx)
+		}
+		for i := range results {
+			results[i].WordCount = -1
+			if wordCounts == nil {
+				continue
+			}
+
+			count, found, err := wordCounts.get(results[i].EntryOffset)
+			if err != nil {
+				return nil, fmt.Errorf("query failed to read word count: %w", err)
+			}
+			if found {
+				results[i].WordCount = count
+			}
+		}
+	}
+
+	return results, nil
+}
+
+// queryTranslit returns up to limit keys with a transliteration which starts
+// with prefix. A key is only returned once, even if several of its spellings
+// match.
+func (w *Wiki) queryTranslit(ctx context.Context, prefix string, limit int, filter entryFilter) ([]SearchResult, error) {
+	matches, err := w.translit.query(ctx, strings.ToLower(prefix), limit, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query transliteration index: %w", err)
+	}
+
+	results := make([]SearchResult, 0, len(matches))
+	for _, m := range matches {
+		_, key, found := strings.Cut(m.Key, translit.Separator)
+		if !found {
+			return nil, fmt.Errorf("%w: transliteration index key without a separator: %q", ErrCorrupt, m.Key)
+		}
+
+		if slices.ContainsFunc(results, func(r SearchResult) bool { return r.Key == key }) {
+			continue
+		}
+		results = append(results, SearchResult{Key: key, EntryOffset: m.EntryOffset})
+	}
+
+	return results, nil
+}
+
+// appendWordMatches appends keys with a word after the first which starts with
+// prefix to results, until there are limit of them. Keys which are already in
+// results aren't appended again.
+func (w *Wiki) appendWordMatches(ctx context.Context, results []SearchResult, prefix string, limit int, filter entryFilter) ([]SearchResult, error) {
+	// Each key can match at several of its words, so more rows than needed
+	// are read to make up for the duplicates.
+	matches, err := w.words.query(ctx, prefix, limit*2, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query word index: %w", err)
+	}
+
+	for _, m := range matches {
+		_, key, found := strings.Cut(m.Key, words.Separator)
+		if !found {
+			return nil, fmt.Errorf("%w: word index key without a separator: %q", ErrCorrupt, m.Key)
+		}
+
+		if slices.ContainsFunc(results, func(r SearchResult) bool { return r.Key == key }) {
+			continue
+		}
+		results = append(results, SearchResult{Key: key, EntryOffset: m.EntryOffset})
+		if len(results) == limit {
+			break
+		}
+	}
+
+	return results, nil
+}
+
+// query returns up to limit keys which start with prefix in order, without
+// snippets. If filter isn't nil, only the keys of the entries that it includes
+// are returned.
+func (w *Wiki) query(ctx context.Context, prefix string, limit int, filter entryFilter) ([]SearchResult, error) {
+	prefixChars := utf16.Encode([]rune(prefix))
+
+	_, span := w.startSpan(ctx, SpanSeek)
+	start, end := w.bucket(prefixChars)
+	s := w.scan(ctx, start)
+	defer s.close()
+
+	found, err := s.seek(prefixChars, end)
+	span.End()
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	if !found {
+		return nil, nil
+	}
+
+	_, span = w.startSpan(ctx, SpanScan)
+	defer span.End()
+
+	results := make([]SearchResult, 0, min(limit, defaultQueryLimit))
+	for {
+		result := s.result()
+		if !strings.HasPrefix(result.Key, prefix) {
+			break
+		}
+
+		include := true
+		if filter != nil {
+			var err error
+			include, err = filter(result.EntryOffset)
+			if err != nil {
+				return nil, fmt.Errorf("query failed: %w", err)
+			}
+		}
+
+		if include {
+			results = append(results, result)
+			if len(results) == limit {
+				break
+			}
+		}
+
+		err := s.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("query failed: %w", err)
+		}
+	}
+
+	return results, nil
+}
+
+// MainPage returns the key of the entry which was chosen as the landing page
+// of the wiki when it was built, or an empty string if there isn't one.
+func (w *Wiki) MainPage() string {
+	return w.mainPage
+}
+
+// HasWordIndex returns whether the wiki was built with a word index, which
+// queries use to match words after the start of keys.
+func (w *Wiki) HasWordIndex() bool {
+	return w.words != nil
+}
+
+// Compression returns the ID of the compressor that the entries are compressed
+// with.
+func (w *Wiki) Compression() compression.ID {
+	return w.compressionID
+}
+
+// EntryOffset returns the offset of the entry with the given name, or an error
+// wrapping ErrNotFound if there isn't one.
+func (w *Wiki) EntryOffset(name string) (int64, error) {
+	return w.EntryOffsetContext(context.Background(), name)
+}
+
+// EntryOffsetContext is like EntryOffset, but stops reading and returns an
+// error once ctx is done.
+func (w *Wiki) EntryOffsetContext(ctx context.Context, name string) (int64, error) {
+	nameChars := utf16.Encode([]rune(name))
+
+	if w.bloom != nil && !w.bloom.MayContain(nameChars) {
+		return -1, fmt.Errorf("%w: %s isn't in the bloom filter", ErrNotFound, name)
+	}
+
+	_, span := w.startSpan(ctx, SpanSeek)
+	defer span.End()
+
+	start, end := w.bucket(nameChars)
+	s := w.scan(ctx, start)
+	defer s.close()
+
+	found, err := s.seek(nameChars, end)
+	if err != nil {
+		return -1, fmt.Errorf("entryOffset failed: %w", err)
+	}
+	if !found || s.compare(nameChars) != 0 {
+		return -1, fmt.Errorf("%w: %s isn't in the second level index", ErrNotFound, name)
+	}
+
+	return s.result().EntryOffset, nil
+}
+
+// bucket returns the part of the second level index (as offsets into it) which
+// starts with the last key that's <= chars in the first level index, and ends
+// at the next one.
+func (w *Wiki) bucket(chars []uint16) (int64, int64) {
+	i := w.first.bucket(chars)
+
+	end := w.secondLevelIndexLen
+	if i+1 < len(w.first.offsets) {
+		end = int64(w.first.offsets[i+1])
+	}
+
+	return int64(w.first.offsets[i]), end
+}
+
+// EntryAt returns a reader for the decompressed contents of the entry at
+// offset.
+func (w *Wiki) EntryAt(offset int64) (io.Reader, error) {
+	return w.EntryAtContext(context.Background(), offset)
+}
+
+// EntryAtContext is like EntryAt, but reading from the returned reader fails
+// once ctx is done.
+func (w *Wiki) EntryAtContext(ctx context.Context, offset int64) (io.Reader, error) {
+	if offset < 0 || offset+3 > w.entriesLen {
+		return nil, fmt.Errorf("%w: %d isn't within the %d B of entries", ErrOutOfRange, offset, w.entriesLen)
+	}
+
+	if w.cache != nil {
+		if b, found := w.cache.get(offset); found {
+			w.readAhead(offset, len(b))
+			return w.decompress(ctx, bytes.NewReader(b), offset, len(b))
+		}
+	}
+
+	compressedSize, compressed, err := w.RawEntryContext(ctx, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	if w.cache != nil {
+		w.readAhead(offset, compressedSize)
+	}
+
+	return w.decompress(ctx, compressed, offset, compressedSize)
+}
+
+// decompress returns a reader for the decompressed contents of the entry at
+// offset, which are read from compressed. Its span ends once it's read to the
+// end.
+func (w *Wiki) decompress(ctx context.Context, compressed io.Reader, offset int64, compressedSize int) (io.Reader, error) {
+	_, span := w.startSpan(ctx, SpanDecompress)
+
+	r, err := w.newEntryReader(compressed, offset, compressedSize)
+	if err != nil {
+		span.End()
+		return nil, err
+	}
+
+	return &spanReader{r: r, span: span}, nil
+}
+
+// RawEntry returns the length of the entry at offset as it's stored in the
+// wiki file (i.e. compressed with the compressor from Compression), along with
+// a reader for it. This allows
+// entries to be copied without decompressing them.
+func (w *Wiki) RawEntry(offset int64) (int, io.Reader, error) {
+	return w.RawEntryContext(context.Background(), offset)
+}
+
+// RawEntryContext is like RawEntry, but stops reading once ctx is done.
+func (w *Wiki) RawEntryContext(ctx context.Context, offset int64) (int, io.Reader, error) {
+	if offset < 0 || offset+3 > w.entriesLen {
+		return 0, nil, fmt.Errorf("%w: %d isn't within the %d B of entries", ErrOutOfRange, offset, w.entriesLen)
+	}
+
+	start := w.entriesOffset + offset
+	entries := withContext(ctx, w.entries)
+
+	var buf [3]byte
+	if _, err := entries.ReadAt(buf[:], start); err != nil {
+		return 0, nil, fmt.Errorf("failed to read entry length at %d: %w", offset, err)
+	}
+
+	compressedSize := int(entryLength(buf[:]))
+	if offset+3+int64(compressedSize) > w.entriesLen {
+		return 0, nil, fmt.Errorf("%w: entry at %d with length %d extends past the entries", ErrCorrupt, offset, compressedSize)
+	}
+
+	if w.remote {
+		// Read the whole entry at once, rather than in small chunks as it's
+		// read.
+		b := make([]byte, compressedSize)
+		if _, err := entries.ReadAt(b, start+3); err != nil {
+			return 0, nil, fmt.Errorf("failed to read entry at %d: %w", offset, err)
+		}
+		return compressedSize, bytes.NewReader(b), nil
+	}
+
+	return compressedSize, io.NewSectionReader(entries, start+3, int64(compressedSize)), nil
+}
+
+func (w *Wiki) newEntryReader(compressed io.Reader, offset int64, compressedSize int) (io.ReadCloser, error) {
+	r, err := w.compressor.NewReader(compressed)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s NewReader failed for %d; len=%d: %w", ErrCorrupt, w.compressor.Name(), offset, compressedSize, err)
+	}
+
+	return r, nil
+}
+
+// readCompressed returns the compressed bytes of the entry at offset.
+func (w *Wiki) readCompressed(ctx context.Context, offset int64) ([]byte, error) {
+	start := w.entriesOffset + offset
+	entries := withContext(ctx, w.entries)
+
+	var buf [3]byte
+	if _, err := entries.ReadAt(buf[:], start); err != nil {
+		return nil, fmt.Errorf("failed to read entry length at %d: %w", offset, err)
+	}
+
+	b := make([]byte, entryLength(buf[:]))
+	if _, err := entries.ReadAt(b, start+3); err != nil {
+		return nil, fmt.Errorf("failed to read entry at %d: %w", offset, err)
+	}
+
+	return b, nil
+}
+
+// Keys calls fn with every key in the wiki, in sorted order, stopping at the
+// first error.
+func (w *Wiki) Keys(fn func(SearchResult) error) error {
+	s := w.scan(context.Background(), 0)
+	defer s.close()
+
+	for {
+		err := s.next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := fn(s.result()); err != nil {
+			return err
+		}
+	}
+}
+
+// Entry is an entry yielded by Entries.
+type Entry struct {
+	Name   string
+	Offset int64
+	// Contents is a reader for the decompressed contents of the entry. It's
+	// only valid until the next iteration.
+	Contents io.Reader
+}
+
+// errStopIteration is returned by the callback to Keys when iterating over
+// Entries is stopped early.
+var errStopIteration = errors.New("stop iteration")
+
+// Entries returns an iterator over every key in the wiki in sorted order,
+// along with the contents of its entry. Redirects are included, so the same
+// entry can be yielded under multiple names. If there's an error, it's yielded
+// and the iteration stops.
+func (w *Wiki) Entries() iter.Seq2[Entry, error] {
+	return func(yield func(Entry, error) bool) {
+		err := w.Keys(func(r SearchResult) error {
+			contents, err := w.EntryAt(r.EntryOffset)
+			if err != nil {
+				return err
+			}
+
+			if !yield(Entry{Name: r.Key, Offset: r.EntryOffset, Contents: contents}, nil) {
+				return errStopIteration
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, errStopIteration) {
+			yield(Entry{}, err)
+		}
+	}
+}
+
+func compareTo(buf []byte, prefixChars []uint16) int {
+	for i := range min(len(buf)/2, len(prefixChars)) {
+		bufCh := storage.UTF16Order(binary.LittleEndian.Uint16(buf[i*2:]))
+		prefixCh := storage.UTF16Order(prefixChars[i])
+
+		if c := cmp.Compare(bufCh, prefixCh); c != 0 {
+			return c
+		}
+	}
+
+	return len(buf) - len(prefixChars)*2
+}
+
+func entryLength(b []byte) uint32 {
+	_ = b[2] // bounds check hint to compiler; see golang.org/issue/14808
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16
+}
+
+func entryOffsetToUInt64(b []byte, offset int, width int) uint64 {
+	// The width comes from the header, and is between 5 and 8 bytes.
+	var v uint64
+	for i, ch := range b[offset:][:width] {
+		v |= uint64(ch) << (8 * i)
+	}
+
+	return v
+}
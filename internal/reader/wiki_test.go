@@ -0,0 +1,85 @@
+package reader_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rsookram/wiki-builder/internal/reader"
+	"github.com/rsookram/wiki-builder/wikifile"
+)
+
+// createWiki writes a wiki with numEntries small entries, named Entry_0,
+// Entry_1, etc., and opens it.
+func createWiki(b *testing.B, numEntries int) reader.Wiki {
+	path := filepath.Join(b.TempDir(), "bench.wiki")
+	w, err := wikifile.Create(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := range numEntries {
+		content := fmt.Sprintf("<html><body><p>%s</p></body></html>", strings.Repeat(fmt.Sprintf("Entry %d. ", i), 50))
+		if err := w.AddEntry(fmt.Sprintf("Entry_%d", i), strings.NewReader(content)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		b.Fatal(err)
+	}
+
+	wiki, err := reader.OpenWiki(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { wiki.Close() })
+	return wiki
+}
+
+// BenchmarkEntryAt reads small entries to the end, which is what web does for
+// each request. Decompressors are reused between entries, so it shouldn't
+// allocate one for each.
+func BenchmarkEntryAt(b *testing.B) {
+	wiki := createWiki(b, 100)
+
+	offsets := make([]int64, 0, 100)
+	err := wiki.Keys(func(r reader.SearchResult) error {
+		offsets = append(offsets, r.EntryOffset)
+		return nil
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := range b.N {
+		r, err := wiki.EntryAt(offsets[i%len(offsets)])
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := io.Copy(io.Discard, r); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkEntryOffsetContext looks up entries with a context which can be
+// cancelled, like web does for each request when it has a read timeout. Reads
+// which can be abandoned shouldn't allocate a buffer each.
+func BenchmarkEntryOffsetContext(b *testing.B) {
+	wiki := createWiki(b, 100)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := range b.N {
+		if _, err := wiki.EntryOffsetContext(ctx, fmt.Sprintf("Entry_%d", i%100)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
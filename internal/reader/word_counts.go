@@ -0,0 +1,32 @@
+package reader
+
+import "fmt"
+
+// EntryWordCount returns the number of words in the text of the entry with the
+// given name. It returns an error wrapping ErrNotFound if there isn't an entry
+// with the name, or if the wiki was built without word counts.
+func (w *Wiki) EntryWordCount(name string) (int64, error) {
+	offset, err := w.EntryOffset(name)
+	if err != nil {
+		return 0, err
+	}
+
+	return w.EntryWordCountAt(offset)
+}
+
+// EntryWordCountAt is like EntryWordCount, but for the entry at offset.
+func (w *Wiki) EntryWordCountAt(offset int64) (int64, error) {
+	if w.wordCounts == nil {
+		return 0, fmt.Errorf("%w: the wiki was built without word counts", ErrNotFound)
+	}
+
+	count, found, err := w.wordCounts.get(offset)
+	if err != nil {
+		return 0, err
+	}
+	if !found {
+		return 0, fmt.Errorf("%w: no word count for the entry at %d", ErrNotFound, offset)
+	}
+
+	return count, nil
+}
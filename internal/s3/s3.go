@@ -0,0 +1,369 @@
+// Package s3 lists and reads objects in S3-compatible object storage, so that
+// dumps can be built without copying them to a local disk first.
+//
+// It's configured with the same environment variables as the AWS CLI:
+//   - AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, and AWS_SESSION_TOKEN are the
+//     credentials. Requests aren't signed without them, for public buckets.
+//   - AWS_REGION (or AWS_DEFAULT_REGION) is the region, us-east-1 by default.
+//   - AWS_ENDPOINT_URL_S3 (or AWS_ENDPOINT_URL) is the URL of another
+//     S3-compatible service, e.g. http://localhost:9000 for MinIO. Buckets are
+//     addressed by path with it.
+package s3
+
+import (
+	"cmp"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Scheme is the scheme of the URLs of objects, e.g. s3://bucket/key.
+const Scheme = "s3://"
+
+// emptyHash is the SHA-256 of an empty request body.
+const emptyHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// attempts is the number of times a request is sent before giving up, when
+// it fails with a server error (e.g. 503 Slow Down) or a network error.
+const attempts = 4
+
+// IsURL reports whether path is the URL of an object (or a prefix) in object
+// storage, rather than a local path.
+func IsURL(path string) bool {
+	return strings.HasPrefix(path, Scheme)
+}
+
+// ParseURL splits a URL like s3://bucket/key into the bucket and the key.
+func ParseURL(u string) (bucket string, key string, err error) {
+	rest, found := strings.CutPrefix(u, Scheme)
+	if !found {
+		return "", "", fmt.Errorf("%q isn't an %s URL", u, Scheme)
+	}
+
+	bucket, key, _ = strings.Cut(rest, "/")
+	if bucket == "" {
+		return "", "", fmt.Errorf("%q doesn't have a bucket", u)
+	}
+
+	return bucket, key, nil
+}
+
+// Client sends requests to object storage.
+type Client struct {
+	endpoint *url.URL
+	// pathStyle addresses buckets by path (endpoint/bucket/key) rather than
+	// by host (bucket.endpoint/key).
+	pathStyle    bool
+	region       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	http         *http.Client
+	// now is the time that requests are signed with.
+	now func() time.Time
+}
+
+// FromEnv returns a client configured with the environment variables listed
+// in the package documentation.
+func FromEnv() (*Client, error) {
+	region := cmp.Or(os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), "us-east-1")
+
+	endpoint := cmp.Or(os.Getenv("AWS_ENDPOINT_URL_S3"), os.Getenv("AWS_ENDPOINT_URL"))
+	pathStyle := endpoint != ""
+	if endpoint == "" {
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid endpoint %q: it must be an HTTP(S) URL", endpoint)
+	}
+
+	return &Client{
+		endpoint:     u,
+		pathStyle:    pathStyle,
+		region:       region,
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		http:         http.DefaultClient,
+		now:          time.Now,
+	}, nil
+}
+
+// Object is an object found by List.
+type Object struct {
+	Key  string
+	Size int64
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key  string
+		Size int64
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// List calls fn with every object in bucket whose key starts with prefix, in
+// the order of their keys. It stops early if fn returns an error, and returns
+// it.
+func (c *Client) List(ctx context.Context, bucket string, prefix string, fn func(Object) error) error {
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		resp, err := c.do(ctx, http.MethodGet, bucket, "", query, nil)
+		if err != nil {
+			return err
+		}
+
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to list s3://%s/%s: %w", bucket, prefix, err)
+		}
+
+		for _, o := range result.Contents {
+			if err := fn(Object{Key: o.Key, Size: o.Size}); err != nil {
+				return err
+			}
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// Get reads the object with key in bucket, starting at offset. If length isn't
+// negative, at most length bytes are read, which only requests that range of
+// the object.
+func (c *Client) Get(ctx context.Context, bucket string, key string, offset int64, length int64) (io.ReadCloser, error) {
+	header := http.Header{}
+	if length == 0 {
+		return io.NopCloser(strings.NewReader("")), nil
+	} else if length > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	} else if offset > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := c.do(ctx, http.MethodGet, bucket, key, nil, header)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+// Size returns the size of the object with key in bucket.
+func (c *Client) Size(ctx context.Context, bucket string, key string) (int64, error) {
+	resp, err := c.do(ctx, http.MethodHead, bucket, key, nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	if resp.ContentLength < 0 {
+		return 0, fmt.Errorf("s3://%s/%s: the response doesn't have a Content-Length", bucket, key)
+	}
+
+	return resp.ContentLength, nil
+}
+
+// do sends a request for key in bucket, retrying it if it fails with a server
+// error. The response has a 2xx status.
+func (c *Client) do(ctx context.Context, method string, bucket string, key string, query url.Values, header http.Header) (*http.Response, error) {
+	u := *c.endpoint
+	if c.pathStyle {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + bucket + "/" + key
+	} else {
+		u.Host = bucket + "." + u.Host
+		u.Path = "/" + key
+	}
+	u.RawPath = escapePath(u.Path)
+	u.RawQuery = canonicalQuery(query)
+
+	var lastErr error
+	for attempt := range attempts {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(time.Duration(1<<attempt) * 100 * time.Millisecond):
+			}
+		}
+
+		req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		c.sign(req)
+
+		resp, err := c.http.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return resp, nil
+		}
+
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		lastErr = fmt.Errorf("s3://%s/%s: %s: %s", bucket, key, resp.Status, strings.TrimSpace(string(body)))
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("%w (%w)", lastErr, os.ErrNotExist)
+		}
+		if resp.StatusCode < 500 {
+			return nil, lastErr
+		}
+	}
+
+	return nil, lastErr
+}
+
+// sign adds an AWS Signature Version 4 to req, unless the client doesn't have
+// credentials.
+func (c *Client) sign(req *http.Request) {
+	if c.accessKey == "" || c.secretKey == "" {
+		return
+	}
+
+	now := c.now().UTC()
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	req.Header.Set("X-Amz-Content-Sha256", emptyHash)
+	if c.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", c.sessionToken)
+	}
+
+	// Only the host and the headers set above (and Range) are signed, since
+	// the transport can add others.
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		k = strings.ToLower(k)
+		if k == "range" || strings.HasPrefix(k, "x-amz-") {
+			headers[k] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	slices.Sort(names)
+
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		emptyHash,
+	}, "\n")
+
+	scope := date + "/" + c.region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		now.Format("20060102T150405Z"),
+		scope,
+		hexHash([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+c.secretKey), date)
+	key = hmacSHA256(key, c.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.accessKey, scope, signedHeaders, signature,
+	))
+}
+
+// escapePath escapes p like the canonical URI of a signature, which leaves
+// only unreserved characters and slashes unescaped.
+func escapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, s := range segments {
+		segments[i] = escape(s)
+	}
+
+	return strings.Join(segments, "/")
+}
+
+// canonicalQuery encodes query like the canonical query string of a
+// signature, sorted by key and with spaces as %20.
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	var params []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			params = append(params, escape(k)+"="+escape(v))
+		}
+	}
+
+	return strings.Join(params, "&")
+}
+
+// escape percent-encodes every byte of s apart from unreserved characters.
+func escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+func hexHash(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
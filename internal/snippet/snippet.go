@@ -0,0 +1,89 @@
+// Package snippet extracts a short summary of the text of an entry, to show
+// alongside search results.
+package snippet
+
+import (
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// MaxLen is the number of characters that snippets are truncated to.
+const MaxLen = 200
+
+// Extract returns up to maxLen characters of the text of the first paragraph
+// of the page read from r, with whitespace collapsed. The text of the whole
+// body is used when there isn't a paragraph with any text.
+func Extract(r io.Reader, maxLen int) string {
+	var paragraph strings.Builder
+	var body strings.Builder
+	inParagraph := false
+	// skipDepth is greater than 0 within elements whose text isn't shown.
+	skipDepth := 0
+
+	z := html.NewTokenizer(r)
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+
+		tok := z.Token()
+		switch tt {
+		case html.StartTagToken:
+			switch tok.DataAtom {
+			case atom.P:
+				inParagraph = true
+			case atom.Head, atom.Script, atom.Style:
+				skipDepth++
+			case atom.Body:
+				// In case the head wasn't closed.
+				skipDepth = 0
+			}
+		case html.TextToken:
+			if skipDepth > 0 {
+				continue
+			}
+			if inParagraph {
+				paragraph.WriteString(tok.Data)
+			}
+			if body.Len() < maxLen*8 {
+				body.WriteString(tok.Data)
+				body.WriteByte(' ')
+			}
+		case html.EndTagToken:
+			switch tok.DataAtom {
+			case atom.P:
+				if inParagraph {
+					inParagraph = false
+					if s := collapse(paragraph.String()); s != "" {
+						return truncate(s, maxLen)
+					}
+					paragraph.Reset()
+				}
+			case atom.Head, atom.Script, atom.Style:
+				skipDepth = max(0, skipDepth-1)
+			}
+		}
+	}
+
+	if s := collapse(paragraph.String()); s != "" {
+		return truncate(s, maxLen)
+	}
+	return truncate(collapse(body.String()), maxLen)
+}
+
+func collapse(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func truncate(s string, maxLen int) string {
+	runes := []rune(s)
+	if len(runes) <= maxLen {
+		return s
+	}
+
+	return string(runes[:maxLen]) + "…"
+}
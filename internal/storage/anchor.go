@@ -0,0 +1,35 @@
+package storage
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// ReadAnchors returns the anchors of each entry written by compress-entries,
+// separated by tabs, in the same order as the entry metadata, or nil if
+// anchors weren't extracted.
+func ReadAnchors(rdr *bufio.Reader, dataDir string) []string {
+	f, err := os.Open(filepath.Join(dataDir, "stage-1-anchors.txt"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		panic(fmt.Sprintf("Error reading anchors from compress-entries: %s", err))
+	}
+	defer f.Close()
+
+	rdr.Reset(f)
+
+	numAnchors := readInt(rdr)
+	anchors := make([]string, numAnchors)
+
+	for i := range numAnchors {
+		anchors[i] = readString(rdr, '\n')
+	}
+
+	return anchors
+}
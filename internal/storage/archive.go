@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// IsArchive reports whether path is a tar archive of a dump (which can be
+// compressed with gzip or zstd), going by its extension, rather than a
+// directory.
+func IsArchive(path string) bool {
+	for _, ext := range []string{".tar", ".tar.gz", ".tgz", ".tar.zst", ".tar.zstd"} {
+		if strings.HasSuffix(path, ext) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Archive reads the files in a tar archive in one pass.
+type Archive struct {
+	*tar.Reader
+	closers []func() error
+}
+
+// OpenArchive opens the tar archive at path, which can be a URL like for
+// Open, decompressing it if its extension says it's compressed.
+func OpenArchive(path string) (*Archive, error) {
+	f, err := Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &Archive{closers: []func() error{f.Close}}
+	var r io.Reader = f
+	switch {
+	case strings.HasSuffix(path, ".gz") || strings.HasSuffix(path, ".tgz"):
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			a.Close()
+			return nil, err
+		}
+		a.closers = append(a.closers, zr.Close)
+		r = zr
+	case strings.HasSuffix(path, ".zst") || strings.HasSuffix(path, ".zstd"):
+		zr, err := zstd.NewReader(f)
+		if err != nil {
+			a.Close()
+			return nil, err
+		}
+		a.closers = append(a.closers, func() error {
+			zr.Close()
+			return nil
+		})
+		r = zr
+	}
+
+	a.Reader = tar.NewReader(r)
+	return a, nil
+}
+
+// Close closes the archive, and the file it's read from.
+func (a *Archive) Close() error {
+	var err error
+	for i := len(a.closers) - 1; i >= 0; i-- {
+		if e := a.closers[i](); err == nil {
+			err = e
+		}
+	}
+
+	return err
+}
+
+// DumpPath returns the path of the file named name in an archive relative to
+// the root of the dump in it, e.g. A/Tokyo.html. The dump can be at the root
+// of the archive, or in a single directory within it (e.g. dump/A/Tokyo.html).
+// Files outside of A and _exceptions aren't part of the dump.
+func DumpPath(name string) (string, bool) {
+	name = strings.TrimPrefix(name, "./")
+	if isDumpPath(name) {
+		return name, true
+	}
+
+	if _, rest, found := strings.Cut(name, "/"); found && isDumpPath(rest) {
+		return rest, true
+	}
+
+	return "", false
+}
+
+func isDumpPath(p string) bool {
+	return strings.HasPrefix(p, "A/") || strings.HasPrefix(p, "_exceptions/")
+}
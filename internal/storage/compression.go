@@ -0,0 +1,32 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rsookram/wiki-builder/internal/compression"
+)
+
+// ReadCompression returns the ID of the compressor that compress-entries
+// compressed the entries with. Entries from before it was recorded are zlib
+// compressed.
+func ReadCompression(dataDir string) compression.ID {
+	b, err := os.ReadFile(filepath.Join(dataDir, "stage-1-compression.txt"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return compression.Zlib
+	}
+	if err != nil {
+		panic(fmt.Sprintf("Error reading compression from compress-entries: %s", err))
+	}
+
+	id, err := compression.LookupName(strings.TrimSpace(string(b)))
+	if err != nil {
+		panic(fmt.Sprintf("Error reading compression from compress-entries: %s", err))
+	}
+
+	return id
+}
@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultContentType is the content type of entries which don't have one
+// recorded.
+const DefaultContentType = "text/html; charset=utf-8"
+
+// DetectContentType returns the content type of the entry at path, which
+// starts with head. Entries which look like HTML are always treated as HTML,
+// since names of HTML entries often end with something that looks like an
+// extension (e.g. Node.js). Otherwise the extension is used, falling back to
+// sniffing the content.
+func DetectContentType(path string, head []byte) string {
+	sniffed := http.DetectContentType(head)
+	if strings.HasPrefix(sniffed, "text/html") {
+		return DefaultContentType
+	}
+
+	if t := mime.TypeByExtension(filepath.Ext(path)); t != "" {
+		return t
+	}
+
+	return sniffed
+}
+
+// IsHTML returns whether contentType is for HTML.
+func IsHTML(contentType string) bool {
+	return strings.HasPrefix(contentType, "text/html")
+}
+
+// ReadContentTypes returns the content type of each entry written by
+// compress-entries, in the same order as the entry metadata, or nil if they
+// weren't recorded.
+func ReadContentTypes(rdr *bufio.Reader, dataDir string) []string {
+	f, err := os.Open(filepath.Join(dataDir, "stage-1-content-types.txt"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		panic(fmt.Sprintf("Error reading content types from compress-entries: %s", err))
+	}
+	defer f.Close()
+
+	rdr.Reset(f)
+
+	numContentTypes := readInt(rdr)
+	contentTypes := make([]string, numContentTypes)
+
+	for i := range numContentTypes {
+		contentTypes[i] = readString(rdr, '\n')
+	}
+
+	return contentTypes
+}
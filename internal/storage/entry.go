@@ -5,25 +5,17 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"strings"
 	"unicode/utf16"
 )
 
 // TODO: SOA makes more sense for this
 type Entry struct {
-	LocalPath      string
-	htmlPathOffset int
+	LocalPath string
+	name      string
 }
 
 func (e Entry) Name() string {
-	htmlPath := e.LocalPath[e.htmlPathOffset:]
-
-	htmlPath, found := strings.CutPrefix(htmlPath, "_exceptions/")
-	if found {
-		htmlPath = strings.Replace(htmlPath, "%2f", "/", -1)
-	}
-
-	return htmlPath[len("A/"):]
+	return e.name
 }
 
 func (e Entry) NameUTF16() []uint16 {
@@ -43,8 +35,9 @@ func ReadEntries(rdr *bufio.Reader, dataDir string) []Entry {
 	entries := make([]Entry, numEntries)
 
 	for i := range numEntries {
-		localPath := readString(rdr, '\n')
-		entries[i] = Entry{localPath, len(dataDir)}
+		localPath := readString(rdr, '\t')
+		name := readString(rdr, '\n')
+		entries[i] = Entry{localPath, name}
 	}
 
 	return entries
@@ -2,34 +2,60 @@ package storage
 
 import (
 	"bufio"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
 	"unicode/utf16"
+	"unicode/utf8"
+	"unsafe"
 )
 
+// EntryMetadata is the metadata of the entries written by compress-entries.
+// The names of all the entries are stored in a single buffer, rather than
+// individually, to avoid millions of small allocations. When it's read from
+// the binary metadata, the buffers refer to the file mapped into memory.
 type EntryMetadata struct {
-	namesUTF16 [][]uint16
-	endOffsets []uint64
+	// chars are the names of all the entries in UTF-16, packed.
+	chars []uint16
+	// nameEnds are the end indexes of each name in chars.
+	nameEnds     []uint64
+	startOffsets []uint64
 }
 
+// Name returns the name of the ith entry in UTF-16. It must not be modified.
 func (em EntryMetadata) Name(i int) []uint16 {
-	return em.namesUTF16[i]
+	start := 0
+	if i > 0 {
+		start = int(em.nameEnds[i-1])
+	}
+
+	end := int(em.nameEnds[i])
+	return em.chars[start:end:end]
 }
 
+// StartOffset returns the offset of the ith entry, relative to the start of
+// the entries. Entries aren't necessarily written in the same order as their
+// metadata.
 func (em EntryMetadata) StartOffset(i int) uint64 {
-	if i == 0 {
-		return 0
-	}
-
-	return em.endOffsets[i-1]
+	return em.startOffsets[i]
 }
 
 func (em EntryMetadata) Len() int {
-	return len(em.namesUTF16)
+	return len(em.nameEnds)
 }
 
+// ReadEntryMetadata reads the entry metadata written by compress-entries to
+// dataDir. The binary metadata is used when it was written (with
+// -binary-meta), since it doesn't need to be parsed.
 func ReadEntryMetadata(rdr *bufio.Reader, dataDir string) EntryMetadata {
+	if em, found := mapEntryMetadata(filepath.Join(dataDir, "stage-1-entry-meta.bin")); found {
+		return em
+	}
+
 	f, err := os.Open(filepath.Join(dataDir, "stage-1-entry-meta.txt"))
 	if err != nil {
 		panic(fmt.Sprintf("Error reading entry metadata from compress-entries %s", err))
@@ -39,19 +65,119 @@ func ReadEntryMetadata(rdr *bufio.Reader, dataDir string) EntryMetadata {
 	rdr.Reset(f)
 
 	numEntries := readInt(rdr)
-	names := make([][]uint16, numEntries)
-	endOffsets := make([]uint64, numEntries)
+	var chars []uint16
+	nameEnds := make([]uint64, numEntries)
+	startOffsets := make([]uint64, numEntries)
 
 	for i := range numEntries {
-		name := readString(rdr, '\n')
+		// The line is only valid until the next read, which avoids allocating
+		// a string for each name.
+		line, err := rdr.ReadSlice('\n')
+		if err != nil {
+			panic(err)
+		}
+		line = line[:len(line)-1]
+
+		for len(line) > 0 {
+			r, size := utf8.DecodeRune(line)
+			chars = utf16.AppendRune(chars, r)
+			line = line[size:]
+		}
 
-		names[i] = utf16.Encode([]rune(name))
+		nameEnds[i] = uint64(len(chars))
 	}
 
 	for i := range numEntries {
-		offset := readUint64(rdr)
-		endOffsets[i] = offset
+		startOffsets[i] = readUint64(rdr)
+	}
+
+	return EntryMetadata{slices.Clip(chars), nameEnds, startOffsets}
+}
+
+// mapEntryMetadata maps the binary entry metadata at path into memory, and
+// returns whether it exists. The numbers in it are used without copying them
+// when they're in the byte order of the machine.
+func mapEntryMetadata(path string) (EntryMetadata, bool) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return EntryMetadata{}, false
+	}
+	if err != nil {
+		panic(fmt.Sprintf("Error reading entry metadata from compress-entries: %s", err))
+	}
+	defer f.Close()
+
+	b, err := mapFile(f)
+	if err != nil {
+		panic(fmt.Sprintf("Error reading entry metadata from compress-entries: %s", err))
+	}
+
+	corrupt := fmt.Sprintf("%s is truncated or corrupt", path)
+	if len(b) < 8 {
+		panic(corrupt)
+	}
+	numEntries := binary.LittleEndian.Uint64(b)
+	if numEntries > uint64(len(b)-8)/16 {
+		panic(corrupt)
 	}
+	n := int(numEntries)
 
-	return EntryMetadata{names, endOffsets}
+	offsetsBytes := b[8:][:8*n]
+	nameEndsBytes := b[8+8*n:][:8*n]
+	charsBytes := b[8+16*n:]
+	if len(charsBytes)%2 != 0 || (n > 0 && binary.LittleEndian.Uint64(nameEndsBytes[8*(n-1):]) != uint64(len(charsBytes)/2)) {
+		panic(corrupt)
+	}
+
+	em := EntryMetadata{
+		chars:        uint16s(charsBytes),
+		nameEnds:     uint64s(nameEndsBytes),
+		startOffsets: uint64s(offsetsBytes),
+	}
+
+	prev := uint64(0)
+	for _, end := range em.nameEnds {
+		if end < prev {
+			panic(corrupt)
+		}
+		prev = end
+	}
+
+	return em, true
+}
+
+// isLittleEndian is whether the machine stores numbers in little endian, like
+// the binary entry metadata.
+var isLittleEndian = binary.NativeEndian.Uint16([]byte{1, 0}) == 1
+
+// uint64s returns b as little endian u64s, referring to b when possible.
+func uint64s(b []byte) []uint64 {
+	if len(b) == 0 {
+		return nil
+	}
+	if isLittleEndian && uintptr(unsafe.Pointer(&b[0]))%8 == 0 {
+		return unsafe.Slice((*uint64)(unsafe.Pointer(&b[0])), len(b)/8)
+	}
+
+	nums := make([]uint64, len(b)/8)
+	for i := range nums {
+		nums[i] = binary.LittleEndian.Uint64(b[8*i:])
+	}
+	return nums
+}
+
+// uint16s returns b as little endian u16s, referring to b when possible.
+func uint16s(b []byte) []uint16 {
+	if len(b) == 0 {
+		return nil
+	}
+	if isLittleEndian && uintptr(unsafe.Pointer(&b[0]))%2 == 0 {
+		return unsafe.Slice((*uint16)(unsafe.Pointer(&b[0])), len(b)/2)
+	}
+
+	nums := make([]uint16, len(b)/2)
+	for i := range nums {
+		nums[i] = binary.LittleEndian.Uint16(b[2*i:])
+	}
+	return nums
 }
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/rsookram/wiki-builder/internal/s3"
+)
+
+// s3Client is shared by everything which reads entries from object storage.
+var s3Client = sync.OnceValues(s3.FromEnv)
+
+// S3 returns the client for reading dumps from object storage, configured by
+// the environment (see package s3).
+func S3() *s3.Client {
+	c, err := s3Client()
+	if err != nil {
+		panic(err)
+	}
+
+	return c
+}
+
+// Open opens the file of an entry at path, which is either a local path, or
+// the URL of an object in object storage (e.g. s3://bucket/A/Tokyo.html).
+func Open(path string) (io.ReadCloser, error) {
+	return OpenHead(path, -1)
+}
+
+// OpenHead is like Open, but only reads the first n bytes of the file (or all
+// of it if n is negative). Only that range is requested from object storage.
+func OpenHead(path string, n int64) (io.ReadCloser, error) {
+	if !s3.IsURL(path) {
+		f, err := os.Open(path)
+		if err != nil || n < 0 {
+			return f, err
+		}
+
+		return struct {
+			io.Reader
+			io.Closer
+		}{io.LimitReader(f, n), f}, nil
+	}
+
+	bucket, key, err := s3.ParseURL(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return S3().Get(context.Background(), bucket, key, 0, n)
+}
+
+// FileSize returns the size of the file at path, which can be a URL like for
+// Open.
+func FileSize(path string) (int64, error) {
+	if !s3.IsURL(path) {
+		info, err := os.Stat(path)
+		if err != nil {
+			return 0, err
+		}
+
+		return info.Size(), nil
+	}
+
+	bucket, key, err := s3.ParseURL(path)
+	if err != nil {
+		return 0, err
+	}
+
+	return S3().Size(context.Background(), bucket, key)
+}
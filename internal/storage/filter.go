@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// NameFilter decides which entries are kept based on their names. Patterns
+// use the syntax of path.Match. An entry is kept when it matches at least one
+// of the include patterns (or there aren't any), and none of the exclude
+// patterns.
+type NameFilter struct {
+	Include Patterns
+	Exclude Patterns
+}
+
+func (f NameFilter) Keep(name string) bool {
+	if len(f.Include) > 0 && !f.Include.Match(name) {
+		return false
+	}
+
+	return !f.Exclude.Match(name)
+}
+
+// Patterns is a list of glob patterns which can be used as a flag that's
+// specified multiple times.
+type Patterns []string
+
+func (p *Patterns) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *Patterns) Set(pattern string) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+
+	*p = append(*p, pattern)
+	return nil
+}
+
+func (p Patterns) Match(name string) bool {
+	for _, pattern := range p {
+		// The error is ignored since patterns are validated in Set.
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+
+	return false
+}
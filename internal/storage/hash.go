@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// ReadHashes returns the SHA-256 of each entry written by compress-entries,
+// in the same order as the entry metadata, or nil if hashes weren't computed.
+func ReadHashes(rdr *bufio.Reader, dataDir string) [][]byte {
+	f, err := os.Open(filepath.Join(dataDir, "stage-1-hashes.txt"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		panic(fmt.Sprintf("Error reading hashes from compress-entries: %s", err))
+	}
+	defer f.Close()
+
+	rdr.Reset(f)
+
+	numHashes := readInt(rdr)
+	hashes := make([][]byte, numHashes)
+
+	for i := range numHashes {
+		h, err := hex.DecodeString(readString(rdr, '\n'))
+		if err != nil {
+			panic(fmt.Sprintf("Error reading hash %d from compress-entries: %s", i, err))
+		}
+
+		hashes[i] = h
+	}
+
+	return hashes
+}
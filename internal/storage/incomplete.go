@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// incompleteFile is created in the data directory by compress-entries while
+// it's writing the entries, and removed once it finishes. If it's cancelled, the
+// size of the entries that it wrote is recorded in the file, so that it can be
+// resumed.
+const incompleteFile = "stage-1-incomplete"
+
+// IsIncomplete returns whether compress-entries was cancelled before it
+// finished writing the entries in dataDir.
+func IsIncomplete(dataDir string) bool {
+	_, err := os.Stat(filepath.Join(dataDir, incompleteFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false
+	}
+	if err != nil {
+		panic(fmt.Sprintf("Error checking whether compress-entries finished: %s", err))
+	}
+
+	return true
+}
+
+// SetIncomplete records whether the entries in dataDir are incomplete. They're
+// incomplete while they're written, so that the ones left behind by a process
+// which was killed aren't used.
+func SetIncomplete(dataDir string, incomplete bool) {
+	path := filepath.Join(dataDir, incompleteFile)
+	if !incomplete {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			panic(err)
+		}
+		return
+	}
+
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		panic(err)
+	}
+}
+
+// SetResumable records that the entries in dataDir are incomplete, but that
+// compress-entries can be resumed after the first entriesSize bytes of them,
+// which the other stage files describe.
+func SetResumable(dataDir string, entriesSize int64) {
+	path := filepath.Join(dataDir, incompleteFile)
+	if err := os.WriteFile(path, []byte(strconv.FormatInt(entriesSize, 10)), 0o644); err != nil {
+		panic(err)
+	}
+}
+
+// ResumeOffset returns the size of the incomplete entries in dataDir which
+// compress-entries can be resumed after, or false if it can't be resumed (e.g.
+// because it was killed before it could record where it stopped).
+func ResumeOffset(dataDir string) (int64, bool) {
+	b, err := os.ReadFile(filepath.Join(dataDir, incompleteFile))
+	if err != nil {
+		panic(fmt.Sprintf("Error checking whether compress-entries finished: %s", err))
+	}
+
+	size, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return size, true
+}
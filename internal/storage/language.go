@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// htmlLangPattern matches the lang attribute of the html element.
+var htmlLangPattern = regexp.MustCompile(`(?is)<html\b[^>]*?\slang\s*=\s*["']?([a-z0-9-]+)`)
+
+// DetectLanguage returns the language code (e.g. "en" or "ja") from the lang
+// attribute of the html element at the start of an HTML entry, or an empty
+// string if it doesn't have one.
+func DetectLanguage(head []byte) string {
+	m := htmlLangPattern.FindSubmatch(head)
+	if m == nil {
+		return ""
+	}
+
+	return strings.ToLower(string(m[1]))
+}
+
+// ReadLanguages returns the language of each entry written by
+// compress-entries (or an empty string for entries without one), in the same
+// order as the entry metadata, or nil if languages weren't detected.
+func ReadLanguages(rdr *bufio.Reader, dataDir string) []string {
+	f, err := os.Open(filepath.Join(dataDir, "stage-1-languages.txt"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		panic(fmt.Sprintf("Error reading languages from compress-entries: %s", err))
+	}
+	defer f.Close()
+
+	rdr.Reset(f)
+
+	numLanguages := readInt(rdr)
+	languages := make([]string, numLanguages)
+
+	for i := range numLanguages {
+		languages[i] = readString(rdr, '\n')
+	}
+
+	return languages
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package storage
+
+import "os"
+
+// lock does nothing, since advisory locks aren't available on this OS, so
+// concurrent builds aren't detected.
+func lock(f *os.File, shared bool) error {
+	return nil
+}
+
+func unlock(f *os.File) {}
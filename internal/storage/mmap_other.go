@@ -0,0 +1,14 @@
+//go:build !unix
+
+package storage
+
+import (
+	"io"
+	"os"
+)
+
+// mapFile reads the contents of f into memory, since it can't be mapped on
+// this OS.
+func mapFile(f *os.File) ([]byte, error) {
+	return io.ReadAll(f)
+}
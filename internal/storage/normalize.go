@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Normalization is a set of transformations which are applied to names so
+// that different spellings of the same title end up as the same key. The same
+// Normalization needs to be used when building a wiki, and when looking up
+// names in it.
+type Normalization uint8
+
+const (
+	// NormalizePercent decodes percent-encoded names (e.g. Foo%20bar).
+	NormalizePercent Normalization = 1 << iota
+	// NormalizeSpaces replaces underscores with spaces.
+	NormalizeSpaces
+	// NormalizeUnderscores replaces spaces with underscores.
+	NormalizeUnderscores
+	// NormalizeNFC converts names to Unicode Normalization Form C.
+	NormalizeNFC
+)
+
+var normalizationNames = []struct {
+	name string
+	n    Normalization
+}{
+	{"percent", NormalizePercent},
+	{"spaces", NormalizeSpaces},
+	{"underscores", NormalizeUnderscores},
+	{"nfc", NormalizeNFC},
+}
+
+// Apply returns the normalized version of name. Percent-decoding happens
+// first, so that encoded underscores and spaces are handled too.
+func (n Normalization) Apply(name string) string {
+	if n&NormalizePercent != 0 {
+		if unescaped, err := url.PathUnescape(name); err == nil {
+			name = unescaped
+		}
+	}
+
+	if n&NormalizeSpaces != 0 {
+		name = strings.ReplaceAll(name, "_", " ")
+	} else if n&NormalizeUnderscores != 0 {
+		name = strings.ReplaceAll(name, " ", "_")
+	}
+
+	if n&NormalizeNFC != 0 {
+		name = norm.NFC.String(name)
+	}
+
+	return name
+}
+
+func (n *Normalization) String() string {
+	var names []string
+	for _, nn := range normalizationNames {
+		if *n&nn.n != 0 {
+			names = append(names, nn.name)
+		}
+	}
+
+	return strings.Join(names, ",")
+}
+
+// Set parses a comma-separated list of normalizations, e.g. "percent,nfc".
+func (n *Normalization) Set(s string) error {
+	var result Normalization
+	for _, name := range strings.Split(s, ",") {
+		found := false
+		for _, nn := range normalizationNames {
+			if nn.name == name {
+				result |= nn.n
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return fmt.Errorf("unknown normalization %q", name)
+		}
+	}
+
+	if result&NormalizeSpaces != 0 && result&NormalizeUnderscores != 0 {
+		return fmt.Errorf("spaces and underscores can't be used together")
+	}
+
+	*n = result
+	return nil
+}
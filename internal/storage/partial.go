@@ -0,0 +1,43 @@
+package storage
+
+import "os"
+
+// partialSuffix is added to the name of an output file while it's written, so
+// that one left behind by a process which was killed can't be mistaken for a
+// complete one, and the output from a previous run is kept until then.
+const partialSuffix = ".partial"
+
+// PartialFile is an output file which is written next to path, and only
+// replaces the file at path once it's committed.
+type PartialFile struct {
+	*os.File
+	path string
+}
+
+// CreatePartial creates the file to write the output for path to.
+func CreatePartial(path string) (*PartialFile, error) {
+	f, err := os.Create(path + partialSuffix)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PartialFile{File: f, path: path}, nil
+}
+
+// Commit closes the file and moves it to its path, replacing the previous
+// output.
+func (f *PartialFile) Commit() error {
+	if err := f.File.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), f.path)
+}
+
+// Discard closes and removes the file, leaving the previous output as it was.
+// It does nothing after Commit, so it can be deferred to clean up after a
+// panic.
+func (f *PartialFile) Discard() {
+	f.File.Close()
+	os.Remove(f.Name())
+}
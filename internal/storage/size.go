@@ -0,0 +1,35 @@
+package storage
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// ReadSizes returns the uncompressed size of each entry written by
+// compress-entries, in the same order as the entry metadata, or nil if sizes
+// weren't stored.
+func ReadSizes(rdr *bufio.Reader, dataDir string) []uint64 {
+	f, err := os.Open(filepath.Join(dataDir, "stage-1-sizes.txt"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		panic(fmt.Sprintf("Error reading sizes from compress-entries: %s", err))
+	}
+	defer f.Close()
+
+	rdr.Reset(f)
+
+	numSizes := readInt(rdr)
+	sizes := make([]uint64, numSizes)
+
+	for i := range numSizes {
+		sizes[i] = readUint64(rdr)
+	}
+
+	return sizes
+}
@@ -0,0 +1,35 @@
+package storage
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// ReadSnippets returns the snippet of each entry written by compress-entries,
+// in the same order as the entry metadata, or nil if snippets weren't
+// extracted.
+func ReadSnippets(rdr *bufio.Reader, dataDir string) []string {
+	f, err := os.Open(filepath.Join(dataDir, "stage-1-snippets.txt"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		panic(fmt.Sprintf("Error reading snippets from compress-entries: %s", err))
+	}
+	defer f.Close()
+
+	rdr.Reset(f)
+
+	numSnippets := readInt(rdr)
+	snippets := make([]string, numSnippets)
+
+	for i := range numSnippets {
+		snippets[i] = readString(rdr, '\n')
+	}
+
+	return snippets
+}
@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rsookram/wiki-builder/internal/s3"
+)
+
+// stageRoot is the directory in the data directory that the stage files of
+// builds with -workdir are kept in.
+const stageRoot = ".wiki-builder"
+
+// lockFile is created in the directory of the stage files, and locked while a
+// command reads or writes them.
+const lockFile = "stage.lock"
+
+// StageDir returns the directory that the stage files for the dump in dataDir
+// are written to and read from. They're in outputDir if it's set (e.g. on
+// scratch storage when dataDir is read-only), or else in dataDir itself. If
+// workdir is set, they're in a directory with that name within it, so that
+// builds with different workdirs (e.g. with different flags) don't overwrite
+// each other's files.
+func StageDir(dataDir string, outputDir string, workdir string) string {
+	if (s3.IsURL(dataDir) || IsArchive(dataDir)) && outputDir == "" {
+		panic("stage files can only be written to a local directory, so pass -output-dir with one for them")
+	}
+
+	if outputDir != "" {
+		dataDir = outputDir
+		if !strings.HasSuffix(dataDir, string(os.PathSeparator)) {
+			dataDir += string(os.PathSeparator)
+		}
+	}
+	if workdir == "" {
+		return dataDir
+	}
+
+	if !filepath.IsLocal(workdir) || strings.ContainsAny(workdir, `/\`) {
+		panic(fmt.Sprintf("invalid -workdir %q: it must be a file name", workdir))
+	}
+
+	return filepath.Join(dataDir, stageRoot, workdir) + string(os.PathSeparator)
+}
+
+// LockStageDir locks the stage files in dir so that another command can't use
+// them at the same time, and returns a function which unlocks them. It panics
+// if another command has them locked. Readers lock them with shared set, which
+// lets other readers use them at the same time, but not writers.
+//
+// The lock is released when the process exits, so it doesn't need to be
+// unlocked before exiting (e.g. after being cancelled).
+func LockStageDir(dir string, shared bool) func() {
+	if !shared {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			panic(err)
+		}
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, lockFile), os.O_RDWR|os.O_CREATE, 0o644)
+	if errors.Is(err, fs.ErrNotExist) {
+		panic(fmt.Sprintf("there aren't any stage files in %s. Run index-fs and compress-entries on it first, with the same -workdir.", dir))
+	}
+	if err != nil {
+		panic(err)
+	}
+
+	if err := lock(f, shared); err != nil {
+		f.Close()
+		panic(fmt.Sprintf("another command is using the stage files in %s (%s). Wait for it to finish, or pass a different -workdir.", dir, err))
+	}
+
+	return func() {
+		unlock(f)
+		f.Close()
+	}
+}
@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// Stream is passed as -output-dir to read the stage files from stdin and write
+// them to stdout instead, so that the stages can be piped into each other
+// (e.g. between containers without a shared volume).
+const Stream = "-"
+
+// TempStageDir creates a temporary directory for stage files which are
+// streamed. The caller removes it when it's done with them.
+func TempStageDir() string {
+	dir, err := os.MkdirTemp("", "wiki-builder-stage-*")
+	if err != nil {
+		panic(err)
+	}
+
+	return dir + string(os.PathSeparator)
+}
+
+// ReceiveStage extracts the stage files from the tar archive read from r
+// (written by SendStage) to a new temporary directory, and returns it.
+func ReceiveStage(r io.Reader) string {
+	dir := TempStageDir()
+
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			os.RemoveAll(dir)
+			panic(fmt.Sprintf("Error reading stage files from stdin: %s", err))
+		}
+
+		if h.Typeflag != tar.TypeReg || !filepath.IsLocal(h.Name) || filepath.Base(h.Name) != h.Name {
+			os.RemoveAll(dir)
+			panic(fmt.Sprintf("Error reading stage files from stdin: unexpected file %q", h.Name))
+		}
+
+		if err := receiveFile(filepath.Join(dir, h.Name), tr); err != nil {
+			os.RemoveAll(dir)
+			panic(fmt.Sprintf("Error reading stage files from stdin: %s", err))
+		}
+	}
+
+	return dir
+}
+
+func receiveFile(path string, r io.Reader) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// SendStage writes the stage files in dir to w as a tar archive. The lock
+// file and any directories (e.g. of shards) are left out.
+func SendStage(w io.Writer, dir string) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		panic(err)
+	}
+	files = slices.DeleteFunc(files, func(f os.DirEntry) bool {
+		return !f.Type().IsRegular() || f.Name() == lockFile
+	})
+
+	tw := tar.NewWriter(w)
+	for _, f := range files {
+		if err := sendFile(tw, filepath.Join(dir, f.Name())); err != nil {
+			panic(fmt.Sprintf("Error writing stage files to stdout: %s", err))
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		panic(fmt.Sprintf("Error writing stage files to stdout: %s", err))
+	}
+}
+
+func sendFile(tw *tar.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	h, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(h); err != nil {
+		return err
+	}
+
+	_, err = io.Copy(tw, f)
+	return err
+}
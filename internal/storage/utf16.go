@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"cmp"
+	"unicode/utf16"
+)
+
+// UTF16Order maps a UTF-16 code unit so that comparing mapped code units
+// orders strings by code point (the same order as comparing them in UTF-8).
+// Comparing code units directly would put supplementary characters (e.g.
+// emoji, and rare CJK characters), which are encoded as surrogate pairs,
+// before U+E000-U+FFFF.
+func UTF16Order(ch uint16) uint16 {
+	switch {
+	case ch >= 0xE000:
+		return ch - 0x800
+	case ch >= 0xD800:
+		return ch + 0x2000
+	default:
+		return ch
+	}
+}
+
+// CompareUTF16 compares a and b in code point order. A string sorts before
+// any longer string that it's a prefix of.
+func CompareUTF16(a, b []uint16) int {
+	for i := range min(len(a), len(b)) {
+		if c := cmp.Compare(UTF16Order(a[i]), UTF16Order(b[i])); c != 0 {
+			return c
+		}
+	}
+
+	return cmp.Compare(len(a), len(b))
+}
+
+// TruncateUTF16 returns at most the first n code units of s, without splitting
+// a surrogate pair.
+func TruncateUTF16(s []uint16, n int) []uint16 {
+	if len(s) <= n {
+		return s
+	}
+
+	if n > 0 && utf16.IsSurrogate(rune(s[n-1])) && s[n-1] < 0xDC00 {
+		// Don't keep the first half of a pair without the second.
+		n--
+	}
+
+	return s[:n]
+}
@@ -0,0 +1,35 @@
+package storage
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// ReadWordCounts returns the number of words in the text of each entry written
+// by compress-entries, in the same order as the entry metadata, or nil if word
+// counts weren't stored.
+func ReadWordCounts(rdr *bufio.Reader, dataDir string) []uint64 {
+	f, err := os.Open(filepath.Join(dataDir, "stage-1-word-counts.txt"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		panic(fmt.Sprintf("Error reading word counts from compress-entries: %s", err))
+	}
+	defer f.Close()
+
+	rdr.Reset(f)
+
+	numCounts := readInt(rdr)
+	counts := make([]uint64, numCounts)
+
+	for i := range numCounts {
+		counts[i] = readUint64(rdr)
+	}
+
+	return counts
+}
@@ -0,0 +1,128 @@
+package transform
+
+import (
+	"bytes"
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Minify is also available as "minify".
+var Minify Transformer = minify{}
+
+func init() {
+	Register("minify", Minify)
+}
+
+// minify removes comments, collapses whitespace in text, and writes attributes
+// in their shortest form. Whitespace is kept as-is in elements where it's
+// significant.
+type minify struct{}
+
+func (minify) Transform(content []byte) ([]byte, error) {
+	var out bytes.Buffer
+	out.Grow(len(content))
+
+	// The number of open elements where whitespace needs to be kept.
+	preserveDepth := 0
+
+	z := html.NewTokenizer(bytes.NewReader(content))
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			if z.Err() == io.EOF {
+				return out.Bytes(), nil
+			}
+			return nil, z.Err()
+		case html.CommentToken:
+			continue
+		case html.TextToken:
+			if preserveDepth > 0 {
+				out.Write(z.Raw())
+			} else {
+				writeCollapsed(&out, z.Raw())
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tok := z.Token()
+			if tt == html.StartTagToken && preservesWhitespace(tok.Data) {
+				preserveDepth++
+			}
+			writeStartTag(&out, tok)
+		case html.EndTagToken:
+			tok := z.Token()
+			if preservesWhitespace(tok.Data) && preserveDepth > 0 {
+				preserveDepth--
+			}
+			out.WriteString("</")
+			out.WriteString(tok.Data)
+			out.WriteByte('>')
+		default:
+			out.Write(z.Raw())
+		}
+	}
+}
+
+func preservesWhitespace(tag string) bool {
+	switch tag {
+	case "pre", "textarea", "script", "style":
+		return true
+	default:
+		return false
+	}
+}
+
+// writeCollapsed writes text with each run of whitespace replaced by a single
+// space.
+func writeCollapsed(out *bytes.Buffer, text []byte) {
+	inSpace := false
+	for _, b := range text {
+		if b == ' ' || b == '\t' || b == '\n' || b == '\r' || b == '\f' {
+			if !inSpace {
+				out.WriteByte(' ')
+			}
+			inSpace = true
+			continue
+		}
+
+		inSpace = false
+		out.WriteByte(b)
+	}
+}
+
+func writeStartTag(out *bytes.Buffer, tok html.Token) {
+	out.WriteByte('<')
+	out.WriteString(tok.Data)
+
+	for _, a := range tok.Attr {
+		out.WriteByte(' ')
+		if a.Namespace != "" {
+			out.WriteString(a.Namespace)
+			out.WriteByte(':')
+		}
+		out.WriteString(a.Key)
+
+		if a.Val == "" {
+			// An attribute without a value has an empty value.
+			continue
+		}
+
+		out.WriteByte('=')
+		val := strings.ReplaceAll(a.Val, "&", "&amp;")
+		// A trailing slash would be mistaken for a self-closing tag.
+		if strings.ContainsAny(val, " \t\n\r\f\"'=<>`") || strings.HasSuffix(val, "/") {
+			out.WriteByte('"')
+			out.WriteString(strings.ReplaceAll(val, `"`, "&quot;"))
+			out.WriteByte('"')
+		} else {
+			out.WriteString(val)
+		}
+	}
+
+	if tok.Type == html.SelfClosingTagToken {
+		out.WriteString("/>")
+		return
+	}
+	out.WriteByte('>')
+}
@@ -0,0 +1,97 @@
+package transform
+
+import (
+	"bytes"
+	"io"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+func init() {
+	Register("edit-links", removeElements(func(tok html.Token) bool {
+		return hasClass(tok, "mw-editsection")
+	}))
+	Register("citations", removeElements(func(tok html.Token) bool {
+		return tok.Data == "sup" && hasClass(tok, "reference")
+	}))
+	Register("navboxes", removeElements(func(tok html.Token) bool {
+		return hasClass(tok, "navbox")
+	}))
+}
+
+// removeElements is a Transformer which removes the elements (including their
+// children) which match.
+type removeElements func(tok html.Token) bool
+
+func (match removeElements) Transform(content []byte) ([]byte, error) {
+	var out bytes.Buffer
+	out.Grow(len(content))
+
+	// The tag of the element being removed, and how deeply nested in elements
+	// with the same tag the tokenizer is.
+	var removing string
+	depth := 0
+
+	z := html.NewTokenizer(bytes.NewReader(content))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if z.Err() == io.EOF {
+				return out.Bytes(), nil
+			}
+			return nil, z.Err()
+		}
+
+		if removing != "" {
+			name, _ := z.TagName()
+			switch {
+			case tt == html.StartTagToken && string(name) == removing:
+				depth++
+			case tt == html.EndTagToken && string(name) == removing:
+				depth--
+				if depth == 0 {
+					removing = ""
+				}
+			}
+			continue
+		}
+
+		if tt == html.StartTagToken || tt == html.SelfClosingTagToken {
+			raw := slices.Clone(z.Raw())
+			tok := z.Token()
+			if match(tok) {
+				if tt == html.StartTagToken && !isVoid(tok.Data) {
+					removing = tok.Data
+					depth = 1
+				}
+				continue
+			}
+
+			out.Write(raw)
+			continue
+		}
+
+		out.Write(z.Raw())
+	}
+}
+
+func hasClass(tok html.Token, class string) bool {
+	for _, a := range tok.Attr {
+		if a.Key == "class" && slices.Contains(strings.Fields(a.Val), class) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isVoid(tag string) bool {
+	switch tag {
+	case "area", "base", "br", "col", "embed", "hr", "img", "input", "link", "meta", "source", "track", "wbr":
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,73 @@
+// Package transform modifies the HTML of entries before they're compressed,
+// e.g. to remove parts of pages which aren't useful offline.
+package transform
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Transformer modifies the HTML of an entry. Transform is called concurrently
+// for different entries, so implementations must be safe for concurrent use.
+type Transformer interface {
+	Transform(html []byte) ([]byte, error)
+}
+
+var registry = map[string]Transformer{}
+
+// Register makes a Transformer available to be selected by name. It panics if
+// name is already registered.
+func Register(name string, t Transformer) {
+	if _, found := registry[name]; found {
+		panic(fmt.Sprintf("transformer %q is already registered", name))
+	}
+
+	registry[name] = t
+}
+
+// Names returns the names of all the registered transformers, sorted.
+func Names() []string {
+	var names []string
+	for name := range registry {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	return names
+}
+
+// Chain is a list of transformers which are applied in order.
+type Chain []Transformer
+
+func (c Chain) Transform(html []byte) ([]byte, error) {
+	for _, t := range c {
+		var err error
+		html, err = t.Transform(html)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return html, nil
+}
+
+// Lookup returns a Chain of the transformers with the given comma-separated
+// names, in order.
+func Lookup(names string) (Chain, error) {
+	var chain Chain
+	if names == "" {
+		return chain, nil
+	}
+
+	for _, name := range strings.Split(names, ",") {
+		t, found := registry[name]
+		if !found {
+			return nil, fmt.Errorf("unknown transformer %q. Available: %s", name, strings.Join(Names(), ", "))
+		}
+
+		chain = append(chain, t)
+	}
+
+	return chain, nil
+}
@@ -0,0 +1,147 @@
+package translit
+
+import (
+	"strings"
+	"unicode"
+)
+
+func init() {
+	Register("kana", kana{})
+}
+
+// kana transliterates names written in hiragana and katakana (along with
+// Latin characters, digits, and punctuation) to romaji. Each name gets a
+// spelling as it would be typed with an IME (e.g. toukyou), and one with long
+// vowels shortened (e.g. tokyo).
+type kana struct{}
+
+func (kana) Transliterate(name string) []string {
+	typed, ok := kanaToRomaji(name, false)
+	if !ok {
+		return nil
+	}
+	short, _ := kanaToRomaji(name, true)
+	short = shortVowels.Replace(short)
+
+	if short == typed {
+		return []string{typed}
+	}
+	return []string{typed, short}
+}
+
+var shortVowels = strings.NewReplacer("ou", "o", "oo", "o", "uu", "u")
+
+// kanaToRomaji converts name to romaji, returning false if name doesn't have
+// any kana, or if it has characters which can't be converted (e.g. kanji). If
+// dropLongVowels is true, the long vowel mark is dropped instead of repeating
+// the vowel before it.
+func kanaToRomaji(name string, dropLongVowels bool) (string, bool) {
+	runes := []rune(name)
+	for i, r := range runes {
+		// Convert katakana to hiragana, since they're at the same offsets.
+		if r >= 'ァ' && r <= 'ヶ' {
+			runes[i] = r - ('ァ' - 'ぁ')
+		}
+	}
+
+	var sb strings.Builder
+	hasKana := false
+	doubleNext := false
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+
+		switch {
+		case r == 'っ':
+			hasKana = true
+			doubleNext = true
+			continue
+		case r == 'ー':
+			if !dropLongVowels {
+				if s := sb.String(); s != "" && strings.ContainsRune("aeiou", rune(s[len(s)-1])) {
+					sb.WriteByte(s[len(s)-1])
+				}
+			}
+			continue
+		}
+
+		syllable, found := "", false
+		if i+1 < len(runes) {
+			syllable, found = kanaDigraphs[string(runes[i:i+2])]
+			if found {
+				i++
+			}
+		}
+		if !found {
+			syllable, found = kanaSyllables[r]
+		}
+
+		if found {
+			hasKana = true
+			if doubleNext {
+				if strings.HasPrefix(syllable, "ch") {
+					sb.WriteByte('t')
+				} else if !strings.ContainsRune("aeiou", rune(syllable[0])) {
+					sb.WriteByte(syllable[0])
+				}
+				doubleNext = false
+			}
+			sb.WriteString(syllable)
+			continue
+		}
+
+		doubleNext = false
+		switch {
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			sb.WriteRune(unicode.ToLower(r))
+		case r == '・' || r == '　':
+			sb.WriteByte(' ')
+		case r < unicode.MaxASCII && (unicode.IsPunct(r) || unicode.IsSpace(r)):
+			sb.WriteRune(r)
+		default:
+			return "", false
+		}
+	}
+
+	return sb.String(), hasKana
+}
+
+var kanaSyllables = map[rune]string{
+	'あ': "a", 'い': "i", 'う': "u", 'え': "e", 'お': "o",
+	'か': "ka", 'き': "ki", 'く': "ku", 'け': "ke", 'こ': "ko",
+	'さ': "sa", 'し': "shi", 'す': "su", 'せ': "se", 'そ': "so",
+	'た': "ta", 'ち': "chi", 'つ': "tsu", 'て': "te", 'と': "to",
+	'な': "na", 'に': "ni", 'ぬ': "nu", 'ね': "ne", 'の': "no",
+	'は': "ha", 'ひ': "hi", 'ふ': "fu", 'へ': "he", 'ほ': "ho",
+	'ま': "ma", 'み': "mi", 'む': "mu", 'め': "me", 'も': "mo",
+	'や': "ya", 'ゆ': "yu", 'よ': "yo",
+	'ら': "ra", 'り': "ri", 'る': "ru", 'れ': "re", 'ろ': "ro",
+	'わ': "wa", 'ゐ': "i", 'ゑ': "e", 'を': "o", 'ん': "n",
+	'が': "ga", 'ぎ': "gi", 'ぐ': "gu", 'げ': "ge", 'ご': "go",
+	'ざ': "za", 'じ': "ji", 'ず': "zu", 'ぜ': "ze", 'ぞ': "zo",
+	'だ': "da", 'ぢ': "ji", 'づ': "zu", 'で': "de", 'ど': "do",
+	'ば': "ba", 'び': "bi", 'ぶ': "bu", 'べ': "be", 'ぼ': "bo",
+	'ぱ': "pa", 'ぴ': "pi", 'ぷ': "pu", 'ぺ': "pe", 'ぽ': "po",
+	'ぁ': "a", 'ぃ': "i", 'ぅ': "u", 'ぇ': "e", 'ぉ': "o",
+	'ゃ': "ya", 'ゅ': "yu", 'ょ': "yo", 'ゎ': "wa",
+	'ゔ': "vu", 'ゕ': "ka", 'ゖ': "ke",
+}
+
+// kanaDigraphs are pairs of kana which are read as one syllable.
+var kanaDigraphs = map[string]string{
+	"きゃ": "kya", "きゅ": "kyu", "きょ": "kyo",
+	"しゃ": "sha", "しゅ": "shu", "しょ": "sho", "しぇ": "she",
+	"ちゃ": "cha", "ちゅ": "chu", "ちょ": "cho", "ちぇ": "che",
+	"にゃ": "nya", "にゅ": "nyu", "にょ": "nyo",
+	"ひゃ": "hya", "ひゅ": "hyu", "ひょ": "hyo",
+	"みゃ": "mya", "みゅ": "myu", "みょ": "myo",
+	"りゃ": "rya", "りゅ": "ryu", "りょ": "ryo",
+	"ぎゃ": "gya", "ぎゅ": "gyu", "ぎょ": "gyo",
+	"じゃ": "ja", "じゅ": "ju", "じょ": "jo", "じぇ": "je",
+	"びゃ": "bya", "びゅ": "byu", "びょ": "byo",
+	"ぴゃ": "pya", "ぴゅ": "pyu", "ぴょ": "pyo",
+	"ふぁ": "fa", "ふぃ": "fi", "ふぇ": "fe", "ふぉ": "fo",
+	"てぃ": "ti", "でぃ": "di", "とぅ": "tu", "どぅ": "du",
+	"うぃ": "wi", "うぇ": "we", "うぉ": "wo",
+	"ゔぁ": "va", "ゔぃ": "vi", "ゔぇ": "ve", "ゔぉ": "vo",
+	"つぁ": "tsa", "つぃ": "tsi", "つぇ": "tse", "つぉ": "tso",
+}
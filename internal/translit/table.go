@@ -0,0 +1,76 @@
+package translit
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Table transliterates names one character at a time, e.g. from hanzi to
+// pinyin. Names with characters which aren't in the table (other than Latin
+// characters, digits, and punctuation) aren't transliterated.
+type Table map[rune]string
+
+// LoadTable reads a table from a file with a character, a tab, and its
+// spelling on each line. Only the first spelling of each character is used.
+// Empty lines and lines starting with # are ignored.
+func LoadTable(path string) (Table, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	table := make(Table)
+	scanner := bufio.NewScanner(f)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		ch, spelling, found := strings.Cut(line, "\t")
+		r, size := utf8.DecodeRuneInString(ch)
+		if !found || size != len(ch) || spelling == "" {
+			return nil, fmt.Errorf("%s:%d: expected a character, a tab, and its spelling", path, lineNum)
+		}
+
+		if _, found := table[r]; !found {
+			table[r] = strings.ToLower(spelling)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return table, nil
+}
+
+func (t Table) Transliterate(name string) []string {
+	var sb strings.Builder
+	found := false
+	for _, r := range name {
+		if spelling, ok := t[r]; ok {
+			sb.WriteString(spelling)
+			found = true
+			continue
+		}
+
+		switch {
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			sb.WriteRune(unicode.ToLower(r))
+		case r < unicode.MaxASCII && (unicode.IsPunct(r) || unicode.IsSpace(r)):
+			sb.WriteRune(r)
+		default:
+			return nil
+		}
+	}
+
+	if !found {
+		return nil
+	}
+	return []string{sb.String()}
+}
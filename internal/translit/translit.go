@@ -0,0 +1,78 @@
+// Package translit transliterates names into Latin script (e.g. kana to
+// romaji), so that they can be searched for from a Latin keyboard.
+package translit
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Transliterator returns the Latin spellings of a name, or none if it can't
+// transliterate it. Spellings are lowercase.
+type Transliterator interface {
+	Transliterate(name string) []string
+}
+
+var registry = map[string]Transliterator{}
+
+// Register makes a Transliterator available to be selected by name. It panics
+// if name is already registered.
+func Register(name string, t Transliterator) {
+	if _, found := registry[name]; found {
+		panic(fmt.Sprintf("transliterator %q is already registered", name))
+	}
+
+	registry[name] = t
+}
+
+// Names returns the names of all the registered transliterators, sorted.
+func Names() []string {
+	var names []string
+	for name := range registry {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	return names
+}
+
+// Set is a list of transliterators whose spellings are combined.
+type Set []Transliterator
+
+func (s Set) Transliterate(name string) []string {
+	var spellings []string
+	for _, t := range s {
+		for _, spelling := range t.Transliterate(name) {
+			if spelling != "" && !slices.Contains(spellings, spelling) {
+				spellings = append(spellings, spelling)
+			}
+		}
+	}
+
+	return spellings
+}
+
+// Lookup returns a Set of the transliterators with the given comma-separated
+// names.
+func Lookup(names string) (Set, error) {
+	var set Set
+	if names == "" {
+		return set, nil
+	}
+
+	for _, name := range strings.Split(names, ",") {
+		t, found := registry[name]
+		if !found {
+			return nil, fmt.Errorf("unknown transliterator %q. Available: %s", name, strings.Join(Names(), ", "))
+		}
+
+		set = append(set, t)
+	}
+
+	return set, nil
+}
+
+// Separator separates a spelling from the name it's for in the keys of the
+// transliteration index.
+const Separator = "\t"
@@ -0,0 +1,64 @@
+// Package words splits names into words, so that they can be searched for by
+// any of their words rather than only by their start.
+package words
+
+import "unicode"
+
+// Separator separates the part of a name starting at a word from the name
+// itself in the keys of the word index.
+const Separator = "\t"
+
+// Suffixes returns the parts of name which start at each of its words after
+// the first, longest first. Words are runs of letters, digits, and marks, so
+// "General_relativity_(physics)" has the suffixes "relativity_(physics)" and
+// "physics)".
+func Suffixes(name string) []string {
+	var suffixes []string
+	prevInWord := true // So that the start of the name isn't a suffix.
+	for i, r := range name {
+		inWord := unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r)
+		if inWord && !prevInWord {
+			suffixes = append(suffixes, name[i:])
+		}
+		prevInWord = inWord
+	}
+
+	return suffixes
+}
+
+// Count returns the number of words in text, for estimating how long it takes
+// to read. Words are runs of letters, digits, and marks, like in Suffixes,
+// apart from in Chinese and Japanese, which aren't written with spaces between
+// words. Their characters count as half a word each instead, since words in
+// them are about two characters long on average.
+func Count(text string) int {
+	words := 0
+	chars := 0
+	prevInWord := false
+	for _, r := range text {
+		// ー (the long vowel mark) isn't in the Katakana script.
+		if unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana) || r == 'ー' {
+			chars++
+			prevInWord = false
+			continue
+		}
+
+		inWord := unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r)
+		if inWord && !prevInWord {
+			words++
+		}
+		prevInWord = inWord
+	}
+
+	return words + (chars+1)/2
+}
+
+// WordsPerMinute is the reading speed used to estimate how long entries take
+// to read.
+const WordsPerMinute = 200
+
+// ReadingMinutes returns the estimated number of minutes it takes to read count
+// words, rounded up.
+func ReadingMinutes(count int64) int64 {
+	return (count + WordsPerMinute - 1) / WordsPerMinute
+}
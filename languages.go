@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"slices"
+
+	"github.com/rsookram/wiki-builder/internal/storage"
+	"github.com/rsookram/wiki-builder/wikifile"
+)
+
+// languageRows are the languages of entries, in the order that they were read.
+type languageRows struct {
+	languages []string
+	offsets   []uint64
+	indexes   []byte
+}
+
+// append adds the languages of the entries from a source whose entries start
+// at baseOffset. Entries without a language get defaultLanguage, unless it's
+// empty. languages can be nil to give every entry defaultLanguage.
+func (l *languageRows) append(entries storage.EntryMetadata, languages []string, defaultLanguage string, baseOffset uint64) {
+	if languages != nil && len(languages) != entries.Len() {
+		panic(fmt.Sprintf("number of languages (%d) doesn't match the number of entries (%d)", len(languages), entries.Len()))
+	}
+
+	for i := range entries.Len() {
+		language := defaultLanguage
+		if languages != nil && languages[i] != "" {
+			language = languages[i]
+		}
+
+		l.add(baseOffset+entries.StartOffset(i), language)
+	}
+}
+
+// add adds the language of the entry at offset, unless it's empty.
+func (l *languageRows) add(offset uint64, language string) {
+	if language == "" {
+		return
+	}
+
+	idx := slices.Index(l.languages, language)
+	if idx < 0 {
+		if len(l.languages) == math.MaxUint8 {
+			panic("too many languages")
+		}
+		if len(language) > math.MaxUint8 {
+			panic(fmt.Sprintf("language is too long: %s", language))
+		}
+
+		idx = len(l.languages)
+		l.languages = append(l.languages, language)
+	}
+
+	l.offsets = append(l.offsets, offset)
+	l.indexes = append(l.indexes, byte(idx))
+}
+
+// encode returns the languages section, with the rows sorted by offset.
+func (l *languageRows) encode(offsetWidth byte) []byte {
+	bb := []byte{byte(len(l.languages))}
+	for _, language := range l.languages {
+		bb = append(bb, byte(len(language)))
+		bb = append(bb, language...)
+	}
+
+	bb = binary.LittleEndian.AppendUint32(bb, uint32(len(l.offsets)))
+	for _, i := range sortedByOffset(l.offsets) {
+		bb = wikifile.AppendOffset(bb, l.offsets[i], offsetWidth)
+		bb = append(bb, l.indexes[i])
+	}
+
+	return bb
+}
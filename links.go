@@ -0,0 +1,191 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"path"
+	"runtime"
+	"slices"
+	"strings"
+
+	nethtml "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+
+	"github.com/rsookram/wiki-builder/internal/reader"
+	"github.com/rsookram/wiki-builder/internal/storage"
+)
+
+// checkLinks resolves the relative links in every entry in the wiki file at
+// wikiPath against its keys (which include redirects), and writes the targets
+// of broken links to w, along with how many times each is linked to. When the
+// wiki was built with anchors, links to sections (e.g. "Title#History") are
+// also broken if the entry doesn't have the anchor.
+func checkLinks(w io.Writer, wikiPath string, normalization storage.Normalization) {
+	wiki, err := reader.OpenWiki(wikiPath)
+	if err != nil {
+		panic(err)
+	}
+
+	keys := make(map[string]struct{})
+	// Each entry is checked once, from the first key which refers to it, since
+	// redirects share the contents of the entry.
+	var entryKeys []reader.SearchResult
+	seen := make(map[int64]bool)
+	err = wiki.Keys(func(r reader.SearchResult) error {
+		keys[r.Key] = struct{}{}
+		if !seen[r.EntryOffset] {
+			seen[r.EntryOffset] = true
+			entryKeys = append(entryKeys, r)
+		}
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	log.Println("Read", len(keys), "keys")
+
+	broken := make(map[string]int)
+	numLinks := 0
+	readEntryLinks(&wiki, entryKeys, func(_ reader.SearchResult, targets []string) {
+		for _, t := range targets {
+			numLinks++
+			name, fragment, _ := strings.Cut(t, "#")
+			key := normalization.Apply(name)
+			if _, found := keys[key]; !found {
+				broken[name]++
+				continue
+			}
+
+			if fragment == "" {
+				continue
+			}
+			if _, err := wiki.LookupSection(key + "#" + fragment); err != nil {
+				if !errors.Is(err, reader.ErrNotFound) {
+					panic(err)
+				}
+				broken[t]++
+			}
+		}
+	})
+
+	brokenTargets := make([]string, 0, len(broken))
+	numBroken := 0
+	for t, count := range broken {
+		brokenTargets = append(brokenTargets, t)
+		numBroken += count
+	}
+	slices.SortFunc(brokenTargets, func(a, b string) int {
+		if c := cmp.Compare(broken[b], broken[a]); c != 0 {
+			return c
+		}
+		return strings.Compare(a, b)
+	})
+
+	for _, t := range brokenTargets {
+		if _, err := fmt.Fprintf(w, "%d\t%s\n", broken[t], t); err != nil {
+			panic(err)
+		}
+	}
+
+	log.Println(numBroken, "of", numLinks, "links are broken, to", len(brokenTargets), "targets")
+}
+
+// readEntryLinks reads the links in the entries for keys on all CPUs, and
+// calls fn with the targets of the links in each (see entryLinks), in the
+// order of keys.
+func readEntryLinks(wiki *reader.Wiki, keys []reader.SearchResult, fn func(k reader.SearchResult, targets []string)) {
+	results := make([]chan []string, len(keys))
+	for i := range results {
+		results[i] = make(chan []string, 1)
+	}
+
+	// Limit parallelism
+	tokens := make(chan struct{}, runtime.NumCPU())
+	for range runtime.NumCPU() {
+		tokens <- struct{}{}
+	}
+
+	go func() {
+		for i, k := range keys {
+			<-tokens
+
+			go func(idx int, k reader.SearchResult) {
+				results[idx] <- entryLinks(wiki, k)
+			}(i, k)
+		}
+	}()
+
+	for i, k := range keys {
+		targets := <-results[i]
+		tokens <- struct{}{}
+
+		fn(k, targets)
+
+		if i%10000 == 0 {
+			log.Println(i+1, "/", len(keys))
+		}
+	}
+
+	log.Println(len(keys), "/", len(keys))
+}
+
+// entryLinks returns the keys that the relative links in the entry for k point
+// to, followed by # and the anchor for links to sections. Links to sections of
+// the entry itself are only included when the wiki has anchors to check them
+// against.
+func entryLinks(wiki *reader.Wiki, k reader.SearchResult) []string {
+	if !storage.IsHTML(wiki.ContentType(k.EntryOffset)) {
+		return nil
+	}
+
+	rdr, err := wiki.EntryAt(k.EntryOffset)
+	if err != nil {
+		panic(err)
+	}
+
+	var targets []string
+	z := nethtml.NewTokenizer(rdr)
+	for {
+		tt := z.Next()
+		if tt == nethtml.ErrorToken {
+			if z.Err() == io.EOF {
+				return targets
+			}
+			panic(fmt.Sprintf("failed to parse %s: %s", k.Key, z.Err()))
+		}
+
+		if tt != nethtml.StartTagToken {
+			continue
+		}
+
+		tok := z.Token()
+		if tok.DataAtom != atom.A {
+			continue
+		}
+
+		for _, a := range tok.Attr {
+			if a.Key != "href" {
+				continue
+			}
+
+			if u, ok := parseRelativeLink(a.Val); ok {
+				// Resolve the link the same way a browser would for the page
+				// served at /<key>.
+				target := strings.TrimPrefix(path.Join("/", path.Dir(k.Key), u.Path), "/")
+				if u.Fragment != "" {
+					target += "#" + u.Fragment
+				}
+				targets = append(targets, target)
+			} else if strings.HasPrefix(a.Val, "#") && wiki.HasAnchors() {
+				if u, err := url.Parse(a.Val); err == nil && u.Fragment != "" {
+					targets = append(targets, k.Key+"#"+u.Fragment)
+				}
+			}
+			break
+		}
+	}
+}
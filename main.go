@@ -1,36 +1,11 @@
-// File format:
+// Input: Paths of directories containing the output of index-fs and
+// compress-entries
 //
-// Note: All multi-byte values are in little endian
-//
-// Entries
-// each entry is zlib compressed, prefixed with its compressed length (u24)
-// and packed
-//
-// Second level index:
-// - The key in each row is compressed using incremental encoding
-// - The row starts with a common prefix length (u8)
-// - Then a length-prefixed (u8) string in UTF-16LE followed by an
-// offset (u40) to an entry relative to the start of the entries
-// u32 for length of second level index in bytes (including this length)
-//
-// First level index:
-// - packed strings: 8 B string, followed by 8 B string...
-// - then packed offsets: u32, u32, ... (used to read the part of the second
-// level where the names start with the associated prefix)
-// - the offset is relative to the start of the second level index (after its
-// length)
-// u16 for length of first level index in bytes (including this length)
-// - the number of entries will be inferred by the size of the index:
-// (size - 2) / 12. Strings are UTF-16LE.
-//
-// Can do a scan (or binary search) on the packed strings to find the index of
-// the correct offset for a query.
-// Then get that offset by index.
+// Output: A wiki file. See package wikifile for the format.
 package main
 
 import (
 	"bufio"
-	"encoding/binary"
 	"flag"
 	"fmt"
 	"io"
@@ -40,15 +15,50 @@ import (
 	"runtime/pprof"
 	"slices"
 	"strings"
+	"time"
 	"unicode/utf16"
 
+	"github.com/rsookram/wiki-builder/internal/dryrun"
+	"github.com/rsookram/wiki-builder/internal/progress"
 	"github.com/rsookram/wiki-builder/internal/storage"
+	"github.com/rsookram/wiki-builder/internal/translit"
+	"github.com/rsookram/wiki-builder/wikifile"
 )
 
 var cpuprofile = flag.String("cpuprofile", "", "write cpu profile to file")
 var memprofile = flag.String("memprofile", "", "write memory profile to this file")
+var stats = flag.Bool("stats", false, "print statistics about the indexes and entries, for tuning the build")
+var entriesOutput = flag.String("entries", "", "write the entries to this file instead, so that the output only contains the indexes and a reference to it")
+var entriesURL = flag.String("entries-url", "", "with -entries, the HTTP(S) URL that the entries file will be served from, to refer to it by instead of its path")
+var duplicates = flag.String("duplicates", "prefer-entry", "what to do with keys which refer to more than one entry: error, keep-first, or prefer-entry (over redirects, then keep the first)")
+var keepRedundantRedirects = flag.Bool("keep-redundant-redirects", false, "don't remove redirects with the same name and entry as another key (e.g. ones to an entry with the same name), leaving them to -duplicates")
+var progressFD = flag.Int("progress-fd", -1, "write progress events as lines of JSON to this file descriptor")
+var controlFD = flag.Int("control-fd", -1, "read commands from this file descriptor; \"cancel\" stops without writing the output")
+var translitNames = flag.String("translit", "", "comma-separated list of transliterators to index Latin spellings of keys with, so that they can be searched for from a Latin keyboard: "+strings.Join(translit.Names(), ", "))
+var translitTable = flag.String("translit-table", "", "a file with a character, a tab, and its Latin spelling on each line (e.g. hanzi and pinyin), to index spellings of keys with")
+var wordIndex = flag.Bool("words", false, "index keys by each of their words, so that they can be searched for by a word other than the first (e.g. \"relativity\" for \"General_relativity\")")
+var bloomBits = flag.Uint("bloom-bits", 0, "the number of bits for each key in a bloom filter over the keys, which lets readers tell that a key is missing without scanning the index (10 gives ~1% false positives; 0 for none)")
+var compressIndex = flag.Bool("compress-index", false, "compress each bucket of the second level index as a zstd frame, so that readers of big wikis read and decompress a few KB for each lookup instead of scanning the uncompressed bucket (needs a reader which supports version 4 of the format)")
+var stageOutputDir = flag.String("output-dir", "", "read the stage files from this directory instead of the data directory (use the same -output-dir as for index-fs and compress-entries), or - to read them from stdin as a tar archive piped from compress-entries. For crawl, write them there instead.")
+var workdir = flag.String("workdir", "", "read the stage files from the directory with this name in .wiki-builder in each data directory (use the same -workdir as for index-fs and compress-entries)")
+var sourceName = flag.String("source", "", "an identifier for the dump being built (e.g. its file name or URL), recorded in the output so that it can be traced back to it. Defaults to the names of the data directories.")
+var language = flag.String("language", "", "the language code (e.g. \"en\") to tag entries with, for the ones without a language from compress-entries -languages, so that queries can be filtered by language")
+var pageviewsPath = flag.String("pageviews", "", "a file with a title, a tab, and its number of page views on each line, to rank search results by and list the most popular entries with")
+var pageRankPath = flag.String("pagerank", "", "a file of links between keys (e.g. from graph) to compute the PageRank of each entry with, to break ties between search results with")
+var mainPage = flag.String("main-page", "", "the key of the entry to show as the landing page (e.g. \"Main_Page\"), which web serves at / instead of an empty search page")
+var titlesPath = flag.String("titles", "", "for export-some and subset, a file with a title to export on each line")
+var keyPrefix = flag.String("prefix", "", "for subset, keep the keys which start with this prefix, for analyze, only include them, and for crawl, only fetch the URLs which start with it (by default, the directory of the start URL)")
+var crawlDelay = flag.Duration("delay", time.Second, "for crawl, the time to wait between requests to the site")
+var crawlConcurrency = flag.Int("concurrency", 2, "for crawl, the number of requests to send at the same time")
+var maxPages = flag.Int("max-pages", 0, "for crawl, stop after fetching this many URLs (0 for no limit)")
+var outputPath = flag.String("o", "", "for graph, write the output to this file instead of stdout")
+var withLinks = flag.Bool("with-links", false, "for export-some, also export the entries which the listed titles link to")
+var dryRun = flag.Bool("dry-run", false, "read the outputs of the earlier stages and report the size of the output file and any problems, without writing it")
+var firstLevelKeyLen = flag.Uint("first-level-key-len", wikifile.DefaultFirstLevelKeyLen, "the number of characters in each key of the first level index (1-8). Fewer characters suit languages like Japanese where titles diverge early.")
 
 func main() {
+	var normalization storage.Normalization
+	flag.Var(&normalization, "normalize", "for check-links, tui, cat, export-some, subset, and crawl, comma-separated list of normalizations to apply to link targets; this should match what index-fs used")
 	flag.Parse()
 	if *cpuprofile != "" {
 		f, err := os.Create(*cpuprofile)
@@ -59,52 +69,229 @@ func main() {
 		defer pprof.StopCPUProfile()
 	}
 
-	dataDir := flag.Arg(0)
-	outputPath := flag.Arg(1)
-	if dataDir == "" || outputPath == "" {
-		panic("missing required arguments")
+	if flag.Arg(0) == "static" {
+		if flag.Arg(1) == "" || flag.Arg(2) == "" {
+			panic("missing required arguments")
+		}
+
+		exportStatic(flag.Arg(1), flag.Arg(2))
+		return
+	}
+
+	if flag.Arg(0) == "export-sqlite" {
+		if flag.Arg(1) == "" || flag.Arg(2) == "" {
+			panic("missing required arguments")
+		}
+
+		exportSQLite(flag.Arg(1), flag.Arg(2))
+		return
+	}
+
+	if flag.Arg(0) == "export-zim" {
+		if flag.Arg(1) == "" || flag.Arg(2) == "" {
+			panic("missing required arguments")
+		}
+
+		exportZIM(flag.Arg(1), flag.Arg(2), flag.Arg(3))
+		return
+	}
+
+	if flag.Arg(0) == "export-some" {
+		if flag.Arg(1) == "" || flag.Arg(2) == "" || *titlesPath == "" {
+			panic("missing required arguments")
+		}
+
+		exportSome(flag.Arg(1), *titlesPath, flag.Arg(2), *withLinks, normalization)
+		return
+	}
+
+	if flag.Arg(0) == "epub" {
+		if flag.Arg(1) == "" || flag.Arg(2) == "" || flag.Arg(3) == "" {
+			panic("missing required arguments")
+		}
+
+		exportEPUB(flag.Arg(1), flag.Arg(2), flag.Arg(3), normalization)
+		return
+	}
+
+	if flag.Arg(0) == "tui" {
+		if flag.Arg(1) == "" {
+			panic("missing required arguments")
+		}
+
+		runTUI(flag.Arg(1), normalization)
+		return
+	}
+
+	if flag.Arg(0) == "inspect" {
+		if flag.Arg(1) == "" {
+			panic("missing required arguments")
+		}
+
+		inspect(os.Stdout, flag.Arg(1))
+		return
+	}
+
+	if flag.Arg(0) == "cat" {
+		if flag.Arg(1) == "" || flag.Arg(2) == "" {
+			panic("missing required arguments")
+		}
+
+		catEntry(os.Stdout, flag.Arg(1), flag.Arg(2), normalization)
+		return
+	}
+
+	if flag.Arg(0) == "text" {
+		if flag.Arg(1) == "" {
+			panic("missing required arguments")
+		}
+
+		writeText(os.Stdout, flag.Arg(1), flag.Arg(2), normalization)
+		return
+	}
+
+	if flag.Arg(0) == "graph" {
+		if flag.Arg(1) == "" {
+			panic("missing required arguments")
+		}
+
+		w := os.Stdout
+		if *outputPath != "" {
+			f, err := os.Create(*outputPath)
+			if err != nil {
+				panic(err)
+			}
+			defer f.Close()
+			w = f
+		}
+
+		writeLinkGraph(w, flag.Arg(1), normalization)
+		return
+	}
+
+	if flag.Arg(0) == "analyze" {
+		if flag.Arg(1) == "" {
+			panic("missing required arguments")
+		}
+
+		analyze(os.Stdout, flag.Arg(1), *keyPrefix)
+		return
+	}
+
+	if flag.Arg(0) == "check-links" {
+		if flag.Arg(1) == "" {
+			panic("missing required arguments")
+		}
+
+		checkLinks(os.Stdout, flag.Arg(1), normalization)
+		return
+	}
+
+	if *firstLevelKeyLen < 1 || *firstLevelKeyLen > wikifile.MaxFirstLevelKeyLen {
+		panic(fmt.Sprintf("first level key length must be between 1 and %d", wikifile.MaxFirstLevelKeyLen))
+	}
+	keyLen := byte(*firstLevelKeyLen)
+
+	policy, ok := parseDuplicatePolicy(*duplicates)
+	if !ok {
+		panic(fmt.Sprintf("unknown duplicate policy: %s", *duplicates))
 	}
 
-	if !strings.HasSuffix(dataDir, string(os.PathSeparator)) {
-		dataDir = dataDir + string(os.PathSeparator)
+	if *entriesURL != "" && *entriesOutput == "" {
+		panic("-entries-url requires -entries")
 	}
 
-	outputFile, err := os.Create(outputPath)
+	transliterator, err := translit.Lookup(*translitNames)
 	if err != nil {
 		panic(err)
 	}
-	defer outputFile.Close()
+	if *translitTable != "" {
+		table, err := translit.LoadTable(*translitTable)
+		if err != nil {
+			panic(err)
+		}
+		transliterator = append(transliterator, table)
+	}
 
-	compressedEntriesFile, err := os.Open(filepath.Join(dataDir, "stage-1-entries.dat"))
-	if err != nil {
-		panic(fmt.Sprintf("Error reading entries from compress-entries: %s", err))
+	if flag.Arg(0) == "subset" {
+		if flag.Arg(1) == "" || flag.Arg(2) == "" || (*titlesPath == "" && *keyPrefix == "") {
+			panic("missing required arguments")
+		}
+
+		subset(flag.Arg(1), flag.Arg(2), *titlesPath, *keyPrefix, keyLen, transliterator, normalization)
+		return
 	}
-	defer compressedEntriesFile.Close()
 
-	output := bufio.NewWriterSize(outputFile, 1024*1024)
+	if flag.Arg(0) == "reindex" {
+		if flag.Arg(1) == "" || flag.Arg(2) == "" {
+			panic("missing required arguments")
+		}
 
-	if _, err := io.Copy(output, compressedEntriesFile); err != nil {
-		panic(err)
+		reindex(flag.Arg(1), flag.Arg(2), keyLen, transliterator)
+		return
 	}
 
-	rdr := bufio.NewReaderSize(nil, 1024*1024)
-	redirects := storage.ReadRedirects(rdr, dataDir)
+	reporter := progress.New("wiki-builder", *progressFD, *controlFD)
+	reporter.Start()
 
-	writtenEntries := storage.ReadEntryMetadata(rdr, dataDir)
+	if flag.Arg(0) == "merge" {
+		outputPath := flag.Arg(1)
+		if outputPath == "" || flag.NArg() < 3 {
+			panic("missing required arguments")
+		}
 
-	secondLevelRows := createSecondLevelIndex(writtenEntries, redirects)
-	log.Println("Finished creating second level index")
+		// Every dump has its own stage files, so they can't all be in the
+		// same directory.
+		if *stageOutputDir != "" {
+			panic("-output-dir can't be used with merge. Pass the output directories of the dumps instead.")
+		}
 
-	firstLevelIndex := writeSecondLevel(output, secondLevelRows)
-	log.Println("Finished creating first level index")
+		var sources []source
+		for _, arg := range flag.Args()[2:] {
+			sources = append(sources, parseMergeSource(arg))
+		}
 
-	writeFirstLevel(output, firstLevelIndex)
-	log.Println("Finished writing indexes")
+		build(outputPath, *entriesOutput, sources, keyLen, policy, transliterator, reporter)
+	} else if flag.Arg(0) == "merge-shards" {
+		outputPath := flag.Arg(1)
+		if outputPath == "" || flag.NArg() < 3 {
+			panic("missing required arguments")
+		}
 
-	if err := output.Flush(); err != nil {
-		panic(err)
+		// Shards have disjoint entries, so they're combined like a merge
+		// without prefixes.
+		var sources []source
+		for _, dir := range flag.Args()[2:] {
+			sources = append(sources, source{dataDir: dir, stageDir: dir})
+		}
+
+		build(outputPath, *entriesOutput, sources, keyLen, policy, transliterator, reporter)
+	} else if flag.Arg(0) == "crawl" {
+		startURL := flag.Arg(1)
+		dataDir := flag.Arg(2)
+		if startURL == "" || dataDir == "" {
+			panic("missing required arguments")
+		}
+
+		crawl(startURL, dataDir, *keyPrefix, *crawlDelay, *crawlConcurrency, *maxPages, normalization, reporter)
+	} else {
+		dataDir := flag.Arg(0)
+		outputPath := flag.Arg(1)
+		if dataDir == "" || outputPath == "" {
+			panic("missing required arguments")
+		}
+
+		src := source{dataDir: dataDir}
+		if *stageOutputDir == storage.Stream {
+			src.stageDir = storage.ReceiveStage(os.Stdin)
+			src.temporary = true
+		}
+
+		build(outputPath, *entriesOutput, []source{src}, keyLen, policy, transliterator, reporter)
 	}
 
+	reporter.Finish()
+
 	if *memprofile != "" {
 		f, err := os.Create(*memprofile)
 		if err != nil {
@@ -116,210 +303,496 @@ func main() {
 	}
 }
 
-type firstLevelIndex struct {
-	keys    []firstLevelIndexKey
-	offsets []uint32
+// source is the output of the earlier stages for a single dump.
+type source struct {
+	dataDir string
+	// stageDir is the directory in dataDir with the stage files, which is
+	// chosen by -workdir unless it's set.
+	stageDir string
+	// temporary is set if stageDir is a temporary directory (e.g. with stage
+	// files read from stdin), which is removed after the build.
+	temporary bool
+	// prefix is prepended to every key from the dump.
+	prefix string
 }
 
-func (i *firstLevelIndex) Append(key firstLevelIndexKey, offset uint32) {
-	i.keys = append(i.keys, key)
-	i.offsets = append(i.offsets, offset)
-}
+// build writes a wiki file to outputPath containing the entries from all the
+// sources. If entriesPath isn't empty, the entries are written there instead
+// and outputPath only contains the indexes. Keys which appear more than once
+// are resolved with policy, and transliterations of keys are indexed with
+// transliterator. If the reporter is cancelled, the build stops and the
+// partial outputs are removed, leaving the previous ones as they were.
+func build(
+	outputPath string,
+	entriesPath string,
+	sources []source,
+	keyLen byte,
+	policy duplicatePolicy,
+	transliterator translit.Set,
+	reporter *progress.Reporter,
+) {
+	// The stage files are locked before the output is created, so that it
+	// isn't truncated if they're in use.
+	for i, src := range sources {
+		if src.temporary {
+			defer os.RemoveAll(src.stageDir)
+		}
 
-func writeFirstLevel(w io.Writer, index firstLevelIndex) {
-	totalSize := uint16((len(index.keys) * (8 + 4)) + 2) // +2 to include the size of `totalSize`
+		if !strings.HasSuffix(src.dataDir, string(os.PathSeparator)) {
+			sources[i].dataDir = src.dataDir + string(os.PathSeparator)
+		}
+		if src.stageDir == "" {
+			sources[i].stageDir = storage.StageDir(sources[i].dataDir, *stageOutputDir, *workdir)
+		} else if !strings.HasSuffix(src.stageDir, string(os.PathSeparator)) {
+			sources[i].stageDir = src.stageDir + string(os.PathSeparator)
+		}
 
-	bb := make([]byte, 0, totalSize)
-	for _, k := range index.keys {
-		bb = k.Append(bb)
-	}
-	for _, offset := range index.offsets {
-		bb = binary.LittleEndian.AppendUint32(bb, offset)
+		// The stage files are only read, so other builds from them can
+		// run at the same time, but not compress-entries.
+		defer storage.LockStageDir(sources[i].stageDir, true)()
 	}
 
-	bb = binary.LittleEndian.AppendUint16(bb, totalSize)
-	if _, err := w.Write(bb); err != nil {
-		panic(err)
+	// The outputs are written to partial files, so that a previous wiki file
+	// isn't truncated until the new one is done.
+	var outputFile io.Writer
+	var outputPartial, entriesPartial *storage.PartialFile
+	var outputSize, entriesOutputSize dryrun.Counter
+	if *dryRun {
+		outputFile = &outputSize
+	} else {
+		f, err := storage.CreatePartial(outputPath)
+		if err != nil {
+			panic(err)
+		}
+		defer f.Discard()
+
+		outputPartial = f
+		outputFile = f
 	}
-}
 
-type secondLevelIndexRow struct {
-	nameUTF16 []uint16
-	offset    uint64
-}
+	checkCancelled := func() {
+		if !reporter.IsCancelled() {
+			return
+		}
 
-func newSecondLevelIndexRow(name []uint16, offset uint64) secondLevelIndexRow {
-	return secondLevelIndexRow{
-		nameUTF16: name,
-		offset:    offset,
+		if outputPartial != nil {
+			outputPartial.Discard()
+		}
+		if entriesPartial != nil {
+			entriesPartial.Discard()
+		}
+		for _, src := range sources {
+			if src.temporary {
+				os.RemoveAll(src.stageDir)
+			}
+		}
+		reporter.Exit()
 	}
-}
 
-func createSecondLevelIndex(entries storage.EntryMetadata, redirects []storage.Redirect) []secondLevelIndexRow {
-	rows := make([]secondLevelIndexRow, 0, entries.Len()+len(redirects))
+	entriesName := ""
+	var entriesOutput *bufio.Writer
+	if entriesPath != "" {
+		if *entriesURL != "" {
+			entriesName = *entriesURL
+		} else {
+			entriesName = relativeEntriesPath(outputPath, entriesPath)
+		}
 
-	for i := range entries.Len() {
-		offset := entries.StartOffset(i)
+		if *dryRun {
+			entriesOutput = bufio.NewWriter(&entriesOutputSize)
+		} else {
+			f, err := storage.CreatePartial(entriesPath)
+			if err != nil {
+				panic(err)
+			}
+			defer f.Discard()
+
+			entriesPartial = f
+			entriesOutput = bufio.NewWriterSize(f, 1024*1024)
+		}
+	}
 
-		rows = append(rows, newSecondLevelIndexRow(entries.Name(i), offset))
+	var st *buildStats
+	if *stats {
+		st = &buildStats{}
 	}
 
-	for _, r := range redirects {
-		i := r.EntryIdx
+	rdr := bufio.NewReaderSize(nil, 1024*1024)
+	var secondLevelRows []wikifile.IndexRow
+	var snippets snippetRows
+	var contentTypes contentTypeRows
+	var hashes hashRows
+	var sizes sizeRows
+	var languages languageRows
+	var anchors anchorRows
+	var wordCounts sizeRows
+	entriesFiles := make([]*os.File, len(sources))
+	entriesSize := uint64(0)
+	// The entries are copied as they are, so every source needs to be
+	// compressed the same way.
+	compressionID := storage.ReadCompression(sources[0].stageDir)
+	for i, src := range sources {
+		if storage.IsIncomplete(src.stageDir) {
+			if _, ok := storage.ResumeOffset(src.stageDir); ok {
+				panic(fmt.Sprintf("compress-entries didn't finish for %s. Run it again with -resume.", src.stageDir))
+			}
+			panic(fmt.Sprintf("compress-entries didn't finish for %s. Run it again.", src.stageDir))
+		}
 
-		offset := entries.StartOffset(i)
+		if storage.ReadCompression(src.stageDir) != compressionID {
+			panic(fmt.Sprintf("the entries in %s were compressed with a different -compression than the ones in %s", src.stageDir, sources[0].stageDir))
+		}
 
-		rows = append(rows, newSecondLevelIndexRow(r.NameUTF16, offset))
-	}
+		f, err := os.Open(filepath.Join(src.stageDir, "stage-1-entries.dat"))
+		if err != nil {
+			panic(fmt.Sprintf("Error reading entries from compress-entries: %s", err))
+		}
+		defer f.Close()
 
-	slices.SortFunc(rows, func(a, b secondLevelIndexRow) int {
-		return slices.Compare(a.nameUTF16, b.nameUTF16)
-	})
+		info, err := f.Stat()
+		if err != nil {
+			panic(err)
+		}
 
-	return rows
-}
+		entriesFiles[i] = f
+
+		redirects := storage.ReadRedirects(rdr, src.stageDir)
 
-func writeSecondLevel(w io.Writer, rows []secondLevelIndexRow) firstLevelIndex {
-	totalSize := uint32(0)
+		writtenEntries := storage.ReadEntryMetadata(rdr, src.stageDir)
+
+		prefix := utf16.Encode([]rune(src.prefix))
+		secondLevelRows = appendSecondLevelRows(secondLevelRows, writtenEntries, redirects, prefix, entriesSize)
+		if st != nil {
+			st.addEntries(writtenEntries, uint64(info.Size()))
+		}
 
-	var firstLevelIndex firstLevelIndex
-	prevFirstLevelKey := newFirstLevelIndexKey(rows[0].nameUTF16)
-	firstLevelIndex.Append(prevFirstLevelKey, 0)
-	countForPrevKey := 0
+		if t := storage.ReadContentTypes(rdr, src.stageDir); t != nil {
+			contentTypes.append(writtenEntries, t, entriesSize)
+		}
 
-	var bb []byte
-	var prevKey []uint16
-	for _, r := range rows {
-		currFirstLevelIndexKey := newFirstLevelIndexKey(r.nameUTF16)
-		shouldCompress := true
-		if countForPrevKey >= 1024 && currFirstLevelIndexKey != prevFirstLevelKey {
-			// We need to be able to jump to this key, so it can't be compressed.
-			shouldCompress = false
-			firstLevelIndex.Append(currFirstLevelIndexKey, totalSize)
-			countForPrevKey = 0
+		if h := storage.ReadHashes(rdr, src.stageDir); h != nil {
+			hashes.append(writtenEntries, h, entriesSize)
 		}
-		prevFirstLevelKey = currFirstLevelIndexKey
-		countForPrevKey++
 
-		numChars := len(r.nameUTF16)
-		if numChars > 127 {
-			panic(fmt.Sprintf(
-				"found a key that is too long: len=%d, %v",
-				numChars,
-				string(utf16.Decode(r.nameUTF16)),
-			))
+		if s := storage.ReadSizes(rdr, src.stageDir); s != nil {
+			sizes.append(writtenEntries, s, entriesSize)
 		}
 
-		// Using incremental encoding / front compression for the key:
-		// https://en.wikipedia.org/wiki/Incremental_encoding
+		if l := storage.ReadLanguages(rdr, src.stageDir); l != nil || *language != "" {
+			languages.append(writtenEntries, l, *language, entriesSize)
+		}
 
-		// Write common prefix length (how many chars to reuse from previous key)
-		commonLen := commonPrefixLen(prevKey, r.nameUTF16)
-		if !shouldCompress {
-			commonLen = 0
+		if s := storage.ReadSnippets(rdr, src.stageDir); s != nil {
+			snippets.append(writtenEntries, s, entriesSize)
 		}
-		bb = append(bb, commonLen)
-		totalSize += 1
 
-		// Write length (in characters) prefix
-		remainingLen := byte(numChars) - commonLen
-		bb = append(bb, remainingLen)
-		totalSize += 1
+		if a := storage.ReadAnchors(rdr, src.stageDir); a != nil {
+			anchors.append(writtenEntries, a, entriesSize)
+		}
 
-		// Write new part of key
-		for _, ch := range r.nameUTF16[commonLen:] {
-			bb = binary.LittleEndian.AppendUint16(bb, ch)
+		if c := storage.ReadWordCounts(rdr, src.stageDir); c != nil {
+			wordCounts.append(writtenEntries, c, entriesSize)
+			if st != nil {
+				st.addWordCounts(c)
+			}
 		}
-		totalSize += uint32(remainingLen) * 2
 
-		prevKey = r.nameUTF16
+		entriesSize += uint64(info.Size())
+
+		checkCancelled()
+		reporter.Update(i+1, len(sources)+2)
+	}
+
+	width := wikifile.OffsetWidth(entriesSize)
+
+	var snippetsSection []byte
+	if len(snippets.offsets) > 0 {
+		snippetsSection = snippets.encode(width)
+	}
+
+	var contentTypesSection []byte
+	if len(contentTypes.offsets) > 0 {
+		contentTypesSection = contentTypes.encode(width)
+	}
+
+	var hashesSection []byte
+	if len(hashes.offsets) > 0 {
+		hashesSection = hashes.encode(width)
+	}
+
+	var sizesSection []byte
+	if len(sizes.offsets) > 0 {
+		sizesSection = sizes.encode(width)
+	}
+
+	var languagesSection []byte
+	if len(languages.offsets) > 0 {
+		languagesSection = languages.encode(width)
+	}
+
+	var anchorsSection []byte
+	if len(anchors.offsets) > 0 {
+		anchorsSection = anchors.encode(width)
+	}
+
+	var wordCountsSection []byte
+	if len(wordCounts.offsets) > 0 {
+		wordCountsSection = wordCounts.encode(width)
+	}
+
+	wikifile.SortIndexRows(secondLevelRows)
+	if !*keepRedundantRedirects {
+		secondLevelRows = pruneRedundantRows(secondLevelRows)
+	}
+	secondLevelRows = removeDuplicateRows(secondLevelRows, policy)
+	log.Println("Finished creating second level index")
 
-		// Write offset
-		bb = appendOffset(bb, r.offset)
-		totalSize += 5
+	var translitSection []byte
+	if len(transliterator) > 0 {
+		translitSection = encodeTranslitIndex(secondLevelRows, transliterator, width, keyLen)
+	}
 
-		if _, err := w.Write(bb); err != nil {
+	var wordsSection []byte
+	if *wordIndex {
+		wordsSection = encodeWordIndex(secondLevelRows, width, keyLen)
+	}
+
+	var bloomSection []byte
+	if *bloomBits > 0 {
+		bloomSection = encodeBloomFilter(secondLevelRows, *bloomBits)
+	}
+
+	var popularitySection []byte
+	if *pageviewsPath != "" {
+		popularitySection = encodePopularity(secondLevelRows, entryWeights(secondLevelRows, readPageviews(*pageviewsPath)), width)
+	}
+
+	var pageRanksSection []byte
+	if *pageRankPath != "" {
+		pageRanksSection = encodePageRanks(pageRanks(secondLevelRows, readLinkGraph(*pageRankPath)), width)
+	}
+
+	longKeysSection := wikifile.EncodeLongKeys(secondLevelRows)
+	redirectsSection := wikifile.EncodeRedirects(secondLevelRows, keyLen)
+
+	output := bufio.NewWriterSize(outputFile, 1024*1024)
+
+	header := wikifile.Header{
+		OffsetWidth:      width,
+		FirstLevelKeyLen: keyLen,
+		EntriesFile:      entriesName,
+		ContentTypesLen:  uint64(len(contentTypesSection)),
+		SnippetsLen:      uint64(len(snippetsSection)),
+		TranslitLen:      uint64(len(translitSection)),
+		HashesLen:        uint64(len(hashesSection)),
+		WordsLen:         uint64(len(wordsSection)),
+		BloomLen:         uint64(len(bloomSection)),
+		LongKeysLen:      uint64(len(longKeysSection)),
+		SizesLen:         uint64(len(sizesSection)),
+		LanguagesLen:     uint64(len(languagesSection)),
+		PopularityLen:    uint64(len(popularitySection)),
+		AnchorsLen:       uint64(len(anchorsSection)),
+		WordCountsLen:    uint64(len(wordCountsSection)),
+		PageRanksLen:     uint64(len(pageRanksSection)),
+		RedirectsLen:     uint64(len(redirectsSection)),
+		MainPage:         checkMainPage(secondLevelRows, *mainPage),
+		Compression:      compressionID,
+		CompressedIndex:  *compressIndex,
+	}
+	header = provenance(header, *sourceName, sources, entriesSize)
+	if err := wikifile.WriteHeader(output, header); err != nil {
+		panic(err)
+	}
+
+	if entriesOutput == nil {
+		entriesOutput = output
+	}
+
+	for _, f := range entriesFiles {
+		if *dryRun {
+			// Only the size matters, so skip copying the entries.
+			info, err := f.Stat()
+			if err != nil {
+				panic(err)
+			}
+			if err := entriesOutput.Flush(); err != nil {
+				panic(err)
+			}
+			if entriesPath != "" {
+				entriesOutputSize.N += info.Size()
+			} else {
+				outputSize.N += info.Size()
+			}
+			continue
+		}
+
+		if _, err := io.Copy(entriesOutput, f); err != nil {
 			panic(err)
 		}
-		bb = bb[:0]
+		checkCancelled()
 	}
+	reporter.Update(len(sources)+1, len(sources)+2)
 
-	totalSize += 4 // Include the size of `totalSize`
-	bb = binary.LittleEndian.AppendUint32(bb, totalSize)
-	if _, err := w.Write(bb); err != nil {
+	if _, err := output.Write(contentTypesSection); err != nil {
 		panic(err)
 	}
 
-	return firstLevelIndex
-}
+	if _, err := output.Write(snippetsSection); err != nil {
+		panic(err)
+	}
 
-func commonPrefixLen(lhs, rhs []uint16) byte {
-	maxPossible := byte(min(len(lhs), len(rhs)))
-	for i := range maxPossible {
-		if lhs[i] != rhs[i] {
-			return i
-		}
+	if _, err := output.Write(translitSection); err != nil {
+		panic(err)
 	}
 
-	return maxPossible
-}
+	if _, err := output.Write(hashesSection); err != nil {
+		panic(err)
+	}
 
-func appendOffset(bb []byte, v uint64) []byte {
-	return append(bb,
-		byte(v),
-		byte(v>>8),
-		byte(v>>16),
-		byte(v>>24),
-		byte(v>>32),
-	)
-}
+	if _, err := output.Write(wordsSection); err != nil {
+		panic(err)
+	}
 
-type firstLevelIndexKey struct {
-	ch0 uint16
-	ch1 uint16
-	ch2 uint16
-	ch3 uint16
-}
+	if _, err := output.Write(bloomSection); err != nil {
+		panic(err)
+	}
+
+	if _, err := output.Write(longKeysSection); err != nil {
+		panic(err)
+	}
+
+	if _, err := output.Write(sizesSection); err != nil {
+		panic(err)
+	}
+
+	if _, err := output.Write(languagesSection); err != nil {
+		panic(err)
+	}
+
+	if _, err := output.Write(popularitySection); err != nil {
+		panic(err)
+	}
+
+	if _, err := output.Write(anchorsSection); err != nil {
+		panic(err)
+	}
+
+	if _, err := output.Write(wordCountsSection); err != nil {
+		panic(err)
+	}
+
+	if _, err := output.Write(pageRanksSection); err != nil {
+		panic(err)
+	}
+
+	if _, err := output.Write(redirectsSection); err != nil {
+		panic(err)
+	}
 
-func newFirstLevelIndexKey(chars []uint16) firstLevelIndexKey {
-	var p firstLevelIndexKey
+	checkCancelled()
 
-	p.ch0 = chars[0]
-	if len(chars) > 1 {
-		p.ch1 = chars[1]
+	var indexStats *wikifile.IndexStats
+	if st != nil {
+		indexStats = &st.index
 	}
-	if len(chars) > 2 {
-		p.ch2 = chars[2]
+	writeIndexes := wikifile.WriteIndexes
+	if *compressIndex {
+		writeIndexes = wikifile.WriteCompressedIndexes
+	}
+	if err := writeIndexes(output, secondLevelRows, width, keyLen, indexStats); err != nil {
+		panic(err)
 	}
-	if len(chars) > 3 {
-		p.ch3 = chars[3]
+	log.Println("Finished writing indexes")
+
+	if st != nil {
+		st.print(os.Stdout)
 	}
 
-	return p
+	if err := output.Flush(); err != nil {
+		panic(err)
+	}
+	if err := entriesOutput.Flush(); err != nil {
+		panic(err)
+	}
+
+	// The entries are moved first, so that the wiki file never refers to
+	// entries which aren't there.
+	if entriesPartial != nil {
+		if err := entriesPartial.Commit(); err != nil {
+			panic(err)
+		}
+	}
+	if outputPartial != nil {
+		if err := outputPartial.Commit(); err != nil {
+			panic(err)
+		}
+	}
+	reporter.Update(len(sources)+2, len(sources)+2)
+
+	if *dryRun {
+		dryrun.Report(outputPath, outputSize.N)
+		if entriesPath != "" {
+			dryrun.Report(entriesPath, entriesOutputSize.N)
+		}
+	}
 }
 
-func (p firstLevelIndexKey) Append(bb []byte) []byte {
-	bb = binary.LittleEndian.AppendUint16(bb, p.ch0)
-	bb = binary.LittleEndian.AppendUint16(bb, p.ch1)
-	bb = binary.LittleEndian.AppendUint16(bb, p.ch2)
-	bb = binary.LittleEndian.AppendUint16(bb, p.ch3)
+// relativeEntriesPath returns the path to store in the header to refer to the
+// entries file at entriesPath from the index file at outputPath.
+func relativeEntriesPath(outputPath string, entriesPath string) string {
+	outputDir, err := filepath.Abs(filepath.Dir(outputPath))
+	if err != nil {
+		panic(err)
+	}
+	entriesPath, err = filepath.Abs(entriesPath)
+	if err != nil {
+		panic(err)
+	}
+
+	rel, err := filepath.Rel(outputDir, entriesPath)
+	if err != nil {
+		panic(err)
+	}
 
-	return bb
+	return filepath.ToSlash(rel)
 }
 
-func (p firstLevelIndexKey) String() string {
-	chars := []uint16{p.ch0, p.ch1, p.ch2, p.ch3}
+// appendSecondLevelRows appends a row to rows for every entry and redirect.
+// prefix is prepended to their names, and baseOffset is added to their
+// offsets.
+func appendSecondLevelRows(
+	rows []wikifile.IndexRow,
+	entries storage.EntryMetadata,
+	redirects []storage.Redirect,
+	prefix []uint16,
+	baseOffset uint64,
+) []wikifile.IndexRow {
+	rows = slices.Grow(rows, entries.Len()+len(redirects))
+
+	numSkipped := 0
+	appendRow := func(name []uint16, offset uint64, redirect bool) {
+		if len(prefix) > 0 {
+			name = slices.Concat(prefix, name)
+			if len(name) > wikifile.MaxLongKeyLen {
+				numSkipped++
+				return
+			}
+		}
 
-	length := 1
-	if p.ch1 != 0 {
-		length++
+		rows = append(rows, wikifile.IndexRow{Name: name, Offset: baseOffset + offset, Redirect: redirect})
 	}
-	if p.ch2 != 0 {
-		length++
+
+	for i := range entries.Len() {
+		appendRow(entries.Name(i), entries.StartOffset(i), false)
 	}
-	if p.ch3 != 0 {
-		length++
+
+	for _, r := range redirects {
+		appendRow(r.NameUTF16, entries.StartOffset(r.EntryIdx), true)
 	}
 
-	return string(utf16.Decode(chars[:length]))
+	if numSkipped > 0 {
+		log.Println("Skipped", numSkipped, "keys which are too long with the prefix", string(utf16.Decode(prefix)))
+	}
+
+	return rows
 }
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"unicode/utf16"
+
+	"github.com/rsookram/wiki-builder/wikifile"
+)
+
+// checkMainPage returns key, after checking that it's one of the keys of rows,
+// unless it's empty.
+func checkMainPage(rows []wikifile.IndexRow, key string) string {
+	if key != "" && !hasKey(rows, key) {
+		panic(fmt.Sprintf("the main page %q isn't one of the keys", key))
+	}
+
+	return key
+}
+
+// hasKey returns whether key is one of the keys of rows.
+func hasKey(rows []wikifile.IndexRow, key string) bool {
+	name := utf16.Encode([]rune(key))
+	return slices.ContainsFunc(rows, func(r wikifile.IndexRow) bool {
+		return slices.Equal(r.Name, name)
+	})
+}
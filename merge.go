@@ -0,0 +1,17 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// parseMergeSource parses an argument of the form prefix=dataDir, e.g.
+// "dict:=wiktionary-dump/".
+func parseMergeSource(arg string) source {
+	prefix, dataDir, found := strings.Cut(arg, "=")
+	if !found || dataDir == "" {
+		panic(fmt.Sprintf("expected prefix=dataDir, got %q", arg))
+	}
+
+	return source{dataDir: dataDir, prefix: prefix}
+}
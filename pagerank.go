@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/rsookram/wiki-builder/wikifile"
+)
+
+// pageRankDamping is the probability of following a link rather than jumping
+// to a random entry.
+const pageRankDamping = 0.85
+
+// pageRankIterations is the number of times that scores are propagated along
+// the links, which is enough for them to converge for ranking.
+const pageRankIterations = 50
+
+// pageRankScale is what scores are multiplied by to store them as integers.
+// It's the score of an entry that's as important as the average one.
+const pageRankScale = 1000
+
+// readLinkGraph reads a file of links from graph, with the key of the entry
+// that links, a tab, and the key of the entry that it links to on each line.
+func readLinkGraph(path string) [][2]string {
+	f, err := os.Open(path)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+
+	var links [][2]string
+	scanner := bufio.NewScanner(f)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		from, to, found := strings.Cut(line, "\t")
+		if !found {
+			panic(fmt.Sprintf("%s:%d: expected a key, a tab, and the key that it links to", path, lineNum))
+		}
+
+		links = append(links, [2]string{from, to})
+	}
+	if err := scanner.Err(); err != nil {
+		panic(fmt.Sprintf("failed to read %s: %s", path, err))
+	}
+
+	return links
+}
+
+// pageRanks returns the PageRank of each entry that rows refer to, scaled by
+// pageRankScale, given the links between their keys. Links from or to keys
+// which aren't in rows are ignored, and links through redirects count as links
+// to the entry that they redirect to.
+func pageRanks(rows []wikifile.IndexRow, links [][2]string) map[uint64]uint64 {
+	offsets := make(map[string]uint64, len(rows))
+	nodes := make(map[uint64]int)
+	for _, r := range rows {
+		offsets[string(utf16.Decode(r.Name))] = r.Offset
+		if _, found := nodes[r.Offset]; !found {
+			nodes[r.Offset] = len(nodes)
+		}
+	}
+
+	n := len(nodes)
+	if n == 0 {
+		return nil
+	}
+
+	// Each link is only counted once, so that linking to an entry several
+	// times doesn't give it more weight.
+	seen := make(map[[2]int]bool)
+	outLinks := make([][]int, n)
+	numMatched := 0
+	for _, l := range links {
+		from, fromFound := offsets[l[0]]
+		to, toFound := offsets[l[1]]
+		if !fromFound || !toFound || from == to {
+			continue
+		}
+
+		edge := [2]int{nodes[from], nodes[to]}
+		if seen[edge] {
+			continue
+		}
+		seen[edge] = true
+		outLinks[edge[0]] = append(outLinks[edge[0]], edge[1])
+		numMatched++
+	}
+	log.Println("Matched", numMatched, "of", len(links), "links to entries")
+
+	ranks := make([]float64, n)
+	next := make([]float64, n)
+	for i := range ranks {
+		ranks[i] = 1 / float64(n)
+	}
+
+	for range pageRankIterations {
+		// The rank of entries without links is spread over every entry, as if
+		// they linked to all of them.
+		dangling := 0.0
+		for i, out := range outLinks {
+			if len(out) == 0 {
+				dangling += ranks[i]
+			}
+		}
+
+		base := (1-pageRankDamping)/float64(n) + pageRankDamping*dangling/float64(n)
+		for i := range next {
+			next[i] = base
+		}
+		for i, out := range outLinks {
+			share := pageRankDamping * ranks[i] / float64(len(out))
+			for _, j := range out {
+				next[j] += share
+			}
+		}
+
+		ranks, next = next, ranks
+	}
+
+	scores := make(map[uint64]uint64, n)
+	for offset, i := range nodes {
+		scores[offset] = uint64(min(math.Round(ranks[i]*float64(n)*pageRankScale), math.MaxUint32))
+	}
+
+	return scores
+}
+
+// encodePageRanks returns the page ranks section for the entries with scores.
+func encodePageRanks(scores map[uint64]uint64, offsetWidth byte) []byte {
+	var rows sizeRows
+	for offset, score := range scores {
+		rows.add(offset, score)
+	}
+
+	return rows.encode(offsetWidth)
+}
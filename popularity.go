@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/rsookram/wiki-builder/wikifile"
+)
+
+// numPopularKeys is the number of the most popular entries whose keys are
+// listed in the popularity section, e.g. for the index page of web.
+const numPopularKeys = 100
+
+// readPageviews reads a file with a title, a tab, and a number of page views
+// on each line, and returns the number of page views for each title. Titles
+// which are listed more than once have the sum of their page views.
+func readPageviews(path string) map[string]uint64 {
+	f, err := os.Open(path)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+
+	views := make(map[string]uint64)
+	scanner := bufio.NewScanner(f)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		title, countStr, found := strings.Cut(line, "\t")
+		if !found {
+			panic(fmt.Sprintf("%s:%d: expected a title, a tab, and a number of page views", path, lineNum))
+		}
+		count, err := strconv.ParseUint(strings.TrimSpace(countStr), 10, 64)
+		if err != nil {
+			panic(fmt.Sprintf("%s:%d: invalid number of page views: %s", path, lineNum, err))
+		}
+
+		views[title] += count
+	}
+	if err := scanner.Err(); err != nil {
+		panic(fmt.Sprintf("failed to read %s: %s", path, err))
+	}
+
+	return views
+}
+
+// entryWeights returns the weight of each entry that rows refer to, which is
+// the sum of the page views of its keys (including redirects), for the
+// entries with any.
+func entryWeights(rows []wikifile.IndexRow, views map[string]uint64) map[uint64]uint64 {
+	weights := make(map[uint64]uint64)
+	numMatched := 0
+	for _, r := range rows {
+		count, found := views[string(utf16.Decode(r.Name))]
+		if !found {
+			continue
+		}
+
+		weights[r.Offset] += count
+		numMatched++
+	}
+	log.Println("Matched page views to", numMatched, "of", len(views), "titles")
+
+	return weights
+}
+
+// encodePopularity returns the popularity section for the entries that rows
+// refer to, with their weights.
+func encodePopularity(rows []wikifile.IndexRow, weights map[uint64]uint64, offsetWidth byte) []byte {
+	offsets := make([]uint64, 0, len(weights))
+	for offset := range weights {
+		offsets = append(offsets, offset)
+	}
+	slices.Sort(offsets)
+
+	bb := make([]byte, 0, 4+len(offsets)*(int(offsetWidth)+4))
+	bb = binary.LittleEndian.AppendUint32(bb, uint32(len(offsets)))
+	for _, offset := range offsets {
+		bb = wikifile.AppendOffset(bb, offset, offsetWidth)
+		bb = binary.LittleEndian.AppendUint32(bb, uint32(min(weights[offset], math.MaxUint32)))
+	}
+
+	// Each popular entry is listed by its key, rather than by a redirect to
+	// it. Keys which don't fit in the length prefix are left out.
+	var popular []wikifile.IndexRow
+	for _, r := range rows {
+		if !r.Redirect && weights[r.Offset] > 0 && len(string(utf16.Decode(r.Name))) <= math.MaxUint16 {
+			popular = append(popular, r)
+		}
+	}
+	slices.SortStableFunc(popular, func(a, b wikifile.IndexRow) int {
+		return cmp.Compare(weights[b.Offset], weights[a.Offset])
+	})
+	popular = popular[:min(len(popular), numPopularKeys)]
+
+	bb = binary.LittleEndian.AppendUint16(bb, uint16(len(popular)))
+	for _, r := range popular {
+		key := string(utf16.Decode(r.Name))
+		bb = wikifile.AppendOffset(bb, r.Offset, offsetWidth)
+		bb = binary.LittleEndian.AppendUint16(bb, uint16(len(key)))
+		bb = append(bb, key...)
+	}
+
+	return bb
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rsookram/wiki-builder/wikifile"
+)
+
+// provenance returns the header with the provenance of a build from sources
+// filled in. Builds are reproducible when SOURCE_DATE_EPOCH is set (see
+// https://reproducible-builds.org/specs/source-date-epoch/): it's used as the
+// build time, and the build ID is derived from it instead of being random.
+func provenance(h wikifile.Header, source string, sources []source, entriesSize uint64) wikifile.Header {
+	if source == "" {
+		names := make([]string, 0, len(sources))
+		for _, src := range sources {
+			names = append(names, filepath.Base(filepath.Clean(src.dataDir)))
+		}
+		source = joinSourceNames(names)
+	}
+
+	h.Source = source
+	h.ToolVersion = toolVersion()
+	h.BuildID = make([]byte, wikifile.BuildIDLen)
+
+	if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" {
+		secs, err := strconv.ParseInt(epoch, 10, 64)
+		if err != nil {
+			panic(fmt.Sprintf("invalid SOURCE_DATE_EPOCH: %s", epoch))
+		}
+		h.BuildTime = time.Unix(secs, 0)
+
+		sum := sha256.New()
+		fmt.Fprintf(sum, "%s\x00%s\x00%d\x00%d", h.Source, h.ToolVersion, secs, entriesSize)
+		copy(h.BuildID, sum.Sum(nil))
+		// Mark it as a custom UUID (version 8).
+		h.BuildID[6] = h.BuildID[6]&0x0f | 0x80
+	} else {
+		h.BuildTime = time.Now()
+
+		rand.Read(h.BuildID)
+		// Mark it as a random UUID (version 4).
+		h.BuildID[6] = h.BuildID[6]&0x0f | 0x40
+	}
+	// RFC 9562 variant
+	h.BuildID[8] = h.BuildID[8]&0x3f | 0x80
+
+	return h
+}
+
+// joinSourceNames joins the names of the data directories of a build with
+// commas. If there are too many to fit in the header, the ones that don't fit
+// are only counted.
+func joinSourceNames(names []string) string {
+	source := strings.Join(names, ",")
+	for n := len(names) - 1; len(source) > math.MaxUint8 && n > 0; n-- {
+		source = fmt.Sprintf("%s,+%d more", strings.Join(names[:n], ","), len(names)-n)
+	}
+	if len(source) > math.MaxUint8 {
+		// Even the first name is too long.
+		source = fmt.Sprintf("%d dumps", len(names))
+	}
+
+	return source
+}
+
+// toolVersion returns the version of wiki-builder, from the version control
+// information that Go stamps into binaries.
+func toolVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "wiki-builder (unknown)"
+	}
+
+	return fmt.Sprintf("wiki-builder %s (%s)", info.Main.Version, info.GoVersion)
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"log"
+
+	"github.com/rsookram/wiki-builder/internal/reader"
+	"github.com/rsookram/wiki-builder/internal/translit"
+)
+
+// reindex writes a copy of the wiki file at wikiPath to outputPath with its
+// indexes rebuilt from its keys, e.g. to change the first level key length or
+// add a transliteration index without building it again. Since every key is
+// kept, the entries are copied as they are, at the same offsets (apart from
+// any which no key refers to).
+func reindex(wikiPath string, outputPath string, keyLen byte, transliterator translit.Set) {
+	wiki, err := reader.OpenWiki(wikiPath)
+	if err != nil {
+		panic(err)
+	}
+	defer wiki.Close()
+
+	var keys []reader.SearchResult
+	err = wiki.Keys(func(r reader.SearchResult) error {
+		keys = append(keys, r)
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	log.Println("Read", len(keys), "keys")
+
+	copyKeys(&wiki, wikiPath, outputPath, keys, keys, keyLen, transliterator)
+}
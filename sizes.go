@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/rsookram/wiki-builder/internal/storage"
+	"github.com/rsookram/wiki-builder/wikifile"
+)
+
+// sizeRows are the uncompressed sizes of entries, in the order that they were
+// read.
+type sizeRows struct {
+	offsets []uint64
+	sizes   []uint64
+}
+
+// append adds the sizes of the entries from a source whose entries start at
+// baseOffset.
+func (s *sizeRows) append(entries storage.EntryMetadata, sizes []uint64, baseOffset uint64) {
+	if len(sizes) != entries.Len() {
+		panic(fmt.Sprintf("number of sizes (%d) doesn't match the number of entries (%d)", len(sizes), entries.Len()))
+	}
+
+	for i, size := range sizes {
+		s.add(baseOffset+entries.StartOffset(i), size)
+	}
+}
+
+// add adds the uncompressed size of the entry at offset.
+func (s *sizeRows) add(offset uint64, size uint64) {
+	s.offsets = append(s.offsets, offset)
+	s.sizes = append(s.sizes, size)
+}
+
+// encode returns the entry sizes section, with the rows sorted by offset.
+// Sizes which don't fit in a u32 are stored as the largest one that does, which
+// is still enough to tell that the entry is long.
+func (s *sizeRows) encode(offsetWidth byte) []byte {
+	bb := make([]byte, 0, 4+len(s.offsets)*(int(offsetWidth)+4))
+	bb = binary.LittleEndian.AppendUint32(bb, uint32(len(s.offsets)))
+	for _, i := range sortedByOffset(s.offsets) {
+		bb = wikifile.AppendOffset(bb, s.offsets[i], offsetWidth)
+		bb = binary.LittleEndian.AppendUint32(bb, uint32(min(s.sizes[i], math.MaxUint32)))
+	}
+
+	return bb
+}
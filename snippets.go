@@ -0,0 +1,83 @@
+package main
+
+import (
+	"cmp"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"slices"
+
+	"github.com/rsookram/wiki-builder/internal/storage"
+	"github.com/rsookram/wiki-builder/wikifile"
+)
+
+// snippetRows are the snippets of entries, in the order that they were read.
+type snippetRows struct {
+	offsets []uint64
+	ends    []int
+	text    []byte
+}
+
+// append adds the snippets of the entries from a source whose entries start at
+// baseOffset.
+func (s *snippetRows) append(entries storage.EntryMetadata, snippets []string, baseOffset uint64) {
+	if len(snippets) != entries.Len() {
+		panic(fmt.Sprintf("number of snippets (%d) doesn't match the number of entries (%d)", len(snippets), entries.Len()))
+	}
+
+	for i, snippet := range snippets {
+		s.add(baseOffset+entries.StartOffset(i), snippet)
+	}
+}
+
+// add adds the snippet of the entry at offset.
+func (s *snippetRows) add(offset uint64, snippet string) {
+	s.text = append(s.text, snippet...)
+	if len(s.text) > math.MaxUint32 {
+		panic("snippets are too big")
+	}
+
+	s.offsets = append(s.offsets, offset)
+	s.ends = append(s.ends, len(s.text))
+}
+
+// encode returns the snippets section, with the rows sorted by offset since
+// entries aren't necessarily written in the same order as their metadata.
+func (s *snippetRows) encode(offsetWidth byte) []byte {
+	order := sortedByOffset(s.offsets)
+
+	bb := make([]byte, 0, 4+len(s.offsets)*(int(offsetWidth)+4)+len(s.text))
+	bb = binary.LittleEndian.AppendUint32(bb, uint32(len(s.offsets)))
+
+	text := make([]byte, 0, len(s.text))
+	for _, i := range order {
+		text = append(text, s.snippet(i)...)
+
+		bb = wikifile.AppendOffset(bb, s.offsets[i], offsetWidth)
+		bb = binary.LittleEndian.AppendUint32(bb, uint32(len(text)))
+	}
+
+	return append(bb, text...)
+}
+
+func (s *snippetRows) snippet(i int) []byte {
+	start := 0
+	if i > 0 {
+		start = s.ends[i-1]
+	}
+
+	return s.text[start:s.ends[i]]
+}
+
+// sortedByOffset returns the indexes of offsets in the order of the offsets.
+func sortedByOffset(offsets []uint64) []int {
+	order := make([]int, len(offsets))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortFunc(order, func(a, b int) int {
+		return cmp.Compare(offsets[a], offsets[b])
+	})
+
+	return order
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+
+	_ "modernc.org/sqlite"
+
+	"github.com/rsookram/wiki-builder/internal/compression"
+	"github.com/rsookram/wiki-builder/internal/reader"
+)
+
+// sqliteSchema is the schema of the database written by exportSQLite. The data
+// of each entry is zlib compressed, like it is in the wiki file.
+const sqliteSchema = `
+CREATE TABLE entries (
+	id INTEGER PRIMARY KEY,
+	title TEXT NOT NULL UNIQUE,
+	content_type TEXT NOT NULL,
+	data BLOB NOT NULL
+);
+
+CREATE TABLE redirects (
+	title TEXT PRIMARY KEY,
+	entry_id INTEGER NOT NULL REFERENCES entries (id)
+);
+
+-- The titles of both entries and redirects, for full-text search.
+CREATE VIRTUAL TABLE titles USING fts5 (title, entry_id UNINDEXED);
+`
+
+// exportSQLite writes the entries in the wiki file at wikiPath to a SQLite
+// database at dbPath, replacing it if it already exists. Each entry is stored
+// under its canonical key (see canonicalKeys), and the other keys for it are
+// stored as redirects.
+func exportSQLite(wikiPath, dbPath string) {
+	wiki, err := reader.OpenWiki(wikiPath)
+	if err != nil {
+		panic(err)
+	}
+	if wiki.Compression() != compression.Zlib {
+		panic("only wiki files with zlib compressed entries can be exported to SQLite")
+	}
+
+	var keys []reader.SearchResult
+	err = wiki.Keys(func(r reader.SearchResult) error {
+		keys = append(keys, r)
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	log.Println("Read", len(keys), "keys")
+
+	canonical := canonicalKeys(keys)
+
+	if err := os.Remove(dbPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		panic(err)
+	}
+
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		panic(err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		panic(err)
+	}
+
+	insertEntry := prepare(tx, "INSERT INTO entries (id, title, content_type, data) VALUES (?, ?, ?, ?)")
+	insertRedirect := prepare(tx, "INSERT INTO redirects (title, entry_id) VALUES (?, ?)")
+	insertTitle := prepare(tx, "INSERT INTO titles (title, entry_id) VALUES (?, ?)")
+
+	// Entries are inserted first so that redirects can refer to their IDs.
+	ids := make(map[int64]int64, len(canonical))
+	for _, k := range keys {
+		if k.Key != canonical[k.EntryOffset] {
+			continue
+		}
+
+		size, rdr, err := wiki.RawEntry(k.EntryOffset)
+		if err != nil {
+			panic(err)
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(rdr, data); err != nil {
+			panic(err)
+		}
+
+		id := int64(len(ids)) + 1
+		ids[k.EntryOffset] = id
+		if _, err := insertEntry.Exec(id, k.Key, wiki.ContentType(k.EntryOffset), data); err != nil {
+			panic(err)
+		}
+
+		if len(ids)%10000 == 0 {
+			log.Println(len(ids), "/", len(canonical), "entries")
+		}
+	}
+
+	for _, k := range keys {
+		id := ids[k.EntryOffset]
+		if k.Key != canonical[k.EntryOffset] {
+			if _, err := insertRedirect.Exec(k.Key, id); err != nil {
+				panic(err)
+			}
+		}
+
+		if _, err := insertTitle.Exec(k.Key, id); err != nil {
+			panic(err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		panic(err)
+	}
+
+	log.Println("Exported", len(ids), "entries and", len(keys)-len(ids), "redirects")
+}
+
+func prepare(tx *sql.Tx, query string) *sql.Stmt {
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		panic(err)
+	}
+
+	return stmt
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	nethtml "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+
+	"github.com/rsookram/wiki-builder/internal/assets"
+	"github.com/rsookram/wiki-builder/internal/reader"
+)
+
+// exportStatic renders every entry in the wiki file at wikiPath into outDir as
+// a static HTML site. Each key is written to <key>.html. Redirects are written
+// as pages which refresh to the entry they point at, and links between entries
+// are rewritten to include the .html extension.
+func exportStatic(wikiPath, outDir string) {
+	wiki, err := reader.OpenWiki(wikiPath)
+	if err != nil {
+		panic(err)
+	}
+
+	var keys []reader.SearchResult
+	err = wiki.Keys(func(r reader.SearchResult) error {
+		keys = append(keys, r)
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	log.Println("Read", len(keys), "keys")
+
+	// The contents of each entry are written to its canonical key, since
+	// relative links in entries are resolved against it.
+	canonical := canonicalKeys(keys)
+
+	writeStaticFile(filepath.Join(outDir, "-", "style.css"), func(w io.Writer) error {
+		_, err := io.WriteString(w, assets.StyleCSS)
+		return err
+	})
+
+	keyCh := make(chan reader.SearchResult)
+	var wg sync.WaitGroup
+	for range runtime.NumCPU() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := range keyCh {
+				writeStaticKey(&wiki, outDir, k, canonical[k.EntryOffset])
+			}
+		}()
+	}
+
+	for i, k := range keys {
+		if !filepath.IsLocal(filepath.FromSlash(k.Key)) {
+			log.Println("Skipping key which isn't a local path:", k.Key)
+			continue
+		}
+
+		keyCh <- k
+
+		if i%10000 == 0 {
+			log.Println(i+1, "/", len(keys))
+		}
+	}
+	close(keyCh)
+	wg.Wait()
+
+	log.Println(len(keys), "/", len(keys))
+
+	writeStaticFile(filepath.Join(outDir, "index.html"), func(w io.Writer) error {
+		return writeStaticIndex(w, keys)
+	})
+}
+
+// canonicalKeys returns the key to treat as the name of each entry (rather
+// than a redirect to it), by entry offset. The file format doesn't distinguish
+// between entries and redirects, so this is the key with the fewest path
+// segments.
+func canonicalKeys(keys []reader.SearchResult) map[int64]string {
+	canonical := make(map[int64]string)
+	for _, k := range keys {
+		existing, found := canonical[k.EntryOffset]
+		if !found || strings.Count(k.Key, "/") < strings.Count(existing, "/") {
+			canonical[k.EntryOffset] = k.Key
+		}
+	}
+
+	return canonical
+}
+
+func writeStaticKey(wiki *reader.Wiki, outDir string, k reader.SearchResult, canonicalKey string) {
+	path := filepath.Join(outDir, filepath.FromSlash(k.Key)+".html")
+
+	if k.Key != canonicalKey {
+		writeStaticFile(path, func(w io.Writer) error {
+			_, err := fmt.Fprintf(
+				w,
+				`<!DOCTYPE html><html><head><meta charset="utf-8"><meta http-equiv="refresh" content="0;url=%s"></head></html>`,
+				html.EscapeString(relativeHref(k.Key, canonicalKey)),
+			)
+			return err
+		})
+		return
+	}
+
+	rdr, err := wiki.EntryAt(k.EntryOffset)
+	if err != nil {
+		panic(err)
+	}
+
+	writeStaticFile(path, func(w io.Writer) error {
+		return rewriteLinks(w, rdr)
+	})
+}
+
+func writeStaticFile(path string, write func(io.Writer) error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		panic(err)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	if err := write(w); err != nil {
+		panic(fmt.Sprintf("failed to write %s: %s", path, err))
+	}
+
+	if err := w.Flush(); err != nil {
+		panic(err)
+	}
+}
+
+// relativeHref returns a link to the page for the key to, from the page for
+// the key from.
+func relativeHref(from, to string) string {
+	rel, err := filepath.Rel(filepath.Dir(filepath.FromSlash(from)), filepath.FromSlash(to))
+	if err != nil {
+		panic(err)
+	}
+
+	u := url.URL{Path: filepath.ToSlash(rel) + ".html"}
+	return u.String()
+}
+
+// rewriteLinks copies the HTML from r to w, adding the .html extension to
+// relative links so that they point at the exported pages.
+func rewriteLinks(w io.Writer, r io.Reader) error {
+	z := nethtml.NewTokenizer(r)
+	for {
+		tt := z.Next()
+		if tt == nethtml.ErrorToken {
+			if z.Err() == io.EOF {
+				return nil
+			}
+			return z.Err()
+		}
+
+		raw := z.Raw()
+		if tt == nethtml.StartTagToken {
+			tok := z.Token()
+			if tok.DataAtom == atom.A && rewriteHref(tok.Attr) {
+				raw = []byte(tok.String())
+			}
+		}
+
+		if _, err := w.Write(raw); err != nil {
+			return err
+		}
+	}
+}
+
+func rewriteHref(attrs []nethtml.Attribute) bool {
+	for i, a := range attrs {
+		if a.Key != "href" {
+			continue
+		}
+
+		u, ok := parseRelativeLink(a.Val)
+		if !ok {
+			return false
+		}
+
+		u.Path += ".html"
+		attrs[i].Val = u.String()
+		return true
+	}
+
+	return false
+}
+
+// parseRelativeLink parses href, returning whether it's a relative link to
+// another entry.
+func parseRelativeLink(href string) (*url.URL, bool) {
+	u, err := url.Parse(href)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" || strings.HasPrefix(u.Path, "/") {
+		return nil, false
+	}
+
+	return u, true
+}
+
+func writeStaticIndex(w io.Writer, keys []reader.SearchResult) error {
+	if _, err := io.WriteString(w, `<!DOCTYPE html><html><head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1"><link rel="stylesheet" href="-/style.css"></head><body><ul>`); err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		if !filepath.IsLocal(filepath.FromSlash(k.Key)) {
+			continue
+		}
+
+		u := url.URL{Path: k.Key + ".html"}
+		if _, err := fmt.Fprintf(w, `<li><a href="%s">%s</a></li>`, html.EscapeString(u.String()), html.EscapeString(k.Key)); err != nil {
+			return err
+		}
+	}
+
+	_, err := io.WriteString(w, `</ul></body></html>`)
+	return err
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/bits"
+	"slices"
+
+	"github.com/rsookram/wiki-builder/internal/storage"
+	"github.com/rsookram/wiki-builder/wikifile"
+)
+
+// buildStats are statistics about a build, which can be used to tune it.
+type buildStats struct {
+	index wikifile.IndexStats
+	// entrySizes are the compressed sizes of entries in bytes.
+	entrySizes []uint64
+	// wordCounts are the number of words in entries with text, if the
+	// sources have word counts.
+	wordCounts []uint64
+}
+
+// addEntries adds the sizes of the entries from a source with entriesSize
+// bytes of entries.
+func (s *buildStats) addEntries(entries storage.EntryMetadata, entriesSize uint64) {
+	// The size of each entry is the distance to the next one in the file,
+	// which isn't necessarily the next one in the metadata.
+	offsets := make([]uint64, entries.Len())
+	for i := range entries.Len() {
+		offsets[i] = entries.StartOffset(i)
+	}
+	slices.Sort(offsets)
+
+	for i, offset := range offsets {
+		end := entriesSize
+		if i+1 < len(offsets) {
+			end = offsets[i+1]
+		}
+		s.entrySizes = append(s.entrySizes, end-offset-3) // 3 for length prefix
+	}
+}
+
+// addWordCounts adds the word counts of the entries from a source. Entries
+// without any words (e.g. images) are left out.
+func (s *buildStats) addWordCounts(counts []uint64) {
+	for _, c := range counts {
+		if c > 0 {
+			s.wordCounts = append(s.wordCounts, c)
+		}
+	}
+}
+
+func (s *buildStats) print(w io.Writer) {
+	fmt.Fprintln(w, "Second level index:")
+	fmt.Fprintf(w, "  rows: %d\n", s.index.NumRows)
+	fmt.Fprintf(w, "  size: %d B\n", s.index.SecondLevelSize)
+	if s.index.CompressedSize > 0 {
+		fmt.Fprintf(w, "  compressed size: %d B (%.1f%%)\n", s.index.CompressedSize, 100*float64(s.index.CompressedSize)/float64(s.index.SecondLevelSize))
+	}
+	if s.index.NumRows > 0 {
+		fmt.Fprintf(
+			w,
+			"  characters saved by incremental encoding: %d (%.2f per row, %d B)\n",
+			s.index.SavedChars,
+			float64(s.index.SavedChars)/float64(s.index.NumRows),
+			s.index.SavedChars*2,
+		)
+	}
+
+	fmt.Fprintln(w, "Rows per first level key:")
+	fmt.Fprintf(w, "  keys: %d\n", len(s.index.BucketSizes))
+	printDistribution(w, toUint64s(s.index.BucketSizes), "")
+
+	fmt.Fprintln(w, "Compressed entry sizes:")
+	fmt.Fprintf(w, "  entries: %d\n", len(s.entrySizes))
+	printDistribution(w, s.entrySizes, " B")
+
+	if len(s.wordCounts) > 0 {
+		fmt.Fprintln(w, "Words per entry:")
+		fmt.Fprintf(w, "  entries: %d\n", len(s.wordCounts))
+		printDistribution(w, s.wordCounts, " words")
+	}
+}
+
+func toUint64s(values []int) []uint64 {
+	result := make([]uint64, len(values))
+	for i, v := range values {
+		result[i] = uint64(v)
+	}
+
+	return result
+}
+
+// printDistribution prints a summary of values, along with a histogram with
+// power of two buckets.
+func printDistribution(w io.Writer, values []uint64, unit string) {
+	if len(values) == 0 {
+		return
+	}
+
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+
+	total := uint64(0)
+	for _, v := range sorted {
+		total += v
+	}
+
+	fmt.Fprintf(
+		w,
+		"  min: %d%s, median: %d%s, mean: %d%s, max: %d%s\n",
+		sorted[0], unit,
+		sorted[len(sorted)/2], unit,
+		total/uint64(len(sorted)), unit,
+		sorted[len(sorted)-1], unit,
+	)
+
+	// counts[i] is the number of values in [2^(i-1), 2^i)
+	var counts [65]int
+	for _, v := range sorted {
+		counts[bits.Len64(v)]++
+	}
+
+	for i, count := range counts {
+		if count == 0 {
+			continue
+		}
+
+		lower := uint64(0)
+		if i > 0 {
+			lower = 1 << (i - 1)
+		}
+		upper := uint64(1)<<i - 1
+		fmt.Fprintf(w, "  %d-%d%s: %d\n", lower, upper, unit, count)
+	}
+}
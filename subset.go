@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/rsookram/wiki-builder/internal/reader"
+	"github.com/rsookram/wiki-builder/internal/storage"
+	"github.com/rsookram/wiki-builder/internal/translit"
+	"github.com/rsookram/wiki-builder/wikifile"
+)
+
+// copiedEntry is an entry which is copied from one wiki to another.
+type copiedEntry struct {
+	// offset is the offset of the entry in the original wiki.
+	offset int64
+	// newOffset is the offset of the entry in the new wiki.
+	newOffset uint64
+	size      int
+}
+
+// subset writes a wiki file to outputPath with the keys of the wiki file at
+// wikiPath which are listed in the file at titlesPath or start with prefix
+// (either can be empty), along with the entries they refer to. See copyKeys
+// for how they're written.
+func subset(
+	wikiPath string,
+	outputPath string,
+	titlesPath string,
+	prefix string,
+	keyLen byte,
+	transliterator translit.Set,
+	normalization storage.Normalization,
+) {
+	wiki, err := reader.OpenWiki(wikiPath)
+	if err != nil {
+		panic(err)
+	}
+	defer wiki.Close()
+
+	titles := make(map[string]bool)
+	if titlesPath != "" {
+		for _, t := range readTitleList(titlesPath) {
+			titles[normalization.Apply(t)] = true
+		}
+	}
+
+	var keys []reader.SearchResult
+	var selected []reader.SearchResult
+	err = wiki.Keys(func(r reader.SearchResult) error {
+		keys = append(keys, r)
+		if titles[r.Key] || (prefix != "" && strings.HasPrefix(r.Key, prefix)) {
+			selected = append(selected, r)
+		}
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	if len(selected) == 0 {
+		panic("none of the keys were selected")
+	}
+	log.Println("Selected", len(selected), "of", len(keys), "keys")
+
+	copyKeys(&wiki, wikiPath, outputPath, keys, selected, keyLen, transliterator)
+}
+
+// copyKeys writes a wiki file to outputPath with the selected keys of wiki
+// (which is at wikiPath, and has keys), along with the entries they refer to.
+// The entries are copied without recompressing them in the order that they're
+// in wiki, along with their content types, snippets, hashes, sizes,
+// languages, anchors, and word counts. Entries without a language are tagged
+// with -language, and the weights and page ranks of entries are copied unless
+// -pageviews and -pagerank are passed. The main page is kept if it's selected, unless -main-page is passed.
+// The indexes are rebuilt with keyLen, and transliterations of the keys are
+// indexed with transliterator. The word index and bloom filter are written as
+// chosen with -words and -bloom-bits.
+func copyKeys(
+	wiki *reader.Wiki,
+	wikiPath string,
+	outputPath string,
+	keys []reader.SearchResult,
+	selected []reader.SearchResult,
+	keyLen byte,
+	transliterator translit.Set,
+) {
+	// The entries are copied in the order that they're in the original wiki,
+	// so that it's read sequentially.
+	entriesByOffset := make(map[int64]*copiedEntry)
+	var entries []*copiedEntry
+	for _, r := range selected {
+		if _, found := entriesByOffset[r.EntryOffset]; !found {
+			e := &copiedEntry{offset: r.EntryOffset}
+			entriesByOffset[r.EntryOffset] = e
+			entries = append(entries, e)
+		}
+	}
+	slices.SortFunc(entries, func(a, b *copiedEntry) int {
+		return cmp.Compare(a.offset, b.offset)
+	})
+
+	var contentTypes contentTypeRows
+	var snippets snippetRows
+	var hashes hashRows
+	var sizes sizeRows
+	var languages languageRows
+	var anchors anchorRows
+	var wordCounts sizeRows
+	weights := make(map[uint64]uint64)
+	ranks := make(map[uint64]uint64)
+	entriesSize := uint64(0)
+	for _, e := range entries {
+		size, _, err := wiki.RawEntry(e.offset)
+		if err != nil {
+			panic(err)
+		}
+		e.size = size
+		e.newOffset = entriesSize
+		entriesSize += 3 + uint64(size)
+
+		contentTypes.add(e.newOffset, wiki.ContentType(e.offset))
+
+		snippet, err := wiki.EntrySnippetAt(e.offset)
+		if err != nil {
+			panic(err)
+		}
+		if snippet != "" {
+			snippets.add(e.newOffset, snippet)
+		}
+
+		hash, err := wiki.EntryHashAt(e.offset)
+		if err != nil && !errors.Is(err, reader.ErrNotFound) {
+			panic(err)
+		}
+		if hash != nil {
+			hashes.add(e.newOffset, hash)
+		}
+
+		uncompressedSize, err := wiki.EntrySizeAt(e.offset)
+		if err != nil && !errors.Is(err, reader.ErrNotFound) {
+			panic(err)
+		}
+		if err == nil {
+			sizes.add(e.newOffset, uint64(uncompressedSize))
+		}
+
+		wordCount, err := wiki.EntryWordCountAt(e.offset)
+		if err != nil && !errors.Is(err, reader.ErrNotFound) {
+			panic(err)
+		}
+		if err == nil {
+			wordCounts.add(e.newOffset, uint64(wordCount))
+		}
+
+		lang, err := wiki.EntryLanguageAt(e.offset)
+		if err != nil {
+			panic(err)
+		}
+		languages.add(e.newOffset, cmp.Or(lang, *language))
+
+		weight, err := wiki.EntryWeightAt(e.offset)
+		if err != nil {
+			panic(err)
+		}
+		if weight > 0 {
+			weights[e.newOffset] = uint64(weight)
+		}
+
+		rank, err := wiki.EntryPageRankAt(e.offset)
+		if err != nil && !errors.Is(err, reader.ErrNotFound) {
+			panic(err)
+		}
+		if err == nil {
+			ranks[e.newOffset] = uint64(rank)
+		}
+
+		entryAnchors, err := wiki.EntryAnchorsAt(e.offset)
+		if err != nil {
+			panic(err)
+		}
+		if entryAnchors != "" {
+			anchors.add(e.newOffset, entryAnchors)
+		}
+	}
+
+	// Wikis built before redirects were recorded don't distinguish between
+	// entries and redirects, so they're told apart like they are by the
+	// exports.
+	canonical := canonicalKeys(keys)
+	rows := make([]wikifile.IndexRow, 0, len(selected))
+	for _, r := range selected {
+		redirect := r.Redirect
+		if !wiki.HasRedirectFlags() {
+			redirect = canonical[r.EntryOffset] != r.Key
+		}
+		rows = append(rows, wikifile.IndexRow{
+			Name:     utf16.Encode([]rune(r.Key)),
+			Offset:   entriesByOffset[r.EntryOffset].newOffset,
+			Redirect: redirect,
+		})
+	}
+	wikifile.SortIndexRows(rows)
+
+	width := wikifile.OffsetWidth(entriesSize)
+
+	var contentTypesSection []byte
+	if len(contentTypes.offsets) > 0 {
+		contentTypesSection = contentTypes.encode(width)
+	}
+
+	var snippetsSection []byte
+	if len(snippets.offsets) > 0 {
+		snippetsSection = snippets.encode(width)
+	}
+
+	var translitSection []byte
+	if len(transliterator) > 0 {
+		translitSection = encodeTranslitIndex(rows, transliterator, width, keyLen)
+	}
+
+	var hashesSection []byte
+	if len(hashes.offsets) > 0 {
+		hashesSection = hashes.encode(width)
+	}
+
+	var sizesSection []byte
+	if len(sizes.offsets) > 0 {
+		sizesSection = sizes.encode(width)
+	}
+
+	var languagesSection []byte
+	if len(languages.offsets) > 0 {
+		languagesSection = languages.encode(width)
+	}
+
+	var anchorsSection []byte
+	if len(anchors.offsets) > 0 {
+		anchorsSection = anchors.encode(width)
+	}
+
+	var wordCountsSection []byte
+	if len(wordCounts.offsets) > 0 {
+		wordCountsSection = wordCounts.encode(width)
+	}
+
+	var wordsSection []byte
+	if *wordIndex {
+		wordsSection = encodeWordIndex(rows, width, keyLen)
+	}
+
+	var bloomSection []byte
+	if *bloomBits > 0 {
+		bloomSection = encodeBloomFilter(rows, *bloomBits)
+	}
+
+	if *pageviewsPath != "" {
+		weights = entryWeights(rows, readPageviews(*pageviewsPath))
+	}
+	var popularitySection []byte
+	if len(weights) > 0 {
+		popularitySection = encodePopularity(rows, weights, width)
+	}
+
+	if *pageRankPath != "" {
+		ranks = pageRanks(rows, readLinkGraph(*pageRankPath))
+	}
+	var pageRanksSection []byte
+	if len(ranks) > 0 {
+		pageRanksSection = encodePageRanks(ranks, width)
+	}
+
+	longKeysSection := wikifile.EncodeLongKeys(rows)
+	redirectsSection := wikifile.EncodeRedirects(rows, keyLen)
+
+	f, err := os.Create(outputPath)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+
+	output := bufio.NewWriterSize(f, 1024*1024)
+
+	header := wikifile.Header{
+		OffsetWidth:      width,
+		FirstLevelKeyLen: keyLen,
+		ContentTypesLen:  uint64(len(contentTypesSection)),
+		SnippetsLen:      uint64(len(snippetsSection)),
+		TranslitLen:      uint64(len(translitSection)),
+		HashesLen:        uint64(len(hashesSection)),
+		WordsLen:         uint64(len(wordsSection)),
+		BloomLen:         uint64(len(bloomSection)),
+		LongKeysLen:      uint64(len(longKeysSection)),
+		SizesLen:         uint64(len(sizesSection)),
+		LanguagesLen:     uint64(len(languagesSection)),
+		PopularityLen:    uint64(len(popularitySection)),
+		AnchorsLen:       uint64(len(anchorsSection)),
+		WordCountsLen:    uint64(len(wordCountsSection)),
+		PageRanksLen:     uint64(len(pageRanksSection)),
+		RedirectsLen:     uint64(len(redirectsSection)),
+		MainPage:         checkMainPage(rows, *mainPage),
+		Compression:      wiki.Compression(),
+		CompressedIndex:  *compressIndex,
+	}
+	if *mainPage == "" && hasKey(rows, wiki.MainPage()) {
+		header.MainPage = wiki.MainPage()
+	}
+	source := cmp.Or(*sourceName, wiki.BuildInfo().Source, filepath.Base(wikiPath))
+	header = provenance(header, source, nil, entriesSize)
+	if err := wikifile.WriteHeader(output, header); err != nil {
+		panic(err)
+	}
+
+	for i, e := range entries {
+		_, rdr, err := wiki.RawEntry(e.offset)
+		if err != nil {
+			panic(err)
+		}
+
+		if _, err := output.Write([]byte{byte(e.size), byte(e.size >> 8), byte(e.size >> 16)}); err != nil {
+			panic(err)
+		}
+		if _, err := io.Copy(output, rdr); err != nil {
+			panic(err)
+		}
+
+		if i%10000 == 0 {
+			log.Println(i+1, "/", len(entries))
+		}
+	}
+	log.Println("Copied", len(entries), "entries")
+
+	for _, section := range [][]byte{contentTypesSection, snippetsSection, translitSection, hashesSection, wordsSection, bloomSection, longKeysSection, sizesSection, languagesSection, popularitySection, anchorsSection, wordCountsSection, pageRanksSection, redirectsSection} {
+		if _, err := output.Write(section); err != nil {
+			panic(err)
+		}
+	}
+
+	writeIndexes := wikifile.WriteIndexes
+	if *compressIndex {
+		writeIndexes = wikifile.WriteCompressedIndexes
+	}
+	if err := writeIndexes(output, rows, width, keyLen, nil); err != nil {
+		panic(err)
+	}
+	log.Println("Finished writing indexes")
+
+	if err := output.Flush(); err != nil {
+		panic(err)
+	}
+}
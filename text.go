@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+
+	"github.com/rsookram/wiki-builder/internal/reader"
+	"github.com/rsookram/wiki-builder/internal/storage"
+)
+
+// writeText writes the entry for name in the wiki file at wikiPath to w as
+// plain text. When name is empty, every entry which is text is written
+// instead, as a line of JSON with its key and text, e.g. to feed into other
+// tools. Redirects are skipped, since they have the same text as their entry.
+func writeText(w io.Writer, wikiPath string, name string, normalization storage.Normalization) {
+	wiki, err := reader.OpenWiki(wikiPath)
+	if err != nil {
+		panic(err)
+	}
+	defer wiki.Close()
+
+	if name != "" {
+		text, err := wiki.EntryText(normalization.Apply(name))
+		if err != nil {
+			panic(err)
+		}
+
+		if _, err := io.WriteString(w, text); err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	var keys []reader.SearchResult
+	err = wiki.Keys(func(r reader.SearchResult) error {
+		keys = append(keys, r)
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	canonical := canonicalKeys(keys)
+
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	enc.SetEscapeHTML(false)
+	numEntries := 0
+	for _, k := range keys {
+		if canonical[k.EntryOffset] != k.Key {
+			continue
+		}
+
+		text, err := wiki.EntryTextAt(k.EntryOffset)
+		if errors.Is(err, reader.ErrNotText) {
+			continue
+		}
+		if err != nil {
+			panic(err)
+		}
+
+		err = enc.Encode(struct {
+			Key  string `json:"key"`
+			Text string `json:"text"`
+		}{k.Key, text})
+		if err != nil {
+			panic(err)
+		}
+		numEntries++
+	}
+
+	if err := bw.Flush(); err != nil {
+		panic(err)
+	}
+
+	log.Println("Wrote the text of", numEntries, "entries")
+}
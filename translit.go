@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"unicode/utf16"
+
+	"github.com/rsookram/wiki-builder/internal/translit"
+	"github.com/rsookram/wiki-builder/wikifile"
+)
+
+// encodeTranslitIndex returns the transliteration index for rows, which maps
+// Latin spellings of keys (along with the keys themselves) to the same
+// entries. It's empty if none of the keys could be transliterated.
+func encodeTranslitIndex(rows []wikifile.IndexRow, t translit.Transliterator, offsetWidth byte, keyLen byte) []byte {
+	var translitRows []wikifile.IndexRow
+	numSkipped := 0
+	for _, r := range rows {
+		name := string(utf16.Decode(r.Name))
+		for _, spelling := range t.Transliterate(name) {
+			key := utf16.Encode([]rune(spelling + translit.Separator + name))
+			if len(key) > wikifile.MaxKeyLen {
+				numSkipped++
+				continue
+			}
+
+			translitRows = append(translitRows, wikifile.IndexRow{Name: key, Offset: r.Offset})
+		}
+	}
+
+	if numSkipped > 0 {
+		log.Println("Skipped", numSkipped, "transliterations which are too long")
+	}
+	if len(translitRows) == 0 {
+		return nil
+	}
+
+	wikifile.SortIndexRows(translitRows)
+
+	var buf bytes.Buffer
+	if err := wikifile.WriteIndexes(&buf, translitRows, offsetWidth, keyLen, nil); err != nil {
+		panic(err)
+	}
+
+	log.Println("Transliterated", len(translitRows), "keys")
+	return buf.Bytes()
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+
+	"github.com/rsookram/wiki-builder/internal/reader"
+	"github.com/rsookram/wiki-builder/internal/storage"
+)
+
+// key is a key press read from the terminal.
+type key struct {
+	// r is the character typed, or 0 for special keys.
+	r       rune
+	special specialKey
+}
+
+type specialKey int
+
+const (
+	keyNone specialKey = iota
+	keyUp
+	keyDown
+	keyPageUp
+	keyPageDown
+	keyEnter
+	keyBackspace
+	keyEscape
+	keyClear
+	keyQuit
+)
+
+// parseKeys parses the bytes read from a terminal in raw mode into key
+// presses.
+func parseKeys(b []byte) []key {
+	var keys []key
+	for len(b) > 0 {
+		if b[0] == 0x1b {
+			switch {
+			case len(b) == 1:
+				keys = append(keys, key{special: keyEscape})
+				b = b[1:]
+			case len(b) >= 3 && (b[1] == '[' || b[1] == 'O') && (b[2] == 'A' || b[2] == 'B'):
+				k := keyUp
+				if b[2] == 'B' {
+					k = keyDown
+				}
+				keys = append(keys, key{special: k})
+				b = b[3:]
+			case len(b) >= 4 && b[1] == '[' && (b[2] == '5' || b[2] == '6') && b[3] == '~':
+				k := keyPageUp
+				if b[2] == '6' {
+					k = keyPageDown
+				}
+				keys = append(keys, key{special: k})
+				b = b[4:]
+			default:
+				// Ignore other escape sequences.
+				i := 1
+				for i < len(b) && (b[i] == '[' || b[i] == 'O' || (b[i] >= '0' && b[i] <= '9') || b[i] == ';') {
+					i++
+				}
+				b = b[min(i+1, len(b)):]
+			}
+			continue
+		}
+
+		switch b[0] {
+		case '\r', '\n':
+			keys = append(keys, key{special: keyEnter})
+		case 0x7f, 0x08:
+			keys = append(keys, key{special: keyBackspace})
+		case 0x15: // Ctrl-U
+			keys = append(keys, key{special: keyClear})
+		case 0x03, 0x04: // Ctrl-C, Ctrl-D
+			keys = append(keys, key{special: keyQuit})
+		default:
+			r, size := utf8.DecodeRune(b)
+			if unicode.IsPrint(r) {
+				keys = append(keys, key{r: r})
+			}
+			b = b[size:]
+			continue
+		}
+		b = b[1:]
+	}
+
+	return keys
+}
+
+// tui is the state of the terminal UI. It shows search results for the query
+// until an entry is opened, and then shows the text of the entry.
+type tui struct {
+	wiki          *reader.Wiki
+	normalization storage.Normalization
+	out           *bufio.Writer
+	columns, rows int
+
+	query    []rune
+	results  []reader.SearchResult
+	selected int
+	err      error
+
+	// entry is the key of the entry being shown, or empty when searching.
+	entry  string
+	lines  []textLine
+	scroll int
+}
+
+// runTUI lets the wiki file at wikiPath be searched and read in the terminal.
+func runTUI(wikiPath string, normalization storage.Normalization) {
+	wiki, err := reader.OpenWiki(wikiPath)
+	if err != nil {
+		panic(err)
+	}
+	defer wiki.Close()
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		panic("tui needs to be run in a terminal")
+	}
+
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		panic(err)
+	}
+	defer term.Restore(fd, state)
+
+	t := &tui{wiki: &wiki, normalization: normalization, out: bufio.NewWriter(os.Stdout)}
+
+	// Use the alternate screen so that the terminal is left as it was.
+	t.out.WriteString("\x1b[?1049h")
+	defer func() {
+		t.out.WriteString("\x1b[?25h\x1b[?1049l")
+		t.out.Flush()
+	}()
+
+	keys := make(chan []key)
+	go func() {
+		buf := make([]byte, 256)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if err != nil {
+				close(keys)
+				return
+			}
+			keys <- parseKeys(buf[:n])
+		}
+	}()
+
+	resized := make(chan os.Signal, 1)
+	signal.Notify(resized, syscall.SIGWINCH)
+	defer signal.Stop(resized)
+
+	for {
+		t.columns, t.rows, err = term.GetSize(fd)
+		if err != nil {
+			panic(err)
+		}
+		t.draw()
+
+		select {
+		case <-resized:
+			if t.entry != "" {
+				t.columns, t.rows, err = term.GetSize(fd)
+				if err != nil {
+					panic(err)
+				}
+				// Wrap the text for the new width.
+				t.open(t.entry, t.scroll)
+			}
+		case ks, ok := <-keys:
+			if !ok {
+				return
+			}
+			for _, k := range ks {
+				if !t.handle(k) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// handle updates the state for k, returning false if the UI should exit.
+func (t *tui) handle(k key) bool {
+	if k.special == keyQuit {
+		return false
+	}
+
+	if t.entry != "" {
+		page := max(t.rows-3, 1)
+		switch {
+		case k.special == keyEscape || k.r == 'q':
+			t.entry = ""
+			t.lines = nil
+		case k.special == keyUp || k.r == 'k':
+			t.scrollTo(t.scroll - 1)
+		case k.special == keyDown || k.r == 'j':
+			t.scrollTo(t.scroll + 1)
+		case k.special == keyPageUp:
+			t.scrollTo(t.scroll - page)
+		case k.special == keyPageDown || k.r == ' ':
+			t.scrollTo(t.scroll + page)
+		}
+		return true
+	}
+
+	switch k.special {
+	case keyEscape:
+		return false
+	case keyUp:
+		t.selected = max(t.selected-1, 0)
+	case keyDown:
+		t.selected = min(t.selected+1, max(len(t.results)-1, 0))
+	case keyEnter:
+		if t.selected < len(t.results) {
+			t.open(t.results[t.selected].Key, 0)
+		}
+	case keyBackspace:
+		if len(t.query) > 0 {
+			t.query = t.query[:len(t.query)-1]
+			t.search()
+		}
+	case keyClear:
+		t.query = t.query[:0]
+		t.search()
+	case keyNone:
+		t.query = append(t.query, k.r)
+		t.search()
+	}
+
+	return true
+}
+
+func (t *tui) search() {
+	t.results = nil
+	t.selected = 0
+	t.err = nil
+
+	query := t.normalization.Apply(string(t.query))
+	if query == "" {
+		return
+	}
+
+	t.results, t.err = t.wiki.Query(query)
+}
+
+// open shows the text of the entry for name, scrolled to the given line.
+func (t *tui) open(name string, scroll int) {
+	lines, err := t.entryText(name)
+	if err != nil {
+		lines = []textLine{{text: err.Error()}}
+	}
+
+	t.entry = name
+	t.lines = lines
+	t.scrollTo(scroll)
+}
+
+func (t *tui) entryText(name string) ([]textLine, error) {
+	offset, err := t.wiki.EntryOffset(name)
+	if err != nil {
+		return nil, err
+	}
+
+	contentType := t.wiki.ContentType(offset)
+	if !storage.IsHTML(contentType) && !strings.HasPrefix(contentType, "text/") {
+		return []textLine{{text: fmt.Sprintf("Entries of type %s can't be shown.", contentType)}}, nil
+	}
+
+	rdr, err := t.wiki.EntryAt(offset)
+	if err != nil {
+		return nil, err
+	}
+
+	if storage.IsHTML(contentType) {
+		return htmlToText(rdr, t.columns)
+	}
+
+	b, err := io.ReadAll(rdr)
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []textLine
+	for _, l := range strings.Split(string(b), "\n") {
+		for _, wrapped := range wrapText(l, t.columns) {
+			lines = append(lines, textLine{text: wrapped})
+		}
+	}
+	return lines, nil
+}
+
+func (t *tui) scrollTo(line int) {
+	maxScroll := max(len(t.lines)-(t.rows-3), 0)
+	t.scroll = min(max(line, 0), maxScroll)
+}
+
+func (t *tui) draw() {
+	// Clear the screen and move to the top left.
+	t.out.WriteString("\x1b[H\x1b[2J")
+
+	if t.entry != "" {
+		t.drawEntry()
+	} else {
+		t.drawSearch()
+	}
+
+	t.out.Flush()
+}
+
+func (t *tui) drawSearch() {
+	t.out.WriteString("\x1b[1mSearch:\x1b[0m ")
+	t.out.WriteString(string(t.query))
+	t.out.WriteString("\r\n")
+	t.drawDim(truncateText("↑/↓ select · Enter open · Ctrl-U clear · Esc quit", t.columns))
+	t.out.WriteString("\r\n")
+
+	if t.err != nil {
+		t.out.WriteString(truncateText(t.err.Error(), t.columns))
+	}
+
+	for i, r := range t.results {
+		if i >= t.rows-3 {
+			break
+		}
+		t.out.WriteString("\r\n")
+
+		line := truncateText(r.Key, t.columns)
+		if i == t.selected {
+			t.out.WriteString("\x1b[7m" + line + "\x1b[0m")
+		} else {
+			t.out.WriteString(line)
+		}
+
+		if r.Snippet != "" {
+			remaining := t.columns - stringWidth(line) - 2
+			if remaining > 0 {
+				t.out.WriteString("  \x1b[2m" + truncateText(r.Snippet, remaining) + "\x1b[0m")
+			}
+		}
+	}
+
+	// Put the cursor at the end of the query.
+	fmt.Fprintf(t.out, "\x1b[?25h\x1b[1;%dH", len("Search: ")+stringWidth(string(t.query))+1)
+}
+
+func (t *tui) drawEntry() {
+	t.out.WriteString("\x1b[?25l")
+	t.out.WriteString("\x1b[1m" + truncateText(t.entry, t.columns) + "\x1b[0m\r\n")
+	t.drawDim(strings.Repeat("─", t.columns))
+	t.out.WriteString("\r\n")
+
+	end := min(t.scroll+t.rows-3, len(t.lines))
+	for _, l := range t.lines[t.scroll:end] {
+		if l.heading {
+			t.out.WriteString("\x1b[1m" + l.text + "\x1b[0m")
+		} else {
+			t.out.WriteString(l.text)
+		}
+		t.out.WriteString("\r\n")
+	}
+
+	fmt.Fprintf(t.out, "\x1b[%d;1H", t.rows)
+	t.drawDim(truncateText(fmt.Sprintf("↑/↓ scroll · PgUp/PgDn page · q back · %d/%d", end, len(t.lines)), t.columns))
+}
+
+func (t *tui) drawDim(s string) {
+	t.out.WriteString("\x1b[2m" + s + "\x1b[0m")
+}
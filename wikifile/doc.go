@@ -0,0 +1,188 @@
+// Package wikifile writes wiki files, which can be read by the web and static
+// export commands.
+//
+// File format:
+//
+// Note: All multi-byte values are in little endian
+//
+// Header:
+// - u16 for length of the header in bytes (including this length)
+// - u8 for the width of entry offsets in bytes (5-8). The narrowest width
+// that can address every entry is chosen at build time.
+// - u8 for the number of characters in each first level index key (1-8)
+// - optional fields until the end of the header, each with a type (u8), and a
+// length-prefixed (u8) value. Readers skip types they don't know.
+//   - 1: a UTF-8 path to a file containing the entries, relative to the
+//     directory of this file, or an HTTP(S) URL to fetch them from. The
+//     entries section below is empty when this is present.
+//   - 2: the length of the snippets section in bytes (u64)
+//   - 3: the length of the content types section in bytes (u64)
+//   - 4: the length of the transliteration index section in bytes (u64)
+//   - 5: the length of the hashes section in bytes (u64)
+//   - 6: a random ID for the build (16 B)
+//   - 7: a UTF-8 identifier for the dump that the file was built from
+//   - 8: the time of the build in seconds since the Unix epoch (u64)
+//   - 9: the UTF-8 version of the tool that built the file
+//   - 10: "WIKI" followed by the version of the format (u8, currently 4).
+//     It's written as the first field, and is missing from files written
+//     before it was added. Readers reject files with a newer version. Version
+//     2 added long keys, and first level keys which repeat. Version 3 added
+//     entries which aren't zlib compressed, and is only written for them.
+//     Version 4 added compressed buckets in the second level index, and is
+//     only written for them.
+//   - 11: the length of the word index section in bytes (u64)
+//   - 12: the length of the bloom filter section in bytes (u64)
+//   - 13: the length of the long keys section in bytes (u64)
+//   - 14: the length of the entry sizes section in bytes (u64)
+//   - 15: the length of the languages section in bytes (u64)
+//   - 16: the length of the popularity section in bytes (u64)
+//   - 17: the UTF-8 key of the entry to show as the landing page (e.g.
+//     "Main_Page")
+//   - 18: the length of the anchors section in bytes (u64)
+//   - 19: the ID of the compressor that the entries are compressed with (u8).
+//     Files without it are zlib compressed.
+//   - 20: the length of the word counts section in bytes (u64)
+//   - 21: the length of the page ranks section in bytes (u64)
+//   - 22: the format of the second level index of the main index (u8): 1 if
+//     each of its buckets is compressed as a zstd frame. Files without it
+//     have uncompressed second level indexes.
+//   - 23: the length of the redirects section in bytes (u64)
+//
+// Entries
+// each entry is compressed (with zlib unless the header says otherwise),
+// prefixed with its compressed length (u24) and packed
+//
+// Content types (only when present in the header):
+// - u8 for the number of content types, each a length-prefixed (u8) UTF-8
+// string
+// - u32 for the number of rows
+// - rows sorted by entry offset, each with an offset (with the width from the
+// header) to an entry, and a u8 index into the content types. Entries without
+// a row are HTML.
+//
+// Snippets (only when present in the header):
+// - u32 for the number of rows
+// - rows sorted by entry offset, each with an offset (with the width from the
+// header) to an entry, and a u32 for the end of its snippet relative to the
+// start of the text (the start is the end of the previous row's)
+// - the UTF-8 text of the snippets, packed
+//
+// Transliteration index (only when present in the header):
+// - a second level index and first level index in the same format as below,
+// where each key is a Latin spelling of a key of the main index, then a tab,
+// then the key itself (e.g. "tokyo\tとうきょう"). It's searched when there
+// are no results in the main index.
+//
+// Hashes (only when present in the header):
+// - u32 for the number of rows
+// - rows sorted by entry offset, each with an offset (with the width from the
+// header) to an entry, and the SHA-256 of its uncompressed contents (32 B)
+//
+// Word index (only when present in the header):
+// - a second level index and first level index in the same format as below,
+// where each key is the part of a key of the main index starting at one of
+// its words after the first, then a tab, then the key itself (e.g.
+// "relativity\tGeneral_relativity"). It's searched along with the main index.
+//
+// Bloom filter (only when present in the header):
+// - u8 for the number of hash functions (k)
+// - the bits of a bloom filter over the keys of the main index, packed with
+// bit i in byte i/8 at position i%8 (m is 8 times the number of bytes)
+// - the position for hash function i (0 to k-1) is (h1 + i*h2) mod m, where
+// h1 is the low 32 bits of the 64-bit FNV-1a hash of the key in UTF-16LE, and
+// h2 is the high 32 bits, with the lowest bit set
+//
+// Long keys (only when present in the header):
+// - u32 for the number of keys
+// - the keys of the main index which are longer than 127 UTF-16 code units,
+// in the same order as in the index, each a length-prefixed (u16) string in
+// UTF-16LE
+//
+// Entry sizes (only when present in the header):
+// - u32 for the number of rows
+// - rows sorted by entry offset, each with an offset (with the width from the
+// header) to an entry, and the size of its uncompressed contents in bytes (u32)
+//
+// Languages (only when present in the header):
+// - u8 for the number of languages, each a length-prefixed (u8) UTF-8
+// language code (e.g. "en" or "ja")
+// - u32 for the number of rows
+// - rows sorted by entry offset, each with an offset (with the width from the
+// header) to an entry, and a u8 index into the languages. Entries without a
+// row don't have a language.
+//
+// Popularity (only when present in the header):
+// - u32 for the number of rows
+// - rows sorted by entry offset, each with an offset (with the width from the
+// header) to an entry, and its weight (u32), e.g. its number of page views.
+// Entries without a row have a weight of 0.
+// - u16 for the number of popular entries
+// - the entries with the highest weights, the highest first, each with an
+// offset (with the width from the header) to the entry, and its key as a
+// length-prefixed (u16) UTF-8 string
+//
+// Anchors (only when present in the header):
+// - the same layout as the snippets section, where the text of each row is the
+// anchors of the entry (the IDs that fragments of links to it can refer to),
+// separated by tabs. Entries without a row don't have any anchors.
+//
+// Word counts (only when present in the header):
+// - the same layout as the entry sizes section, where the u32 of each row is
+// the number of words in the text of the entry. Entries without a row don't
+// have a word count.
+//
+// Page ranks (only when present in the header):
+// - the same layout as the entry sizes section, where the u32 of each row is
+// the PageRank of the entry from the links between entries, scaled so that
+// the average is 1000. Entries without a row have a rank of 0.
+//
+// Redirects (only when present in the header):
+// - u32 for the number of buckets of the main index (the number of offsets in
+// its first level index)
+// - u32 for each bucket, with the index of its first row in the second level
+// index
+// - a bit for each row of the second level index in order, packed with row i
+// in byte i/8 at position i%8, which is set if its key is from a redirect
+// rather than the name of an entry
+//
+// Second level index:
+// - Rows are sorted by key, in code point order. Each key appears once.
+// - The key in each row is compressed using incremental encoding
+// - The row starts with a common prefix length (u8)
+// - Then a length-prefixed (u8) string in UTF-16LE followed by an
+// offset (with the width from the header) to an entry relative to the start
+// of the entries
+// - Keys are at most 127 code units. For longer keys, the common prefix length
+// is 0 and the length is 255, followed by the index of the key in the long keys
+// section (u32) instead of the string. The next row can reuse the start of the
+// long key.
+// u32 for length of second level index in bytes (including this length)
+//
+// First level index:
+// - packed strings: each with the number of characters from the header
+// (4 by default, so 8 B), padded with zeros
+// - then packed offsets: u32, u32, ... (used to read the part of the second
+// level where the names start with the associated prefix)
+// - the offset is relative to the start of the second level index (after its
+// length)
+// u16 for length of first level index in bytes (including this length)
+// - the number of entries will be inferred by the size of the index:
+// (size - 2) / (key length * 2 + 4). Strings are UTF-16LE.
+//
+// Can do a scan (or binary search) on the packed strings to find the index of
+// the correct offset for a query.
+// Then get that offset by index.
+//
+// A string can repeat when there are too many keys which start with it for
+// one part of the second level index. Each part then starts with an
+// uncompressed row, and the part to read for a query is the last one whose
+// first key is <= the query.
+//
+// When the header says that the buckets are compressed, the rows of each part
+// of the main second level index (from one first level offset to the next)
+// are compressed as a separate zstd frame instead, which can be decompressed
+// without the others since each part starts with an uncompressed row. The
+// offsets of the first level index and the length of the second level index
+// are then of the frames.
+// The transliteration and word indexes are never compressed.
+package wikifile
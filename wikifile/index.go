@@ -0,0 +1,682 @@
+package wikifile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"time"
+	"unicode/utf16"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/rsookram/wiki-builder/internal/compression"
+	"github.com/rsookram/wiki-builder/internal/storage"
+)
+
+// MaxKeyLen is the maximum number of UTF-16 code units in a key which is
+// stored in the second level index. Longer keys are stored in the long keys
+// section instead.
+const MaxKeyLen = 127
+
+// MaxLongKeyLen is the maximum number of UTF-16 code units in any key.
+const MaxLongKeyLen = math.MaxUint16
+
+// longKeySentinel is written as the length of the key in rows of the second
+// level index whose keys are in the long keys section.
+const longKeySentinel = math.MaxUint8
+
+// MaxFirstLevelKeyLen is the maximum number of characters in a key of the
+// first level index.
+const MaxFirstLevelKeyLen = 8
+
+// DefaultFirstLevelKeyLen is the number of characters in each key of the first
+// level index unless chosen otherwise.
+const DefaultFirstLevelKeyLen = 4
+
+// HashLen is the number of bytes in each hash of the hashes section (SHA-256).
+const HashLen = 32
+
+// minOffsetWidth is the width of entry offsets in bytes that's used unless the
+// entries are too big to be addressed with it. 2^40 B ~= 1 TB
+const minOffsetWidth = 5
+
+// OffsetWidth returns the number of bytes needed to store any offset into
+// entries of the given total size.
+func OffsetWidth(entriesSize uint64) byte {
+	width := byte(minOffsetWidth)
+	for width < 8 && entriesSize >= 1<<(8*uint64(width)) {
+		width++
+	}
+
+	return width
+}
+
+// The types of the optional fields in the header.
+const (
+	headerFieldEntriesFile     = 1
+	headerFieldSnippetsLen     = 2
+	headerFieldContentTypesLen = 3
+	headerFieldTranslitLen     = 4
+	headerFieldHashesLen       = 5
+	headerFieldBuildID         = 6
+	headerFieldSource          = 7
+	headerFieldBuildTime       = 8
+	headerFieldToolVersion     = 9
+	headerFieldFormat          = 10
+	headerFieldWordsLen        = 11
+	headerFieldBloomLen        = 12
+	headerFieldLongKeysLen     = 13
+	headerFieldSizesLen        = 14
+	headerFieldLanguagesLen    = 15
+	headerFieldPopularityLen   = 16
+	headerFieldMainPage        = 17
+	headerFieldAnchorsLen      = 18
+	headerFieldCompression     = 19
+	headerFieldWordCountsLen   = 20
+	headerFieldPageRanksLen    = 21
+	headerFieldIndexFormat     = 22
+	headerFieldRedirectsLen    = 23
+)
+
+// formatMagic identifies wiki files. It's written in the format header field
+// along with formatVersion.
+const formatMagic = "WIKI"
+
+// formatVersion is the version of the format that's written. It only changes
+// when older readers can't read new files. Version 2 added long keys, and
+// buckets with the same first level key, which older readers would misread.
+// Version 3 added entries compressed with other algorithms than zlib, which
+// older readers can't decompress, so it's only written for those files.
+// Version 4 added second level indexes with compressed buckets, and is only
+// written for them.
+const formatVersion = 4
+
+// compressedEntriesFormatVersion is the version that's written for files with
+// entries which aren't zlib compressed, and an uncompressed index.
+const compressedEntriesFormatVersion = 3
+
+// zlibFormatVersion is the version that's written for files with zlib
+// compressed entries and an uncompressed index.
+const zlibFormatVersion = 2
+
+// indexFormatZstdBuckets is the value of the index format header field for
+// second level indexes whose buckets are zstd frames.
+const indexFormatZstdBuckets = 1
+
+// bucketSize is the number of rows of the second level index after which a
+// new bucket is started.
+const bucketSize = 1024
+
+// BuildIDLen is the number of bytes in a build ID.
+const BuildIDLen = 16
+
+// Header is the header at the start of a wiki file.
+type Header struct {
+	OffsetWidth      byte
+	FirstLevelKeyLen byte
+	// EntriesFile is the path (relative to the wiki file) or HTTP(S) URL of
+	// the file containing the entries, or empty if they're in the wiki file.
+	EntriesFile     string
+	ContentTypesLen uint64
+	SnippetsLen     uint64
+	TranslitLen     uint64
+	HashesLen       uint64
+	WordsLen        uint64
+	BloomLen        uint64
+	LongKeysLen     uint64
+	SizesLen        uint64
+	LanguagesLen    uint64
+	PopularityLen   uint64
+	AnchorsLen      uint64
+	WordCountsLen   uint64
+	PageRanksLen    uint64
+	RedirectsLen    uint64
+	// MainPage is the key of the entry to show as the landing page, or empty
+	// if there isn't one.
+	MainPage string
+	// Compression is the compressor that the entries are compressed with.
+	Compression compression.ID
+	// CompressedIndex is whether the indexes are written with
+	// WriteCompressedIndexes.
+	CompressedIndex bool
+
+	// BuildID identifies the build that wrote the file, with BuildIDLen
+	// bytes. It and the rest of the provenance of the file below are left
+	// out of the header when they're empty.
+	BuildID []byte
+	// Source identifies the dump that the file was built from, e.g. its name
+	// or URL.
+	Source      string
+	BuildTime   time.Time
+	ToolVersion string
+}
+
+// WriteHeader writes h to w.
+func WriteHeader(w io.Writer, h Header) error {
+	// The format is the first field so that files can be identified by their
+	// first bytes.
+	fields := []byte{headerFieldFormat, byte(len(formatMagic) + 1)}
+	fields = append(fields, formatMagic...)
+	switch {
+	case h.CompressedIndex:
+		fields = append(fields, formatVersion)
+	case h.Compression != compression.Zlib:
+		fields = append(fields, compressedEntriesFormatVersion)
+	default:
+		fields = append(fields, zlibFormatVersion)
+	}
+
+	if h.EntriesFile != "" {
+		if len(h.EntriesFile) > math.MaxUint8 {
+			return fmt.Errorf("reference to the entries file is too long: %s", h.EntriesFile)
+		}
+		fields = append(fields, headerFieldEntriesFile, byte(len(h.EntriesFile)))
+		fields = append(fields, h.EntriesFile...)
+	}
+	if h.ContentTypesLen > 0 {
+		fields = append(fields, headerFieldContentTypesLen, 8)
+		fields = binary.LittleEndian.AppendUint64(fields, h.ContentTypesLen)
+	}
+	if h.SnippetsLen > 0 {
+		fields = append(fields, headerFieldSnippetsLen, 8)
+		fields = binary.LittleEndian.AppendUint64(fields, h.SnippetsLen)
+	}
+	if h.TranslitLen > 0 {
+		fields = append(fields, headerFieldTranslitLen, 8)
+		fields = binary.LittleEndian.AppendUint64(fields, h.TranslitLen)
+	}
+	if h.HashesLen > 0 {
+		fields = append(fields, headerFieldHashesLen, 8)
+		fields = binary.LittleEndian.AppendUint64(fields, h.HashesLen)
+	}
+	if h.WordsLen > 0 {
+		fields = append(fields, headerFieldWordsLen, 8)
+		fields = binary.LittleEndian.AppendUint64(fields, h.WordsLen)
+	}
+	if h.BloomLen > 0 {
+		fields = append(fields, headerFieldBloomLen, 8)
+		fields = binary.LittleEndian.AppendUint64(fields, h.BloomLen)
+	}
+	if h.LongKeysLen > 0 {
+		fields = append(fields, headerFieldLongKeysLen, 8)
+		fields = binary.LittleEndian.AppendUint64(fields, h.LongKeysLen)
+	}
+	if h.SizesLen > 0 {
+		fields = append(fields, headerFieldSizesLen, 8)
+		fields = binary.LittleEndian.AppendUint64(fields, h.SizesLen)
+	}
+	if h.LanguagesLen > 0 {
+		fields = append(fields, headerFieldLanguagesLen, 8)
+		fields = binary.LittleEndian.AppendUint64(fields, h.LanguagesLen)
+	}
+	if h.PopularityLen > 0 {
+		fields = append(fields, headerFieldPopularityLen, 8)
+		fields = binary.LittleEndian.AppendUint64(fields, h.PopularityLen)
+	}
+	if h.AnchorsLen > 0 {
+		fields = append(fields, headerFieldAnchorsLen, 8)
+		fields = binary.LittleEndian.AppendUint64(fields, h.AnchorsLen)
+	}
+	if h.WordCountsLen > 0 {
+		fields = append(fields, headerFieldWordCountsLen, 8)
+		fields = binary.LittleEndian.AppendUint64(fields, h.WordCountsLen)
+	}
+	if h.PageRanksLen > 0 {
+		fields = append(fields, headerFieldPageRanksLen, 8)
+		fields = binary.LittleEndian.AppendUint64(fields, h.PageRanksLen)
+	}
+	if h.RedirectsLen > 0 {
+		fields = append(fields, headerFieldRedirectsLen, 8)
+		fields = binary.LittleEndian.AppendUint64(fields, h.RedirectsLen)
+	}
+	if h.MainPage != "" {
+		if len(h.MainPage) > math.MaxUint8 {
+			return fmt.Errorf("main page key is too long: %s", h.MainPage)
+		}
+		fields = append(fields, headerFieldMainPage, byte(len(h.MainPage)))
+		fields = append(fields, h.MainPage...)
+	}
+	if h.Compression != compression.Zlib {
+		fields = append(fields, headerFieldCompression, 1, byte(h.Compression))
+	}
+	if h.CompressedIndex {
+		fields = append(fields, headerFieldIndexFormat, 1, indexFormatZstdBuckets)
+	}
+	if h.BuildID != nil {
+		if len(h.BuildID) != BuildIDLen {
+			return fmt.Errorf("build ID has %d bytes, but should have %d", len(h.BuildID), BuildIDLen)
+		}
+		fields = append(fields, headerFieldBuildID, BuildIDLen)
+		fields = append(fields, h.BuildID...)
+	}
+	if h.Source != "" {
+		if len(h.Source) > math.MaxUint8 {
+			return fmt.Errorf("source is too long: %s", h.Source)
+		}
+		fields = append(fields, headerFieldSource, byte(len(h.Source)))
+		fields = append(fields, h.Source...)
+	}
+	if !h.BuildTime.IsZero() {
+		fields = append(fields, headerFieldBuildTime, 8)
+		fields = binary.LittleEndian.AppendUint64(fields, uint64(h.BuildTime.Unix()))
+	}
+	if h.ToolVersion != "" {
+		if len(h.ToolVersion) > math.MaxUint8 {
+			return fmt.Errorf("tool version is too long: %s", h.ToolVersion)
+		}
+		fields = append(fields, headerFieldToolVersion, byte(len(h.ToolVersion)))
+		fields = append(fields, h.ToolVersion...)
+	}
+
+	totalSize := 2 + 1 + 1 + len(fields) // +2 to include the size of `totalSize`
+	if totalSize > math.MaxUint16 {
+		return fmt.Errorf("header is too big: %d B", totalSize)
+	}
+
+	bb := make([]byte, 0, totalSize)
+	bb = binary.LittleEndian.AppendUint16(bb, uint16(totalSize))
+	bb = append(bb, h.OffsetWidth)
+	bb = append(bb, h.FirstLevelKeyLen)
+	bb = append(bb, fields...)
+
+	_, err := w.Write(bb)
+	return err
+}
+
+// IndexRow is a key in the second level index, along with the offset of its
+// entry.
+type IndexRow struct {
+	Name   []uint16
+	Offset uint64
+	// Redirect is whether the key is from a redirect rather than the name of
+	// an entry. It isn't written to the index, but to the redirects section
+	// (see EncodeRedirects).
+	Redirect bool
+}
+
+// SortIndexRows sorts rows by name, in code point order. Rows with the same
+// name keep their relative order.
+func SortIndexRows(rows []IndexRow) {
+	slices.SortStableFunc(rows, func(a, b IndexRow) int {
+		return storage.CompareUTF16(a.Name, b.Name)
+	})
+}
+
+// IndexStats are statistics about the indexes, which can be used to tune the
+// first level key length.
+type IndexStats struct {
+	// BucketSizes is the number of rows of the second level index for each key
+	// of the first level index.
+	BucketSizes []int
+	NumRows     int
+	// SavedChars is the number of characters which didn't need to be written
+	// due to incremental encoding.
+	SavedChars      int
+	SecondLevelSize uint32
+	// CompressedSize is the size of the second level index once its buckets
+	// are compressed, or 0 if they aren't.
+	CompressedSize uint32
+}
+
+// EncodeLongKeys returns the long keys section for rows, which must be sorted
+// with SortIndexRows, or nil if none of their keys are longer than MaxKeyLen.
+// It has to be written along with the indexes for rows.
+func EncodeLongKeys(rows []IndexRow) []byte {
+	var numLongKeys uint32
+	var keys []byte
+	for _, r := range rows {
+		if len(r.Name) <= MaxKeyLen || len(r.Name) > MaxLongKeyLen {
+			continue
+		}
+
+		numLongKeys++
+		keys = binary.LittleEndian.AppendUint16(keys, uint16(len(r.Name)))
+		for _, ch := range r.Name {
+			keys = binary.LittleEndian.AppendUint16(keys, ch)
+		}
+	}
+	if numLongKeys == 0 {
+		return nil
+	}
+
+	return append(binary.LittleEndian.AppendUint32(nil, numLongKeys), keys...)
+}
+
+// EncodeRedirects returns the redirects section for rows, which must be sorted
+// with SortIndexRows, or nil if none of them are redirects. It has to be
+// written along with the indexes for rows, with the same key length.
+func EncodeRedirects(rows []IndexRow, keyLen byte) []byte {
+	if !slices.ContainsFunc(rows, func(r IndexRow) bool { return r.Redirect }) {
+		return nil
+	}
+
+	starts := bucketStarts(rows, keyLen)
+	bb := binary.LittleEndian.AppendUint32(nil, uint32(len(starts)))
+	for _, start := range starts {
+		bb = binary.LittleEndian.AppendUint32(bb, uint32(start))
+	}
+
+	bits := make([]byte, (len(rows)+7)/8)
+	for i, r := range rows {
+		if r.Redirect {
+			bits[i/8] |= 1 << (i % 8)
+		}
+	}
+
+	return append(bb, bits...)
+}
+
+// WriteIndexes writes the second and first level indexes for rows, which must
+// be sorted with SortIndexRows. Keys which are longer than MaxKeyLen refer to
+// the section from EncodeLongKeys. Statistics are recorded in st if it isn't
+// nil.
+func WriteIndexes(w io.Writer, rows []IndexRow, offsetWidth byte, keyLen byte, st *IndexStats) error {
+	if len(rows) == 0 {
+		return fmt.Errorf("there are no keys to index")
+	}
+
+	first, err := writeSecondLevel(w, rows, offsetWidth, keyLen, st)
+	if err != nil {
+		return err
+	}
+
+	return writeFirstLevel(w, first, keyLen)
+}
+
+// WriteCompressedIndexes is like WriteIndexes, but compresses each bucket of
+// the second level index as a separate zstd frame, so that a lookup only reads
+// and decompresses the bucket of its key. The header has to be written with
+// CompressedIndex set.
+func WriteCompressedIndexes(w io.Writer, rows []IndexRow, offsetWidth byte, keyLen byte, st *IndexStats) error {
+	if len(rows) == 0 {
+		return fmt.Errorf("there are no keys to index")
+	}
+
+	var buf bytes.Buffer
+	first, err := writeSecondLevel(&buf, rows, offsetWidth, keyLen, st)
+	if err != nil {
+		return err
+	}
+
+	// The rows of each bucket start with an uncompressed key, so they can be
+	// decompressed without the buckets before them. The length at the end is
+	// rewritten for the compressed buckets.
+	first, err = writeCompressedBuckets(w, buf.Bytes()[:buf.Len()-4], first, st)
+	if err != nil {
+		return err
+	}
+
+	return writeFirstLevel(w, first, keyLen)
+}
+
+// writeCompressedBuckets writes each bucket of the rows of a second level
+// index as a zstd frame, followed by its length, returning first with the
+// offsets of the frames instead of the buckets.
+func writeCompressedBuckets(w io.Writer, secondLevel []byte, first firstLevelIndex, st *IndexStats) (firstLevelIndex, error) {
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedBetterCompression), zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return first, err
+	}
+	defer enc.Close()
+
+	compressed := firstLevelIndex{keys: first.keys}
+	totalSize := uint64(0)
+	var frame []byte
+	for i, start := range first.offsets {
+		end := uint32(len(secondLevel))
+		if i+1 < len(first.offsets) {
+			end = first.offsets[i+1]
+		}
+
+		compressed.offsets = append(compressed.offsets, uint32(totalSize))
+		frame = enc.EncodeAll(secondLevel[start:end], frame[:0])
+		if _, err := w.Write(frame); err != nil {
+			return compressed, err
+		}
+
+		totalSize += uint64(len(frame))
+		if totalSize > math.MaxUint32-4 {
+			return compressed, fmt.Errorf("compressed second level index is too big: %d B", totalSize)
+		}
+	}
+
+	if st != nil {
+		st.CompressedSize = uint32(totalSize)
+	}
+
+	totalSize += 4 // Include the size of `totalSize`
+	_, err = w.Write(binary.LittleEndian.AppendUint32(nil, uint32(totalSize)))
+	return compressed, err
+}
+
+type firstLevelIndex struct {
+	keys    []firstLevelIndexKey
+	offsets []uint32
+}
+
+func (i *firstLevelIndex) Append(key firstLevelIndexKey, offset uint32) {
+	i.keys = append(i.keys, key)
+	i.offsets = append(i.offsets, offset)
+}
+
+func writeFirstLevel(w io.Writer, index firstLevelIndex, keyLen byte) error {
+	size := (len(index.keys) * (int(keyLen)*2 + 4)) + 2 // +2 to include the size of `totalSize`
+	if size > math.MaxUint16 {
+		return fmt.Errorf("first level index is too big: %d B. Try a shorter key length", size)
+	}
+	totalSize := uint16(size)
+
+	bb := make([]byte, 0, totalSize)
+	for _, k := range index.keys {
+		bb = k.Append(bb, keyLen)
+	}
+	for _, offset := range index.offsets {
+		bb = binary.LittleEndian.AppendUint32(bb, offset)
+	}
+
+	bb = binary.LittleEndian.AppendUint16(bb, totalSize)
+	_, err := w.Write(bb)
+	return err
+}
+
+// bucketStarts returns the indexes of the rows which start the buckets of the
+// second level index for rows, starting with 0.
+func bucketStarts(rows []IndexRow, keyLen byte) []int {
+	starts := []int{0}
+	prevFirstLevelKey := newFirstLevelIndexKey(rows[0].Name, keyLen)
+	bucketKey := prevFirstLevelKey
+	countForPrevKey := 0
+	for i, r := range rows {
+		currFirstLevelIndexKey := newFirstLevelIndexKey(r.Name, keyLen)
+		newKey := currFirstLevelIndexKey != prevFirstLevelKey
+
+		// A bucket normally ends where the first level key changes, but keys
+		// which start the same way (e.g. "List") can be too many for one
+		// bucket. Those get their own buckets, with the same first level key,
+		// so that readers can tell which buckets were split.
+		startBucket := false
+		switch {
+		case newKey && countForPrevKey >= bucketSize:
+			startBucket = true
+		case newKey:
+			startBucket = firstLevelRunLen(rows[i:], currFirstLevelIndexKey, keyLen) >= bucketSize
+		case currFirstLevelIndexKey == bucketKey && countForPrevKey >= bucketSize:
+			startBucket = true
+		}
+
+		if startBucket {
+			starts = append(starts, i)
+			bucketKey = currFirstLevelIndexKey
+			countForPrevKey = 0
+		}
+		prevFirstLevelKey = currFirstLevelIndexKey
+		countForPrevKey++
+	}
+
+	return starts
+}
+
+// writeSecondLevel writes the second level index, returning the first level
+// index for it.
+func writeSecondLevel(w io.Writer, rows []IndexRow, offsetWidth byte, keyLen byte, st *IndexStats) (firstLevelIndex, error) {
+	totalSize := uint32(0)
+
+	var firstLevelIndex firstLevelIndex
+	starts := bucketStarts(rows, keyLen)
+	nextBucket := 0
+
+	var bb []byte
+	var prevKey []uint16
+	numLongKeys := uint32(0)
+	for i, r := range rows {
+		shouldCompress := true
+		if nextBucket < len(starts) && starts[nextBucket] == i {
+			if st != nil && i > 0 {
+				st.BucketSizes = append(st.BucketSizes, i-starts[nextBucket-1])
+			}
+
+			// We need to be able to jump to this key, so it can't be compressed.
+			shouldCompress = false
+			firstLevelIndex.Append(newFirstLevelIndexKey(r.Name, keyLen), totalSize)
+			nextBucket++
+		}
+
+		numChars := len(r.Name)
+		if numChars > MaxLongKeyLen {
+			return firstLevelIndex, fmt.Errorf(
+				"found a key that is too long: len=%d, %v",
+				numChars,
+				string(utf16.Decode(r.Name)),
+			)
+		}
+
+		if numChars > MaxKeyLen {
+			// The key is in the long keys section, so the row only refers to
+			// it, and doesn't reuse the previous key.
+			bb = append(bb, 0, longKeySentinel)
+			bb = binary.LittleEndian.AppendUint32(bb, numLongKeys)
+			bb = AppendOffset(bb, r.Offset, offsetWidth)
+			totalSize += uint32(2 + 4 + offsetWidth)
+			numLongKeys++
+
+			prevKey = r.Name
+
+			if _, err := w.Write(bb); err != nil {
+				return firstLevelIndex, err
+			}
+			bb = bb[:0]
+			continue
+		}
+
+		// Using incremental encoding / front compression for the key:
+		// https://en.wikipedia.org/wiki/Incremental_encoding
+
+		// Write common prefix length (how many chars to reuse from previous key)
+		commonLen := commonPrefixLen(prevKey, r.Name)
+		if !shouldCompress {
+			commonLen = 0
+		}
+		if st != nil {
+			st.SavedChars += int(commonLen)
+		}
+		bb = append(bb, commonLen)
+		totalSize += 1
+
+		// Write length (in characters) prefix
+		remainingLen := byte(numChars) - commonLen
+		bb = append(bb, remainingLen)
+		totalSize += 1
+
+		// Write new part of key
+		for _, ch := range r.Name[commonLen:] {
+			bb = binary.LittleEndian.AppendUint16(bb, ch)
+		}
+		totalSize += uint32(remainingLen) * 2
+
+		prevKey = r.Name
+
+		// Write offset
+		bb = AppendOffset(bb, r.Offset, offsetWidth)
+		totalSize += uint32(offsetWidth)
+
+		if _, err := w.Write(bb); err != nil {
+			return firstLevelIndex, err
+		}
+		bb = bb[:0]
+	}
+
+	if st != nil {
+		st.BucketSizes = append(st.BucketSizes, len(rows)-starts[len(starts)-1])
+		st.NumRows = len(rows)
+		st.SecondLevelSize = totalSize
+	}
+
+	totalSize += 4 // Include the size of `totalSize`
+	bb = binary.LittleEndian.AppendUint32(bb, totalSize)
+	if _, err := w.Write(bb); err != nil {
+		return firstLevelIndex, err
+	}
+
+	return firstLevelIndex, nil
+}
+
+// firstLevelRunLen returns the number of rows at the start of rows whose first
+// level key is key, up to bucketSize.
+func firstLevelRunLen(rows []IndexRow, key firstLevelIndexKey, keyLen byte) int {
+	n := 0
+	for n < len(rows) && n < bucketSize && newFirstLevelIndexKey(rows[n].Name, keyLen) == key {
+		n++
+	}
+
+	return n
+}
+
+func commonPrefixLen(lhs, rhs []uint16) byte {
+	maxPossible := byte(min(len(lhs), len(rhs)))
+	for i := range maxPossible {
+		if lhs[i] != rhs[i] {
+			return i
+		}
+	}
+
+	return maxPossible
+}
+
+// AppendOffset appends v to bb as an offset with the given width in bytes.
+func AppendOffset(bb []byte, v uint64, width byte) []byte {
+	for i := range width {
+		bb = append(bb, byte(v>>(8*i)))
+	}
+
+	return bb
+}
+
+// firstLevelIndexKey is the prefix of a name. Unused characters are zero.
+type firstLevelIndexKey [MaxFirstLevelKeyLen]uint16
+
+func newFirstLevelIndexKey(chars []uint16, keyLen byte) firstLevelIndexKey {
+	var p firstLevelIndexKey
+	copy(p[:keyLen], storage.TruncateUTF16(chars, int(keyLen)))
+
+	return p
+}
+
+func (p firstLevelIndexKey) Append(bb []byte, keyLen byte) []byte {
+	for _, ch := range p[:keyLen] {
+		bb = binary.LittleEndian.AppendUint16(bb, ch)
+	}
+
+	return bb
+}
+
+func (p firstLevelIndexKey) String() string {
+	length := 0
+	for length < len(p) && p[length] != 0 {
+		length++
+	}
+
+	return string(utf16.Decode(p[:length]))
+}
@@ -0,0 +1,299 @@
+package wikifile
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"unicode/utf16"
+
+	"github.com/rsookram/wiki-builder/internal/compression"
+)
+
+// Writer creates a wiki file from entries and redirects added one at a time,
+// without going through index-fs and compress-entries. Entries are compressed
+// in the background, and written to a temporary file next to the wiki file
+// until Close is called. The rows of the index are built as each entry is
+// written, so that building the wiki overlaps with compressing its entries.
+type Writer struct {
+	path   string
+	keyLen byte
+
+	entriesFile *os.File
+	entries     *bufio.Writer
+	entriesSize uint64
+
+	// names are the names of the entries added so far, including the ones
+	// which haven't been written yet.
+	names map[string]bool
+	// pending has the compressed entries, in the order that they were added.
+	pending chan chan compressedEntry
+	// tokens limits the number of entries being compressed at once.
+	tokens chan struct{}
+	// done is closed once the pending entries are written, after which err
+	// and the fields below can be read.
+	done chan struct{}
+	// err is the first error from compressing or writing an entry.
+	err error
+
+	// offsets are the offsets of the written entries by name.
+	offsets   map[string]uint64
+	rows      []IndexRow
+	redirects []redirect
+}
+
+type redirect struct {
+	from string
+	to   string
+}
+
+type compressedEntry struct {
+	name string
+	buf  *bytes.Buffer
+	err  error
+}
+
+var bufPool = sync.Pool{
+	New: func() any {
+		return bytes.NewBuffer(make([]byte, 0, 64*1024))
+	},
+}
+
+var encoderPool = sync.Pool{
+	New: func() any {
+		c, err := compression.Lookup(compression.Zlib)
+		if err != nil {
+			panic(err)
+		}
+		return c.Compress(nil)
+	},
+}
+
+// Create starts writing a wiki file to path.
+func Create(path string) (*Writer, error) {
+	return CreateWithKeyLen(path, DefaultFirstLevelKeyLen)
+}
+
+// CreateWithKeyLen is like Create, but with a different number of characters
+// in each key of the first level index (between 1 and MaxFirstLevelKeyLen).
+func CreateWithKeyLen(path string, keyLen byte) (*Writer, error) {
+	if keyLen < 1 || keyLen > MaxFirstLevelKeyLen {
+		return nil, fmt.Errorf("first level key length must be between 1 and %d", MaxFirstLevelKeyLen)
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".entries-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file for entries: %w", err)
+	}
+
+	w := &Writer{
+		path:        path,
+		keyLen:      keyLen,
+		entriesFile: f,
+		entries:     bufio.NewWriterSize(f, 1024*1024),
+		names:       make(map[string]bool),
+		pending:     make(chan chan compressedEntry, runtime.NumCPU()),
+		tokens:      make(chan struct{}, runtime.NumCPU()),
+		done:        make(chan struct{}),
+		offsets:     make(map[string]uint64),
+	}
+	go w.writeEntries()
+
+	return w, nil
+}
+
+// AddEntry adds an entry with the contents read from r. r is read before
+// AddEntry returns, but the entry is compressed and written in the
+// background, so errors from doing that are returned by Close.
+func (w *Writer) AddEntry(name string, r io.Reader) error {
+	if err := checkKey(name); err != nil {
+		return err
+	}
+	if w.names[name] {
+		return fmt.Errorf("duplicate entry %q", name)
+	}
+
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("failed to read %q: %w", name, err)
+	}
+	w.names[name] = true
+
+	w.tokens <- struct{}{}
+	result := make(chan compressedEntry, 1)
+	w.pending <- result
+	go func() {
+		result <- compress(name, content)
+	}()
+
+	return nil
+}
+
+// compress returns the zlib compressed content of the entry named name.
+func compress(name string, content []byte) compressedEntry {
+	buf := bufPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	zw := encoderPool.Get().(compression.Encoder)
+	defer encoderPool.Put(zw)
+	zw.Reset(buf)
+
+	if _, err := zw.Write(content); err != nil {
+		return compressedEntry{name, buf, fmt.Errorf("failed to compress %q: %w", name, err)}
+	}
+	if err := zw.Close(); err != nil {
+		return compressedEntry{name, buf, fmt.Errorf("failed to compress %q: %w", name, err)}
+	}
+
+	return compressedEntry{name: name, buf: buf}
+}
+
+// writeEntries writes the pending entries in the order that they were added,
+// adding a row to the index for each, until pending is closed.
+func (w *Writer) writeEntries() {
+	defer close(w.done)
+
+	for result := range w.pending {
+		e := <-result
+		<-w.tokens
+
+		if w.err == nil {
+			w.err = w.writeEntry(e)
+		}
+		bufPool.Put(e.buf)
+	}
+}
+
+func (w *Writer) writeEntry(e compressedEntry) error {
+	if e.err != nil {
+		return e.err
+	}
+
+	size := e.buf.Len()
+	if size >= 1<<24 {
+		return fmt.Errorf("entry %q is too big, size=%d", e.name, size)
+	}
+
+	// Write length prefix
+	if _, err := w.entries.Write([]byte{byte(size), byte(size >> 8), byte(size >> 16)}); err != nil {
+		return err
+	}
+
+	// Write compressed data
+	if _, err := w.entries.Write(e.buf.Bytes()); err != nil {
+		return err
+	}
+
+	w.offsets[e.name] = w.entriesSize
+	w.rows = append(w.rows, IndexRow{Name: utf16.Encode([]rune(e.name)), Offset: w.entriesSize})
+	w.entriesSize += uint64(size) + 3 // 3 for length prefix
+
+	return nil
+}
+
+// AddRedirect adds a key named from which refers to the entry named to. The
+// entry can be added before or after the redirect. Redirects to entries which
+// are never added are dropped, like they are by index-fs.
+func (w *Writer) AddRedirect(from, to string) error {
+	if err := checkKey(from); err != nil {
+		return err
+	}
+
+	w.redirects = append(w.redirects, redirect{from, to})
+	return nil
+}
+
+// Close waits for the entries to be written, then writes the indexes, and
+// combines them with the entries into the wiki file.
+func (w *Writer) Close() error {
+	defer os.Remove(w.entriesFile.Name())
+	defer w.entriesFile.Close()
+
+	close(w.pending)
+	<-w.done
+	if w.err != nil {
+		return w.err
+	}
+
+	if err := w.entries.Flush(); err != nil {
+		return err
+	}
+
+	rows := w.rows
+	for _, r := range w.redirects {
+		if _, found := w.offsets[r.from]; found {
+			// The entry takes precedence.
+			continue
+		}
+		if offset, found := w.offsets[r.to]; found {
+			rows = append(rows, IndexRow{Name: utf16.Encode([]rune(r.from)), Offset: offset, Redirect: true})
+		}
+	}
+	if len(rows) == 0 {
+		return errors.New("no entries were added")
+	}
+
+	SortIndexRows(rows)
+
+	f, err := os.Create(w.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	output := bufio.NewWriterSize(f, 1024*1024)
+
+	longKeys := EncodeLongKeys(rows)
+	redirects := EncodeRedirects(rows, w.keyLen)
+
+	width := OffsetWidth(w.entriesSize)
+	header := Header{
+		OffsetWidth:      width,
+		FirstLevelKeyLen: w.keyLen,
+		LongKeysLen:      uint64(len(longKeys)),
+		RedirectsLen:     uint64(len(redirects)),
+	}
+	if err := WriteHeader(output, header); err != nil {
+		return err
+	}
+
+	if _, err := w.entriesFile.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.Copy(output, w.entriesFile); err != nil {
+		return err
+	}
+
+	if _, err := output.Write(longKeys); err != nil {
+		return err
+	}
+
+	if _, err := output.Write(redirects); err != nil {
+		return err
+	}
+
+	if err := WriteIndexes(output, rows, width, w.keyLen, nil); err != nil {
+		return err
+	}
+
+	if err := output.Flush(); err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+func checkKey(name string) error {
+	if name == "" {
+		return errors.New("empty name")
+	}
+	if n := len(utf16.Encode([]rune(name))); n > MaxLongKeyLen {
+		return fmt.Errorf("name %q is too long: %d UTF-16 code units, but the maximum is %d", name, n, MaxLongKeyLen)
+	}
+
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"slices"
+	"unicode/utf16"
+
+	"github.com/rsookram/wiki-builder/internal/storage"
+	"github.com/rsookram/wiki-builder/internal/words"
+	"github.com/rsookram/wiki-builder/wikifile"
+)
+
+// encodeWordIndex returns the word index for rows, which maps the part of
+// each key starting at each of its words after the first (along with the key
+// itself) to the same entries. The parts are truncated so that the keys fit in
+// the index. It's empty if none of the keys have more than one word.
+func encodeWordIndex(rows []wikifile.IndexRow, offsetWidth byte, keyLen byte) []byte {
+	separator := utf16.Encode([]rune(words.Separator))
+
+	var wordRows []wikifile.IndexRow
+	numSkipped := 0
+	for _, r := range rows {
+		name := string(utf16.Decode(r.Name))
+		for _, suffix := range words.Suffixes(name) {
+			suffixChars := storage.TruncateUTF16(
+				utf16.Encode([]rune(suffix)),
+				max(wikifile.MaxKeyLen-len(separator)-len(r.Name), 0),
+			)
+			if len(suffixChars) == 0 {
+				numSkipped++
+				continue
+			}
+
+			key := slices.Concat(suffixChars, separator, r.Name)
+			wordRows = append(wordRows, wikifile.IndexRow{Name: key, Offset: r.Offset})
+		}
+	}
+
+	if numSkipped > 0 {
+		log.Println("Skipped", numSkipped, "words in keys which are too long")
+	}
+	if len(wordRows) == 0 {
+		return nil
+	}
+
+	wikifile.SortIndexRows(wordRows)
+
+	var buf bytes.Buffer
+	if err := wikifile.WriteIndexes(&buf, wordRows, offsetWidth, keyLen, nil); err != nil {
+		panic(err)
+	}
+
+	log.Println("Indexed", len(wordRows), "words")
+	return buf.Bytes()
+}
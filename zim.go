@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/rsookram/wiki-builder/internal/reader"
+)
+
+// See https://wiki.openzim.org/wiki/ZIM_file_format for the format. Files are
+// written with the layout that libzim uses: header, MIME types, clusters,
+// directory entries, pointer lists, and then the checksum.
+const (
+	zimMagic      = 72173914
+	zimHeaderSize = 80
+	// Version 6.1 uses the namespace scheme where all content is in C.
+	zimMajorVersion = 6
+	zimMinorVersion = 1
+
+	zimRedirect  = 0xffff
+	zimNoPage    = 0xffffffff
+	zimNoneCodec = 1
+	zimZstdCodec = 5
+
+	// zimClusterSize is the uncompressed size after which a cluster is
+	// written. It's the same as libzim's default.
+	zimClusterSize = 2 * 1024 * 1024
+)
+
+// zimDirent is a directory entry, which is either a blob in a cluster or a
+// redirect to another directory entry.
+type zimDirent struct {
+	namespace byte
+	path      string
+	mimeType  uint16
+	cluster   uint32
+	blob      uint32
+	// target is the path (in namespace C) of the entry that a redirect points
+	// to.
+	target string
+}
+
+func (d zimDirent) fullPath() string {
+	return string(d.namespace) + d.path
+}
+
+func (d zimDirent) append(bb []byte, index map[string]uint32) []byte {
+	bb = binary.LittleEndian.AppendUint16(bb, d.mimeType)
+	bb = append(bb, 0) // No extra parameters
+	bb = append(bb, d.namespace)
+	bb = binary.LittleEndian.AppendUint32(bb, 0) // Revision
+	if d.mimeType == zimRedirect {
+		bb = binary.LittleEndian.AppendUint32(bb, index["C"+d.target])
+	} else {
+		bb = binary.LittleEndian.AppendUint32(bb, d.cluster)
+		bb = binary.LittleEndian.AppendUint32(bb, d.blob)
+	}
+	bb = append(bb, d.path...)
+	bb = append(bb, 0)
+	bb = append(bb, 0) // The title is the same as the path
+	return bb
+}
+
+// zimCluster collects blobs to write together as a cluster.
+type zimCluster struct {
+	data []byte
+	// ends are the offsets of the end of each blob in data.
+	ends []uint32
+}
+
+func (c *zimCluster) add(b []byte) uint32 {
+	c.data = append(c.data, b...)
+	c.ends = append(c.ends, uint32(len(c.data)))
+	return uint32(len(c.ends) - 1)
+}
+
+// encode returns the cluster in the format that's written to the file,
+// compressed with enc if it isn't nil.
+func (c *zimCluster) encode(enc *zstd.Encoder) []byte {
+	// The offsets are relative to the start of the offset list, so the first
+	// one is its size.
+	listSize := uint32(4 * (len(c.ends) + 1))
+
+	payload := make([]byte, 0, int(listSize)+len(c.data))
+	payload = binary.LittleEndian.AppendUint32(payload, listSize)
+	for _, end := range c.ends {
+		payload = binary.LittleEndian.AppendUint32(payload, listSize+end)
+	}
+	payload = append(payload, c.data...)
+
+	if enc == nil {
+		return append([]byte{zimNoneCodec}, payload...)
+	}
+
+	return enc.EncodeAll(payload, []byte{zimZstdCodec})
+}
+
+func (c *zimCluster) reset() {
+	c.data = c.data[:0]
+	c.ends = c.ends[:0]
+}
+
+// exportZIM writes the entries in the wiki file at wikiPath to a ZIM file at
+// zimPath, which can be read by Kiwix. Each entry is stored under its
+// canonical key (see canonicalKeys), and the other keys for it are stored as
+// redirects. If mainPage isn't empty, it's the key of the entry to open first.
+func exportZIM(wikiPath, zimPath, mainPage string) {
+	wiki, err := reader.OpenWiki(wikiPath)
+	if err != nil {
+		panic(err)
+	}
+
+	var keys []reader.SearchResult
+	err = wiki.Keys(func(r reader.SearchResult) error {
+		keys = append(keys, r)
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	log.Println("Read", len(keys), "keys")
+
+	canonical := canonicalKeys(keys)
+
+	if mainPage != "" {
+		i := slices.IndexFunc(keys, func(k reader.SearchResult) bool { return k.Key == mainPage })
+		if i < 0 {
+			panic(fmt.Sprintf("main page %q isn't in the wiki", mainPage))
+		}
+		mainPage = canonical[keys[i].EntryOffset]
+	}
+
+	f, err := os.Create(zimPath)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+
+	w := bufio.NewWriterSize(f, 1024*1024)
+	pos := uint64(0)
+	write := func(b []byte) {
+		if _, err := w.Write(b); err != nil {
+			panic(err)
+		}
+		pos += uint64(len(b))
+	}
+
+	// The header is written last, once the positions are known.
+	write(make([]byte, zimHeaderSize))
+
+	mimeTypes := []string{"text/plain"}
+	mimeIndexes := map[string]uint16{"text/plain": 0}
+	for _, k := range keys {
+		t := zimMimeType(wiki.ContentType(k.EntryOffset))
+		if _, found := mimeIndexes[t]; !found {
+			mimeIndexes[t] = uint16(len(mimeTypes))
+			mimeTypes = append(mimeTypes, t)
+		}
+	}
+	mimeListPos := pos
+	for _, t := range mimeTypes {
+		write(append([]byte(t), 0))
+	}
+	write([]byte{0})
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedBetterCompression))
+	if err != nil {
+		panic(err)
+	}
+	defer enc.Close()
+
+	var clusterPositions []uint64
+	var cluster zimCluster
+	writeCluster := func(enc *zstd.Encoder) {
+		clusterPositions = append(clusterPositions, pos)
+		write(cluster.encode(enc))
+		cluster.reset()
+	}
+
+	dirents := make([]zimDirent, 0, len(keys)+2)
+	numEntries := 0
+	for _, k := range keys {
+		if k.Key != canonical[k.EntryOffset] {
+			dirents = append(dirents, zimDirent{namespace: 'C', path: k.Key, mimeType: zimRedirect, target: canonical[k.EntryOffset]})
+			continue
+		}
+
+		rdr, err := wiki.EntryAt(k.EntryOffset)
+		if err != nil {
+			panic(err)
+		}
+		contents, err := io.ReadAll(rdr)
+		if err != nil {
+			panic(fmt.Sprintf("failed to read %s: %s", k.Key, err))
+		}
+
+		dirents = append(dirents, zimDirent{
+			namespace: 'C',
+			path:      k.Key,
+			mimeType:  mimeIndexes[zimMimeType(wiki.ContentType(k.EntryOffset))],
+			cluster:   uint32(len(clusterPositions)),
+			blob:      cluster.add(contents),
+		})
+		if len(cluster.data) >= zimClusterSize {
+			writeCluster(enc)
+		}
+
+		numEntries++
+		if numEntries%10000 == 0 {
+			log.Println(numEntries, "/", len(canonical), "entries")
+		}
+	}
+	if len(cluster.ends) > 0 {
+		writeCluster(enc)
+	}
+
+	// Metadata is small, so it's stored uncompressed.
+	title := strings.TrimSuffix(filepath.Base(wikiPath), filepath.Ext(wikiPath))
+	dirents = append(dirents, zimDirent{
+		namespace: 'M',
+		path:      "Title",
+		mimeType:  mimeIndexes["text/plain"],
+		cluster:   uint32(len(clusterPositions)),
+		blob:      cluster.add([]byte(title)),
+	})
+	writeCluster(nil)
+
+	if mainPage != "" {
+		dirents = append(dirents, zimDirent{namespace: 'W', path: "mainPage", mimeType: zimRedirect, target: mainPage})
+	}
+
+	slices.SortFunc(dirents, func(a, b zimDirent) int {
+		return strings.Compare(a.fullPath(), b.fullPath())
+	})
+	index := make(map[string]uint32, len(dirents))
+	for i, d := range dirents {
+		index[d.fullPath()] = uint32(i)
+	}
+
+	direntPositions := make([]uint64, len(dirents))
+	var bb []byte
+	for i, d := range dirents {
+		direntPositions[i] = pos
+		bb = d.append(bb[:0], index)
+		write(bb)
+	}
+
+	pathPtrPos := pos
+	bb = bb[:0]
+	for _, p := range direntPositions {
+		bb = binary.LittleEndian.AppendUint64(bb, p)
+	}
+	write(bb)
+
+	// Titles are the same as paths, so they're in the same order.
+	titlePtrPos := pos
+	bb = bb[:0]
+	for i := range dirents {
+		bb = binary.LittleEndian.AppendUint32(bb, uint32(i))
+	}
+	write(bb)
+
+	clusterPtrPos := pos
+	bb = bb[:0]
+	for _, p := range clusterPositions {
+		bb = binary.LittleEndian.AppendUint64(bb, p)
+	}
+	write(bb)
+
+	checksumPos := pos
+
+	if err := w.Flush(); err != nil {
+		panic(err)
+	}
+
+	mainPageIndex := uint32(zimNoPage)
+	if mainPage != "" {
+		mainPageIndex = index["WmainPage"]
+	}
+
+	header := make([]byte, 0, zimHeaderSize)
+	header = binary.LittleEndian.AppendUint32(header, zimMagic)
+	header = binary.LittleEndian.AppendUint16(header, zimMajorVersion)
+	header = binary.LittleEndian.AppendUint16(header, zimMinorVersion)
+	uuid := make([]byte, 16)
+	rand.Read(uuid)
+	header = append(header, uuid...)
+	header = binary.LittleEndian.AppendUint32(header, uint32(len(dirents)))
+	header = binary.LittleEndian.AppendUint32(header, uint32(len(clusterPositions)))
+	header = binary.LittleEndian.AppendUint64(header, pathPtrPos)
+	header = binary.LittleEndian.AppendUint64(header, titlePtrPos)
+	header = binary.LittleEndian.AppendUint64(header, clusterPtrPos)
+	header = binary.LittleEndian.AppendUint64(header, mimeListPos)
+	header = binary.LittleEndian.AppendUint32(header, mainPageIndex)
+	header = binary.LittleEndian.AppendUint32(header, zimNoPage) // Layout page
+	header = binary.LittleEndian.AppendUint64(header, checksumPos)
+	if _, err := f.WriteAt(header, 0); err != nil {
+		panic(err)
+	}
+
+	// The checksum is the MD5 of everything before it.
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		panic(err)
+	}
+	h := md5.New()
+	if _, err := io.Copy(h, f); err != nil {
+		panic(err)
+	}
+	if _, err := f.Write(h.Sum(nil)); err != nil {
+		panic(err)
+	}
+
+	log.Println("Exported", numEntries, "entries and", len(keys)-numEntries, "redirects in", len(clusterPositions), "clusters")
+}
+
+// zimMimeType returns the MIME type to store for the content type of an
+// entry. Parameters are dropped since readers compare the type exactly (e.g.
+// to text/html).
+func zimMimeType(contentType string) string {
+	t, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return contentType
+	}
+
+	return t
+}